@@ -9,12 +9,16 @@ import (
 	"syscall"
 	"time"
 
+	"user-microservice/internal/auth"
 	"user-microservice/internal/config"
 	"user-microservice/internal/handlers"
+	"user-microservice/internal/idempotency"
 	"user-microservice/internal/migration"
 	"user-microservice/internal/notification"
+	"user-microservice/internal/outbox"
 	"user-microservice/internal/repository"
 	"user-microservice/internal/service"
+	"user-microservice/internal/webhook"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -98,15 +102,80 @@ func run() error {
 	}
 	defer subscriberCleanup()
 
-	// Initialize services with notification dependency
-	userService := service.NewUserService(userRepo, notificationSvc, logger)
+	// Events the subscriber gave up on are inspected and re-driven by admins
+	var deadLetters handlers.DeadLetterService
+	if subscriber != nil {
+		deadLetterQueue, err := subscriber.DeadLetters()
+		if err != nil {
+			return fmt.Errorf("failed to open dead-letter queue: %w", err)
+		}
+		defer func() {
+			if err := deadLetterQueue.Close(); err != nil {
+				logger.Error("Error closing dead-letter queue", zap.Error(err))
+			}
+		}()
+		deadLetters = deadLetterQueue
+	}
+
+	// Webhook deliveries are scheduled with each event and sent by the
+	// dispatcher
+	webhookStore := webhook.NewPostgresStore(db, logger)
+	webhookNotifier := webhook.NewNotifier(webhookStore, cfg.Notification.CloudEvents, logger)
+	dispatcher := webhook.NewDispatcher(webhookStore, cfg.Webhooks, logger)
+	dispatcherCtx, stopDispatcher := context.WithCancel(context.Background())
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		dispatcher.Run(dispatcherCtx)
+	}()
+	defer func() {
+		stopDispatcher()
+		<-dispatcherDone
+	}()
+
+	// Events are fanned out to every enabled sink; the fan-out is closed
+	// once the relay stopped, before the RabbitMQ connection
+	publisher, publisherCleanup, err := setupPublisher(cfg, logger, notificationSvc, webhookNotifier, eventHandler)
+	if err != nil {
+		return fmt.Errorf("failed to initialize notification sinks: %w", err)
+	}
+	defer publisherCleanup()
+
+	// User events are written to the outbox with each change and published
+	// by the relay; it stops before the publisher is closed
+	relay := outbox.NewRelay(userRepo, publisher, cfg.Outbox, logger)
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(relayCtx)
+	}()
+	defer func() {
+		stopRelay()
+		<-relayDone
+	}()
+
+	userService := service.NewUserService(userRepo, logger, &cfg.Users)
+
+	authenticator := auth.NewAuthenticator(cfg.Auth, logger)
+
+	// Idempotency keys are purged once expired
+	idempotencyStore := idempotency.NewPostgresStore(db, logger)
+	purgeCtx, stopPurger := context.WithCancel(context.Background())
+	defer stopPurger()
+	go idempotencyStore.RunPurger(purgeCtx, time.Hour)
+	idempotencyMiddleware := idempotency.NewMiddleware(idempotencyStore, cfg.Idempotency, logger)
 
 	// Initialize handlers
-	userHandler := handlers.NewUserHandler(userService, logger)
-	healthHandler := handlers.NewHealthHandler(userRepo, logger, &cfg.App)
+	userHandler := handlers.NewUserHandler(userService, authenticator, logger)
+	adminHandler := handlers.NewAdminHandler(userService, authenticator, logger)
+	healthHandler := handlers.NewHealthHandler(userRepo, publisher, logger, &cfg.App)
+	schemaHandler := handlers.NewSchemaHandler(notification.NewSchemaRegistry(), logger)
+	webhookHandler := handlers.NewWebhookHandler(webhook.NewService(webhookStore, cfg.Webhooks, logger), authenticator, logger)
+	deadLetterHandler := handlers.NewDeadLetterHandler(deadLetters, authenticator, logger)
 
 	// Set up HTTP server
-	server := setupHTTPServer(cfg, userHandler, healthHandler, logger)
+	server := setupHTTPServer(cfg, userHandler, adminHandler, deadLetterHandler, healthHandler, schemaHandler, webhookHandler, idempotencyMiddleware, logger)
 
 	// Using errgroup to manage all goroutines
 	g, ctx := errgroup.WithContext(context.Background())
@@ -149,6 +218,11 @@ func run() error {
 			return fmt.Errorf("server shutdown failed: %w", err)
 		}
 
+		// running imports are stopped and marked as failed
+		if err := userService.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Error stopping import jobs", zap.Error(err))
+		}
+
 		logger.Info("Server exited gracefully")
 		return nil
 	})
@@ -163,11 +237,7 @@ func setupNotificationService(cfg *config.Config, logger *zap.Logger) (notificat
 		return notification.NewMockNotificationService(logger), func() {}, nil
 	}
 
-	rabbitSvc, err := notification.NewRabbitMQNotificationService(
-		cfg.Notification.RabbitMQURL,
-		cfg.Notification.QueueName,
-		logger,
-	)
+	rabbitSvc, err := notification.NewRabbitMQNotificationService(cfg.Notification, logger)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create RabbitMQ service: %w", err)
 	}
@@ -183,7 +253,55 @@ func setupNotificationService(cfg *config.Config, logger *zap.Logger) (notificat
 	return rabbitSvc, cleanup, nil
 }
 
-func setupHTTPServer(cfg *config.Config, userHandler *handlers.UserHandler, healthHandler *handlers.HealthHandler, logger *zap.Logger) *http.Server {
+// setupPublisher fans events out to the enabled sinks: RabbitMQ, webhooks,
+// the event file and the in-process bus, which passes them to handler
+func setupPublisher(cfg *config.Config, logger *zap.Logger, rabbit, webhooks notification.NotificationService, handler notification.EventHandlerInterface) (*notification.FanoutNotificationService, func(), error) {
+	sinksCfg := cfg.Notification.Sinks
+	var sinks []notification.Sink
+	var closers []func() error
+
+	if sinksCfg.RabbitMQ.Enabled {
+		sinks = append(sinks, notification.Sink{Name: "rabbitmq", Service: rabbit, SinkConfig: sinksCfg.RabbitMQ})
+	}
+	if sinksCfg.Webhooks.Enabled {
+		sinks = append(sinks, notification.Sink{Name: "webhooks", Service: webhooks, SinkConfig: sinksCfg.Webhooks})
+	}
+	if sinksCfg.File.Enabled {
+		file, err := notification.NewFileNotificationService(sinksCfg.File.Path, cfg.Notification.CloudEvents, logger)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open event file: %w", err)
+		}
+		sinks = append(sinks, notification.Sink{Name: "file", Service: file, SinkConfig: sinksCfg.File.SinkConfig})
+		closers = append(closers, file.Close)
+	}
+	if sinksCfg.Bus.Enabled {
+		bus := notification.NewBus(cfg.Notification.CloudEvents, logger)
+		bus.Subscribe(notification.BusHandlerFor(handler))
+		sinks = append(sinks, notification.Sink{Name: "bus", Service: bus, SinkConfig: sinksCfg.Bus})
+	}
+
+	publisher := notification.NewFanoutNotificationService(logger, sinks...)
+	cleanup := func() {
+		// queued events are published before the sinks are closed
+		if err := publisher.Close(); err != nil {
+			logger.Error("Error closing notification publisher", zap.Error(err))
+		}
+		for _, closeSink := range closers {
+			if err := closeSink(); err != nil {
+				logger.Error("Error closing notification sink", zap.Error(err))
+			}
+		}
+	}
+
+	names := make([]string, 0, len(sinks))
+	for _, sink := range sinks {
+		names = append(names, sink.Name)
+	}
+	logger.Info("Notification sinks initialized", zap.Strings("sinks", names))
+	return publisher, cleanup, nil
+}
+
+func setupHTTPServer(cfg *config.Config, userHandler *handlers.UserHandler, adminHandler *handlers.AdminHandler, deadLetterHandler *handlers.DeadLetterHandler, healthHandler *handlers.HealthHandler, schemaHandler *handlers.SchemaHandler, webhookHandler *handlers.WebhookHandler, idempotencyMiddleware *idempotency.Middleware, logger *zap.Logger) *http.Server {
 	r := chi.NewRouter()
 
 	// Middleware stack
@@ -191,16 +309,29 @@ func setupHTTPServer(cfg *config.Config, userHandler *handlers.UserHandler, heal
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
 
-	// Swagger
-	r.Get("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL("/swagger/doc.json"), // The url pointing to API definition
-	))
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(60 * time.Second))
+		// POSTs with an Idempotency-Key replay their first response when retried
+		r.Use(idempotencyMiddleware.Handler)
+
+		// Swagger
+		r.Get("/swagger/*", httpSwagger.Handler(
+			httpSwagger.URL("/swagger/doc.json"), // The url pointing to API definition
+		))
+
+		// Routes
+		userHandler.RegisterRoutes(r)
+		adminHandler.RegisterRoutes(r)
+		deadLetterHandler.RegisterRoutes(r)
+		healthHandler.RegisterRoutes(r)
+		schemaHandler.RegisterRoutes(r)
+		webhookHandler.RegisterRoutes(r)
+	})
 
-	// Routes
-	userHandler.RegisterRoutes(r)
-	healthHandler.RegisterRoutes(r)
+	// Streaming routes run for as long as the client keeps reading; their
+	// idempotent bodies are spooled to disk
+	userHandler.RegisterStreamingRoutes(r.With(idempotencyMiddleware.Streaming(handlers.MaxImportBodyBytes)))
 
 	return &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
@@ -217,13 +348,8 @@ func setupRabbitMQSubscriber(cfg *config.Config, logger *zap.Logger, handler not
 		return nil, func() {}, nil
 	}
 
-	subscriber, err := notification.NewRabbitMQSubscriber(
-		cfg.Notification.RabbitMQURL,
-		cfg.Notification.QueueName,
-		logger,
-		handler,
-		cfg.Notification.EnableConsumer, // false = consumer disabled
-	)
+	// the consumer is disabled unless notification.enableConsumer is set
+	subscriber, err := notification.NewRabbitMQSubscriber(cfg.Notification, logger, handler)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create RabbitMQ subscriber: %w", err)
 	}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/repository"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const usage = `usage: usertool <command> [flags]
+
+commands:
+  backfill-countries   normalise stored countries to ISO 3166-1 alpha-2 codes
+  report-conflicts     list users whose emails or nicknames collide case-insensitively
+  backfill-skeletons   compute confusable skeletons for existing nicknames
+`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "usertool: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("missing command")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	logger, err := cfg.Logging.NewLogger()
+	if err != nil {
+		return fmt.Errorf("error initializing logger: %w", err)
+	}
+	defer func() {
+		if err := logger.Sync(); err != nil {
+			logger.Error("error syncing logger", zap.Error(err))
+		}
+	}()
+
+	db, err := sqlx.Connect("postgres", cfg.Database.DSN())
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	defer db.Close()
+
+	repo := repository.NewPostgresUserRepository(db, logger)
+
+	switch args[0] {
+	case "backfill-countries":
+		return backfillCountries(context.Background(), repo, args[1:])
+	case "report-conflicts":
+		return reportConflicts(context.Background(), repo)
+	case "backfill-skeletons":
+		return backfillSkeletons(context.Background(), repo)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+func backfillCountries(ctx context.Context, repo *repository.PostgresUserRepository, args []string) error {
+	fs := flag.NewFlagSet("backfill-countries", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report the changes without writing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := repo.NormalizeCountries(ctx, *dryRun)
+	if err != nil {
+		return fmt.Errorf("country backfill failed: %w", err)
+	}
+
+	originals := make([]string, 0, len(report.Mapped))
+	for original := range report.Mapped {
+		originals = append(originals, original)
+	}
+	sort.Strings(originals)
+
+	for _, original := range originals {
+		fmt.Printf("%q -> %s\n", original, report.Mapped[original])
+	}
+
+	if *dryRun {
+		fmt.Printf("dry run: %d distinct values would be rewritten\n", len(report.Mapped))
+	} else {
+		fmt.Printf("%d rows updated\n", report.RowsUpdated)
+	}
+
+	if len(report.Unmappable) > 0 {
+		fmt.Printf("%d unmappable values:\n", len(report.Unmappable))
+		for _, value := range report.Unmappable {
+			fmt.Printf("  %q (%d rows)\n", value.Value, value.Count)
+		}
+	}
+
+	return nil
+}
+
+func reportConflicts(ctx context.Context, repo *repository.PostgresUserRepository) error {
+	conflicts, err := repo.FindConflicts(ctx)
+	if err != nil {
+		return fmt.Errorf("conflict report failed: %w", err)
+	}
+
+	for _, group := range conflicts {
+		fmt.Printf("%s %q:\n", group.Kind, group.Key)
+		for _, user := range group.Users {
+			fmt.Printf("  %s  %-30s  %-40s  %s\n", user.ID, user.Nickname, user.Email, user.CreatedAt.Format(time.RFC3339))
+		}
+	}
+
+	fmt.Printf("%d conflicting groups\n", len(conflicts))
+	return nil
+}
+
+func backfillSkeletons(ctx context.Context, repo *repository.PostgresUserRepository) error {
+	updated, err := repo.BackfillNicknameSkeletons(ctx)
+	if err != nil {
+		return fmt.Errorf("skeleton backfill failed: %w", err)
+	}
+
+	fmt.Printf("%d rows updated\n", updated)
+	return nil
+}
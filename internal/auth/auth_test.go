@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-microservice/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRequireScope(t *testing.T) {
+	authenticator := NewAuthenticator(config.AuthConfig{
+		AdminAPIKey: "admin-key",
+		APIKeys: []config.APIKeyConfig{
+			{Name: "feed", Key: "feed-key", Scopes: []string{"users:read"}},
+		},
+	}, zap.NewNop())
+
+	handler := authenticator.RequireScope("users:lookup")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		assert.True(t, ok)
+		assert.NotEmpty(t, principal.Name)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name     string
+		key      string
+		expected int
+	}{
+		{"missing key", "", http.StatusUnauthorized},
+		{"unknown key", "nope", http.StatusUnauthorized},
+		{"key without scope", "feed-key", http.StatusForbidden},
+		{"admin key", "admin-key", http.StatusOK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.key != "" {
+				req.Header.Set(APIKeyHeader, tc.key)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expected, w.Code)
+		})
+	}
+}
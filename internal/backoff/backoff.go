@@ -0,0 +1,31 @@
+// Package backoff computes the delays between the attempts of an operation
+// retried with exponential back-off
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Exponential starts at Min after the first failed attempt and doubles with
+// each further one up to Max
+type Exponential struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Delay is the delay after attempts failed attempts
+func (b Exponential) Delay(attempts int) time.Duration {
+	delay := b.Min
+	for i := 1; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	return min(delay, b.Max)
+}
+
+// Jittered is a random delay between half and all of Delay(attempts), so
+// clients failing together do not retry in lockstep
+func (b Exponential) Jittered(attempts int) time.Duration {
+	delay := b.Delay(attempts)
+	return delay/2 + rand.N(delay/2+1)
+}
@@ -0,0 +1,31 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponential_Delay(t *testing.T) {
+	b := Exponential{Min: time.Second, Max: 5 * time.Second}
+
+	assert.Equal(t, time.Second, b.Delay(0))
+	assert.Equal(t, time.Second, b.Delay(1))
+	assert.Equal(t, 2*time.Second, b.Delay(2))
+	assert.Equal(t, 4*time.Second, b.Delay(3))
+	assert.Equal(t, 5*time.Second, b.Delay(4))
+	assert.Equal(t, 5*time.Second, b.Delay(1000))
+}
+
+func TestExponential_Jittered(t *testing.T) {
+	b := Exponential{Min: time.Second, Max: 8 * time.Second}
+
+	for attempts := 1; attempts <= 5; attempts++ {
+		for range 20 {
+			delay := b.Jittered(attempts)
+			assert.GreaterOrEqual(t, delay, b.Delay(attempts)/2)
+			assert.LessOrEqual(t, delay, b.Delay(attempts))
+		}
+	}
+}
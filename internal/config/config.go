@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -16,6 +18,11 @@ type Config struct {
 	Database     DatabaseConfig     `mapstructure:"database"`
 	Notification NotificationConfig `mapstructure:"notification"`
 	Logging      LoggingConfig      `mapstructure:"logging"`
+	Users        UsersConfig        `mapstructure:"users"`
+	Auth         AuthConfig         `mapstructure:"auth"`
+	Idempotency  IdempotencyConfig  `mapstructure:"idempotency"`
+	Outbox       OutboxConfig       `mapstructure:"outbox"`
+	Webhooks     WebhookConfig      `mapstructure:"webhooks"`
 }
 
 type AppConfig struct {
@@ -41,10 +48,217 @@ type DatabaseConfig struct {
 }
 
 type NotificationConfig struct {
-	RabbitMQURL    string   `mapstructure:"rabbitMQURL"`
-	QueueName      string   `mapstructure:"queueName"`
-	Subscribers    []string `mapstructure:"subscribers"`
-	EnableConsumer bool     `mapstructure:"enableConsumer"`
+	RabbitMQURL string `mapstructure:"rabbitMQURL"`
+	// QueueName is the queue consumed by the service's own subscriber; it is
+	// bound to every event unless listed in Queues
+	QueueName      string `mapstructure:"queueName"`
+	EnableConsumer bool   `mapstructure:"enableConsumer"`
+	// Exchange receives every event, with the event type as routing key
+	Exchange ExchangeConfig `mapstructure:"exchange"`
+	// Queues are declared and bound to the exchange at startup
+	Queues []QueueConfig `mapstructure:"queues"`
+	// CloudEvents describes the envelope of published events
+	CloudEvents CloudEventsConfig `mapstructure:"cloudEvents"`
+	// SchemaValidation is strict to reject events not matching their JSON
+	// Schema, or log to only log them
+	SchemaValidation string `mapstructure:"schemaValidation"`
+	// Sinks are the destinations the outbox relay fans events out to
+	Sinks SinksConfig `mapstructure:"sinks"`
+	// Retry bounds how the subscriber retries the events it fails to handle
+	Retry SubscriberRetryConfig `mapstructure:"retry"`
+	// Prefetch is the number of unacknowledged messages the broker sends the
+	// subscriber; it should be at least Workers to keep them all busy
+	Prefetch int `mapstructure:"prefetch"`
+	// Workers is the number of messages the subscriber handles concurrently
+	Workers int `mapstructure:"workers"`
+	// OrderByUser hands the events of a user to the same worker, so they
+	// are handled in the order they were received. A retried event comes
+	// back after the back-off, so the later events of its user may be
+	// handled before it.
+	OrderByUser bool `mapstructure:"orderByUser"`
+	// DrainTimeout bounds how long the subscriber waits on shutdown for the
+	// messages being handled
+	DrainTimeout time.Duration `mapstructure:"drainTimeout"`
+}
+
+// SubscriberRetryConfig describes the retries of the subscriber. An event
+// whose handler fails is retried after a delay, through a queue per attempt
+// whose messages expire back into the subscriber's queue, and is
+// dead-lettered after MaxAttempts.
+type SubscriberRetryConfig struct {
+	// MaxAttempts is the number of times an event is handled before it is
+	// dead-lettered
+	MaxAttempts int `mapstructure:"maxAttempts"`
+	// MinBackoff and MaxBackoff bound the delay before a retry, doubling
+	// after each attempt
+	MinBackoff time.Duration `mapstructure:"minBackoff"`
+	MaxBackoff time.Duration `mapstructure:"maxBackoff"`
+	// DeadLetterExchange receives the events that failed for good
+	DeadLetterExchange string `mapstructure:"deadLetterExchange"`
+	// DeadLetterQueue keeps them for inspection; {queueName}.dlq unless set
+	DeadLetterQueue string `mapstructure:"deadLetterQueue"`
+}
+
+// SinksConfig configures each destination of the published events
+type SinksConfig struct {
+	RabbitMQ SinkConfig     `mapstructure:"rabbitmq"`
+	Webhooks SinkConfig     `mapstructure:"webhooks"`
+	File     FileSinkConfig `mapstructure:"file"`
+	Bus      SinkConfig     `mapstructure:"bus"`
+}
+
+// SinkConfig isolates a sink from the others: it has its own queue and
+// timeout, so a slow or failing sink does not hold the others back
+type SinkConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Required sinks must publish an event before it leaves the outbox;
+	// the others are best effort
+	Required bool `mapstructure:"required"`
+	// QueueSize bounds the events waiting for the sink; events are dropped
+	// when it is full
+	QueueSize int `mapstructure:"queueSize"`
+	// Timeout bounds a single publish
+	Timeout time.Duration `mapstructure:"timeout"`
+}
+
+// FileSinkConfig configures the sink appending events to a file
+type FileSinkConfig struct {
+	SinkConfig `mapstructure:",squash"`
+	// Path is the file events are appended to, one JSON CloudEvent per line
+	Path string `mapstructure:"path"`
+}
+
+// Schema validation modes
+const (
+	SchemaValidationStrict = "strict"
+	SchemaValidationLog    = "log"
+)
+
+// CloudEvents content modes
+const (
+	CloudEventsStructured = "structured"
+	CloudEventsBinary     = "binary"
+)
+
+// CloudEventsConfig describes how events are wrapped in CloudEvents 1.0
+type CloudEventsConfig struct {
+	// Mode is structured, with the whole event as JSON body, or binary, with
+	// the attributes as message headers and the data as body
+	Mode string `mapstructure:"mode"`
+	// Source identifies the producer of the events; the app name unless set
+	Source string `mapstructure:"source"`
+	// DataSchemaURL is the base URL of the event schemas; an event's schema
+	// is DataSchemaURL/{type}/{version}. No dataschema is sent when empty
+	DataSchemaURL   string `mapstructure:"dataSchemaURL"`
+	DataContentType string `mapstructure:"dataContentType"`
+}
+
+// ExchangeConfig describes the durable exchange events are published to
+type ExchangeConfig struct {
+	Name string `mapstructure:"name"`
+	// Type is the exchange type, topic unless set
+	Type      string                 `mapstructure:"type"`
+	Arguments map[string]interface{} `mapstructure:"arguments"`
+}
+
+// QueueConfig describes a durable queue bound to the events exchange
+type QueueConfig struct {
+	Name string `mapstructure:"name"`
+	// Bindings are routing key patterns, such as "user.created" or "user.updated.#"
+	Bindings []string `mapstructure:"bindings"`
+	// Arguments are the queue arguments, such as x-message-ttl
+	Arguments map[string]interface{} `mapstructure:"arguments"`
+}
+
+type UsersConfig struct {
+	// ConfusableCheck rejects nicknames that are visually confusable with an existing one
+	ConfusableCheck bool                 `mapstructure:"confusableCheck"`
+	Nickname        NicknamePolicyConfig `mapstructure:"nickname"`
+	// MaxBatchSize bounds the users fetched or resolved by a single batch request
+	MaxBatchSize int `mapstructure:"maxBatchSize"`
+	// CursorSecret signs list cursors; a random secret is used when empty
+	CursorSecret string `mapstructure:"cursorSecret"`
+	// MaxImportRows bounds the rows accepted by a single import
+	MaxImportRows int `mapstructure:"maxImportRows"`
+}
+
+type NicknamePolicyConfig struct {
+	MinLength     int    `mapstructure:"minLength"`
+	MaxLength     int    `mapstructure:"maxLength"`
+	ReservedFile  string `mapstructure:"reservedFile"`
+	ProfanityFile string `mapstructure:"profanityFile"`
+	// ReservedSubstring rejects nicknames containing a reserved word instead of only exact matches
+	ReservedSubstring bool `mapstructure:"reservedSubstring"`
+	// ProfanitySubstring rejects nicknames containing a profane word instead of only exact matches
+	ProfanitySubstring bool `mapstructure:"profanitySubstring"`
+	// Quarantine holds a released nickname for its previous owner before anyone else can take it
+	Quarantine time.Duration `mapstructure:"quarantine"`
+	// ChangeCooldown is the minimum time between two nickname changes of the same user
+	ChangeCooldown time.Duration `mapstructure:"changeCooldown"`
+
+	// Reserved and Profanity are read from ReservedFile and ProfanityFile
+	Reserved  []string `mapstructure:"-"`
+	Profanity []string `mapstructure:"-"`
+}
+
+type AuthConfig struct {
+	// AdminAPIKey is granted every scope
+	AdminAPIKey string         `mapstructure:"adminAPIKey"`
+	APIKeys     []APIKeyConfig `mapstructure:"apiKeys"`
+}
+
+type APIKeyConfig struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+type IdempotencyConfig struct {
+	// TTL is how long a response is kept for replay
+	TTL time.Duration `mapstructure:"ttl"`
+	// LockTimeout is how long a request holds its key before another request may take it over
+	LockTimeout time.Duration `mapstructure:"lockTimeout"`
+	// WaitTimeout is how long a retry waits for the request holding its key before giving up with a 409
+	WaitTimeout time.Duration `mapstructure:"waitTimeout"`
+}
+
+type OutboxConfig struct {
+	// PollInterval is how often the relay looks for events to publish
+	PollInterval time.Duration `mapstructure:"pollInterval"`
+	// BatchSize bounds the events claimed by the relay at once
+	BatchSize int `mapstructure:"batchSize"`
+	// MinBackoff and MaxBackoff bound the delay before a failed event is retried
+	MinBackoff time.Duration `mapstructure:"minBackoff"`
+	MaxBackoff time.Duration `mapstructure:"maxBackoff"`
+	// MaxAttempts is how many times an event is tried before it is parked
+	// as failed and stops holding back the later events of its user
+	MaxAttempts int `mapstructure:"maxAttempts"`
+	// PublishTimeout bounds the publication of an event to every required
+	// sink; it must not be shorter than the timeout of any of them
+	PublishTimeout time.Duration `mapstructure:"publishTimeout"`
+	// Retention is how long published events are kept before being purged
+	Retention time.Duration `mapstructure:"retention"`
+}
+
+type WebhookConfig struct {
+	// PollInterval is how often the dispatcher looks for deliveries to send
+	PollInterval time.Duration `mapstructure:"pollInterval"`
+	// BatchSize bounds the deliveries sent concurrently
+	BatchSize int `mapstructure:"batchSize"`
+	// Timeout bounds a single delivery request
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MinBackoff and MaxBackoff bound the delay before a failed delivery is retried
+	MinBackoff time.Duration `mapstructure:"minBackoff"`
+	MaxBackoff time.Duration `mapstructure:"maxBackoff"`
+	// MaxAttempts is the number of attempts after which a delivery fails for good
+	MaxAttempts int `mapstructure:"maxAttempts"`
+	// DisableAfter disables a subscription after this many failed attempts in a row
+	DisableAfter int `mapstructure:"disableAfter"`
+	// Retention is how long finished deliveries are kept in the delivery log
+	Retention time.Duration `mapstructure:"retention"`
+	// AllowPrivateNetworks lets subscriptions target loopback, private and
+	// link-local addresses; only for development
+	AllowPrivateNetworks bool `mapstructure:"allowPrivateNetworks"`
 }
 
 type LoggingConfig struct {
@@ -65,6 +279,22 @@ func LoadConfig() (*Config, error) {
 	viper.BindEnv("notification.rabbitMQURL", "RABBITMQ_URL")
 	viper.BindEnv("notification.queueName", "RABBITMQ_QUEUE_NAME")
 	viper.BindEnv("notification.enableConsumer", "RABBITMQ_ENABLE_CONSUMER")
+	viper.BindEnv("notification.exchange.name", "RABBITMQ_EXCHANGE")
+	viper.BindEnv("notification.cloudEvents.mode", "CLOUDEVENTS_MODE")
+	viper.BindEnv("notification.cloudEvents.dataSchemaURL", "CLOUDEVENTS_DATA_SCHEMA_URL")
+	viper.BindEnv("notification.schemaValidation", "EVENT_SCHEMA_VALIDATION")
+	viper.BindEnv("notification.sinks.file.enabled", "EVENT_FILE_SINK_ENABLED")
+	viper.BindEnv("notification.sinks.file.path", "EVENT_FILE_SINK_PATH")
+	viper.BindEnv("notification.sinks.bus.enabled", "EVENT_BUS_SINK_ENABLED")
+	viper.BindEnv("notification.retry.maxAttempts", "SUBSCRIBER_MAX_ATTEMPTS")
+	viper.BindEnv("notification.prefetch", "RABBITMQ_PREFETCH")
+	viper.BindEnv("notification.workers", "SUBSCRIBER_WORKERS")
+	viper.BindEnv("notification.orderByUser", "SUBSCRIBER_ORDER_BY_USER")
+	viper.BindEnv("users.confusableCheck", "USERS_CONFUSABLE_CHECK")
+	viper.BindEnv("users.cursorSecret", "USERS_CURSOR_SECRET")
+	viper.BindEnv("users.nickname.reservedFile", "NICKNAME_RESERVED_FILE")
+	viper.BindEnv("users.nickname.profanityFile", "NICKNAME_PROFANITY_FILE")
+	viper.BindEnv("auth.adminAPIKey", "ADMIN_API_KEY")
 
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, fmt.Errorf("error reading config file: %w", err)
@@ -75,6 +305,58 @@ func LoadConfig() (*Config, error) {
 	viper.SetDefault("server.writeTimeout", "15s")
 	viper.SetDefault("server.idleTimeout", "60s")
 	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("notification.exchange.name", "user.events")
+	viper.SetDefault("notification.exchange.type", "topic")
+	viper.SetDefault("notification.cloudEvents.mode", CloudEventsStructured)
+	viper.SetDefault("notification.cloudEvents.dataContentType", "application/json")
+	viper.SetDefault("notification.schemaValidation", SchemaValidationLog)
+	viper.SetDefault("notification.sinks.rabbitmq.enabled", true)
+	viper.SetDefault("notification.sinks.rabbitmq.required", true)
+	viper.SetDefault("notification.sinks.rabbitmq.queueSize", 1000)
+	viper.SetDefault("notification.sinks.rabbitmq.timeout", "10s")
+	viper.SetDefault("notification.sinks.webhooks.enabled", true)
+	viper.SetDefault("notification.sinks.webhooks.required", true)
+	viper.SetDefault("notification.sinks.webhooks.queueSize", 1000)
+	viper.SetDefault("notification.sinks.webhooks.timeout", "5s")
+	viper.SetDefault("notification.sinks.file.queueSize", 1000)
+	viper.SetDefault("notification.sinks.file.timeout", "5s")
+	viper.SetDefault("notification.sinks.file.path", "events.ndjson")
+	viper.SetDefault("notification.sinks.bus.queueSize", 1000)
+	viper.SetDefault("notification.sinks.bus.timeout", "5s")
+	viper.SetDefault("notification.prefetch", 20)
+	viper.SetDefault("notification.workers", 4)
+	viper.SetDefault("notification.orderByUser", true)
+	viper.SetDefault("notification.drainTimeout", "30s")
+	viper.SetDefault("notification.retry.maxAttempts", 5)
+	viper.SetDefault("notification.retry.minBackoff", "1s")
+	viper.SetDefault("notification.retry.maxBackoff", "5m")
+	viper.SetDefault("notification.retry.deadLetterExchange", "user.events.dlx")
+	viper.SetDefault("users.confusableCheck", true)
+	viper.SetDefault("users.maxBatchSize", 500)
+	viper.SetDefault("users.maxImportRows", 10000)
+	viper.SetDefault("users.nickname.minLength", 3)
+	viper.SetDefault("users.nickname.maxLength", 50)
+	viper.SetDefault("users.nickname.profanitySubstring", true)
+	viper.SetDefault("users.nickname.quarantine", "720h")
+	viper.SetDefault("users.nickname.changeCooldown", "168h")
+	viper.SetDefault("idempotency.ttl", "24h")
+	viper.SetDefault("idempotency.lockTimeout", "1m")
+	viper.SetDefault("idempotency.waitTimeout", "10s")
+	viper.SetDefault("outbox.pollInterval", "1s")
+	viper.SetDefault("outbox.batchSize", 100)
+	viper.SetDefault("outbox.minBackoff", "1s")
+	viper.SetDefault("outbox.maxBackoff", "5m")
+	viper.SetDefault("outbox.maxAttempts", 100)
+	viper.SetDefault("outbox.publishTimeout", "15s")
+	viper.SetDefault("outbox.retention", "168h")
+	viper.SetDefault("webhooks.pollInterval", "1s")
+	viper.SetDefault("webhooks.batchSize", 50)
+	viper.SetDefault("webhooks.timeout", "10s")
+	viper.SetDefault("webhooks.minBackoff", "10s")
+	viper.SetDefault("webhooks.maxBackoff", "1h")
+	viper.SetDefault("webhooks.maxAttempts", 10)
+	viper.SetDefault("webhooks.disableAfter", 20)
+	viper.SetDefault("webhooks.retention", "720h")
 
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
@@ -90,6 +372,14 @@ func LoadConfig() (*Config, error) {
 		config.Database.Port = dbPort
 	}
 
+	if config.Notification.CloudEvents.Source == "" {
+		config.Notification.CloudEvents.Source = config.App.Name
+	}
+
+	if err := config.Users.Nickname.loadWordLists(); err != nil {
+		return nil, err
+	}
+
 	if err := validateConfig(&config); err != nil {
 		return nil, err
 	}
@@ -114,10 +404,94 @@ func validateConfig(config *Config) error {
 	if config.Notification.RabbitMQURL == "" {
 		return fmt.Errorf("RabbitMQ URL is not set")
 	}
+	switch config.Notification.CloudEvents.Mode {
+	case CloudEventsStructured, CloudEventsBinary:
+	default:
+		return fmt.Errorf("invalid CloudEvents mode '%s'", config.Notification.CloudEvents.Mode)
+	}
+	if config.Notification.CloudEvents.Source == "" {
+		return fmt.Errorf("CloudEvents source is not set")
+	}
+	switch config.Notification.SchemaValidation {
+	case SchemaValidationStrict, SchemaValidationLog:
+	default:
+		return fmt.Errorf("invalid schema validation mode '%s'", config.Notification.SchemaValidation)
+	}
+	if config.Notification.Prefetch < 1 {
+		return fmt.Errorf("RabbitMQ prefetch must be at least 1")
+	}
+	if config.Notification.Workers < 1 {
+		return fmt.Errorf("subscriber workers must be at least 1")
+	}
+	if config.Notification.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("subscriber max attempts must be at least 1")
+	}
+	if config.Notification.Sinks.File.Enabled && config.Notification.Sinks.File.Path == "" {
+		return fmt.Errorf("event file sink path is not set")
+	}
+	if err := validateSinkTimeouts(config); err != nil {
+		return err
+	}
+	// both would pass every event to the same event handler
+	if config.Notification.Sinks.Bus.Enabled && config.Notification.EnableConsumer {
+		return fmt.Errorf("the bus sink and the RabbitMQ consumer cannot both be enabled")
+	}
 
 	return nil
 }
 
+// validateSinkTimeouts checks that the relay waits for the slowest required
+// sink; a shorter publish timeout would fail every event that sink is slow on
+func validateSinkTimeouts(config *Config) error {
+	sinks := config.Notification.Sinks
+	for name, sink := range map[string]SinkConfig{
+		"rabbitmq": sinks.RabbitMQ,
+		"webhooks": sinks.Webhooks,
+		"file":     sinks.File.SinkConfig,
+		"bus":      sinks.Bus,
+	} {
+		if sink.Enabled && sink.Required && sink.Timeout > config.Outbox.PublishTimeout {
+			return fmt.Errorf("outbox publish timeout %s is shorter than the %s timeout of the required %s sink",
+				config.Outbox.PublishTimeout, sink.Timeout, name)
+		}
+	}
+	return nil
+}
+
+// loadWordLists reads the reserved and profanity word lists, one word per
+// line. Blank lines and lines starting with '#' are ignored.
+func (c *NicknamePolicyConfig) loadWordLists() error {
+	var err error
+	if c.Reserved, err = readWordList(c.ReservedFile); err != nil {
+		return fmt.Errorf("error reading reserved nicknames: %w", err)
+	}
+	if c.Profanity, err = readWordList(c.ProfanityFile); err != nil {
+		return fmt.Errorf("error reading profanity list: %w", err)
+	}
+	return nil
+}
+
+func readWordList(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var words []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, nil
+}
+
 func (c *DatabaseConfig) DSN() string {
 	return fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
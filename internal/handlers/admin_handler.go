@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// AdminHandler manages administrative HTTP requests. Every route requires an
+// API key with the admin scope.
+type AdminHandler struct {
+	service service.UserServiceInterface
+	auth    *auth.Authenticator
+	logger  *zap.Logger
+}
+
+// NewAdminHandler creates a new instance of AdminHandler
+func NewAdminHandler(service service.UserServiceInterface, authenticator *auth.Authenticator, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{
+		service: service,
+		auth:    authenticator,
+		logger:  logger.With(zap.String("component", "admin_handler")),
+	}
+}
+
+// RegisterRoutes registers the handler routes on the router
+func (h *AdminHandler) RegisterRoutes(r chi.Router) {
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(h.auth.RequireScope(auth.ScopeAdmin))
+		r.Post("/nickname-grants", h.GrantReservedNickname)
+		r.Post("/users/{id}/verify", h.VerifyUser)
+	})
+}
+
+// GrantNicknameRequest represents the body of the request to grant a reserved nickname
+type GrantNicknameRequest struct {
+	Nickname string `json:"nickname"`
+	UserID   string `json:"user_id"`
+}
+
+// @Summary: Grant a reserved nickname
+// @Description: Allow a specific verified account to use a reserved nickname
+// @Tags: admin
+// @Accept: json
+// @Produce: json
+// @Param grant body GrantNicknameRequest true "Nickname grant"
+// @Success 201 {object} models.NicknameGrant
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/nickname-grants [post]
+func (h *AdminHandler) GrantReservedNickname(w http.ResponseWriter, r *http.Request) {
+	var req GrantNicknameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, h.logger, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	grantedBy := "unknown"
+	if principal, ok := auth.PrincipalFromContext(r.Context()); ok {
+		grantedBy = principal.Name
+	}
+
+	grant, err := h.service.GrantReservedNickname(r.Context(), req.Nickname, req.UserID, grantedBy)
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusCreated, grant)
+}
+
+// @Summary: Verify a user
+// @Description: Mark the account of a user as verified, making it eligible for reserved nickname grants
+// @Tags: admin
+// @Produce: json
+// @Param id path string true "User ID"
+// @Success 200 {object} models.User
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /admin/users/{id}/verify [post]
+func (h *AdminHandler) VerifyUser(w http.ResponseWriter, r *http.Request) {
+	user, err := h.service.VerifyUser(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, user)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+	"user-microservice/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func newAdminRouter(mockService *MockUserService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{AdminAPIKey: "admin-key"}, logger)
+
+	r := chi.NewRouter()
+	NewAdminHandler(mockService, authenticator, logger).RegisterRoutes(r)
+	return r
+}
+
+func TestGrantReservedNickname_Success(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newAdminRouter(mockService)
+
+	grant := &models.NicknameGrant{Nickname: "support", UserID: "123", GrantedBy: "admin"}
+	mockService.On("GrantReservedNickname", mock.Anything, "support", "123", "admin").Return(grant, nil)
+
+	body, _ := json.Marshal(GrantNicknameRequest{Nickname: "support", UserID: "123"})
+	req := httptest.NewRequest(http.MethodPost, "/admin/nickname-grants", bytes.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGrantReservedNickname_NotReserved(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newAdminRouter(mockService)
+
+	mockService.On("GrantReservedNickname", mock.Anything, "johndoe", "123", "admin").Return(nil, service.ErrNicknameNotReserved)
+
+	body, _ := json.Marshal(GrantNicknameRequest{Nickname: "johndoe", UserID: "123"})
+	req := httptest.NewRequest(http.MethodPost, "/admin/nickname-grants", bytes.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGrantReservedNickname_UnverifiedUser(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newAdminRouter(mockService)
+
+	mockService.On("GrantReservedNickname", mock.Anything, "support", "123", "admin").Return(nil, service.ErrUserNotVerified)
+
+	body, _ := json.Marshal(GrantNicknameRequest{Nickname: "support", UserID: "123"})
+	req := httptest.NewRequest(http.MethodPost, "/admin/nickname-grants", bytes.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestVerifyUser(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newAdminRouter(mockService)
+
+	mockService.On("VerifyUser", mock.Anything, "123").Return(&models.User{ID: "123"}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/123/verify", nil)
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestGrantReservedNickname_Unauthorized(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newAdminRouter(mockService)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/nickname-grants", bytes.NewReader([]byte(`{}`)))
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockService.AssertNotCalled(t, "GrantReservedNickname")
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/notification"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDeadLetterLimit = 50
+	maxDeadLetterLimit     = 500
+)
+
+// DeadLetterService inspects and re-drives the events the subscriber gave up on
+type DeadLetterService interface {
+	Peek(ctx context.Context, limit int) ([]notification.DeadLetter, error)
+	Redrive(ctx context.Context, ids []string, limit int) (int, error)
+}
+
+// DeadLetterHandler exposes the dead-letter queue of the subscriber. Every
+// route requires an API key with the admin scope.
+type DeadLetterHandler struct {
+	service DeadLetterService
+	auth    *auth.Authenticator
+	logger  *zap.Logger
+}
+
+// NewDeadLetterHandler creates a new instance of DeadLetterHandler; service
+// is nil when the subscriber is not configured
+func NewDeadLetterHandler(service DeadLetterService, authenticator *auth.Authenticator, logger *zap.Logger) *DeadLetterHandler {
+	return &DeadLetterHandler{
+		service: service,
+		auth:    authenticator,
+		logger:  logger.With(zap.String("component", "dead_letter_handler")),
+	}
+}
+
+// RegisterRoutes registers the handler routes on the router
+func (h *DeadLetterHandler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(h.auth.RequireScope(auth.ScopeAdmin))
+		r.Get("/admin/dead-letters", h.ListDeadLetters)
+		r.Post("/admin/dead-letters/redrive", h.RedriveDeadLetters)
+	})
+}
+
+// DeadLetterListResponse lists dead-lettered events
+type DeadLetterListResponse struct {
+	Messages []notification.DeadLetter `json:"messages"`
+	Count    int                       `json:"count"`
+}
+
+// RedriveRequest selects the dead letters to re-drive
+type RedriveRequest struct {
+	// IDs are the message IDs to re-drive; every message when empty
+	IDs []string `json:"ids,omitempty"`
+	// Limit bounds the messages looked at (default 50, max 500)
+	Limit int `json:"limit,omitempty"`
+}
+
+// RedriveResponse reports how many dead letters were re-driven
+type RedriveResponse struct {
+	Redriven int `json:"redriven"`
+}
+
+// @Summary: List dead-lettered events
+// @Description: The oldest events the subscriber gave up on, with their attempts and last error; they stay in the queue
+// @Tags: admin
+// @Produce: json
+// @Param limit query int false "Maximum messages (default 50, max 500)"
+// @Success 200 {object} DeadLetterListResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /admin/dead-letters [get]
+func (h *DeadLetterHandler) ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, err := deadLetterLimit(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeError(w, h.logger, http.StatusBadRequest, err)
+		return
+	}
+	if !h.available(w) {
+		return
+	}
+
+	messages, err := h.service.Peek(r.Context(), limit)
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, DeadLetterListResponse{Messages: messages, Count: len(messages)})
+}
+
+// @Summary: Re-drive dead-lettered events
+// @Description: Send dead letters back to the subscriber's queue with their attempts reset, all of them or those with the given message IDs
+// @Tags: admin
+// @Accept: json
+// @Produce: json
+// @Param request body RedriveRequest false "Messages to re-drive"
+// @Success 200 {object} RedriveResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Failure 503 {object} ErrorResponse
+// @Router /admin/dead-letters/redrive [post]
+func (h *DeadLetterHandler) RedriveDeadLetters(w http.ResponseWriter, r *http.Request) {
+	var req RedriveRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, h.logger, http.StatusBadRequest, errors.New("invalid request body"))
+			return
+		}
+	}
+	if req.Limit < 0 {
+		writeError(w, h.logger, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+		return
+	}
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultDeadLetterLimit
+	}
+	if !h.available(w) {
+		return
+	}
+
+	redriven, err := h.service.Redrive(r.Context(), req.IDs, min(limit, maxDeadLetterLimit))
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, RedriveResponse{Redriven: redriven})
+}
+
+// available writes a 503 when there is no dead-letter queue
+func (h *DeadLetterHandler) available(w http.ResponseWriter) bool {
+	if h.service == nil {
+		writeError(w, h.logger, http.StatusServiceUnavailable, errors.New("dead-letter queue is not configured"))
+		return false
+	}
+	return true
+}
+
+func deadLetterLimit(value string) (int, error) {
+	if value == "" {
+		return defaultDeadLetterLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	return min(limit, maxDeadLetterLimit), nil
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+	"user-microservice/internal/notification"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// MockDeadLetterService is a mock of the dead-letter queue for testing
+type MockDeadLetterService struct {
+	mock.Mock
+}
+
+func (m *MockDeadLetterService) Peek(ctx context.Context, limit int) ([]notification.DeadLetter, error) {
+	args := m.Called(ctx, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]notification.DeadLetter), args.Error(1)
+}
+
+func (m *MockDeadLetterService) Redrive(ctx context.Context, ids []string, limit int) (int, error) {
+	args := m.Called(ctx, ids, limit)
+	return args.Int(0), args.Error(1)
+}
+
+func newDeadLetterRouter(service DeadLetterService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{AdminAPIKey: "admin-key"}, logger)
+
+	r := chi.NewRouter()
+	// the admin routes share the /admin prefix
+	NewAdminHandler(new(MockUserService), authenticator, logger).RegisterRoutes(r)
+	NewDeadLetterHandler(service, authenticator, logger).RegisterRoutes(r)
+	return r
+}
+
+func TestListDeadLetters(t *testing.T) {
+	mockService := new(MockDeadLetterService)
+	router := newDeadLetterRouter(mockService)
+
+	letters := []notification.DeadLetter{{MessageID: "1", Type: "user.deleted", Attempts: 5, Reason: "retries exhausted"}}
+	mockService.On("Peek", mock.Anything, 10).Return(letters, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters?limit=10", nil)
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response DeadLetterListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Count)
+	assert.Equal(t, "retries exhausted", response.Messages[0].Reason)
+	mockService.AssertExpectations(t)
+}
+
+func TestListDeadLetters_InvalidLimit(t *testing.T) {
+	mockService := new(MockDeadLetterService)
+	router := newDeadLetterRouter(mockService)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters?limit=0", nil)
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockService.AssertNotCalled(t, "Peek", mock.Anything, mock.Anything)
+}
+
+func TestListDeadLetters_RequiresAdmin(t *testing.T) {
+	mockService := new(MockDeadLetterService)
+	router := newDeadLetterRouter(mockService)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRedriveDeadLetters(t *testing.T) {
+	mockService := new(MockDeadLetterService)
+	router := newDeadLetterRouter(mockService)
+
+	mockService.On("Redrive", mock.Anything, []string{"1", "2"}, 50).Return(2, nil)
+
+	body, _ := json.Marshal(RedriveRequest{IDs: []string{"1", "2"}})
+	req := httptest.NewRequest(http.MethodPost, "/admin/dead-letters/redrive", bytes.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"redriven":2}`, w.Body.String())
+	mockService.AssertExpectations(t)
+}
+
+func TestRedriveDeadLetters_WithoutBody(t *testing.T) {
+	mockService := new(MockDeadLetterService)
+	router := newDeadLetterRouter(mockService)
+
+	mockService.On("Redrive", mock.Anything, []string(nil), 50).Return(0, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/dead-letters/redrive", nil)
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestDeadLetters_NotConfigured(t *testing.T) {
+	router := newDeadLetterRouter(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil)
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
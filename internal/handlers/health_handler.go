@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"user-microservice/internal/notification"
 	"user-microservice/internal/repository"
 
 	"user-microservice/internal/config"
@@ -12,8 +13,14 @@ import (
 	"go.uber.org/zap"
 )
 
+// SinkHealthReporter reports the health of the notification sinks
+type SinkHealthReporter interface {
+	Health() []notification.SinkHealth
+}
+
 type HealthHandler struct {
 	db        repository.HealthChecker
+	sinks     SinkHealthReporter
 	logger    *zap.Logger
 	appConfig *config.AppConfig
 }
@@ -23,11 +30,15 @@ type HealthStatus struct {
 	Timestamp time.Time         `json:"timestamp"`
 	Version   string            `json:"version"`
 	Services  map[string]string `json:"services"`
+	// Sinks are the state and counters of each notification sink
+	Sinks []notification.SinkHealth `json:"sinks,omitempty"`
 }
 
-func NewHealthHandler(db repository.HealthChecker, logger *zap.Logger, appConfig *config.AppConfig) *HealthHandler {
+// NewHealthHandler creates a new HealthHandler; sinks may be nil
+func NewHealthHandler(db repository.HealthChecker, sinks SinkHealthReporter, logger *zap.Logger, appConfig *config.AppConfig) *HealthHandler {
 	return &HealthHandler{
 		db:        db,
+		sinks:     sinks,
 		logger:    logger.With(zap.String("component", "health_handler")),
 		appConfig: appConfig,
 	}
@@ -58,11 +69,25 @@ func (h *HealthHandler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 		services["database"] = "ok"
 	}
 
+	// failing sinks do not make the service unhealthy: events wait in the
+	// outbox until they are published
+	var sinks []notification.SinkHealth
+	if h.sinks != nil {
+		sinks = h.sinks.Health()
+		services["notifications"] = "ok"
+		for _, sink := range sinks {
+			if sink.Status != "ok" {
+				services["notifications"] = "degraded"
+			}
+		}
+	}
+
 	response := HealthStatus{
 		Status:    status,
 		Timestamp: time.Now().UTC(),
 		Version:   h.appConfig.Version,
 		Services:  services,
+		Sinks:     sinks,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
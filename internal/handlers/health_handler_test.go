@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/notification"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type fakeHealthChecker struct {
+	err error
+}
+
+func (c fakeHealthChecker) CheckHealth() error {
+	return c.err
+}
+
+type fakeSinkHealth []notification.SinkHealth
+
+func (s fakeSinkHealth) Health() []notification.SinkHealth {
+	return s
+}
+
+func TestHealthCheck_ReportsSinks(t *testing.T) {
+	sinks := fakeSinkHealth{
+		{Name: "rabbitmq", Required: true, Status: "ok", Published: 10},
+		{Name: "file", Status: "degraded", Failed: 2, LastError: "disk full"},
+	}
+	handler := NewHealthHandler(fakeHealthChecker{}, sinks, zap.NewNop(), &config.AppConfig{Name: "user-microservice"})
+
+	w := httptest.NewRecorder()
+	handler.HealthCheck(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	// events wait in the outbox while a sink fails
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response HealthStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "ok", response.Status)
+	assert.Equal(t, "degraded", response.Services["notifications"])
+	require.Len(t, response.Sinks, 2)
+	assert.Equal(t, uint64(10), response.Sinks[0].Published)
+	assert.Equal(t, "disk full", response.Sinks[1].LastError)
+}
+
+func TestHealthCheck_DatabaseDown(t *testing.T) {
+	handler := NewHealthHandler(fakeHealthChecker{err: errors.New("connection refused")}, nil, zap.NewNop(), &config.AppConfig{Name: "user-microservice"})
+
+	w := httptest.NewRecorder()
+	handler.HealthCheck(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.NotContains(t, w.Body.String(), "sinks")
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"user-microservice/internal/notification"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+)
+
+// SchemaHandler serves the JSON Schemas of the published events, which are
+// the dataschema of each event
+type SchemaHandler struct {
+	registry *notification.SchemaRegistry
+	logger   *zap.Logger
+}
+
+// NewSchemaHandler creates a new instance of SchemaHandler
+func NewSchemaHandler(registry *notification.SchemaRegistry, logger *zap.Logger) *SchemaHandler {
+	return &SchemaHandler{
+		registry: registry,
+		logger:   logger.With(zap.String("component", "schema_handler")),
+	}
+}
+
+// RegisterRoutes registers the handler routes on the router
+func (h *SchemaHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/events/schemas", h.ListSchemas)
+	r.Get("/events/schemas/{type}/{version}", h.GetSchema)
+}
+
+// SchemaListResponse lists the available event schemas as type/version
+type SchemaListResponse struct {
+	Schemas []string `json:"schemas"`
+}
+
+// @Summary: List event schemas
+// @Description: List the event types and versions with a JSON Schema
+// @Tags: events
+// @Produce: json
+// @Success 200 {object} SchemaListResponse
+// @Router /events/schemas [get]
+func (h *SchemaHandler) ListSchemas(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, h.logger, http.StatusOK, SchemaListResponse{Schemas: h.registry.Names()})
+}
+
+// @Summary: Get an event schema
+// @Description: Get the JSON Schema of the data of an event type in a version
+// @Tags: events
+// @Produce: json
+// @Param type path string true "Event type, e.g. user.created"
+// @Param version path string true "Schema version, e.g. v1"
+// @Success 200 {object} notification.Schema
+// @Failure 404 {object} ErrorResponse
+// @Router /events/schemas/{type}/{version} [get]
+func (h *SchemaHandler) GetSchema(w http.ResponseWriter, r *http.Request) {
+	schema, ok := h.registry.Schema(pathParam(r, "type"), pathParam(r, "version"))
+	if !ok {
+		writeJSON(w, h.logger, http.StatusNotFound, ErrorResponse{Error: "schema not found"})
+		return
+	}
+
+	body, err := json.Marshal(schema)
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-microservice/internal/notification"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newSchemaRouter() http.Handler {
+	r := chi.NewRouter()
+	NewSchemaHandler(notification.NewSchemaRegistry(), zap.NewNop()).RegisterRoutes(r)
+	return r
+}
+
+func TestGetSchema(t *testing.T) {
+	w := httptest.NewRecorder()
+	newSchemaRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/schemas/user.created/v1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/schema+json", w.Header().Get("Content-Type"))
+
+	var schema notification.Schema
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &schema))
+	assert.Equal(t, "object", schema.Type)
+	assert.Contains(t, schema.Properties, "nickname")
+}
+
+func TestGetSchema_NotFound(t *testing.T) {
+	for _, path := range []string{"/events/schemas/user.created/v9", "/events/schemas/user.renamed/v1"} {
+		w := httptest.NewRecorder()
+		newSchemaRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
+func TestListSchemas(t *testing.T) {
+	w := httptest.NewRecorder()
+	newSchemaRouter().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/events/schemas", nil))
+
+	var response SchemaListResponse
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, []string{"user.created/v1", "user.deleted/v1", "user.updated/v1"}, response.Schemas)
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"user-microservice/internal/service"
+
+	"github.com/pkg/errors"
+)
+
+// BatchUsersRequest represents the body of a batch of user changes
+type BatchUsersRequest struct {
+	Operations []BatchOperationRequest `json:"operations"`
+}
+
+// BatchOperationRequest represents one change of a batch. ID is required for
+// update and delete; User holds the fields of create and update, password
+// being ignored on update.
+type BatchOperationRequest struct {
+	Ref  string            `json:"ref"`
+	Op   string            `json:"op"`
+	ID   string            `json:"id,omitempty"`
+	User CreateUserRequest `json:"user"`
+}
+
+// BatchUsersResponse represents the outcome of a batch, one result per
+// operation in request order
+type BatchUsersResponse struct {
+	Mode    string                   `json:"mode"`
+	Results []BatchOperationResponse `json:"results"`
+}
+
+// BatchOperationResponse represents the outcome of one operation: the status
+// code and body the matching single-user endpoint would have returned
+type BatchOperationResponse struct {
+	Ref    string      `json:"ref"`
+	Status int         `json:"status"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+const (
+	batchModeAtomic     = "atomic"
+	batchModeBestEffort = "best_effort"
+)
+
+// @Summary: Apply a batch of user changes
+// @Description: Create, update and delete users in one request. In atomic mode, the default, the operations run in one transaction and a failure rolls back every operation, which then report 424. In best_effort mode each operation is applied independently. Events are only published for committed operations.
+// @Tags: users
+// @Accept: json
+// @Produce: json
+// @Param mode query string false "atomic or best_effort" default(atomic)
+// @Param batch body BatchUsersRequest true "Operations"
+// @Param Idempotency-Key header string false "Replays the first response when the request is retried with the same key"
+// @Success 200 {object} BatchUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/batch [post]
+func (h *UserHandler) BatchUsers(w http.ResponseWriter, r *http.Request) {
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = batchModeAtomic
+	}
+	if mode != batchModeAtomic && mode != batchModeBestEffort {
+		h.respondWithError(w, http.StatusBadRequest, errors.New("mode must be atomic or best_effort"))
+		return
+	}
+
+	var req BatchUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	ops := make([]service.BatchOperation, len(req.Operations))
+	for i, op := range req.Operations {
+		ops[i] = service.BatchOperation{
+			Ref:       op.Ref,
+			Action:    service.BatchAction(op.Op),
+			ID:        op.ID,
+			FirstName: op.User.FirstName,
+			LastName:  op.User.LastName,
+			Nickname:  op.User.Nickname,
+			Password:  op.User.Password,
+			Email:     op.User.Email,
+			Country:   op.User.Country,
+		}
+	}
+
+	results, err := h.service.ApplyBatch(r.Context(), ops, mode == batchModeAtomic)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := BatchUsersResponse{Mode: mode, Results: make([]BatchOperationResponse, len(results))}
+	for i, result := range results {
+		response.Results[i] = batchOperationResponse(result)
+	}
+
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
+func batchOperationResponse(result service.BatchOperationResult) BatchOperationResponse {
+	response := BatchOperationResponse{Ref: result.Ref}
+
+	switch {
+	case result.Err != nil:
+		response.Status = statusForError(result.Err, http.StatusInternalServerError)
+		response.Body = ErrorResponse{Error: result.Err.Error()}
+	case result.Action == service.BatchCreate:
+		response.Status = http.StatusCreated
+		response.Body = result.User
+	case result.Action == service.BatchDelete:
+		response.Status = http.StatusNoContent
+	default:
+		response.Status = http.StatusOK
+		response.Body = result.User
+	}
+
+	return response
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+	"user-microservice/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func newBatchRouter(mockService *MockUserService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{AdminAPIKey: "admin-key"}, logger)
+
+	handler := NewUserHandler(mockService, authenticator, logger)
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+	return r
+}
+
+func TestBatchUsers(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newBatchRouter(mockService)
+
+	body := `{"operations": [
+		{"ref": "a", "op": "create", "user": {"first_name": "Ann", "last_name": "Doe", "nickname": "ann", "password": "password123", "email": "ann@example.com", "country": "PT"}},
+		{"ref": "b", "op": "update", "id": "42", "user": {"first_name": "Bob"}},
+		{"ref": "c", "op": "delete", "id": "43"}
+	]}`
+
+	ops := []service.BatchOperation{
+		{Ref: "a", Action: service.BatchCreate, FirstName: "Ann", LastName: "Doe", Nickname: "ann", Password: "password123", Email: "ann@example.com", Country: "PT"},
+		{Ref: "b", Action: service.BatchUpdate, ID: "42", FirstName: "Bob"},
+		{Ref: "c", Action: service.BatchDelete, ID: "43"},
+	}
+	mockService.On("ApplyBatch", mock.Anything, ops, false).Return([]service.BatchOperationResult{
+		{Ref: "a", Action: service.BatchCreate, User: &models.User{ID: "1", Nickname: "ann"}},
+		{Ref: "b", Action: service.BatchUpdate, Err: errors.Wrap(service.ErrUserNotFound, "error fetching user for update")},
+		{Ref: "c", Action: service.BatchDelete},
+	}, nil).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/batch?mode=best_effort", strings.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Mode    string `json:"mode"`
+		Results []struct {
+			Ref    string          `json:"ref"`
+			Status int             `json:"status"`
+			Body   json.RawMessage `json:"body"`
+		} `json:"results"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "best_effort", response.Mode)
+	if assert.Len(t, response.Results, 3) {
+		assert.Equal(t, http.StatusCreated, response.Results[0].Status)
+		assert.Contains(t, string(response.Results[0].Body), `"nickname":"ann"`)
+		assert.Equal(t, http.StatusNotFound, response.Results[1].Status)
+		assert.Contains(t, string(response.Results[1].Body), "user not found")
+		assert.Equal(t, http.StatusNoContent, response.Results[2].Status)
+		assert.Empty(t, response.Results[2].Body)
+	}
+	mockService.AssertExpectations(t)
+}
+
+func TestBatchUsers_AtomicAbort(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newBatchRouter(mockService)
+
+	mockService.On("ApplyBatch", mock.Anything, mock.Anything, true).Return([]service.BatchOperationResult{
+		{Ref: "a", Action: service.BatchCreate, Err: errors.Wrap(service.ErrBatchAborted, `operation "b" failed`)},
+		{Ref: "b", Action: service.BatchCreate, Err: service.ErrEmailAlreadyExists},
+	}, nil).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader(`{"operations": [{"ref": "a", "op": "create"}, {"ref": "b", "op": "create"}]}`))
+	req.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response BatchUsersResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "atomic", response.Mode)
+	if assert.Len(t, response.Results, 2) {
+		assert.Equal(t, http.StatusFailedDependency, response.Results[0].Status)
+		assert.Equal(t, http.StatusConflict, response.Results[1].Status)
+	}
+	mockService.AssertExpectations(t)
+}
+
+func TestBatchUsers_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		apiKey string
+		body   string
+		status int
+	}{
+		{"missing api key", "/users/batch", "", `{"operations": []}`, http.StatusUnauthorized},
+		{"unknown mode", "/users/batch?mode=sometimes", "admin-key", `{"operations": []}`, http.StatusBadRequest},
+		{"invalid body", "/users/batch", "admin-key", `{"operations": `, http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockUserService)
+			router := newBatchRouter(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+			if tc.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			mockService.AssertNotCalled(t, "ApplyBatch", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// exportFlushInterval is the number of rows written between flushes, so
+// clients see progress without a flush per row
+const exportFlushInterval = 500
+
+var exportCSVHeader = []string{"id", "first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at"}
+
+// userEncoder writes users in an export format
+type userEncoder interface {
+	Encode(user *models.User) error
+	Flush() error
+}
+
+type ndjsonEncoder struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func newNDJSONEncoder(w io.Writer) *ndjsonEncoder {
+	buffered := bufio.NewWriter(w)
+	return &ndjsonEncoder{w: buffered, enc: json.NewEncoder(buffered)}
+}
+
+func (e *ndjsonEncoder) Encode(user *models.User) error {
+	return e.enc.Encode(user)
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func newCSVEncoder(w io.Writer) (*csvEncoder, error) {
+	e := &csvEncoder{w: csv.NewWriter(w)}
+	return e, e.w.Write(exportCSVHeader)
+}
+
+func (e *csvEncoder) Encode(user *models.User) error {
+	return e.w.Write([]string{
+		user.ID,
+		user.FirstName,
+		user.LastName,
+		user.Nickname,
+		user.Email,
+		user.Country,
+		user.CreatedAt.Format(time.RFC3339Nano),
+		user.UpdatedAt.Format(time.RFC3339Nano),
+	})
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// @Summary: Export users
+// @Description: Stream every user matching the filters as NDJSON or CSV, oldest first. The response is gzip-compressed when the client accepts it.
+// @Tags: users
+// @Produce: application/x-ndjson
+// @Produce: text/csv
+// @Param format query string false "ndjson or csv" default(ndjson)
+// @Param country query string false "Country, as eq:, in: or a plain value"
+// @Param nickname query string false "Nickname, as eq:, prefix:, contains:, in: or a plain value"
+// @Param lastname query string false "Last name, as eq:, prefix:, contains:, in: or a plain value"
+// @Param email query string false "Email, as eq:, prefix:, contains:, in: or a plain value"
+// @Param firstname query string false "First name, as eq:, prefix:, contains:, in: or a plain value"
+// @Param created_after query string false "Created at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+// @Param updated_after query string false "Updated at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param updated_before query string false "Updated before (RFC 3339 or YYYY-MM-DD)"
+// @Success 200 {string} string "Stream of users"
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/export [get]
+func (h *UserHandler) ExportUsers(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+
+	var contentType string
+	switch format {
+	case "ndjson":
+		contentType = "application/x-ndjson"
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+	default:
+		h.respondWithError(w, http.StatusBadRequest, errors.New("format must be ndjson or csv"))
+		return
+	}
+
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// exports outlive the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warn("error clearing write deadline", zap.Error(err))
+	}
+
+	gzipped := acceptsGzip(r)
+
+	// headers are sent with the first byte of the body, so errors before any
+	// row is written still get a proper status code
+	started := false
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="users.`+format+`"`)
+		if gzipped {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	var out io.Writer = writerFunc(func(p []byte) (int, error) {
+		start()
+		return w.Write(p)
+	})
+	var gz *gzip.Writer
+	if gzipped {
+		gz = gzip.NewWriter(out)
+		out = gz
+	}
+
+	var encoder userEncoder
+	if format == "csv" {
+		encoder, err = newCSVEncoder(out)
+	} else {
+		encoder = newNDJSONEncoder(out)
+	}
+
+	flush := func() error {
+		start()
+		if err := encoder.Flush(); err != nil {
+			return err
+		}
+		if gz != nil {
+			if err := gz.Flush(); err != nil {
+				return err
+			}
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		return nil
+	}
+
+	rows := 0
+	if err == nil {
+		err = h.service.ExportUsers(r.Context(), filter, func(user *models.User) error {
+			if err := encoder.Encode(user); err != nil {
+				return err
+			}
+			rows++
+			if rows%exportFlushInterval == 0 {
+				return flush()
+			}
+			return nil
+		})
+	}
+
+	if err != nil {
+		if !started {
+			h.respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		// the status is already sent; dropping the connection without the
+		// final gzip block and chunk tells the client the export is incomplete
+		h.logger.Error("export aborted",
+			zap.Int("rows", rows),
+			zap.Bool("client_gone", r.Context().Err() != nil),
+			zap.Error(err))
+		panic(http.ErrAbortHandler)
+	}
+
+	if err := flush(); err != nil {
+		h.logger.Error("error flushing export", zap.Error(err))
+		panic(http.ErrAbortHandler)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			h.logger.Error("error closing gzip stream", zap.Error(err))
+		}
+	}
+
+	h.logger.Info("users exported", zap.String("format", format), zap.Int("rows", rows))
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// acceptsGzip reports whether the client accepts gzip-encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
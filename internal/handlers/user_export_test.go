@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+	"user-microservice/internal/repository"
+	"user-microservice/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func newExportRouter(mockService *MockUserService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "analytics", Key: "export-key", Scopes: []string{auth.ScopeUsersExport}}},
+	}, logger)
+
+	handler := NewUserHandler(mockService, authenticator, logger)
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+	handler.RegisterStreamingRoutes(r)
+	return r
+}
+
+func exportUsers() []*models.User {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*models.User{
+		{ID: "1", FirstName: "John", LastName: "Doe", Nickname: "johndoe", Email: "john@example.com", Country: "US", CreatedAt: created, UpdatedAt: created},
+		{ID: "2", FirstName: "Jane", LastName: "Roe, Jr.", Nickname: "janeroe", Email: "jane@example.com", Country: "PT", CreatedAt: created, UpdatedAt: created},
+	}
+}
+
+func TestExportUsers_NDJSONGzip(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newExportRouter(mockService)
+
+	filter := service.FilterOptions{Country: service.StringFilter{Op: repository.OpIn, Values: []string{"US", "PT"}}}
+	mockService.On("ExportUsers", mock.Anything, filter, mock.Anything).Return(exportUsers(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/export?country=in:US,PT", nil)
+	req.Header.Set(auth.APIKeyHeader, "export-key")
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(w.Body)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var ids []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		assert.NotContains(t, scanner.Text(), "password")
+		var user models.User
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &user))
+		ids = append(ids, user.ID)
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, []string{"1", "2"}, ids)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestExportUsers_CSV(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newExportRouter(mockService)
+
+	mockService.On("ExportUsers", mock.Anything, service.FilterOptions{}, mock.Anything).Return(exportUsers(), nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/export?format=csv", nil)
+	req.Header.Set(auth.APIKeyHeader, "export-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.NoError(t, err)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, exportCSVHeader, records[0])
+		assert.Equal(t, "Roe, Jr.", records[2][2])
+		assert.Equal(t, "2024-01-02T03:04:05Z", records[1][6])
+	}
+}
+
+func TestExportUsers_Errors(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newExportRouter(mockService)
+
+	mockService.On("ExportUsers", mock.Anything, service.FilterOptions{}, mock.Anything).Return(nil, errors.New("connection refused"))
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"unknown format", "/users/export?format=xml", "export-key", http.StatusBadRequest},
+		{"missing key", "/users/export", "", http.StatusUnauthorized},
+		{"failure before first row", "/users/export", "export-key", http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(auth.APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+		})
+	}
+}
+
+func TestExportUsers_RoutesCoexistWithUserRoutes(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newExportRouter(mockService)
+
+	mockService.On("GetUserByID", mock.Anything, "123").Return(&models.User{ID: "123"}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/123", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
@@ -3,10 +3,14 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
+	"user-microservice/internal/auth"
 	"user-microservice/internal/models"
 	"user-microservice/internal/service"
+	"user-microservice/internal/webhook"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pkg/errors"
@@ -16,13 +20,15 @@ import (
 // UserHandler manages HTTP requests related to users
 type UserHandler struct {
 	service service.UserServiceInterface
+	auth    *auth.Authenticator
 	logger  *zap.Logger
 }
 
 // NewUserHandler creates a new instance of UserHandler
-func NewUserHandler(service service.UserServiceInterface, logger *zap.Logger) *UserHandler {
+func NewUserHandler(service service.UserServiceInterface, authenticator *auth.Authenticator, logger *zap.Logger) *UserHandler {
 	return &UserHandler{
 		service: service,
+		auth:    authenticator,
 		logger:  logger.With(zap.String("component", "user_handler")),
 	}
 }
@@ -32,6 +38,16 @@ func (h *UserHandler) RegisterRoutes(r chi.Router) {
 	r.Route("/users", func(r chi.Router) {
 		r.Get("/", h.ListUsers)
 		r.Post("/", h.CreateUser)
+		r.Get("/search", h.SearchUsers)
+		r.Post("/batch-get", h.BatchGetUsers)
+		r.With(h.auth.RequireScope(auth.ScopeAdmin)).Post("/batch", h.BatchUsers)
+		r.Group(func(r chi.Router) {
+			r.Use(h.auth.RequireScope(auth.ScopeUsersLookup))
+			r.Get("/by-email/{email}", h.GetUserByEmail)
+			r.Get("/by-nickname/{nickname}", h.GetUserByNickname)
+			r.Post("/lookup", h.LookupUsers)
+		})
+		r.With(h.auth.RequireScope(auth.ScopeUsersImport)).Get("/import/{id}", h.GetImportJob)
 		r.Route("/{id}", func(r chi.Router) {
 			r.Get("/", h.GetUser)
 			r.Put("/", h.UpdateUser)
@@ -41,6 +57,13 @@ func (h *UserHandler) RegisterRoutes(r chi.Router) {
 	})
 }
 
+// RegisterStreamingRoutes registers the long-running routes, which must not
+// be wrapped in a request timeout
+func (h *UserHandler) RegisterStreamingRoutes(r chi.Router) {
+	r.With(h.auth.RequireScope(auth.ScopeUsersExport)).Get("/users/export", h.ExportUsers)
+	r.With(h.auth.RequireScope(auth.ScopeUsersImport)).Post("/users/import", h.ImportUsers)
+}
+
 // CreateUserRequest represents the body of the request to create a user
 type CreateUserRequest struct {
 	FirstName string `json:"first_name"`
@@ -73,16 +96,83 @@ type ErrorResponse struct {
 // ListUsersResponse represents the response for listing users
 type ListUsersResponse struct {
 	Users      []*models.User `json:"users"`
-	TotalCount int            `json:"total_count"`
-	Page       int            `json:"page"`
+	TotalCount *int           `json:"total_count,omitempty"`
+	Page       int            `json:"page,omitempty"`
 	PageSize   int            `json:"page_size"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// SearchUsersResponse represents the response for a user search. It shares
+// the pagination envelope of ListUsersResponse.
+type SearchUsersResponse struct {
+	Users      []*SearchHitResponse `json:"users"`
+	TotalCount *int                 `json:"total_count,omitempty"`
+	Page       int                  `json:"page"`
+	PageSize   int                  `json:"page_size"`
+}
+
+// SearchHitResponse represents a user matched by a search. Highlights maps
+// field names to their HTML-escaped value with matches wrapped in <mark>.
+type SearchHitResponse struct {
+	User       *models.User      `json:"user"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// NicknameLookupResponse represents the response for a nickname lookup
+type NicknameLookupResponse struct {
+	User     *models.User      `json:"user"`
+	Redirect *NicknameRedirect `json:"redirect,omitempty"`
+}
+
+// NicknameRedirect indicates that a previous nickname was resolved to the
+// user's current nickname
+type NicknameRedirect struct {
+	Status   int    `json:"status"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Location string `json:"location"`
+}
+
+// BatchGetUsersRequest represents the body of the request to fetch users by ID
+type BatchGetUsersRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// BatchGetUsersResponse represents the response for fetching users by ID.
+// Users follow the order of the request.
+type BatchGetUsersResponse struct {
+	Users   []*models.User `json:"users"`
+	Missing []string       `json:"missing"`
+}
+
+// LookupUsersRequest represents the body of the request to look up users
+type LookupUsersRequest struct {
+	Identifiers []string `json:"identifiers"`
+}
+
+// LookupUsersResponse represents the response for a user lookup. Users is
+// keyed by the identifier as it was sent.
+type LookupUsersResponse struct {
+	Users    map[string]*models.User `json:"users"`
+	NotFound []string                `json:"not_found"`
 }
 
 // respondWithJSON sends a JSON response
 func (h *UserHandler) respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	writeJSON(w, h.logger, code, payload)
+}
+
+// respondWithError sends an error response
+func (h *UserHandler) respondWithError(w http.ResponseWriter, code int, err error) {
+	writeError(w, h.logger, code, err)
+}
+
+// writeJSON sends a JSON response
+func writeJSON(w http.ResponseWriter, logger *zap.Logger, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
-		h.logger.Error("error serializing response", zap.Error(err))
+		logger.Error("error serializing response", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "internal server error"})
 		return
@@ -93,23 +183,55 @@ func (h *UserHandler) respondWithJSON(w http.ResponseWriter, code int, payload i
 	w.Write(response)
 }
 
-// respondWithError sends an error response
-func (h *UserHandler) respondWithError(w http.ResponseWriter, code int, err error) {
-	h.logger.Error("error in request",
+// writeError sends an error response, mapping known errors to their HTTP status code
+func writeError(w http.ResponseWriter, logger *zap.Logger, code int, err error) {
+	code = statusForError(err, code)
+
+	logger.Error("error in request",
 		zap.Int("status", code),
 		zap.Error(err))
 
-	// Map known errors to appropriate HTTP status codes
-	if errors.Is(err, service.ErrInvalidInput) {
-		code = http.StatusBadRequest
-	} else if errors.Is(err, service.ErrEmailAlreadyExists) ||
-		errors.Is(err, service.ErrNicknameAlreadyExists) {
-		code = http.StatusConflict
-	} else if errors.Is(err, service.ErrUserNotFound) {
-		code = http.StatusNotFound
+	writeJSON(w, logger, code, ErrorResponse{Error: err.Error()})
+}
+
+// pathParam returns the unescaped URL parameter. chi returns the raw value
+// when the path contains escapes such as "%40" for "@".
+func pathParam(r *http.Request, name string) string {
+	value := chi.URLParam(r, name)
+	if unescaped, err := url.PathUnescape(value); err == nil {
+		return unescaped
 	}
+	return value
+}
 
-	h.respondWithJSON(w, code, ErrorResponse{Error: err.Error()})
+// statusForError maps known errors to appropriate HTTP status codes
+func statusForError(err error, code int) int {
+	switch {
+	case errors.Is(err, service.ErrInvalidInput),
+		errors.Is(err, service.ErrInvalidCursor),
+		errors.Is(err, service.ErrInvalidSort),
+		errors.Is(err, service.ErrInvalidFilter),
+		errors.Is(err, service.ErrNicknameNotAllowed),
+		errors.Is(err, service.ErrNicknameNotReserved),
+		errors.Is(err, webhook.ErrInvalidSubscription):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrEmailAlreadyExists),
+		errors.Is(err, service.ErrNicknameAlreadyExists),
+		errors.Is(err, service.ErrNicknameConfusable),
+		errors.Is(err, service.ErrNicknameQuarantined),
+		errors.Is(err, service.ErrUserNotVerified),
+		errors.Is(err, service.ErrUserAlreadyExists):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrNicknameCooldown):
+		return http.StatusTooManyRequests
+	case errors.Is(err, service.ErrBatchAborted):
+		return http.StatusFailedDependency
+	case errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrImportJobNotFound),
+		errors.Is(err, webhook.ErrSubscriptionNotFound):
+		return http.StatusNotFound
+	}
+	return code
 }
 
 // @Summary: Create a new user
@@ -118,8 +240,11 @@ func (h *UserHandler) respondWithError(w http.ResponseWriter, code int, err erro
 // @Accept: json
 // @Produce: json
 // @Param user body CreateUserRequest true "User details"
+// @Param Idempotency-Key header string false "Replays the first response when the request is retried with the same key"
 // @Success 201 {object} models.User
 // @Failure 400 {object} ErrorResponse
+// @Failure 409 {object} ErrorResponse
+// @Failure 422 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /users [post]
 func (h *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
@@ -172,6 +297,132 @@ func (h *UserHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	h.respondWithJSON(w, http.StatusOK, user)
 }
 
+// @Summary: Get users by ID
+// @Description: Retrieve a batch of users by ID in one request, in request order. Unknown and malformed IDs are listed as missing.
+// @Tags: users
+// @Accept: json
+// @Produce: json
+// @Param batch body BatchGetUsersRequest true "User IDs"
+// @Success 200 {object} BatchGetUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/batch-get [post]
+func (h *UserHandler) BatchGetUsers(w http.ResponseWriter, r *http.Request) {
+	var req BatchGetUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	result, err := h.service.GetUsersByIDs(r.Context(), req.IDs)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, BatchGetUsersResponse{
+		Users:   result.Users,
+		Missing: result.Missing,
+	})
+}
+
+// @Summary: Get a user by email
+// @Description: Retrieve a user by their email, matched case-insensitively
+// @Tags: users
+// @Produce: json
+// @Param email path string true "Email"
+// @Success 200 {object} models.User
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/by-email/{email} [get]
+func (h *UserHandler) GetUserByEmail(w http.ResponseWriter, r *http.Request) {
+	email := pathParam(r, "email")
+	if email == "" {
+		h.respondWithError(w, http.StatusBadRequest, errors.New("email is required"))
+		return
+	}
+
+	user, err := h.service.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, user)
+}
+
+// @Summary: Look up users
+// @Description: Resolve a batch of mixed identifiers (ids, emails and nicknames) in one request
+// @Tags: users
+// @Accept: json
+// @Produce: json
+// @Param lookup body LookupUsersRequest true "Identifiers"
+// @Success 200 {object} LookupUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/lookup [post]
+func (h *UserHandler) LookupUsers(w http.ResponseWriter, r *http.Request) {
+	var req LookupUsersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.respondWithError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	result, err := h.service.LookupUsers(r.Context(), req.Identifiers)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, LookupUsersResponse{
+		Users:    result.Users,
+		NotFound: result.NotFound,
+	})
+}
+
+// @Summary: Get a user by nickname
+// @Description: Retrieve a user by their current or a previous nickname. A previous nickname resolves to the current user with a redirect indicator.
+// @Tags: users
+// @Produce: json
+// @Param nickname path string true "Nickname"
+// @Success 200 {object} NicknameLookupResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/by-nickname/{nickname} [get]
+func (h *UserHandler) GetUserByNickname(w http.ResponseWriter, r *http.Request) {
+	nickname := pathParam(r, "nickname")
+	if nickname == "" {
+		h.respondWithError(w, http.StatusBadRequest, errors.New("nickname is required"))
+		return
+	}
+
+	lookup, err := h.service.GetUserByNickname(r.Context(), nickname)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := NicknameLookupResponse{User: lookup.User}
+	if lookup.Redirected {
+		response.Redirect = &NicknameRedirect{
+			Status:   http.StatusMovedPermanently,
+			From:     nickname,
+			To:       lookup.User.Nickname,
+			Location: "/users/by-nickname/" + url.PathEscape(lookup.User.Nickname),
+		}
+	}
+
+	h.respondWithJSON(w, http.StatusOK, response)
+}
+
 // @Summary: Update a user by ID
 // @Description: Update a user's details by their ID
 // @Tags: users
@@ -276,58 +527,186 @@ func (h *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 // @Description: Retrieve a list of users with optional filters and pagination
 // @Tags: users
 // @Produce: json
-// @Param country query string false "Country"
-// @Param nickname query string false "Nickname"
-// @Param lastname query string false "Last name"
-// @Param email query string false "Email"
-// @Param firstname query string false "First name"
+// @Param country query string false "Country, as eq:, in: or a plain value"
+// @Param nickname query string false "Nickname, as eq:, prefix:, contains:, in: or a plain value"
+// @Param lastname query string false "Last name, as eq:, prefix:, contains:, in: or a plain value"
+// @Param email query string false "Email, as eq:, prefix:, contains:, in: or a plain value"
+// @Param firstname query string false "First name, as eq:, prefix:, contains:, in: or a plain value"
+// @Param created_after query string false "Created at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param created_before query string false "Created before (RFC 3339 or YYYY-MM-DD)"
+// @Param updated_after query string false "Updated at or after (RFC 3339 or YYYY-MM-DD)"
+// @Param updated_before query string false "Updated before (RFC 3339 or YYYY-MM-DD)"
 // @Param page query int false "Page number" default(1)
 // @Param page_size query int false "Page size" default(10)
+// @Param cursor query string false "Cursor from next_cursor of the previous page; replaces page"
+// @Param include_total query bool false "Include total_count" default(true)
+// @Param sort query string false "Comma-separated sort fields, prefixed with - for descending" default(-created_at)
 // @Success 200 {object} ListUsersResponse
 // @Failure 400 {object} ErrorResponse
 // @Failure 500 {object} ErrorResponse
 // @Router /users [get]
 func (h *UserHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	// Filter parameters
-	country := r.URL.Query().Get("country")
-	nickname := r.URL.Query().Get("nickname")
-	lastname := r.URL.Query().Get("lastname")
-	email := r.URL.Query().Get("email")
-	firstname := r.URL.Query().Get("firstname")
+	filter, err := parseListFilter(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
 
 	// Pagination parameters
-	pageStr := r.URL.Query().Get("page")
-	pageSizeStr := r.URL.Query().Get("page_size")
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.service.ListUsers(r.Context(), filter, opts)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := ListUsersResponse{
+		Users:      result.Users,
+		TotalCount: result.Total,
+		PageSize:   opts.PageSize,
+		NextCursor: result.NextCursor,
+	}
+
+	// page numbers have no meaning when paging by cursor
+	if opts.Cursor == "" {
+		response.Page = opts.Page
+	}
+
+	h.respondWithJSON(w, http.StatusOK, response)
+}
 
-	page := 1
-	pageSize := 10
+// @Summary: Search users
+// @Description: Rank users by name, nickname and email, tolerating partial words and misspellings
+// @Tags: users
+// @Produce: json
+// @Param q query string true "Search query (2 to 100 characters)"
+// @Param page query int false "Page number" default(1)
+// @Param page_size query int false "Page size" default(10)
+// @Param include_total query bool false "Include total_count" default(true)
+// @Success 200 {object} SearchUsersResponse
+// @Failure 400 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/search [get]
+func (h *UserHandler) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.service.SearchUsers(r.Context(), r.URL.Query().Get("q"), opts)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := SearchUsersResponse{
+		Users:      make([]*SearchHitResponse, 0, len(result.Hits)),
+		TotalCount: result.Total,
+		Page:       opts.Page,
+		PageSize:   opts.PageSize,
+	}
+	for _, hit := range result.Hits {
+		response.Users = append(response.Users, &SearchHitResponse{
+			User:       hit.User,
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+		})
+	}
+
+	h.respondWithJSON(w, http.StatusOK, response)
+}
 
-	if pageStr != "" {
+// parseListOptions reads the pagination and sort parameters from the query
+// string. Out of range page and page_size values fall back to the defaults.
+func parseListOptions(query url.Values) (service.ListOptions, error) {
+	opts := service.ListOptions{
+		Page:     1,
+		PageSize: 10,
+		Cursor:   query.Get("cursor"),
+		Sort:     query.Get("sort"),
+	}
+
+	if pageStr := query.Get("page"); pageStr != "" {
 		pageInt, err := strconv.Atoi(pageStr)
 		if err == nil && pageInt > 0 {
-			page = pageInt
+			opts.Page = pageInt
 		}
 	}
 
-	if pageSizeStr != "" {
+	if pageSizeStr := query.Get("page_size"); pageSizeStr != "" {
 		pageSizeInt, err := strconv.Atoi(pageSizeStr)
 		if err == nil && pageSizeInt > 0 && pageSizeInt <= 100 {
-			pageSize = pageSizeInt
+			opts.PageSize = pageSizeInt
 		}
 	}
 
-	users, total, err := h.service.ListUsers(r.Context(), country, email, nickname, firstname, lastname, page, pageSize)
-	if err != nil {
-		h.respondWithError(w, http.StatusInternalServerError, err)
-		return
+	if includeTotal := query.Get("include_total"); includeTotal != "" {
+		include, err := strconv.ParseBool(includeTotal)
+		if err != nil {
+			return opts, errors.Wrap(service.ErrInvalidInput, "include_total must be true or false")
+		}
+		opts.SkipTotal = !include
 	}
 
-	response := ListUsersResponse{
-		Users:      users,
-		TotalCount: total,
-		Page:       page,
-		PageSize:   pageSize,
+	return opts, nil
+}
+
+// parseListFilter reads the list filters from the query string
+func parseListFilter(query url.Values) (service.FilterOptions, error) {
+	var filter service.FilterOptions
+
+	fields := []struct {
+		param  string
+		target *service.StringFilter
+	}{
+		{"country", &filter.Country},
+		{"email", &filter.Email},
+		{"nickname", &filter.Nickname},
+		{"firstname", &filter.FirstName},
+		{"lastname", &filter.LastName},
+	}
+	for _, field := range fields {
+		parsed, err := service.ParseStringFilter(query.Get(field.param))
+		if err != nil {
+			return filter, errors.Wrap(err, field.param)
+		}
+		*field.target = parsed
 	}
 
-	h.respondWithJSON(w, http.StatusOK, response)
+	ranges := []struct {
+		param  string
+		target *time.Time
+	}{
+		{"created_after", &filter.CreatedAt.From},
+		{"created_before", &filter.CreatedAt.To},
+		{"updated_after", &filter.UpdatedAt.From},
+		{"updated_before", &filter.UpdatedAt.To},
+	}
+	for _, r := range ranges {
+		value := query.Get(r.param)
+		if value == "" {
+			continue
+		}
+		parsed, err := parseTimeParam(value)
+		if err != nil {
+			return filter, errors.Wrapf(service.ErrInvalidFilter, "%s must be an RFC 3339 timestamp or a YYYY-MM-DD date", r.param)
+		}
+		*r.target = parsed
+	}
+
+	return filter, nil
+}
+
+func parseTimeParam(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
 }
@@ -6,10 +6,18 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
 	"user-microservice/internal/models"
+	"user-microservice/internal/repository"
+	"user-microservice/internal/service"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
@@ -52,18 +60,133 @@ func (m *MockUserService) DeleteUser(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
-func (m *MockUserService) ListUsers(ctx context.Context, country, email, nickname, firstname, lastname string, page, pageSize int) ([]*models.User, int, error) {
-	args := m.Called(ctx, country, email, nickname, firstname, lastname, page, pageSize)
+func (m *MockUserService) ListUsers(ctx context.Context, filter service.FilterOptions, opts service.ListOptions) (*service.ListResult, error) {
+	args := m.Called(ctx, filter, opts)
+	if result, ok := args.Get(0).(*service.ListResult); ok {
+		return result, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) GrantReservedNickname(ctx context.Context, nickname, userID, grantedBy string) (*models.NicknameGrant, error) {
+	args := m.Called(ctx, nickname, userID, grantedBy)
+	if grant, ok := args.Get(0).(*models.NicknameGrant); ok {
+		return grant, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) VerifyUser(ctx context.Context, id string) (*models.User, error) {
+	args := m.Called(ctx, id)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) GetUserByNickname(ctx context.Context, nickname string) (*service.NicknameLookup, error) {
+	args := m.Called(ctx, nickname)
+	if lookup, ok := args.Get(0).(*service.NicknameLookup); ok {
+		return lookup, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	args := m.Called(ctx, email)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) LookupUsers(ctx context.Context, identifiers []string) (*service.LookupResult, error) {
+	args := m.Called(ctx, identifiers)
+	if result, ok := args.Get(0).(*service.LookupResult); ok {
+		return result, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) GetUsersByIDs(ctx context.Context, ids []string) (*service.BatchGetResult, error) {
+	args := m.Called(ctx, ids)
+	if result, ok := args.Get(0).(*service.BatchGetResult); ok {
+		return result, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) SearchUsers(ctx context.Context, query string, opts service.ListOptions) (*service.SearchResult, error) {
+	args := m.Called(ctx, query, opts)
+	if result, ok := args.Get(0).(*service.SearchResult); ok {
+		return result, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+// ExportUsers passes the users given to Return to fn before returning the error
+func (m *MockUserService) ExportUsers(ctx context.Context, filter service.FilterOptions, fn func(*models.User) error) error {
+	args := m.Called(ctx, filter, fn)
 	if users, ok := args.Get(0).([]*models.User); ok {
-		return users, args.Int(1), args.Error(2)
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockUserService) ImportUsers(ctx context.Context, rows []service.ImportRow, dryRun bool) (*models.ImportReport, error) {
+	args := m.Called(ctx, rows, dryRun)
+	if report, ok := args.Get(0).(*models.ImportReport); ok {
+		return report, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) StartImport(ctx context.Context, rows []service.ImportRow, dryRun bool) (*models.ImportJob, error) {
+	args := m.Called(ctx, rows, dryRun)
+	if job, ok := args.Get(0).(*models.ImportJob); ok {
+		return job, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) GetImportJob(ctx context.Context, id string) (*models.ImportJob, error) {
+	args := m.Called(ctx, id)
+	if job, ok := args.Get(0).(*models.ImportJob); ok {
+		return job, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserService) ApplyBatch(ctx context.Context, ops []service.BatchOperation, atomic bool) ([]service.BatchOperationResult, error) {
+	args := m.Called(ctx, ops, atomic)
+	if results, ok := args.Get(0).([]service.BatchOperationResult); ok {
+		return results, args.Error(1)
 	}
-	return nil, args.Int(1), args.Error(2)
+	return nil, args.Error(1)
+}
+
+func newLookupRouter(mockService *MockUserService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{
+			{Name: "feed", Key: "lookup-key", Scopes: []string{auth.ScopeUsersLookup}},
+			{Name: "other", Key: "other-key"},
+		},
+	}, logger)
+
+	r := chi.NewRouter()
+	NewUserHandler(mockService, authenticator, logger).RegisterRoutes(r)
+	return r
 }
 
 func TestCreateUser_Success(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	reqBody := CreateUserRequest{
 		FirstName: "John",
@@ -99,7 +222,7 @@ func TestCreateUser_Success(t *testing.T) {
 func TestCreateUser_InvalidBody(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader([]byte(`invalid json`)))
 	w := httptest.NewRecorder()
@@ -115,7 +238,7 @@ func TestCreateUser_InvalidBody(t *testing.T) {
 func TestGetUser_Success(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	user := &models.User{
 		ID:    "123",
@@ -141,10 +264,136 @@ func TestGetUser_Success(t *testing.T) {
 	mockService.AssertExpectations(t)
 }
 
+func TestGetUserByNickname_Redirect(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	user := &models.User{ID: "123", Nickname: "newname"}
+	mockService.On("GetUserByNickname", mock.Anything, "oldname").
+		Return(&service.NicknameLookup{User: user, Redirected: true}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/by-nickname/oldname", nil)
+	reqCtx := chi.NewRouteContext()
+	reqCtx.URLParams.Add("nickname", "oldname")
+	req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, reqCtx))
+
+	w := httptest.NewRecorder()
+
+	handler.GetUserByNickname(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body NicknameLookupResponse
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	if assert.NotNil(t, body.Redirect) {
+		assert.Equal(t, http.StatusMovedPermanently, body.Redirect.Status)
+		assert.Equal(t, "oldname", body.Redirect.From)
+		assert.Equal(t, "newname", body.Redirect.To)
+	}
+
+	mockService.AssertExpectations(t)
+}
+
+func TestGetUserByEmail(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newLookupRouter(mockService)
+
+	user := &models.User{ID: "123", Email: "john@example.com"}
+	mockService.On("GetUserByEmail", mock.Anything, "john@example.com").Return(user, nil).Once()
+	mockService.On("GetUserByEmail", mock.Anything, "jane@example.com").Return(nil, service.ErrUserNotFound).Once()
+
+	tests := []struct {
+		name   string
+		path   string
+		key    string
+		status int
+	}{
+		{"found", "/users/by-email/john%40example.com", "lookup-key", http.StatusOK},
+		{"not found", "/users/by-email/jane@example.com", "lookup-key", http.StatusNotFound},
+		{"missing key", "/users/by-email/john@example.com", "", http.StatusUnauthorized},
+		{"missing scope", "/users/by-email/john@example.com", "other-key", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set(auth.APIKeyHeader, tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	mockService.AssertExpectations(t)
+}
+
+func TestLookupUsers(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newLookupRouter(mockService)
+
+	identifiers := []string{"john@example.com", "ghost"}
+	mockService.On("LookupUsers", mock.Anything, identifiers).Return(&service.LookupResult{
+		Users:    map[string]*models.User{"john@example.com": {ID: "123", Email: "john@example.com"}},
+		NotFound: []string{"ghost"},
+	}, nil)
+
+	body, _ := json.Marshal(LookupUsersRequest{Identifiers: identifiers})
+	req := httptest.NewRequest(http.MethodPost, "/users/lookup", bytes.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "lookup-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response LookupUsersResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, "123", response.Users["john@example.com"].ID)
+	assert.Equal(t, []string{"ghost"}, response.NotFound)
+
+	mockService.AssertExpectations(t)
+}
+
+func TestBatchGetUsers(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	ids := []string{"b", "a", "missing"}
+	mockService.On("GetUsersByIDs", mock.Anything, ids).Return(&service.BatchGetResult{
+		Users:   []*models.User{{ID: "b"}, {ID: "a"}},
+		Missing: []string{"missing"},
+	}, nil)
+
+	body, _ := json.Marshal(BatchGetUsersRequest{IDs: ids})
+	req := httptest.NewRequest(http.MethodPost, "/users/batch-get", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handler.BatchGetUsers(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response BatchGetUsersResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Len(t, response.Users, 2)
+	assert.Equal(t, "b", response.Users[0].ID)
+	assert.Equal(t, []string{"missing"}, response.Missing)
+
+	mockService.AssertExpectations(t)
+}
+
 func TestGetUser_MissingID(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	req := httptest.NewRequest(http.MethodGet, "/users/", nil)
 	reqCtx := chi.NewRouteContext()
@@ -162,7 +411,7 @@ func TestGetUser_MissingID(t *testing.T) {
 func TestUpdateUser_Success(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	reqBody := UpdateUserRequest{
 		FirstName: "Updated",
@@ -195,7 +444,7 @@ func TestUpdateUser_Success(t *testing.T) {
 func TestDeleteUser_Success(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	userID := "123"
 
@@ -221,7 +470,7 @@ func TestDeleteUser_Success(t *testing.T) {
 func TestListUsers_Success(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	users := []*models.User{
 		{ID: "123", Email: "john@example.com"},
@@ -229,7 +478,8 @@ func TestListUsers_Success(t *testing.T) {
 	}
 	total := 2
 
-	mockService.On("ListUsers", mock.Anything, "", "", "", "", "", 1, 10).Return(users, total, nil)
+	mockService.On("ListUsers", mock.Anything, service.FilterOptions{}, service.ListOptions{Page: 1, PageSize: 10}).
+		Return(&service.ListResult{Users: users, Total: &total, NextCursor: "next"}, nil)
 
 	req := httptest.NewRequest(http.MethodGet, "/users?page=1&page_size=10", nil)
 
@@ -246,17 +496,134 @@ func TestListUsers_Success(t *testing.T) {
 	err := json.NewDecoder(resp.Body).Decode(&response)
 	assert.NoError(t, err)
 
-	assert.Equal(t, total, response.TotalCount)
+	if assert.NotNil(t, response.TotalCount) {
+		assert.Equal(t, total, *response.TotalCount)
+	}
+	assert.Equal(t, "next", response.NextCursor)
 
 	assert.Equal(t, len(users), len(response.Users))
 
 	mockService.AssertExpectations(t)
 }
 
+func TestListUsers_CursorWithoutTotal(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	mockService.On("ListUsers", mock.Anything, service.FilterOptions{}, service.ListOptions{Page: 1, PageSize: 10, Cursor: "abc", SkipTotal: true}).
+		Return(&service.ListResult{Users: []*models.User{}}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users?cursor=abc&include_total=false", nil)
+	w := httptest.NewRecorder()
+
+	handler.ListUsers(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.NotContains(t, response, "total_count")
+	assert.NotContains(t, response, "page")
+	assert.NotContains(t, response, "next_cursor")
+
+	mockService.AssertExpectations(t)
+}
+
+func TestListUsers_InvalidSort(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	mockService.On("ListUsers", mock.Anything, service.FilterOptions{}, service.ListOptions{Page: 1, PageSize: 10, Sort: "password"}).
+		Return(nil, errors.Wrap(service.ErrInvalidSort, "unknown sort field \"password\""))
+
+	req := httptest.NewRequest(http.MethodGet, "/users?sort=password", nil)
+	w := httptest.NewRecorder()
+
+	handler.ListUsers(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestListUsers_FilterOperators(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	expected := service.FilterOptions{
+		Country:   service.StringFilter{Op: repository.OpIn, Values: []string{"PT", "ES"}},
+		Nickname:  service.StringFilter{Op: repository.OpPrefix, Values: []string{"jo"}},
+		LastName:  repository.Eq("Doe"),
+		CreatedAt: service.TimeRange{From: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	mockService.On("ListUsers", mock.Anything, expected, service.ListOptions{Page: 1, PageSize: 10}).
+		Return(&service.ListResult{Users: []*models.User{}}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users?country=in:PT,ES&nickname=prefix:jo&lastname=Doe&created_after=2024-01-01", nil)
+	w := httptest.NewRecorder()
+
+	handler.ListUsers(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestListUsers_InvalidFilter(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	for _, query := range []string{"country=in:", "nickname=prefix:", "created_before=yesterday"} {
+		req := httptest.NewRequest(http.MethodGet, "/users?"+query, nil)
+		w := httptest.NewRecorder()
+
+		handler.ListUsers(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+
+	mockService.AssertNotCalled(t, "ListUsers", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchUsers(t *testing.T) {
+	mockService := new(MockUserService)
+	logger := zap.NewNop()
+	handler := NewUserHandler(mockService, nil, logger)
+
+	total := 1
+	mockService.On("SearchUsers", mock.Anything, "jon", service.ListOptions{Page: 2, PageSize: 5}).Return(&service.SearchResult{
+		Hits: []*service.SearchHit{{
+			User:       &models.User{ID: "123", FirstName: "Jonathan"},
+			Score:      0.75,
+			Highlights: map[string]string{"first_name": "<mark>Jon</mark>athan"},
+		}},
+		Total: &total,
+	}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/users/search?q=jon&page=2&page_size=5", nil)
+	w := httptest.NewRecorder()
+
+	handler.SearchUsers(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response SearchUsersResponse
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, 2, response.Page)
+	if assert.Len(t, response.Users, 1) {
+		assert.Equal(t, "123", response.Users[0].User.ID)
+		assert.Equal(t, "<mark>Jon</mark>athan", response.Users[0].Highlights["first_name"])
+	}
+
+	mockService.AssertExpectations(t)
+}
+
 func TestUpdatePassword(t *testing.T) {
 	mockService := new(MockUserService)
 	logger := zap.NewNop()
-	handler := NewUserHandler(mockService, logger)
+	handler := NewUserHandler(mockService, nil, logger)
 
 	reqBody := UpdatePasswordRequest{
 		Password: "newpassword",
@@ -281,3 +648,73 @@ func TestUpdatePassword(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 	mockService.AssertExpectations(t)
 }
+
+// uniqueUserRepository enforces email and nickname uniqueness the way the
+// database unique indexes do. Methods the test does not need are left to the
+// embedded nil interface.
+type uniqueUserRepository struct {
+	repository.UserRepository
+	mu        sync.Mutex
+	emails    map[string]bool
+	nicknames map[string]bool
+}
+
+func (r *uniqueUserRepository) WithinTransaction(ctx context.Context, fn func(repo repository.UserRepository) error) error {
+	return fn(r)
+}
+
+func (r *uniqueUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.emails[strings.ToLower(user.Email)] {
+		return repository.ErrEmailAlreadyExists
+	}
+	if r.nicknames[strings.ToLower(user.Nickname)] {
+		return repository.ErrNicknameAlreadyExists
+	}
+	r.emails[strings.ToLower(user.Email)] = true
+	r.nicknames[strings.ToLower(user.Nickname)] = true
+	return nil
+}
+
+func TestCreateUser_ConcurrentDuplicates(t *testing.T) {
+	repo := &uniqueUserRepository{emails: map[string]bool{}, nicknames: map[string]bool{}}
+	logger := zap.NewNop()
+	handler := NewUserHandler(service.NewUserService(repo, logger, nil), nil, logger)
+
+	body, _ := json.Marshal(CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Nickname:  "jdoe",
+		Password:  "password123",
+		Email:     "john@example.com",
+		Country:   "US",
+	})
+
+	start := make(chan struct{})
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+			<-start
+			handler.CreateUser(w, req)
+			codes <- w.Code
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+
+	assert.Equal(t, 1, counts[http.StatusCreated])
+	assert.Equal(t, 1, counts[http.StatusConflict])
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-microservice/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// MaxImportBodyBytes bounds the size of an uploaded import file
+const MaxImportBodyBytes = 32 << 20
+
+// maxImportLineBytes bounds a single NDJSON line
+const maxImportLineBytes = 64 << 10
+
+// importCSVColumns are the columns an import CSV header must contain, in any order
+var importCSVColumns = []string{"first_name", "last_name", "nickname", "password", "email", "country"}
+
+// @Summary: Import users
+// @Description: Create users from a CSV or NDJSON file and report the outcome of every row. CSV files need a header row with first_name, last_name, nickname, password, email and country; NDJSON lines have the shape of CreateUserRequest. Rows duplicating an earlier row or an existing user fail. With async=true a job is returned to poll instead of the report.
+// @Tags: users
+// @Accept: text/csv
+// @Accept: application/x-ndjson
+// @Produce: json
+// @Param format query string false "csv or ndjson; inferred from Content-Type when omitted"
+// @Param dry_run query bool false "Validate without creating users"
+// @Param async query bool false "Run the import in the background and return a job"
+// @Success 200 {object} models.ImportReport
+// @Success 202 {object} models.ImportJob
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 413 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/import [post]
+func (h *UserHandler) ImportUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format, err := importFormat(query.Get("format"), r.Header.Get("Content-Type"))
+	if err != nil {
+		h.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var dryRun, async bool
+	if value := query.Get("dry_run"); value != "" {
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, errors.New("dry_run must be true or false"))
+			return
+		}
+	}
+	if value := query.Get("async"); value != "" {
+		if async, err = strconv.ParseBool(value); err != nil {
+			h.respondWithError(w, http.StatusBadRequest, errors.New("async must be true or false"))
+			return
+		}
+	}
+
+	// synchronous imports of large files outlive the server timeouts
+	rc := http.NewResponseController(w)
+	for _, setDeadline := range []func(time.Time) error{rc.SetReadDeadline, rc.SetWriteDeadline} {
+		if err := setDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			h.logger.Warn("error clearing deadline", zap.Error(err))
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, MaxImportBodyBytes)
+	var rows []service.ImportRow
+	if format == "csv" {
+		rows, err = readImportCSV(body)
+	} else {
+		rows, err = readImportNDJSON(body)
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.respondWithError(w, http.StatusRequestEntityTooLarge, errors.Errorf("import file exceeds %d bytes", tooLarge.Limit))
+			return
+		}
+		h.respondWithError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if async {
+		job, err := h.service.StartImport(r.Context(), rows, dryRun)
+		if err != nil {
+			h.respondWithError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Location", "/users/import/"+job.ID)
+		h.respondWithJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	report, err := h.service.ImportUsers(r.Context(), rows, dryRun)
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, report)
+}
+
+// @Summary: Get an import job
+// @Description: Retrieve the status of an asynchronous import and, once completed, its report
+// @Tags: users
+// @Produce: json
+// @Param id path string true "Import job ID"
+// @Success 200 {object} models.ImportJob
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /users/import/{id} [get]
+func (h *UserHandler) GetImportJob(w http.ResponseWriter, r *http.Request) {
+	job, err := h.service.GetImportJob(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		h.respondWithError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.respondWithJSON(w, http.StatusOK, job)
+}
+
+// importFormat returns the import format from the format parameter or,
+// when it is empty, from the Content-Type of the request
+func importFormat(format, contentType string) (string, error) {
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/ndjson", "application/jsonl":
+			format = "ndjson"
+		}
+	}
+
+	if format != "csv" && format != "ndjson" {
+		return "", errors.New("format must be csv or ndjson")
+	}
+	return format, nil
+}
+
+// readImportCSV reads the rows of a CSV file whose first row names the
+// columns. Malformed records become failed rows rather than failing the file.
+// Rows are numbered by the line they start on, counted after the header.
+func readImportCSV(r io.Reader) ([]service.ImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("import file is empty")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading CSV header")
+	}
+	headerLine, _ := reader.FieldPos(0)
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		// spreadsheets often save CSV files with a byte order mark
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importCSVColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, errors.Errorf("CSV header is missing the %s column", name)
+		}
+	}
+
+	// passwords are taken verbatim, other fields are trimmed
+	field := func(record []string, name string) string {
+		i := columns[name]
+		if i >= len(record) {
+			return ""
+		}
+		if name == "password" {
+			return record[i]
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []service.ImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			rows = append(rows, service.ImportRow{Row: parseErr.StartLine - headerLine, Err: errors.Wrap(parseErr.Err, "malformed CSV record")})
+			continue
+		}
+
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, service.ImportRow{
+			Row:       line - headerLine,
+			FirstName: field(record, "first_name"),
+			LastName:  field(record, "last_name"),
+			Nickname:  field(record, "nickname"),
+			Password:  field(record, "password"),
+			Email:     field(record, "email"),
+			Country:   field(record, "country"),
+		})
+	}
+
+	return rows, nil
+}
+
+// readImportNDJSON reads one CreateUserRequest per line, skipping blank
+// lines but counting them in the row numbers. Lines that are not valid JSON
+// become failed rows.
+func readImportNDJSON(r io.Reader) ([]service.ImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxImportLineBytes)
+
+	var rows []service.ImportRow
+	for number := 1; scanner.Scan(); number++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var req CreateUserRequest
+		if err := json.Unmarshal(line, &req); err != nil {
+			rows = append(rows, service.ImportRow{Row: number, Err: errors.New("malformed JSON line")})
+			continue
+		}
+
+		rows = append(rows, service.ImportRow{
+			Row:       number,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Nickname:  req.Nickname,
+			Password:  req.Password,
+			Email:     req.Email,
+			Country:   req.Country,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, errors.Errorf("NDJSON lines must not exceed %d bytes", maxImportLineBytes)
+		}
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, errors.New("import file is empty")
+	}
+	return rows, nil
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+	"user-microservice/internal/service"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func newImportRouter(mockService *MockUserService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{
+		APIKeys: []config.APIKeyConfig{{Name: "onboarding", Key: "import-key", Scopes: []string{auth.ScopeUsersImport}}},
+	}, logger)
+
+	handler := NewUserHandler(mockService, authenticator, logger)
+	r := chi.NewRouter()
+	handler.RegisterRoutes(r)
+	handler.RegisterStreamingRoutes(r)
+	return r
+}
+
+func TestImportUsers_CSV(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newImportRouter(mockService)
+
+	body := "\ufeffEmail,First_Name,last_name,nickname,password,country,notes\n" +
+		"john@example.com, John ,Doe,johndoe, secret123 ,US,vip\n" +
+		"\n" +
+		"\"jane@example.com,Jane\n"
+
+	// the blank line is skipped but counted
+	rows := mock.MatchedBy(func(rows []service.ImportRow) bool {
+		return len(rows) == 2 &&
+			rows[0] == service.ImportRow{Row: 1, FirstName: "John", LastName: "Doe", Nickname: "johndoe", Password: " secret123 ", Email: "john@example.com", Country: "US"} &&
+			rows[1].Row == 3 && rows[1].Err != nil
+	})
+	report := &models.ImportReport{DryRun: true, Total: 2, Valid: 1, Failed: 1, Rows: []models.ImportRowResult{
+		{Row: 1, Status: models.ImportRowValid},
+		{Row: 3, Status: models.ImportRowError, Error: "malformed CSV record: extraneous or missing \" in quoted-field"},
+	}}
+	mockService.On("ImportUsers", mock.Anything, rows, true).Return(report, nil).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import?dry_run=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	req.Header.Set(auth.APIKeyHeader, "import-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.ImportReport
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, *report, response)
+	mockService.AssertExpectations(t)
+}
+
+func TestImportUsers_NDJSONAsync(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newImportRouter(mockService)
+
+	body := `{"first_name":"John","last_name":"Doe","nickname":"johndoe","password":"secret123","email":"john@example.com","country":"US"}
+
+not json
+`
+	rows := mock.MatchedBy(func(rows []service.ImportRow) bool {
+		return len(rows) == 2 &&
+			rows[0].Row == 1 && rows[0].Nickname == "johndoe" && rows[0].Err == nil &&
+			rows[1].Row == 3 && rows[1].Err != nil
+	})
+	job := &models.ImportJob{ID: "0b6f4c1e-7d7a-4d4e-9d8e-2f0c9f5b2a11", Status: models.ImportJobPending, TotalRows: 2}
+	mockService.On("StartImport", mock.Anything, rows, false).Return(job, nil).Once()
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import?format=ndjson&async=true", strings.NewReader(body))
+	req.Header.Set(auth.APIKeyHeader, "import-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, "/users/import/"+job.ID, w.Header().Get("Location"))
+	mockService.AssertExpectations(t)
+}
+
+func TestImportUsers_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		apiKey      string
+		body        string
+		status      int
+	}{
+		{"missing api key", "/users/import?format=csv", "", "", "", http.StatusUnauthorized},
+		{"unknown format", "/users/import", "application/json", "import-key", "{}", http.StatusBadRequest},
+		{"invalid dry_run", "/users/import?format=csv&dry_run=maybe", "", "import-key", "", http.StatusBadRequest},
+		{"missing column", "/users/import?format=csv", "", "import-key", "first_name,last_name,email\nJohn,Doe,john@example.com\n", http.StatusBadRequest},
+		{"empty file", "/users/import?format=ndjson", "", "import-key", "\n\n", http.StatusBadRequest},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockService := new(MockUserService)
+			router := newImportRouter(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			if tc.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.status, w.Code)
+			mockService.AssertNotCalled(t, "ImportUsers", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestGetImportJob(t *testing.T) {
+	mockService := new(MockUserService)
+	router := newImportRouter(mockService)
+
+	job := &models.ImportJob{ID: "0b6f4c1e-7d7a-4d4e-9d8e-2f0c9f5b2a11", Status: models.ImportJobCompleted, Report: &models.ImportReport{Total: 1, Created: 1}}
+	mockService.On("GetImportJob", mock.Anything, job.ID).Return(job, nil).Once()
+	mockService.On("GetImportJob", mock.Anything, "unknown").Return(nil, service.ErrImportJobNotFound).Once()
+
+	req := httptest.NewRequest(http.MethodGet, "/users/import/"+job.ID, nil)
+	req.Header.Set(auth.APIKeyHeader, "import-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.ImportJob
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.ImportJobCompleted, response.Status)
+	assert.Equal(t, 1, response.Report.Created)
+
+	req = httptest.NewRequest(http.MethodGet, "/users/import/unknown", nil)
+	req.Header.Set(auth.APIKeyHeader, "import-key")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockService.AssertExpectations(t)
+}
@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/webhook"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// WebhookHandler manages webhook subscriptions. Every route requires an API
+// key with the webhooks:manage scope.
+type WebhookHandler struct {
+	service webhook.ServiceInterface
+	auth    *auth.Authenticator
+	logger  *zap.Logger
+}
+
+// NewWebhookHandler creates a new instance of WebhookHandler
+func NewWebhookHandler(service webhook.ServiceInterface, authenticator *auth.Authenticator, logger *zap.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		service: service,
+		auth:    authenticator,
+		logger:  logger.With(zap.String("component", "webhook_handler")),
+	}
+}
+
+// RegisterRoutes registers the handler routes on the router
+func (h *WebhookHandler) RegisterRoutes(r chi.Router) {
+	r.Route("/webhooks", func(r chi.Router) {
+		r.Use(h.auth.RequireScope(auth.ScopeWebhooks))
+		r.Post("/", h.CreateSubscription)
+		r.Get("/", h.ListSubscriptions)
+		r.Get("/{id}", h.GetSubscription)
+		r.Patch("/{id}", h.UpdateSubscription)
+		r.Delete("/{id}", h.DeleteSubscription)
+		r.Get("/{id}/deliveries", h.ListDeliveries)
+	})
+}
+
+// @Summary: Create a webhook subscription
+// @Description: Subscribe a URL to events; the secret signing the deliveries is only returned here
+// @Tags: webhooks
+// @Accept: json
+// @Produce: json
+// @Param subscription body webhook.SubscriptionRequest true "Subscription"
+// @Success 201 {object} webhook.Subscription
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks [post]
+func (h *WebhookHandler) CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var req webhook.SubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, h.logger, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	subscription, err := h.service.CreateSubscription(r.Context(), req)
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusCreated, subscription)
+}
+
+// @Summary: List webhook subscriptions
+// @Tags: webhooks
+// @Produce: json
+// @Success 200 {array} webhook.Subscription
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks [get]
+func (h *WebhookHandler) ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	subscriptions, err := h.service.ListSubscriptions(r.Context())
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, subscriptions)
+}
+
+// @Summary: Get a webhook subscription
+// @Tags: webhooks
+// @Produce: json
+// @Param id path string true "Subscription ID"
+// @Success 200 {object} webhook.Subscription
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [get]
+func (h *WebhookHandler) GetSubscription(w http.ResponseWriter, r *http.Request) {
+	subscription, err := h.service.GetSubscription(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, subscription)
+}
+
+// @Summary: Update a webhook subscription
+// @Description: Change the URL or event types, or (re)activate a subscription, resetting its failures
+// @Tags: webhooks
+// @Accept: json
+// @Produce: json
+// @Param id path string true "Subscription ID"
+// @Param update body webhook.SubscriptionUpdate true "Fields to change"
+// @Success 200 {object} webhook.Subscription
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [patch]
+func (h *WebhookHandler) UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	var update webhook.SubscriptionUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, h.logger, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	subscription, err := h.service.UpdateSubscription(r.Context(), chi.URLParam(r, "id"), update)
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, subscription)
+}
+
+// @Summary: Delete a webhook subscription
+// @Tags: webhooks
+// @Param id path string true "Subscription ID"
+// @Success 204
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.DeleteSubscription(r.Context(), chi.URLParam(r, "id")); err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// @Summary: List the deliveries of a webhook subscription
+// @Description: The latest deliveries, newest first, with their status, attempts and last response code
+// @Tags: webhooks
+// @Produce: json
+// @Param id path string true "Subscription ID"
+// @Param limit query int false "Maximum deliveries (default 50, max 500)"
+// @Success 200 {array} webhook.Delivery
+// @Failure 400 {object} ErrorResponse
+// @Failure 401 {object} ErrorResponse
+// @Failure 403 {object} ErrorResponse
+// @Failure 404 {object} ErrorResponse
+// @Failure 500 {object} ErrorResponse
+// @Router /webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	limit := 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, h.logger, http.StatusBadRequest, errors.New("limit must be a positive integer"))
+			return
+		}
+		limit = parsed
+	}
+
+	deliveries, err := h.service.ListDeliveries(r.Context(), chi.URLParam(r, "id"), limit)
+	if err != nil {
+		writeError(w, h.logger, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, h.logger, http.StatusOK, deliveries)
+}
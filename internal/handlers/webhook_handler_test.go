@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+	"user-microservice/internal/webhook"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+// MockWebhookService is a mock of the webhook service for testing
+type MockWebhookService struct {
+	mock.Mock
+}
+
+func (m *MockWebhookService) CreateSubscription(ctx context.Context, req webhook.SubscriptionRequest) (*webhook.Subscription, error) {
+	args := m.Called(ctx, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*webhook.Subscription), args.Error(1)
+}
+
+func (m *MockWebhookService) GetSubscription(ctx context.Context, id string) (*webhook.Subscription, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*webhook.Subscription), args.Error(1)
+}
+
+func (m *MockWebhookService) ListSubscriptions(ctx context.Context) ([]*webhook.Subscription, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*webhook.Subscription), args.Error(1)
+}
+
+func (m *MockWebhookService) UpdateSubscription(ctx context.Context, id string, update webhook.SubscriptionUpdate) (*webhook.Subscription, error) {
+	args := m.Called(ctx, id, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*webhook.Subscription), args.Error(1)
+}
+
+func (m *MockWebhookService) DeleteSubscription(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockWebhookService) ListDeliveries(ctx context.Context, id string, limit int) ([]*webhook.Delivery, error) {
+	args := m.Called(ctx, id, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*webhook.Delivery), args.Error(1)
+}
+
+func newWebhookRouter(mockService *MockWebhookService) http.Handler {
+	logger := zap.NewNop()
+	authenticator := auth.NewAuthenticator(config.AuthConfig{AdminAPIKey: "admin-key"}, logger)
+
+	r := chi.NewRouter()
+	NewWebhookHandler(mockService, authenticator, logger).RegisterRoutes(r)
+	return r
+}
+
+func TestCreateWebhookSubscription_Success(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	req := webhook.SubscriptionRequest{URL: "https://example.com/hooks", EventTypes: []string{"user.created"}}
+	subscription := &webhook.Subscription{ID: "sub-1", URL: req.URL, Secret: "generated-secret", Active: true}
+	mockService.On("CreateSubscription", mock.Anything, req).Return(subscription, nil)
+
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader(body))
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var response webhook.Subscription
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "generated-secret", response.Secret)
+	mockService.AssertExpectations(t)
+}
+
+func TestCreateWebhookSubscription_Invalid(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	mockService.On("CreateSubscription", mock.Anything, mock.Anything).
+		Return(nil, errors.Wrap(webhook.ErrInvalidSubscription, "url must be an absolute http or https URL"))
+
+	httpReq := httptest.NewRequest(http.MethodPost, "/webhooks", bytes.NewReader([]byte(`{"url":"/hooks"}`)))
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWebhookRoutes_RequireAPIKey(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	httpReq := httptest.NewRequest(http.MethodGet, "/webhooks", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	mockService.AssertNotCalled(t, "ListSubscriptions", mock.Anything)
+}
+
+func TestGetWebhookSubscription_NotFound(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	mockService.On("GetSubscription", mock.Anything, "missing").Return(nil, webhook.ErrSubscriptionNotFound)
+
+	httpReq := httptest.NewRequest(http.MethodGet, "/webhooks/missing", nil)
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateWebhookSubscription_Reactivate(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	active := true
+	mockService.On("UpdateSubscription", mock.Anything, "sub-1", webhook.SubscriptionUpdate{Active: &active}).
+		Return(&webhook.Subscription{ID: "sub-1", Active: true}, nil)
+
+	httpReq := httptest.NewRequest(http.MethodPatch, "/webhooks/sub-1", bytes.NewReader([]byte(`{"active":true}`)))
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestDeleteWebhookSubscription_Success(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	mockService.On("DeleteSubscription", mock.Anything, "sub-1").Return(nil)
+
+	httpReq := httptest.NewRequest(http.MethodDelete, "/webhooks/sub-1", nil)
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	mockService.AssertExpectations(t)
+}
+
+func TestListWebhookDeliveries(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	code := http.StatusInternalServerError
+	deliveries := []*webhook.Delivery{{ID: 7, SubscriptionID: "sub-1", Status: webhook.DeliveryPending, Attempts: 2, ResponseCode: &code, Secret: "secret"}}
+	mockService.On("ListDeliveries", mock.Anything, "sub-1", 10).Return(deliveries, nil)
+
+	httpReq := httptest.NewRequest(http.MethodGet, "/webhooks/sub-1/deliveries?limit=10", nil)
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"response_code":500`)
+	assert.NotContains(t, w.Body.String(), "secret")
+	mockService.AssertExpectations(t)
+}
+
+func TestListWebhookDeliveries_InvalidLimit(t *testing.T) {
+	mockService := new(MockWebhookService)
+	router := newWebhookRouter(mockService)
+
+	httpReq := httptest.NewRequest(http.MethodGet, "/webhooks/sub-1/deliveries?limit=none", nil)
+	httpReq.Header.Set(auth.APIKeyHeader, "admin-key")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httpReq)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	mockService.AssertNotCalled(t, "ListDeliveries", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,349 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"time"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// KeyHeader is the request header carrying the idempotency key
+	KeyHeader = "Idempotency-Key"
+
+	// ReplayedHeader marks responses replayed from a previous request
+	ReplayedHeader = "Idempotent-Replayed"
+
+	// maxKeyLength matches the key column
+	maxKeyLength = 255
+
+	// maxBodyBytes bounds the request bodies buffered to fingerprint them
+	maxBodyBytes = 1 << 20
+
+	defaultTTL          = 24 * time.Hour
+	defaultLockTimeout  = time.Minute
+	defaultWaitTimeout  = 10 * time.Second
+	defaultPollInterval = 100 * time.Millisecond
+)
+
+// Response is a stored response, replayed for retries of the same request
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Record is the state of an idempotency key. Response is nil while the
+// first request with the key is still in flight.
+type Record struct {
+	Fingerprint string
+	Response    *Response
+}
+
+// Store persists idempotency keys
+type Store interface {
+	// Acquire claims the key for a request. When another request holds the
+	// key, its record is returned with acquired false; a nil record then
+	// means the key was released meanwhile and Acquire may be retried.
+	Acquire(ctx context.Context, scope, key, fingerprint string, ttl, lockTimeout time.Duration) (record *Record, acquired bool, err error)
+	// Complete stores the response of the request holding the key
+	Complete(ctx context.Context, scope, key string, response *Response) error
+	// Release drops a key whose request did not complete, so it can be retried
+	Release(ctx context.Context, scope, key string) error
+}
+
+// Middleware makes unsafe requests carrying an Idempotency-Key safe to retry:
+// the first response is stored and replayed for retries with the same key
+// and body, while concurrent retries wait for the first request to finish.
+type Middleware struct {
+	store        Store
+	ttl          time.Duration
+	lockTimeout  time.Duration
+	waitTimeout  time.Duration
+	pollInterval time.Duration
+	logger       *zap.Logger
+}
+
+// NewMiddleware creates a Middleware storing keys in store. Zero durations
+// in cfg use the defaults.
+func NewMiddleware(store Store, cfg config.IdempotencyConfig, logger *zap.Logger) *Middleware {
+	m := &Middleware{
+		store:        store,
+		ttl:          cfg.TTL,
+		lockTimeout:  cfg.LockTimeout,
+		waitTimeout:  cfg.WaitTimeout,
+		pollInterval: defaultPollInterval,
+		logger:       logger.With(zap.String("component", "idempotency")),
+	}
+	if m.ttl <= 0 {
+		m.ttl = defaultTTL
+	}
+	if m.lockTimeout <= 0 {
+		m.lockTimeout = defaultLockTimeout
+	}
+	if m.waitTimeout <= 0 {
+		m.waitTimeout = defaultWaitTimeout
+	}
+	return m
+}
+
+var (
+	errBodyTooLarge = errors.New("request body is too large for an idempotent request")
+	errReadingBody  = errors.New("error reading request body")
+)
+
+// bodyReader hashes the body of r and replaces it with a copy that can be
+// read again, released by the returned cleanup once the request is served
+type bodyReader func(w http.ResponseWriter, r *http.Request, hash io.Writer) (cleanup func(), err error)
+
+// Handler applies the middleware to POST requests with an Idempotency-Key;
+// other requests pass through untouched. Bodies are buffered in memory up
+// to 1 MB.
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return m.handler(next, bufferBody)
+}
+
+// Streaming is Handler for long-running routes with bodies of up to
+// maxBytes, which are spooled to a temporary file instead of memory
+func (m *Middleware) Streaming(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return m.handler(next, spoolBody(maxBytes))
+	}
+}
+
+func (m *Middleware) handler(next http.Handler, readBody bodyReader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(KeyHeader)
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			respondWithError(w, http.StatusBadRequest, "Idempotency-Key must not exceed 255 characters")
+			return
+		}
+
+		hash := fingerprintHash(r)
+		cleanup, err := readBody(w, r, hash)
+		switch {
+		case errors.Is(err, errBodyTooLarge):
+			respondWithError(w, http.StatusRequestEntityTooLarge, errBodyTooLarge.Error())
+			return
+		case errors.Is(err, errReadingBody):
+			respondWithError(w, http.StatusBadRequest, errReadingBody.Error())
+			return
+		case err != nil:
+			m.logger.Error("error storing request body", zap.Error(err))
+			respondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		defer cleanup()
+
+		scope := requestScope(r)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+		logger := m.logger.With(zap.String("method", r.Method), zap.String("path", r.URL.Path), zap.String("key", key))
+
+		deadline := time.Now().Add(m.waitTimeout)
+		for {
+			record, acquired, err := m.store.Acquire(r.Context(), scope, key, fingerprint, m.ttl, m.lockTimeout)
+			if err != nil {
+				logger.Error("error acquiring idempotency key", zap.Error(err))
+				respondWithError(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+
+			if acquired {
+				m.serve(w, r, next, scope, key, logger)
+				return
+			}
+
+			if record != nil {
+				if record.Fingerprint != fingerprint {
+					respondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+					return
+				}
+				if record.Response != nil {
+					replay(w, record.Response)
+					return
+				}
+			}
+
+			// the first request is still in flight
+			if time.Now().After(deadline) {
+				w.Header().Set("Retry-After", "1")
+				respondWithError(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+				return
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(m.pollInterval):
+			}
+		}
+	})
+}
+
+// serve runs the request holding the key and stores its response. Server
+// errors are not stored, so the request can be retried.
+func (m *Middleware) serve(w http.ResponseWriter, r *http.Request, next http.Handler, scope, key string, logger *zap.Logger) {
+	// the outcome must be recorded even if the client goes away
+	ctx := context.WithoutCancel(r.Context())
+	recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+
+	completed := false
+	defer func() {
+		if !completed {
+			if err := m.store.Release(ctx, scope, key); err != nil {
+				logger.Error("error releasing idempotency key", zap.Error(err))
+			}
+		}
+	}()
+
+	next.ServeHTTP(recorder, r)
+
+	if recorder.status >= http.StatusInternalServerError {
+		return
+	}
+
+	response := &Response{
+		StatusCode: recorder.status,
+		Header:     w.Header().Clone(),
+		Body:       recorder.body.Bytes(),
+	}
+	if err := m.store.Complete(ctx, scope, key, response); err != nil {
+		logger.Error("error storing idempotent response", zap.Error(err))
+		return
+	}
+	completed = true
+}
+
+// requestScope namespaces keys by endpoint and caller, so the same key sent
+// with another API key, e.g. after a 401, is a separate request. The scope
+// is hashed to fit its column whatever the length of the path.
+func requestScope(r *http.Request) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path))
+	if apiKey := r.Header.Get(auth.APIKeyHeader); apiKey != "" {
+		apiKeyHash := sha256.Sum256([]byte(apiKey))
+		hash.Write([]byte(" " + hex.EncodeToString(apiKeyHash[:8])))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// fingerprintHash starts the fingerprint identifying the request a key was
+// first used for; the body is hashed as it is read
+func fingerprintHash(r *http.Request) hash.Hash {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + "\n" + r.URL.Path + "\n" + r.URL.RawQuery + "\n"))
+	return hash
+}
+
+// bufferBody keeps bodies of up to maxBodyBytes in memory
+func bufferBody(w http.ResponseWriter, r *http.Request, hash io.Writer) (func(), error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, errors.Wrap(errReadingBody, err.Error())
+	}
+	if len(body) > maxBodyBytes {
+		return nil, errBodyTooLarge
+	}
+	hash.Write(body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return func() {}, nil
+}
+
+// spoolBody copies bodies of up to maxBytes to a temporary file, removed
+// once the request is served
+func spoolBody(maxBytes int64) bodyReader {
+	return func(w http.ResponseWriter, r *http.Request, hash io.Writer) (func(), error) {
+		// large bodies outlive the server read timeout
+		if err := http.NewResponseController(w).SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return nil, errors.Wrap(err, "error clearing read deadline")
+		}
+
+		file, err := os.CreateTemp("", "idempotent-body-*")
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating temporary file")
+		}
+		cleanup := func() {
+			file.Close()
+			os.Remove(file.Name())
+		}
+
+		n, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(r.Body, maxBytes+1))
+		switch {
+		case err != nil:
+			// reading the body failed, unless writing the file did
+			if _, ok := err.(*fs.PathError); ok {
+				err = errors.Wrap(err, "error writing temporary file")
+			} else {
+				err = errors.Wrap(errReadingBody, err.Error())
+			}
+		case n > maxBytes:
+			err = errBodyTooLarge
+		default:
+			_, err = file.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		r.Body = file
+		return cleanup, nil
+	}
+}
+
+func replay(w http.ResponseWriter, response *Response) {
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set(ReplayedHeader, "true")
+	w.WriteHeader(response.StatusCode)
+	w.Write(response.Body)
+}
+
+// responseRecorder captures the response while writing it through
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status = code
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,247 @@
+package idempotency
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"user-microservice/internal/auth"
+	"user-microservice/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// memoryStore is an in-memory Store with the semantics of PostgresStore
+type memoryStore struct {
+	mu      sync.Mutex
+	records map[string]*Record
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{records: map[string]*Record{}}
+}
+
+func (s *memoryStore) Acquire(ctx context.Context, scope, key, fingerprint string, ttl, lockTimeout time.Duration) (*Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.records[scope+key]; ok {
+		copied := *record
+		return &copied, false, nil
+	}
+	s.records[scope+key] = &Record{Fingerprint: fingerprint}
+	return nil, true, nil
+}
+
+func (s *memoryStore) Complete(ctx context.Context, scope, key string, response *Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[scope+key].Response = response
+	return nil
+}
+
+func (s *memoryStore) Release(ctx context.Context, scope, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if record, ok := s.records[scope+key]; ok && record.Response == nil {
+		delete(s.records, scope+key)
+	}
+	return nil
+}
+
+func newTestMiddleware(store Store, handler http.Handler) http.Handler {
+	m := NewMiddleware(store, config.IdempotencyConfig{WaitTimeout: 200 * time.Millisecond}, zap.NewNop())
+	m.pollInterval = 5 * time.Millisecond
+	return m.Handler(handler)
+}
+
+func post(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(KeyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestMiddleware_ReplaysResponse(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestMiddleware(newMemoryStore(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if n > 1 {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+
+	first := post(handler, "key-1", `{"nickname":"ann"}`)
+	retry := post(handler, "key-1", `{"nickname":"ann"}`)
+
+	assert.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get(ReplayedHeader))
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+	assert.Equal(t, `{"nickname":"ann"}`, retry.Body.String())
+	assert.Equal(t, int32(1), calls.Load())
+
+	mismatch := post(handler, "key-1", `{"nickname":"bob"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+	assert.Equal(t, int32(1), calls.Load())
+
+	// requests without a key are not deduplicated
+	post(handler, "", `{"nickname":"ann"}`)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestMiddleware_ScopesKeysByAPIKey(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestMiddleware(newMemoryStore(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if r.Header.Get(auth.APIKeyHeader) != "valid" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	for _, apiKey := range []string{"wrong", "valid"} {
+		req := httptest.NewRequest(http.MethodPost, "/users/batch", strings.NewReader("{}"))
+		req.Header.Set(KeyHeader, "key-1")
+		req.Header.Set(auth.APIKeyHeader, apiKey)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Empty(t, w.Header().Get(ReplayedHeader))
+	}
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRequestScope_FitsColumnForLongPaths(t *testing.T) {
+	long := httptest.NewRequest(http.MethodPost, "/users/"+strings.Repeat("a", 1000), nil)
+	other := httptest.NewRequest(http.MethodPost, "/users/"+strings.Repeat("a", 999)+"b", nil)
+
+	assert.Len(t, requestScope(long), 64)
+	assert.NotEqual(t, requestScope(long), requestScope(other))
+}
+
+func TestMiddleware_ServerErrorsAreRetried(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestMiddleware(newMemoryStore(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	assert.Equal(t, http.StatusInternalServerError, post(handler, "key-1", "{}").Code)
+	assert.Equal(t, http.StatusCreated, post(handler, "key-1", "{}").Code)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestMiddleware_SerializesConcurrentRequests(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var calls atomic.Int32
+	handler := newTestMiddleware(newMemoryStore(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- post(handler, "key-1", "{}") }()
+	<-started
+
+	second := make(chan *httptest.ResponseRecorder)
+	go func() { second <- post(handler, "key-1", "{}") }()
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, http.StatusCreated, (<-first).Code)
+	replayed := <-second
+	assert.Equal(t, http.StatusCreated, replayed.Code)
+	assert.Equal(t, "true", replayed.Header().Get(ReplayedHeader))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestMiddleware_GivesUpWaiting(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := newTestMiddleware(newMemoryStore(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		post(handler, "key-1", "{}")
+		close(done)
+	}()
+	<-started
+
+	w := post(handler, "key-1", "{}")
+	close(release)
+	<-done
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
+
+func TestMiddleware_IgnoresOtherMethods(t *testing.T) {
+	var calls atomic.Int32
+	handler := newTestMiddleware(newMemoryStore(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}))
+
+	for range 2 {
+		req := httptest.NewRequest(http.MethodPut, "/users/1", strings.NewReader("{}"))
+		req.Header.Set(KeyHeader, "key-1")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestMiddleware_StreamingSpoolsLargeBodies(t *testing.T) {
+	var calls atomic.Int32
+	m := NewMiddleware(newMemoryStore(), config.IdempotencyConfig{}, zap.NewNop())
+	handler := m.Streaming(4 << 20)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls.Add(1)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, "%d", len(body))
+	}))
+
+	body := strings.Repeat("a", 2<<20)
+	first := post(handler, "key-1", body)
+	retry := post(handler, "key-1", body)
+
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, strconv.Itoa(len(body)), first.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(ReplayedHeader))
+	assert.Equal(t, int32(1), calls.Load())
+
+	mismatch := post(handler, "key-1", body+"b")
+	assert.Equal(t, http.StatusUnprocessableEntity, mismatch.Code)
+
+	tooLarge := post(handler, "key-2", strings.Repeat("a", 4<<20+1))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, tooLarge.Code)
+	assert.Equal(t, int32(1), calls.Load())
+}
@@ -0,0 +1,141 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// PostgresStore keeps idempotency keys in the idempotency_keys table
+type PostgresStore struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+// NewPostgresStore creates a new PostgresStore
+func NewPostgresStore(db *sqlx.DB, logger *zap.Logger) *PostgresStore {
+	return &PostgresStore{
+		db:     db,
+		logger: logger.With(zap.String("component", "idempotency_store")),
+	}
+}
+
+// Acquire inserts the key, taking over an expired key or one whose request
+// held it past its lock without completing, e.g. because the instance died
+func (s *PostgresStore) Acquire(ctx context.Context, scope, key, fingerprint string, ttl, lockTimeout time.Duration) (*Record, bool, error) {
+	query := `
+		INSERT INTO idempotency_keys (scope, key, fingerprint, locked_until, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (scope, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			status_code = NULL,
+			response_header = NULL,
+			response_body = NULL,
+			locked_until = EXCLUDED.locked_until,
+			created_at = EXCLUDED.created_at,
+			expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+			OR (idempotency_keys.status_code IS NULL AND idempotency_keys.locked_until <= EXCLUDED.created_at)
+		RETURNING key
+	`
+
+	now := time.Now().UTC()
+	var acquired string
+	err := s.db.GetContext(ctx, &acquired, query, scope, key, fingerprint, now.Add(lockTimeout), now, now.Add(ttl))
+	if err == nil {
+		return nil, true, nil
+	}
+	if err != sql.ErrNoRows {
+		s.logger.Error("error acquiring idempotency key", zap.Error(err))
+		return nil, false, errors.Wrap(err, "error acquiring idempotency key")
+	}
+
+	var row struct {
+		Fingerprint string        `db:"fingerprint"`
+		StatusCode  sql.NullInt64 `db:"status_code"`
+		Header      []byte        `db:"response_header"`
+		Body        []byte        `db:"response_body"`
+	}
+	query = `
+		SELECT fingerprint, status_code, response_header, response_body
+		FROM idempotency_keys
+		WHERE scope = $1 AND key = $2
+	`
+	if err := s.db.GetContext(ctx, &row, query, scope, key); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, false, nil
+		}
+		s.logger.Error("error retrieving idempotency key", zap.Error(err))
+		return nil, false, errors.Wrap(err, "error retrieving idempotency key")
+	}
+
+	record := &Record{Fingerprint: row.Fingerprint}
+	if row.StatusCode.Valid {
+		record.Response = &Response{StatusCode: int(row.StatusCode.Int64), Body: row.Body}
+		if err := json.Unmarshal(row.Header, &record.Response.Header); err != nil {
+			return nil, false, errors.Wrap(err, "error decoding stored response headers")
+		}
+	}
+	return record, false, nil
+}
+
+// Complete stores the response and releases the lock on the key
+func (s *PostgresStore) Complete(ctx context.Context, scope, key string, response *Response) error {
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return errors.Wrap(err, "error encoding response headers")
+	}
+
+	query := `
+		UPDATE idempotency_keys
+		SET status_code = $3, response_header = $4, response_body = $5, locked_until = NULL
+		WHERE scope = $1 AND key = $2
+	`
+	if _, err := s.db.ExecContext(ctx, query, scope, key, response.StatusCode, header, response.Body); err != nil {
+		s.logger.Error("error storing idempotent response", zap.Error(err))
+		return errors.Wrap(err, "error storing idempotent response")
+	}
+	return nil
+}
+
+// Release deletes a key that has no stored response
+func (s *PostgresStore) Release(ctx context.Context, scope, key string) error {
+	query := `DELETE FROM idempotency_keys WHERE scope = $1 AND key = $2 AND status_code IS NULL`
+	if _, err := s.db.ExecContext(ctx, query, scope, key); err != nil {
+		s.logger.Error("error releasing idempotency key", zap.Error(err))
+		return errors.Wrap(err, "error releasing idempotency key")
+	}
+	return nil
+}
+
+// PurgeExpired deletes expired keys and returns how many were removed
+func (s *PostgresStore) PurgeExpired(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, time.Now().UTC())
+	if err != nil {
+		s.logger.Error("error purging idempotency keys", zap.Error(err))
+		return 0, errors.Wrap(err, "error purging idempotency keys")
+	}
+	return result.RowsAffected()
+}
+
+// RunPurger purges expired keys every interval until ctx is cancelled
+func (s *PostgresStore) RunPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if purged, err := s.PurgeExpired(ctx); err == nil && purged > 0 {
+				s.logger.Info("expired idempotency keys purged", zap.Int64("count", purged))
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps lower-case Cyrillic, Greek and Latin characters to the
+// Latin letter they are confusable with in the Unicode confusables table.
+// Migration 002 computes skeletons with the same table; keep them in sync.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'о': 'o', 'р': 'p',
+	'с': 'c', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w',
+	// Greek
+	'α': 'a', 'γ': 'y', 'η': 'n', 'ι': 'i', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'υ': 'u', 'χ': 'x',
+	// Latin
+	'ı': 'i', 'ɡ': 'g',
+}
+
+// combiningMarks are the combining diacritical mark blocks stripped from
+// the decomposed nickname
+var combiningMarks = &unicode.RangeTable{R16: []unicode.Range16{
+	{Lo: 0x0300, Hi: 0x036f, Stride: 1},
+	{Lo: 0x1ab0, Hi: 0x1aff, Stride: 1},
+	{Lo: 0x1dc0, Hi: 0x1dff, Stride: 1},
+	{Lo: 0x20d0, Hi: 0x20ff, Stride: 1},
+	{Lo: 0xfe20, Hi: 0xfe2f, Stride: 1},
+}}
+
+// CanonicalEmail trims surrounding whitespace and lower-cases the domain.
+// The local part is kept as entered; uniqueness is enforced case-insensitively.
+func CanonicalEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	return email[:at] + "@" + strings.ToLower(email[at+1:])
+}
+
+// CanonicalNickname trims surrounding whitespace and applies Unicode NFKC
+// normalisation, so full-width and compatibility characters collapse to
+// their plain form
+func CanonicalNickname(nickname string) string {
+	return norm.NFKC.String(strings.TrimSpace(nickname))
+}
+
+// NicknameSkeleton reduces a nickname to a form in which visually
+// confusable nicknames compare equal, e.g. "pаypal" with a Cyrillic "а"
+// and "PayPal" share the skeleton "paypal"
+func NicknameSkeleton(nickname string) string {
+	t := transform.Chain(norm.NFKD, runes.Remove(runes.In(combiningMarks)))
+	decomposed, _, err := transform.String(t, CanonicalNickname(nickname))
+	if err != nil {
+		decomposed = nickname
+	}
+
+	var b strings.Builder
+	for _, r := range strings.ToLower(decomposed) {
+		if mapped, ok := confusables[r]; ok {
+			b.WriteRune(mapped)
+			continue
+		}
+		if r == '_' || r == '-' || r == '.' || unicode.IsSpace(r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalEmail(t *testing.T) {
+	assert.Equal(t, "Alice@example.com", CanonicalEmail("  Alice@EXAMPLE.com "))
+	assert.Equal(t, "alice@example.com", CanonicalEmail("alice@Example.Com"))
+	assert.Equal(t, "not-an-email", CanonicalEmail("not-an-email"))
+}
+
+func TestCanonicalNickname(t *testing.T) {
+	// full-width characters collapse to ASCII under NFKC
+	assert.Equal(t, "Alice", CanonicalNickname(" Ａｌｉｃｅ "))
+	assert.Equal(t, "fi", CanonicalNickname("ﬁ"))
+}
+
+func TestNicknameSkeleton(t *testing.T) {
+	// "pаypal" with a Cyrillic "а"
+	assert.Equal(t, NicknameSkeleton("paypal"), NicknameSkeleton("pаypal"))
+	assert.Equal(t, NicknameSkeleton("PayPal"), NicknameSkeleton("paypal"))
+	assert.Equal(t, NicknameSkeleton("cafe"), NicknameSkeleton("café"))
+	// letter sequences and digits are distinct nicknames
+	assert.NotEqual(t, NicknameSkeleton("modern"), NicknameSkeleton("rnodern"))
+	assert.NotEqual(t, NicknameSkeleton("hello"), NicknameSkeleton("he11o"))
+	assert.NotEqual(t, NicknameSkeleton("alice"), NicknameSkeleton("bob"))
+}
+
+func TestNewUser_CanonicalisesEmailAndNickname(t *testing.T) {
+	user, err := NewUser("John", "Doe", " ｊｏｈｎ ", "securePassword123", " John.Doe@EXAMPLE.com", "US")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "john", user.Nickname)
+	assert.Equal(t, "John.Doe@example.com", user.Email)
+	assert.Equal(t, NicknameSkeleton("john"), user.NicknameSkeleton)
+}
+
+func TestNicknameSkeleton_MatchesMigration(t *testing.T) {
+	// migration 002 backfills skeletons with translate(); its table must
+	// match confusables
+	sql, err := os.ReadFile("../../migrations/002_case_insensitive_uniqueness.up.sql")
+	require.NoError(t, err)
+	match := regexp.MustCompile(`'([^']+)',\s*'([a-z]+)'\)\s*WHERE nickname_skeleton`).FindSubmatch(sql)
+	require.NotNil(t, match, "translate() not found in migration 002")
+
+	from, to := []rune(string(match[1])), []rune(string(match[2]))
+	require.Len(t, to, len(from))
+	mapped := map[rune]rune{}
+	for i, r := range from {
+		mapped[r] = to[i]
+	}
+	assert.Equal(t, confusables, mapped)
+}
+
+func TestCanonicalEmail_MatchesMigration(t *testing.T) {
+	// migration 002 canonicalises stored emails with substring(); it must
+	// split them on the same '@' as CanonicalEmail
+	sql, err := os.ReadFile("../../migrations/002_case_insensitive_uniqueness.up.sql")
+	require.NoError(t, err)
+	matches := regexp.MustCompile(`substring\(trim\(email\) from '([^']+)'\)`).FindAllSubmatch(sql, -1)
+	require.Len(t, matches, 2, "substring() calls not found in migration 002")
+	local, domain := regexp.MustCompile(string(matches[0][1])), regexp.MustCompile(string(matches[1][1]))
+
+	migrate := func(email string) string {
+		email = strings.TrimSpace(email)
+		if !strings.Contains(email, "@") {
+			return email
+		}
+		return local.FindStringSubmatch(email)[1] + "@" + strings.ToLower(domain.FindStringSubmatch(email)[1])
+	}
+
+	for _, email := range []string{
+		"John.Doe@EXAMPLE.com",
+		" ann@Example.org ",
+		`"a@b"@Example.COM`,
+		"a@b@Example.COM",
+		"no-domain",
+	} {
+		assert.Equal(t, CanonicalEmail(email), migrate(email), email)
+	}
+}
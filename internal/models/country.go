@@ -0,0 +1,144 @@
+package models
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrUnknownCountry = errors.New("unknown country")
+
+// Country represents an ISO 3166-1 entry
+type Country struct {
+	Alpha2 string
+	Alpha3 string
+	Name   string
+}
+
+//go:embed data/iso3166.csv
+var iso3166CSV string
+
+// countryAliases maps common English names that differ from the ISO short
+// name to their alpha-2 code. Keys are already in folded form.
+var countryAliases = map[string]string{
+	"america":                          "US",
+	"united states of america":         "US",
+	"uk":                               "GB",
+	"great britain":                    "GB",
+	"britain":                          "GB",
+	"russia":                           "RU",
+	"korea":                            "KR",
+	"republic of korea":                "KR",
+	"korea republic of":                "KR",
+	"dprk":                             "KP",
+	"vietnam":                          "VN",
+	"czech republic":                   "CZ",
+	"turkey":                           "TR",
+	"ivory coast":                      "CI",
+	"cape verde":                       "CV",
+	"swaziland":                        "SZ",
+	"macedonia":                        "MK",
+	"holland":                          "NL",
+	"the netherlands":                  "NL",
+	"syria":                            "SY",
+	"laos":                             "LA",
+	"brunei":                           "BN",
+	"vatican":                          "VA",
+	"vatican city":                     "VA",
+	"palestine":                        "PS",
+	"burma":                            "MM",
+	"east timor":                       "TL",
+	"drc":                              "CD",
+	"dr congo":                         "CD",
+	"democratic republic of the congo": "CD",
+	"republic of the congo":            "CG",
+	"macau":                            "MO",
+	"sao tome":                         "ST",
+	"the bahamas":                      "BS",
+	"the gambia":                       "GM",
+	"falkland islands":                 "FK",
+}
+
+var (
+	countriesByCode   = map[string]Country{}
+	countriesByLookup = map[string]string{}
+)
+
+func init() {
+	records, err := csv.NewReader(strings.NewReader(iso3166CSV)).ReadAll()
+	if err != nil {
+		panic(errors.Wrap(err, "invalid embedded ISO 3166 table"))
+	}
+
+	// skip header
+	for _, record := range records[1:] {
+		c := Country{Alpha2: record[0], Alpha3: record[1], Name: record[2]}
+		countriesByCode[c.Alpha2] = c
+		countriesByLookup[foldCountry(c.Alpha2)] = c.Alpha2
+		countriesByLookup[foldCountry(c.Alpha3)] = c.Alpha2
+		countriesByLookup[foldCountry(c.Name)] = c.Alpha2
+	}
+
+	for alias, code := range countryAliases {
+		countriesByLookup[alias] = code
+	}
+}
+
+// NormalizeCountry resolves an alpha-2 code, alpha-3 code or English name to
+// its ISO 3166-1 alpha-2 code
+func NormalizeCountry(input string) (string, error) {
+	code, ok := countriesByLookup[foldCountry(input)]
+	if !ok {
+		return "", errors.Wrapf(ErrUnknownCountry, "%q", strings.TrimSpace(input))
+	}
+	return code, nil
+}
+
+// LookupCountry returns the ISO 3166-1 entry for an alpha-2 code
+func LookupCountry(code string) (Country, bool) {
+	c, ok := countriesByCode[strings.ToUpper(code)]
+	return c, ok
+}
+
+// CountryName returns the display name for an alpha-2 code, or an empty
+// string if the code is unknown
+func CountryName(code string) string {
+	c, _ := LookupCountry(code)
+	return c.Name
+}
+
+// foldCountry lower-cases the input, strips diacritics and punctuation and
+// collapses whitespace so that "Côte d'Ivoire " and "cote divoire" compare equal
+func foldCountry(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	folded, _, err := transform.String(t, s)
+	if err != nil {
+		folded = s
+	}
+
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(folded), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == '(' || r == ')' || r == '-'
+	}) {
+		word = strings.Map(func(r rune) rune {
+			if r == '.' || r == '\'' || r == '’' {
+				return -1
+			}
+			return r
+		}, word)
+		if word == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeCountry(t *testing.T) {
+	cases := map[string]string{
+		"PT":            "PT",
+		"pt":            "PT",
+		"PRT":           "PT",
+		"Portugal":      "PT",
+		"portugal ":     "PT",
+		"Côte d'Ivoire": "CI",
+		"cote divoire":  "CI",
+		"UK":            "GB",
+		"United States": "US",
+		"usa":           "US",
+	}
+
+	for input, expected := range cases {
+		code, err := NormalizeCountry(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, expected, code, input)
+	}
+}
+
+func TestNormalizeCountry_Unknown(t *testing.T) {
+	_, err := NormalizeCountry("Portgual")
+
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrUnknownCountry))
+}
+
+func TestUser_MarshalJSON_IncludesCountryName(t *testing.T) {
+	user := User{ID: "123", Country: "PT"}
+
+	data, err := json.Marshal(user)
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, "PT", decoded["country"])
+	assert.Equal(t, "Portugal", decoded["country_name"])
+	assert.Equal(t, "123", decoded["id"])
+}
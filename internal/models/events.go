@@ -0,0 +1,83 @@
+package models
+
+import "time"
+
+// UserV1 is a user as carried by version 1 events. It is a copy taken when
+// the event is built, with no password field, so neither the hash nor later
+// changes to the user can reach the event.
+type UserV1 struct {
+	ID        string    `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Nickname  string    `json:"nickname"`
+	Email     string    `json:"email"`
+	Country   string    `json:"country"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserCreatedV1 is the data of user.created events
+type UserCreatedV1 struct {
+	UserV1
+}
+
+// UserUpdatedV1 is the data of user.updated events: the updated user, the
+// fields the update changed and their previous values
+type UserUpdatedV1 struct {
+	UserV1
+	ChangedFields []string          `json:"changed_fields"`
+	Previous      map[string]string `json:"previous"`
+}
+
+// UserDeletedV1 is the data of user.deleted events
+type UserDeletedV1 struct {
+	ID string `json:"id"`
+}
+
+func newUserV1(u *User) UserV1 {
+	return UserV1{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Nickname:  u.Nickname,
+		Email:     u.Email,
+		Country:   u.Country,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+func NewUserCreatedV1(u *User) *UserCreatedV1 {
+	return &UserCreatedV1{UserV1: newUserV1(u)}
+}
+
+// NewUserUpdatedV1 describes the update of previous into u; previous may be
+// nil when it is unknown, leaving the changes empty
+func NewUserUpdatedV1(u, previous *User) *UserUpdatedV1 {
+	event := &UserUpdatedV1{
+		UserV1:        newUserV1(u),
+		ChangedFields: []string{},
+		Previous:      map[string]string{},
+	}
+	if previous == nil {
+		return event
+	}
+
+	before := newUserV1(previous)
+	values := map[string]string{
+		"country":    before.Country,
+		"email":      before.Email,
+		"first_name": before.FirstName,
+		"last_name":  before.LastName,
+		"nickname":   before.Nickname,
+	}
+	for _, field := range u.ChangedFields(previous) {
+		event.ChangedFields = append(event.ChangedFields, field)
+		event.Previous[field] = values[field]
+	}
+	return event
+}
+
+func NewUserDeletedV1(id string) *UserDeletedV1 {
+	return &UserDeletedV1{ID: id}
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUserUpdatedV1(t *testing.T) {
+	previous := &User{ID: "user-1", FirstName: "Ann", Nickname: "ann", Email: "ann@example.com", Country: "BR", Password: "hash"}
+	updated := *previous
+	updated.Email = "ann@example.org"
+	updated.Nickname = "annie"
+
+	event := NewUserUpdatedV1(&updated, previous)
+
+	assert.Equal(t, "user-1", event.ID)
+	assert.Equal(t, "annie", event.Nickname)
+	assert.Equal(t, []string{"email", "nickname"}, event.ChangedFields)
+	assert.Equal(t, map[string]string{"email": "ann@example.com", "nickname": "ann"}, event.Previous)
+
+	// the event is a copy, later changes to the user do not reach it
+	updated.Nickname = "anna"
+	assert.Equal(t, "annie", event.Nickname)
+}
+
+func TestNewUserUpdatedV1_UnknownPrevious(t *testing.T) {
+	body, err := json.Marshal(NewUserUpdatedV1(&User{ID: "user-1"}, nil))
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"changed_fields":[],"previous":{}`)
+}
+
+func TestUserEvents_NeverCarryPassword(t *testing.T) {
+	user, err := NewUser("John", "Doe", "jdoe", "Password123!", "john@example.com", "BR")
+	assert.NoError(t, err)
+	previous := *user
+	previous.Email = "old@example.com"
+
+	for _, event := range []interface{}{
+		NewUserCreatedV1(user),
+		NewUserUpdatedV1(user, &previous),
+		NewUserDeletedV1(user.ID),
+	} {
+		body, err := json.Marshal(event)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(body), user.Password)
+		assert.NotContains(t, string(body), "password")
+	}
+}
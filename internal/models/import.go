@@ -0,0 +1,51 @@
+package models
+
+import "time"
+
+// Import row statuses
+const (
+	ImportRowCreated = "created"
+	ImportRowValid   = "valid"
+	ImportRowError   = "error"
+)
+
+// Import job statuses
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// ImportRowResult is the outcome of importing a single row. Row is the
+// 1-based line of the row in the file, counted from the line after a CSV
+// header, so blank lines are counted too.
+type ImportRowResult struct {
+	Row    int    `json:"row"`
+	Status string `json:"status"`
+	UserID string `json:"user_id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarises an import. On a dry run valid rows are reported
+// as valid instead of created and nothing is written.
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Valid   int               `json:"valid"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// ImportJob tracks an asynchronous import
+type ImportJob struct {
+	ID        string        `json:"id" db:"id"`
+	Status    string        `json:"status" db:"status"`
+	DryRun    bool          `json:"dry_run" db:"dry_run"`
+	TotalRows int           `json:"total_rows" db:"total_rows"`
+	Report    *ImportReport `json:"report,omitempty" db:"-"`
+	Error     string        `json:"error,omitempty" db:"error"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at" db:"updated_at"`
+}
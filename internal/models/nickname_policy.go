@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultNicknameMinLength is the shortest nickname accepted by default
+	DefaultNicknameMinLength = 3
+	// DefaultNicknameMaxLength matches the VARCHAR(50) nickname column
+	DefaultNicknameMaxLength = 50
+)
+
+var (
+	ErrNicknameNotAllowed   = errors.New("nickname not allowed")
+	ErrNicknameLength       = fmt.Errorf("%w: invalid length", ErrNicknameNotAllowed)
+	ErrNicknameInvalidChars = fmt.Errorf("%w: only letters, digits, '_', '.' and '-' are allowed", ErrNicknameNotAllowed)
+	ErrNicknameReserved     = fmt.Errorf("%w: nickname is reserved", ErrNicknameNotAllowed)
+	ErrNicknameProfane      = fmt.Errorf("%w: nickname contains disallowed words", ErrNicknameNotAllowed)
+)
+
+var nicknameCharset = regexp.MustCompile(`^[\p{L}\p{N}_.-]+$`)
+
+// WordList is a list of words a nickname is checked against. Words are
+// compared on their confusable skeleton, so "ADMIN" or "аdmin" with a
+// Cyrillic "а" match "admin".
+type WordList struct {
+	skeletons []string
+	substring bool
+}
+
+// NewWordList builds a WordList. With substring set a nickname matches when
+// it contains any word; otherwise the whole nickname must match.
+func NewWordList(words []string, substring bool) WordList {
+	list := WordList{substring: substring}
+	for _, word := range words {
+		if skeleton := NicknameSkeleton(word); skeleton != "" {
+			list.skeletons = append(list.skeletons, skeleton)
+		}
+	}
+	return list
+}
+
+// Matches reports whether the nickname matches any word of the list
+func (l WordList) Matches(nickname string) bool {
+	skeleton := NicknameSkeleton(nickname)
+	for _, word := range l.skeletons {
+		if skeleton == word || (l.substring && strings.Contains(skeleton, word)) {
+			return true
+		}
+	}
+	return false
+}
+
+// NicknamePolicy holds the rules a nickname must satisfy
+type NicknamePolicy struct {
+	MinLength int
+	MaxLength int
+	Reserved  WordList
+	Profanity WordList
+}
+
+// DefaultNicknamePolicy enforces length bounds and the allowed character set only
+func DefaultNicknamePolicy() *NicknamePolicy {
+	return &NicknamePolicy{
+		MinLength: DefaultNicknameMinLength,
+		MaxLength: DefaultNicknameMaxLength,
+	}
+}
+
+// Check validates a canonical nickname against the policy. Reserved
+// nicknames fail with ErrNicknameReserved so callers can apply overrides.
+func (p *NicknamePolicy) Check(nickname string) error {
+	length := utf8.RuneCountInString(nickname)
+	if length < p.MinLength || length > p.MaxLength {
+		return fmt.Errorf("%w: must be between %d and %d characters", ErrNicknameLength, p.MinLength, p.MaxLength)
+	}
+
+	if !nicknameCharset.MatchString(nickname) {
+		return ErrNicknameInvalidChars
+	}
+
+	if p.Profanity.Matches(nickname) {
+		return ErrNicknameProfane
+	}
+
+	if p.Reserved.Matches(nickname) {
+		return ErrNicknameReserved
+	}
+
+	return nil
+}
+
+// NicknameGrant allows a specific account to use a reserved nickname
+type NicknameGrant struct {
+	Nickname  string    `json:"nickname" db:"nickname"`
+	UserID    string    `json:"user_id" db:"user_id"`
+	GrantedBy string    `json:"granted_by" db:"granted_by"`
+	GrantedAt time.Time `json:"granted_at" db:"granted_at"`
+}
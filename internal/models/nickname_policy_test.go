@@ -0,0 +1,43 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNicknamePolicy_Check(t *testing.T) {
+	policy := DefaultNicknamePolicy()
+	policy.Reserved = NewWordList([]string{"admin", "support", "acme"}, false)
+	policy.Profanity = NewWordList([]string{"darn"}, true)
+
+	cases := []struct {
+		nickname string
+		expected error
+	}{
+		{"johndoe", nil},
+		{"john.doe-99", nil},
+		{"jo", ErrNicknameLength},
+		{strings.Repeat("a", 51), ErrNicknameLength},
+		{"john doe", ErrNicknameInvalidChars},
+		{"john<script>", ErrNicknameInvalidChars},
+		{"admin", ErrNicknameReserved},
+		{"ADMIN", ErrNicknameReserved},
+		{"adm\u0456n", ErrNicknameReserved}, // Cyrillic "і"
+		{"suppοrt", ErrNicknameReserved},    // Greek omicron
+		{"acme_fan", nil},                   // reserved list matches exactly
+		{"darnit", ErrNicknameProfane},      // profanity list matches substrings
+	}
+
+	for _, tc := range cases {
+		err := policy.Check(tc.nickname)
+		if tc.expected == nil {
+			assert.NoError(t, err, tc.nickname)
+			continue
+		}
+		assert.True(t, errors.Is(err, tc.expected), "%s: %v", tc.nickname, err)
+		assert.True(t, errors.Is(err, ErrNicknameNotAllowed), tc.nickname)
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"regexp"
 	"time"
 
@@ -13,25 +14,34 @@ import (
 // @Description User object representing the user in the system
 // @model
 type User struct {
-	ID        string    `json:"id" db:"id"`
-	FirstName string    `json:"first_name" db:"first_name"`
-	LastName  string    `json:"last_name" db:"last_name"`
-	Nickname  string    `json:"nickname" db:"nickname"`
-	Password  string    `json:"password,omitempty" db:"password"`
-	Email     string    `json:"email" db:"email"`
-	Country   string    `json:"country" db:"country"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID               string     `json:"id" db:"id"`
+	FirstName        string     `json:"first_name" db:"first_name"`
+	LastName         string     `json:"last_name" db:"last_name"`
+	Nickname         string     `json:"nickname" db:"nickname"`
+	NicknameSkeleton string     `json:"-" db:"nickname_skeleton"`
+	Password         string     `json:"password,omitempty" db:"password"`
+	Email            string     `json:"email" db:"email"`
+	Country          string     `json:"country" db:"country"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at" db:"updated_at"`
+	VerifiedAt       *time.Time `json:"verified_at,omitempty" db:"verified_at"`
 }
 
 func NewUser(firstName, lastName, nickname, password, email, country string) (*User, error) {
+	if code, err := NormalizeCountry(country); err == nil {
+		country = code
+	}
+
+	nickname = CanonicalNickname(nickname)
+
 	tempUser := &User{
-		FirstName: firstName,
-		LastName:  lastName,
-		Nickname:  nickname,
-		Password:  password,
-		Email:     email,
-		Country:   country,
+		FirstName:        firstName,
+		LastName:         lastName,
+		Nickname:         nickname,
+		NicknameSkeleton: NicknameSkeleton(nickname),
+		Password:         password,
+		Email:            CanonicalEmail(email),
+		Country:          country,
 	}
 
 	if err := tempUser.Validate(); err != nil {
@@ -69,6 +79,10 @@ func (u *User) Validate() error {
 		return errors.New("country is required")
 	}
 
+	if _, ok := countriesByCode[u.Country]; !ok {
+		return errors.Wrap(ErrUnknownCountry, "country must be an ISO 3166-1 code or name")
+	}
+
 	if u.Email == "" {
 		return errors.New("email is required")
 	}
@@ -110,10 +124,12 @@ func (u *User) Update(firstName, lastName, nickname, email, country string) erro
 	}
 
 	if nickname != "" {
-		u.Nickname = nickname
+		u.Nickname = CanonicalNickname(nickname)
+		u.NicknameSkeleton = NicknameSkeleton(u.Nickname)
 	}
 
 	if email != "" {
+		email = CanonicalEmail(email)
 		if err := u.ValidateEmail(email); err != nil {
 			return err
 		}
@@ -121,7 +137,11 @@ func (u *User) Update(firstName, lastName, nickname, email, country string) erro
 	}
 
 	if country != "" {
-		u.Country = country
+		code, err := NormalizeCountry(country)
+		if err != nil {
+			return err
+		}
+		u.Country = code
 	}
 
 	u.UpdatedAt = time.Now().UTC()
@@ -129,6 +149,28 @@ func (u *User) Update(firstName, lastName, nickname, email, country string) erro
 	return nil
 }
 
+// ChangedFields lists the JSON names of the profile fields that differ
+// between previous and u, in alphabetical order
+func (u *User) ChangedFields(previous *User) []string {
+	var changed []string
+	if u.Country != previous.Country {
+		changed = append(changed, "country")
+	}
+	if u.Email != previous.Email {
+		changed = append(changed, "email")
+	}
+	if u.FirstName != previous.FirstName {
+		changed = append(changed, "first_name")
+	}
+	if u.LastName != previous.LastName {
+		changed = append(changed, "last_name")
+	}
+	if u.Nickname != previous.Nickname {
+		changed = append(changed, "nickname")
+	}
+	return changed
+}
+
 func (u *User) ValidateEmail(email string) error {
 	emailRegex := `^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`
 	match, err := regexp.MatchString(emailRegex, email)
@@ -141,6 +183,18 @@ func (u *User) ValidateEmail(email string) error {
 	return nil
 }
 
+// MarshalJSON adds the display name of the country next to its ISO code
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	return json.Marshal(struct {
+		user
+		CountryName string `json:"country_name,omitempty"`
+	}{
+		user:        user(u),
+		CountryName: CountryName(u.Country),
+	})
+}
+
 func (u *User) SanitizeForOutput() {
 	u.Password = ""
 }
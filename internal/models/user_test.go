@@ -50,3 +50,29 @@ func TestUser_SanitizeForOutput(t *testing.T) {
 
 	assert.Equal(t, "", user.Password)
 }
+
+func TestNewUser_NormalizesCountry(t *testing.T) {
+	user, err := NewUser("John", "Doe", "johndoe", "securePassword123", "john.doe@example.com", "portugal ")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "PT", user.Country)
+}
+
+func TestNewUser_UnknownCountry(t *testing.T) {
+	_, err := NewUser("John", "Doe", "johndoe", "securePassword123", "john.doe@example.com", "Portgual")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown country")
+}
+
+func TestUser_ChangedFields(t *testing.T) {
+	previous := &User{FirstName: "John", LastName: "Doe", Nickname: "johndoe", Email: "john@example.com", Country: "PT"}
+
+	user := *previous
+	assert.Empty(t, user.ChangedFields(previous))
+
+	user.Nickname = "johnny"
+	user.Email = "johnny@example.com"
+	user.Password = "ignored"
+	assert.Equal(t, []string{"email", "nickname"}, user.ChangedFields(previous))
+}
@@ -0,0 +1,95 @@
+package notification
+
+import (
+	"context"
+	"sync"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"go.uber.org/zap"
+)
+
+// BusHandler receives the events published on a Bus; the event is shared by
+// every handler and must not be modified
+type BusHandler func(ctx context.Context, event *CloudEvent) error
+
+// Bus passes every event to the handlers subscribed in the same process,
+// without a broker. Handlers are called in turn, in the order they
+// subscribed; they all receive the event even when one fails.
+type Bus struct {
+	cloudEvents config.CloudEventsConfig
+	logger      *zap.Logger
+
+	mu       sync.RWMutex
+	handlers []*BusHandler
+}
+
+// NewBus creates a Bus without handlers
+func NewBus(cloudEvents config.CloudEventsConfig, logger *zap.Logger) *Bus {
+	return &Bus{
+		cloudEvents: cloudEvents,
+		logger:      logger.With(zap.String("component", "notification_bus")),
+	}
+}
+
+// Subscribe adds handler to the bus until the returned function is called
+func (b *Bus) Subscribe(handler BusHandler) (unsubscribe func()) {
+	subscription := &handler
+	b.mu.Lock()
+	b.handlers = append(b.handlers, subscription)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, h := range b.handlers {
+			if h == subscription {
+				b.handlers = append(b.handlers[:i:i], b.handlers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// BusHandlerFor passes the events of a bus to the methods of handler
+func BusHandlerFor(handler EventHandlerInterface) BusHandler {
+	return func(ctx context.Context, event *CloudEvent) error {
+		return HandleCloudEvent(ctx, handler, event)
+	}
+}
+
+func (b *Bus) NotifyUserCreated(ctx context.Context, user *models.User) error {
+	return b.Publish(ctx, UserCreatedEvent(user))
+}
+
+func (b *Bus) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
+	return b.Publish(ctx, UserUpdatedEvent(user, previous))
+}
+
+func (b *Bus) NotifyUserDeleted(ctx context.Context, userID string) error {
+	return b.Publish(ctx, UserDeletedEvent(userID))
+}
+
+// Publish passes event to every handler and returns the first error
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	ce, err := NewCloudEvent(event, b.cloudEvents)
+	if err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	var first error
+	for _, handler := range handlers {
+		if err := (*handler)(ctx, ce); err != nil {
+			b.logger.Warn("bus handler failed", zap.String("event_id", ce.ID), zap.String("type", ce.Type), zap.Error(err))
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,50 @@
+package notification
+
+import (
+	"context"
+	"testing"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"go.uber.org/zap"
+)
+
+func TestBus_PassesEventsToEveryHandler(t *testing.T) {
+	bus := NewBus(config.CloudEventsConfig{Source: "user-microservice"}, zap.NewNop())
+
+	failure := errors.New("handler failed")
+	var received []string
+	bus.Subscribe(func(ctx context.Context, event *CloudEvent) error {
+		received = append(received, "first:"+event.Type)
+		return failure
+	})
+	unsubscribe := bus.Subscribe(func(ctx context.Context, event *CloudEvent) error {
+		received = append(received, "second:"+event.Type)
+		return nil
+	})
+
+	assert.ErrorIs(t, bus.NotifyUserDeleted(context.Background(), "1"), failure)
+	unsubscribe()
+	assert.ErrorIs(t, bus.NotifyUserDeleted(context.Background(), "2"), failure)
+
+	assert.Equal(t, []string{"first:user.deleted", "second:user.deleted", "first:user.deleted"}, received)
+}
+
+func TestBusHandlerFor(t *testing.T) {
+	mockHandler := new(MockEventHandler)
+	mockHandler.On("HandleUserCreated", mock.Anything, mock.MatchedBy(func(u *models.User) bool { return u.ID == "1" })).Return(nil).Once()
+	mockHandler.On("HandleUserDeleted", mock.Anything, "1").Return(nil).Once()
+
+	bus := NewBus(config.CloudEventsConfig{Source: "user-microservice"}, zap.NewNop())
+	bus.Subscribe(BusHandlerFor(mockHandler))
+
+	assert.NoError(t, bus.NotifyUserCreated(context.Background(), &models.User{ID: "1"}))
+	assert.NoError(t, bus.NotifyUserDeleted(context.Background(), "1"))
+	assert.ErrorIs(t, bus.Publish(context.Background(), Event{Type: "user.renamed"}), ErrUnknownEventType)
+
+	mockHandler.AssertExpectations(t)
+}
@@ -0,0 +1,174 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"user-microservice/internal/config"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// CloudEventsSpecVersion is the version of the CloudEvents spec events follow
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType is the content type of structured mode messages
+	CloudEventsContentType = "application/cloudevents+json"
+	// cloudEventsHeaderPrefix prefixes the attribute headers of binary mode
+	// messages, as in the CloudEvents AMQP binding
+	cloudEventsHeaderPrefix = "cloudEvents:"
+	// eventSchemaVersion is the version of the event data schemas
+	eventSchemaVersion = "v1"
+)
+
+// ErrInvalidCloudEvent is returned for messages that are not valid CloudEvents
+var ErrInvalidCloudEvent = errors.New("invalid CloudEvent")
+
+// CloudEvent is an event in the CloudEvents 1.0 JSON format
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            *time.Time      `json:"time,omitempty"`
+	Subject         string          `json:"subject,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// NewCloudEvent wraps event for the deployment described by cfg; events
+// without an ID get a new one
+func NewCloudEvent(event Event, cfg config.CloudEventsConfig) (*CloudEvent, error) {
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "error serializing event data")
+	}
+
+	ce := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          cfg.Source,
+		Type:            event.Type,
+		Subject:         event.Subject,
+		DataContentType: cfg.DataContentType,
+		Data:            data,
+	}
+	if ce.ID == "" {
+		ce.ID = uuid.New().String()
+	}
+	if ce.DataContentType == "" {
+		ce.DataContentType = "application/json"
+	}
+	if !event.Timestamp.IsZero() {
+		t := event.Timestamp.UTC()
+		ce.Time = &t
+	}
+	if cfg.DataSchemaURL != "" {
+		ce.DataSchema = fmt.Sprintf("%s/%s/%s", strings.TrimRight(cfg.DataSchemaURL, "/"), event.Type, eventSchemaVersion)
+	}
+	return ce, nil
+}
+
+// encodeCloudEvent builds the message carrying ce in the given content mode
+func encodeCloudEvent(ce *CloudEvent, mode string) (amqp.Publishing, error) {
+	msg := amqp.Publishing{
+		MessageId:    ce.ID,
+		DeliveryMode: amqp.Persistent,
+	}
+	if ce.Time != nil {
+		msg.Timestamp = *ce.Time
+	}
+
+	if mode == config.CloudEventsBinary {
+		headers := amqp.Table{
+			cloudEventsHeaderPrefix + "specversion": ce.SpecVersion,
+			cloudEventsHeaderPrefix + "id":          ce.ID,
+			cloudEventsHeaderPrefix + "source":      ce.Source,
+			cloudEventsHeaderPrefix + "type":        ce.Type,
+		}
+		if ce.Time != nil {
+			headers[cloudEventsHeaderPrefix+"time"] = ce.Time.Format(time.RFC3339Nano)
+		}
+		if ce.Subject != "" {
+			headers[cloudEventsHeaderPrefix+"subject"] = ce.Subject
+		}
+		if ce.DataSchema != "" {
+			headers[cloudEventsHeaderPrefix+"dataschema"] = ce.DataSchema
+		}
+		msg.Headers = headers
+		msg.ContentType = ce.DataContentType
+		msg.Body = ce.Data
+		return msg, nil
+	}
+
+	body, err := json.Marshal(ce)
+	if err != nil {
+		return amqp.Publishing{}, errors.Wrap(err, "error serializing event")
+	}
+	msg.ContentType = CloudEventsContentType
+	msg.Body = body
+	return msg, nil
+}
+
+// decodeCloudEvent reads the CloudEvent carried by a message in either
+// content mode. Binary mode is recognized by its specversion header.
+func decodeCloudEvent(msg amqp.Delivery) (*CloudEvent, error) {
+	if _, ok := msg.Headers[cloudEventsHeaderPrefix+"specversion"]; ok {
+		return decodeBinary(msg)
+	}
+
+	if msg.ContentType != "" {
+		if mediaType, _, err := mime.ParseMediaType(msg.ContentType); err == nil && mediaType != CloudEventsContentType {
+			return nil, errors.Wrapf(ErrInvalidCloudEvent, "unsupported content type %s", msg.ContentType)
+		}
+	}
+
+	var ce CloudEvent
+	if err := json.Unmarshal(msg.Body, &ce); err != nil {
+		return nil, errors.Wrap(ErrInvalidCloudEvent, err.Error())
+	}
+	return &ce, validateCloudEvent(&ce)
+}
+
+func decodeBinary(msg amqp.Delivery) (*CloudEvent, error) {
+	header := func(name string) string {
+		value, _ := msg.Headers[cloudEventsHeaderPrefix+name].(string)
+		return value
+	}
+
+	ce := &CloudEvent{
+		SpecVersion:     header("specversion"),
+		ID:              header("id"),
+		Source:          header("source"),
+		Type:            header("type"),
+		Subject:         header("subject"),
+		DataSchema:      header("dataschema"),
+		DataContentType: msg.ContentType,
+		Data:            json.RawMessage(msg.Body),
+	}
+	if value := header("time"); value != "" {
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return nil, errors.Wrapf(ErrInvalidCloudEvent, "invalid time %q", value)
+		}
+		ce.Time = &t
+	}
+	return ce, validateCloudEvent(ce)
+}
+
+// validateCloudEvent checks the attributes the spec requires
+func validateCloudEvent(ce *CloudEvent) error {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return errors.Wrapf(ErrInvalidCloudEvent, "unsupported specversion %q", ce.SpecVersion)
+	}
+	if ce.ID == "" || ce.Source == "" || ce.Type == "" {
+		return errors.Wrap(ErrInvalidCloudEvent, "id, source and type are required")
+	}
+	return nil
+}
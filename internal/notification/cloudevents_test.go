@@ -0,0 +1,83 @@
+package notification
+
+import (
+	"testing"
+	"time"
+
+	"user-microservice/internal/config"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func deliver(msg amqp.Publishing) amqp.Delivery {
+	return amqp.Delivery{Headers: msg.Headers, ContentType: msg.ContentType, MessageId: msg.MessageId, Body: msg.Body}
+}
+
+func TestCloudEvent_RoundTrip(t *testing.T) {
+	cfg := config.CloudEventsConfig{Source: "user-microservice", DataSchemaURL: "http://localhost:8080/events/schemas"}
+	event := Event{
+		ID:        "event-1",
+		Type:      "user.created",
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Subject:   "user-1",
+		Payload:   map[string]string{"id": "user-1", "nickname": "ann"},
+	}
+
+	for _, mode := range []string{config.CloudEventsStructured, config.CloudEventsBinary} {
+		t.Run(mode, func(t *testing.T) {
+			ce, err := NewCloudEvent(event, cfg)
+			assert.NoError(t, err)
+			msg, err := encodeCloudEvent(ce, mode)
+			assert.NoError(t, err)
+
+			decoded, err := decodeCloudEvent(deliver(msg))
+			assert.NoError(t, err)
+			assert.Equal(t, "1.0", decoded.SpecVersion)
+			assert.Equal(t, "event-1", decoded.ID)
+			assert.Equal(t, "user-microservice", decoded.Source)
+			assert.Equal(t, "user.created", decoded.Type)
+			assert.Equal(t, "user-1", decoded.Subject)
+			assert.Equal(t, "http://localhost:8080/events/schemas/user.created/v1", decoded.DataSchema)
+			assert.Equal(t, "application/json", decoded.DataContentType)
+			if assert.NotNil(t, decoded.Time) {
+				assert.True(t, event.Timestamp.Equal(*decoded.Time))
+			}
+			assert.JSONEq(t, `{"id":"user-1","nickname":"ann"}`, string(decoded.Data))
+		})
+	}
+}
+
+func TestNewCloudEvent_Defaults(t *testing.T) {
+	ce, err := NewCloudEvent(Event{Type: "user.deleted"}, config.CloudEventsConfig{Source: "users"})
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, ce.ID)
+	assert.Nil(t, ce.Time)
+	assert.Empty(t, ce.DataSchema)
+	assert.Equal(t, "application/json", ce.DataContentType)
+}
+
+func TestDecodeCloudEvent_Invalid(t *testing.T) {
+	tests := map[string]amqp.Delivery{
+		"not json":            {ContentType: CloudEventsContentType, Body: []byte("not json")},
+		"missing specversion": {Body: []byte(`{"id":"1","source":"s","type":"user.created"}`)},
+		"missing source":      {Body: []byte(`{"specversion":"1.0","id":"1","type":"user.created"}`)},
+		"other content type":  {ContentType: "text/plain", Body: []byte(`{"specversion":"1.0","id":"1","source":"s","type":"t"}`)},
+		"binary without id": {
+			Headers: amqp.Table{"cloudEvents:specversion": "1.0", "cloudEvents:source": "s", "cloudEvents:type": "t"},
+			Body:    []byte(`{}`),
+		},
+		"binary with bad time": {
+			Headers: amqp.Table{"cloudEvents:specversion": "1.0", "cloudEvents:id": "1", "cloudEvents:source": "s", "cloudEvents:type": "t", "cloudEvents:time": "yesterday"},
+			Body:    []byte(`{}`),
+		},
+	}
+
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := decodeCloudEvent(msg)
+			assert.ErrorIs(t, err, ErrInvalidCloudEvent)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"go.uber.org/zap"
+)
+
+// DeadLetter is a message the subscriber gave up on
+type DeadLetter struct {
+	MessageID string `json:"message_id"`
+	// Type is the event type, when the message is a CloudEvent
+	Type       string `json:"type,omitempty"`
+	RoutingKey string `json:"routing_key,omitempty"`
+	Attempts   int    `json:"attempts"`
+	// Reason is "retries exhausted" or "unprocessable", for messages retrying
+	// cannot fix
+	Reason         string     `json:"reason,omitempty"`
+	Error          string     `json:"error,omitempty"`
+	DeadLetteredAt *time.Time `json:"dead_lettered_at,omitempty"`
+	ContentType    string     `json:"content_type,omitempty"`
+	Body           string     `json:"body"`
+}
+
+// deadLetterChannel reads and republishes dead-lettered messages
+type deadLetterChannel interface {
+	topologyChannel
+	confirmChannel
+	Get(queue string, autoAck bool) (amqp.Delivery, bool, error)
+	Close() error
+}
+
+// DeadLetterQueue inspects and re-drives the subscriber's dead letters
+type DeadLetterQueue struct {
+	channel     deadLetterChannel
+	republisher *confirmedPublisher
+	retry       retryPolicy
+	logger      *zap.Logger
+
+	// mu serializes the walks through the queue
+	mu sync.Mutex
+}
+
+func newDeadLetterQueue(channel deadLetterChannel, retry retryPolicy, logger *zap.Logger) (*DeadLetterQueue, error) {
+	republisher, err := newConfirmedPublisher(channel)
+	if err != nil {
+		return nil, err
+	}
+	return &DeadLetterQueue{
+		channel:     channel,
+		republisher: republisher,
+		retry:       retry,
+		logger:      logger.With(zap.String("component", "dead_letter_queue")),
+	}, nil
+}
+
+// DeadLetters opens a DeadLetterQueue on the subscriber's connection
+func (s *RabbitMQSubscriber) DeadLetters() (*DeadLetterQueue, error) {
+	channel, err := s.conn.Channel()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create RabbitMQ channel")
+	}
+	// the queue may not exist yet when the consumer has not started
+	if err := s.retry.declare(channel); err != nil {
+		return nil, errors.Wrap(err, "failed to declare retry topology")
+	}
+	return newDeadLetterQueue(channel, s.retry, s.logger)
+}
+
+// Peek returns up to limit dead letters, oldest first, leaving them in the
+// queue
+func (q *DeadLetterQueue) Peek(ctx context.Context, limit int) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msgs, err := q.take(ctx, limit)
+	defer requeue(msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]DeadLetter, 0, len(msgs))
+	for _, msg := range msgs {
+		letters = append(letters, newDeadLetter(msg))
+	}
+	return letters, nil
+}
+
+// Redrive publishes dead letters back to the subscriber's queue with their
+// attempts reset, and returns how many were re-driven. A dead letter is
+// removed once the broker confirmed its copy. Only the messages
+// with the given IDs are re-driven, unless ids is empty; up to limit
+// messages are looked at.
+func (q *DeadLetterQueue) Redrive(ctx context.Context, ids []string, limit int) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msgs, err := q.take(ctx, limit)
+	if err != nil {
+		requeue(msgs)
+		return 0, err
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var kept []amqp.Delivery
+	redriven := 0
+	for i, msg := range msgs {
+		if len(wanted) > 0 && !wanted[msg.MessageId] {
+			kept = append(kept, msg)
+			continue
+		}
+
+		reset := republish(msg, amqp.Table{
+			RetryCountHeader:       nil,
+			lastErrorHeader:        nil,
+			deadLetterReasonHeader: nil,
+			deadLetteredAtHeader:   nil,
+		})
+		if err := q.republisher.publish("", q.retry.queue, reset); err != nil {
+			requeue(append(kept, msgs[i:]...))
+			return redriven, errors.Wrap(err, "error re-driving dead letter")
+		}
+		if err := msg.Ack(false); err != nil {
+			q.logger.Error("error acknowledging re-driven dead letter", zap.String("message_id", msg.MessageId), zap.Error(err))
+		}
+		redriven++
+	}
+	requeue(kept)
+
+	if redriven > 0 {
+		q.logger.Info("dead letters re-driven", zap.Int("count", redriven), zap.String("queue", q.retry.queue))
+	}
+	return redriven, nil
+}
+
+// Close closes the channel of the queue
+func (q *DeadLetterQueue) Close() error {
+	return q.channel.Close()
+}
+
+// take gets up to limit messages from the dead-letter queue without
+// acknowledging them, so none is returned twice
+func (q *DeadLetterQueue) take(ctx context.Context, limit int) ([]amqp.Delivery, error) {
+	var msgs []amqp.Delivery
+	for len(msgs) < limit {
+		if err := ctx.Err(); err != nil {
+			return msgs, err
+		}
+		msg, ok, err := q.channel.Get(q.retry.deadLetterQueue, false)
+		if err != nil {
+			return msgs, errors.Wrap(err, "error reading dead letters")
+		}
+		if !ok {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// requeue puts messages taken from a queue back
+func requeue(msgs []amqp.Delivery) {
+	for _, msg := range msgs {
+		_ = msg.Nack(false, true)
+	}
+}
+
+func newDeadLetter(msg amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		MessageID:   msg.MessageId,
+		Attempts:    retryCount(msg.Headers),
+		ContentType: msg.ContentType,
+		Body:        string(msg.Body),
+	}
+	letter.RoutingKey, _ = msg.Headers[originalRoutingKeyHeader].(string)
+	letter.Reason, _ = msg.Headers[deadLetterReasonHeader].(string)
+	letter.Error, _ = msg.Headers[lastErrorHeader].(string)
+	if value, ok := msg.Headers[deadLetteredAtHeader].(string); ok {
+		if at, err := time.Parse(time.RFC3339, value); err == nil {
+			letter.DeadLetteredAt = &at
+		}
+	}
+	if event, err := decodeCloudEvent(msg); err == nil {
+		letter.Type = event.Type
+	}
+	return letter
+}
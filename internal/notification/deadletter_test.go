@@ -0,0 +1,249 @@
+package notification
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeDeadLetterChannel keeps queues in memory; taken messages stay
+// unacknowledged until acked or requeued in their original order, and
+// published messages are confirmed unless nack is set
+type fakeDeadLetterChannel struct {
+	mu         sync.Mutex
+	queues     map[string][]amqp.Delivery
+	unacked    map[uint64]amqp.Delivery
+	tag        uint64
+	exchanges  []string
+	declared   []string
+	bindings   []string
+	queueArgs  map[string]amqp.Table
+	published  []published
+	publishErr error
+	nack       bool
+	confirms   chan amqp.Confirmation
+	confirmTag uint64
+}
+
+func newFakeDeadLetterChannel() *fakeDeadLetterChannel {
+	return &fakeDeadLetterChannel{
+		queues:    map[string][]amqp.Delivery{},
+		unacked:   map[uint64]amqp.Delivery{},
+		queueArgs: map[string]amqp.Table{},
+	}
+}
+
+func (c *fakeDeadLetterChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.exchanges = append(c.exchanges, name+":"+kind)
+	return nil
+}
+
+func (c *fakeDeadLetterChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.declared = append(c.declared, name)
+	c.queueArgs[name] = args
+	return amqp.Queue{Name: name}, nil
+}
+
+func (c *fakeDeadLetterChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.bindings = append(c.bindings, exchange+"->"+name+":"+key)
+	return nil
+}
+
+// put adds a message to queue
+func (c *fakeDeadLetterChannel) put(queue string, msg amqp.Publishing) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tag++
+	c.queues[queue] = append(c.queues[queue], amqp.Delivery{
+		Acknowledger: c,
+		DeliveryTag:  c.tag,
+		Headers:      msg.Headers,
+		ContentType:  msg.ContentType,
+		MessageId:    msg.MessageId,
+		Body:         msg.Body,
+		RoutingKey:   queue,
+	})
+}
+
+func (c *fakeDeadLetterChannel) Get(queue string, autoAck bool) (amqp.Delivery, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.queues[queue]) == 0 {
+		return amqp.Delivery{}, false, nil
+	}
+	msg := c.queues[queue][0]
+	c.queues[queue] = c.queues[queue][1:]
+	c.unacked[msg.DeliveryTag] = msg
+	return msg, true, nil
+}
+
+func (c *fakeDeadLetterChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.publishErr != nil {
+		return c.publishErr
+	}
+	if !mandatory {
+		return errors.New("republished messages must be mandatory")
+	}
+	c.published = append(c.published, published{exchange: exchange, routingKey: key, msg: msg})
+	c.confirmTag++
+	c.confirms <- amqp.Confirmation{DeliveryTag: c.confirmTag, Ack: !c.nack}
+	return nil
+}
+
+func (c *fakeDeadLetterChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (c *fakeDeadLetterChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.confirms = confirm
+	return confirm
+}
+
+func (c *fakeDeadLetterChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	return returns
+}
+
+func (c *fakeDeadLetterChannel) Close() error {
+	return nil
+}
+
+func (c *fakeDeadLetterChannel) Ack(tag uint64, multiple bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.unacked, tag)
+	return nil
+}
+
+func (c *fakeDeadLetterChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	msg := c.unacked[tag]
+	delete(c.unacked, tag)
+	if requeue {
+		queue := append(c.queues[msg.RoutingKey], msg)
+		sort.Slice(queue, func(i, j int) bool { return queue[i].DeliveryTag < queue[j].DeliveryTag })
+		c.queues[msg.RoutingKey] = queue
+	}
+	return nil
+}
+
+func (c *fakeDeadLetterChannel) Reject(tag uint64, requeue bool) error {
+	return c.Nack(tag, false, requeue)
+}
+
+func newTestDeadLetterQueue(t *testing.T) (*DeadLetterQueue, *fakeDeadLetterChannel) {
+	t.Helper()
+	channel := newFakeDeadLetterChannel()
+	retry := newRetryPolicy("users", config.SubscriberRetryConfig{MaxAttempts: 3})
+
+	for _, id := range []string{"user-1", "user-2", "user-3"} {
+		ce, err := NewCloudEvent(Event{ID: "event-" + id, Type: "user.deleted", Subject: id, Payload: models.NewUserDeletedV1(id)}, config.CloudEventsConfig{Source: "user-microservice"})
+		require.NoError(t, err)
+		msg, err := encodeCloudEvent(ce, config.CloudEventsStructured)
+		require.NoError(t, err)
+		msg.Headers = amqp.Table{
+			RetryCountHeader:         int32(3),
+			lastErrorHeader:          "database unavailable",
+			deadLetterReasonHeader:   deadLetterRetriesExhausted,
+			deadLetteredAtHeader:     "2024-01-02T03:04:05Z",
+			originalRoutingKeyHeader: "user.deleted",
+		}
+		channel.put(retry.deadLetterQueue, msg)
+	}
+	queue, err := newDeadLetterQueue(channel, retry, zap.NewNop())
+	require.NoError(t, err)
+	return queue, channel
+}
+
+func TestDeadLetterQueue_Peek(t *testing.T) {
+	queue, channel := newTestDeadLetterQueue(t)
+
+	letters, err := queue.Peek(context.Background(), 2)
+	require.NoError(t, err)
+
+	require.Len(t, letters, 2)
+	assert.Equal(t, "event-user-1", letters[0].MessageID)
+	assert.Equal(t, "user.deleted", letters[0].Type)
+	assert.Equal(t, "user.deleted", letters[0].RoutingKey)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.Equal(t, deadLetterRetriesExhausted, letters[0].Reason)
+	assert.Equal(t, "database unavailable", letters[0].Error)
+	require.NotNil(t, letters[0].DeadLetteredAt)
+	assert.Equal(t, 2024, letters[0].DeadLetteredAt.Year())
+	assert.Contains(t, letters[0].Body, `"subject":"user-1"`)
+	assert.Equal(t, "event-user-2", letters[1].MessageID)
+
+	// peeking leaves the messages in order
+	assert.Len(t, channel.queues["users.dlq"], 3)
+	assert.Equal(t, uint64(1), channel.queues["users.dlq"][0].DeliveryTag)
+	assert.Empty(t, channel.unacked)
+}
+
+func TestDeadLetterQueue_RedriveAll(t *testing.T) {
+	queue, channel := newTestDeadLetterQueue(t)
+
+	redriven, err := queue.Redrive(context.Background(), nil, 100)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, redriven)
+	assert.Empty(t, channel.queues["users.dlq"])
+	require.Len(t, channel.published, 3)
+	msg := channel.published[0]
+	assert.Equal(t, "", msg.exchange)
+	assert.Equal(t, "users", msg.routingKey)
+	// the attempts start over
+	assert.NotContains(t, msg.msg.Headers, RetryCountHeader)
+	assert.NotContains(t, msg.msg.Headers, deadLetterReasonHeader)
+	assert.Equal(t, "user.deleted", msg.msg.Headers[originalRoutingKeyHeader])
+	assert.Equal(t, "event-user-1", msg.msg.MessageId)
+}
+
+func TestDeadLetterQueue_RedriveByID(t *testing.T) {
+	queue, channel := newTestDeadLetterQueue(t)
+
+	redriven, err := queue.Redrive(context.Background(), []string{"event-user-2"}, 100)
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, redriven)
+	require.Len(t, channel.published, 1)
+	assert.Equal(t, "event-user-2", channel.published[0].msg.MessageId)
+	require.Len(t, channel.queues["users.dlq"], 2)
+	assert.Equal(t, "event-user-1", channel.queues["users.dlq"][0].MessageId)
+	assert.Equal(t, "event-user-3", channel.queues["users.dlq"][1].MessageId)
+}
+
+func TestDeadLetterQueue_RedriveFailureKeepsMessages(t *testing.T) {
+	queue, channel := newTestDeadLetterQueue(t)
+	channel.publishErr = errors.New("channel closed")
+
+	redriven, err := queue.Redrive(context.Background(), nil, 100)
+
+	assert.Error(t, err)
+	assert.Equal(t, 0, redriven)
+	assert.Len(t, channel.queues["users.dlq"], 3)
+	assert.Empty(t, channel.unacked)
+}
+
+func TestDeadLetterQueue_RedriveKeepsNackedMessages(t *testing.T) {
+	queue, channel := newTestDeadLetterQueue(t)
+	channel.nack = true
+
+	redriven, err := queue.Redrive(context.Background(), nil, 100)
+
+	assert.ErrorIs(t, err, ErrPublishNacked)
+	assert.Equal(t, 0, redriven)
+	assert.Len(t, channel.queues["users.dlq"], 3)
+	assert.Empty(t, channel.unacked)
+}
@@ -2,11 +2,20 @@ package notification
 
 import (
 	"context"
+	"encoding/json"
 	"user-microservice/internal/models"
 
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrUnknownEventType is returned for events no handler method receives
+	ErrUnknownEventType = errors.New("unknown event type")
+	// ErrInvalidEventData is returned for event data that cannot be decoded
+	ErrInvalidEventData = errors.New("invalid event data")
+)
+
 type EventHandlerInterface interface {
 	HandleUserCreated(ctx context.Context, user *models.User) error
 	HandleUserUpdated(ctx context.Context, user *models.User) error
@@ -35,3 +44,37 @@ func (h *EventHandler) HandleUserDeleted(ctx context.Context, userID string) err
 	h.logger.Info("Processing user.deleted event", zap.String("id", userID))
 	return nil
 }
+
+// HandleCloudEvent decodes the data of event and passes it to the method of
+// handler receiving its type
+func HandleCloudEvent(ctx context.Context, handler EventHandlerInterface, event *CloudEvent) error {
+	switch event.Type {
+	case "user.created", "user.updated":
+		var user models.User
+		if err := json.Unmarshal(event.Data, &user); err != nil {
+			return errors.Wrapf(ErrInvalidEventData, "failed to unmarshal payload to user: %v", err)
+		}
+		if event.Type == "user.created" {
+			return handler.HandleUserCreated(ctx, &user)
+		}
+		return handler.HandleUserUpdated(ctx, &user)
+	case "user.deleted":
+		var payload struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(event.Data, &payload); err != nil {
+			return errors.Wrapf(ErrInvalidEventData, "payload is not of the expected type: %v", err)
+		}
+		// the subject is the deleted user's ID as well
+		id := payload.ID
+		if id == "" {
+			id = event.Subject
+		}
+		if id == "" {
+			return errors.Wrap(ErrInvalidEventData, "ID not found or invalid in payload")
+		}
+		return handler.HandleUserDeleted(ctx, id)
+	default:
+		return errors.Wrap(ErrUnknownEventType, event.Type)
+	}
+}
@@ -0,0 +1,41 @@
+package notification
+
+import (
+	"strings"
+	"time"
+
+	"user-microservice/internal/models"
+)
+
+// UserCreatedEvent describes the creation of user
+func UserCreatedEvent(user *models.User) Event {
+	return Event{
+		Type:      "user.created",
+		Timestamp: time.Now().UTC(),
+		Payload:   models.NewUserCreatedV1(user),
+		Subject:   user.ID,
+	}
+}
+
+// UserUpdatedEvent describes the update of previous into user; like the
+// events of the outbox, the routing key names the changed fields
+func UserUpdatedEvent(user, previous *models.User) Event {
+	payload := models.NewUserUpdatedV1(user, previous)
+	return Event{
+		Type:       "user.updated",
+		Timestamp:  time.Now().UTC(),
+		Payload:    payload,
+		Subject:    user.ID,
+		RoutingKey: strings.Join(append([]string{"user.updated"}, payload.ChangedFields...), "."),
+	}
+}
+
+// UserDeletedEvent describes the deletion of the user with the given ID
+func UserDeletedEvent(userID string) Event {
+	return Event{
+		Type:      "user.deleted",
+		Timestamp: time.Now().UTC(),
+		Payload:   models.NewUserDeletedV1(userID),
+		Subject:   userID,
+	}
+}
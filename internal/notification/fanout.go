@@ -0,0 +1,263 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSinkQueueSize = 1000
+	defaultSinkTimeout   = 10 * time.Second
+)
+
+// ErrSinkQueueFull is returned when a required sink has too many events
+// waiting to take another one
+var ErrSinkQueueFull = errors.New("sink queue is full")
+
+// Sink is a destination of the fan-out, such as RabbitMQ or webhooks
+type Sink struct {
+	Name    string
+	Service NotificationService
+	config.SinkConfig
+}
+
+// SinkHealth is the state and counters of a sink
+type SinkHealth struct {
+	Name     string `json:"name"`
+	Required bool   `json:"required"`
+	// Status is ok, or degraded while the sink's last publish failed or its
+	// queue is full
+	Status string `json:"status"`
+	// Queued is the number of events waiting for the sink
+	Queued              int        `json:"queued"`
+	Published           uint64     `json:"published"`
+	Failed              uint64     `json:"failed"`
+	Dropped             uint64     `json:"dropped"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastPublishedAt     *time.Time `json:"last_published_at,omitempty"`
+	// LastLatencyMs is how long the last publish took
+	LastLatencyMs int64 `json:"last_latency_ms"`
+}
+
+// FanoutNotificationService publishes every event to each of its sinks.
+// Each sink has its own queue, worker and timeout, so a slow or failing
+// sink does not delay or fail the others. Publish waits for the required
+// sinks and returns the first of their errors, so the outbox retries the
+// event; the event is then published again to every sink, which must
+// tolerate events they already published, e.g. by their ID. Best-effort
+// sinks only count and log their failures.
+type FanoutNotificationService struct {
+	sinks  []*sinkWorker
+	logger *zap.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// sinkWorker publishes the events queued for a sink, one at a time
+type sinkWorker struct {
+	Sink
+	queue chan sinkJob
+
+	mu     sync.Mutex
+	health SinkHealth
+}
+
+// sinkJob is an event queued for a sink; done receives the outcome of
+// events a Publish call waits for
+type sinkJob struct {
+	ctx   context.Context
+	event Event
+	done  chan error
+}
+
+// NewFanoutNotificationService starts a worker for each sink. Zero queue
+// sizes and timeouts use the defaults.
+func NewFanoutNotificationService(logger *zap.Logger, sinks ...Sink) *FanoutNotificationService {
+	f := &FanoutNotificationService{logger: logger.With(zap.String("component", "notification_fanout"))}
+	for _, sink := range sinks {
+		if sink.QueueSize <= 0 {
+			sink.QueueSize = defaultSinkQueueSize
+		}
+		if sink.Timeout <= 0 {
+			sink.Timeout = defaultSinkTimeout
+		}
+		w := &sinkWorker{
+			Sink:   sink,
+			queue:  make(chan sinkJob, sink.QueueSize),
+			health: SinkHealth{Name: sink.Name, Required: sink.Required, Status: "ok"},
+		}
+		f.sinks = append(f.sinks, w)
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.work(w)
+		}()
+	}
+	return f
+}
+
+func (f *FanoutNotificationService) NotifyUserCreated(ctx context.Context, user *models.User) error {
+	return f.Publish(ctx, UserCreatedEvent(user))
+}
+
+func (f *FanoutNotificationService) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
+	return f.Publish(ctx, UserUpdatedEvent(user, previous))
+}
+
+func (f *FanoutNotificationService) NotifyUserDeleted(ctx context.Context, userID string) error {
+	return f.Publish(ctx, UserDeletedEvent(userID))
+}
+
+// Publish queues event for every sink and waits until the required sinks
+// published it or ctx is done. Events without an ID get one, shared by all
+// sinks.
+func (f *FanoutNotificationService) Publish(ctx context.Context, event Event) error {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.closed {
+		return errServiceClosed
+	}
+
+	var err error
+	var waiting []*sinkWorker
+	var results []chan error
+	for _, w := range f.sinks {
+		job := sinkJob{ctx: ctx, event: event}
+		if w.Required {
+			job.done = make(chan error, 1)
+		} else {
+			// best-effort sinks outlive the caller
+			job.ctx = context.WithoutCancel(ctx)
+		}
+
+		select {
+		case w.queue <- job:
+		default:
+			w.dropped()
+			f.logger.Warn("sink queue full, event dropped", zap.String("sink", w.Name), zap.String("event_id", event.ID))
+			if w.Required && err == nil {
+				err = errors.Wrapf(ErrSinkQueueFull, "sink %s", w.Name)
+			}
+			continue
+		}
+		if w.Required {
+			waiting = append(waiting, w)
+			results = append(results, job.done)
+		}
+	}
+
+	for i, done := range results {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case sinkErr := <-done:
+			if sinkErr != nil && err == nil {
+				err = errors.Wrapf(sinkErr, "sink %s", waiting[i].Name)
+			}
+		}
+	}
+	return err
+}
+
+// work publishes the events queued for w until its queue is closed
+func (f *FanoutNotificationService) work(w *sinkWorker) {
+	for job := range w.queue {
+		if err := job.ctx.Err(); err != nil {
+			// the caller gave up on the event, which the outbox retries
+			job.done <- err
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(job.ctx, w.Timeout)
+		start := time.Now()
+		err := w.Service.Publish(ctx, job.event)
+		cancel()
+
+		w.record(err, time.Since(start))
+		if err != nil && !w.Required {
+			f.logger.Warn("best-effort sink failed to publish event",
+				zap.String("sink", w.Name),
+				zap.String("event_id", job.event.ID),
+				zap.Error(err))
+		}
+		if job.done != nil {
+			job.done <- err
+		}
+	}
+}
+
+// Health returns the state and counters of every sink
+func (f *FanoutNotificationService) Health() []SinkHealth {
+	health := make([]SinkHealth, 0, len(f.sinks))
+	for _, w := range f.sinks {
+		w.mu.Lock()
+		h := w.health
+		w.mu.Unlock()
+		h.Queued = len(w.queue)
+		if h.Queued == cap(w.queue) {
+			h.Status = "degraded"
+		}
+		health = append(health, h)
+	}
+	return health
+}
+
+// Close stops taking events and waits until the queued ones are published
+func (f *FanoutNotificationService) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return nil
+	}
+	f.closed = true
+	for _, w := range f.sinks {
+		close(w.queue)
+	}
+	f.mu.Unlock()
+
+	f.wg.Wait()
+	return nil
+}
+
+func (w *sinkWorker) record(err error, latency time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().UTC()
+	w.health.LastLatencyMs = latency.Milliseconds()
+	if err != nil {
+		w.health.Failed++
+		w.health.ConsecutiveFailures++
+		w.health.LastError = err.Error()
+		w.health.LastErrorAt = &now
+		w.health.Status = "degraded"
+		return
+	}
+	w.health.Published++
+	w.health.ConsecutiveFailures = 0
+	w.health.LastPublishedAt = &now
+	w.health.Status = "ok"
+}
+
+func (w *sinkWorker) dropped() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.health.Dropped++
+}
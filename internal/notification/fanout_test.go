@@ -0,0 +1,186 @@
+package notification
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// sinkService records the events published to it. It fails with err, and
+// blocks until release is closed when set.
+type sinkService struct {
+	mu      sync.Mutex
+	events  []Event
+	err     error
+	release chan struct{}
+}
+
+func (s *sinkService) NotifyUserCreated(ctx context.Context, user *models.User) error {
+	return s.Publish(ctx, UserCreatedEvent(user))
+}
+
+func (s *sinkService) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
+	return s.Publish(ctx, UserUpdatedEvent(user, previous))
+}
+
+func (s *sinkService) NotifyUserDeleted(ctx context.Context, userID string) error {
+	return s.Publish(ctx, UserDeletedEvent(userID))
+}
+
+func (s *sinkService) Publish(ctx context.Context, event Event) error {
+	if s.release != nil {
+		select {
+		case <-s.release:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return s.err
+}
+
+func (s *sinkService) published() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+func required(name string, service NotificationService) Sink {
+	return Sink{Name: name, Service: service, SinkConfig: config.SinkConfig{Required: true}}
+}
+
+func bestEffort(name string, service NotificationService) Sink {
+	return Sink{Name: name, Service: service}
+}
+
+func TestFanoutNotificationService_PublishesToEverySink(t *testing.T) {
+	rabbit, file := &sinkService{}, &sinkService{}
+	fanout := NewFanoutNotificationService(zap.NewNop(), required("rabbitmq", rabbit), bestEffort("file", file))
+
+	require.NoError(t, fanout.NotifyUserDeleted(context.Background(), "123"))
+	require.NoError(t, fanout.Close())
+
+	require.Len(t, rabbit.published(), 1)
+	require.Len(t, file.published(), 1)
+	// every sink gets the same event ID
+	assert.NotEmpty(t, rabbit.published()[0].ID)
+	assert.Equal(t, rabbit.published()[0].ID, file.published()[0].ID)
+	assert.Equal(t, "user.deleted", file.published()[0].Type)
+}
+
+func TestFanoutNotificationService_RequiredSinkFailureFailsPublish(t *testing.T) {
+	failure := errors.New("broker unavailable")
+	rabbit, webhooks := &sinkService{err: failure}, &sinkService{}
+	fanout := NewFanoutNotificationService(zap.NewNop(), required("rabbitmq", rabbit), required("webhooks", webhooks))
+	defer fanout.Close()
+
+	err := fanout.Publish(context.Background(), Event{ID: "1", Type: "user.deleted"})
+
+	assert.ErrorIs(t, err, failure)
+	assert.Contains(t, err.Error(), "sink rabbitmq")
+	// the other sink still published the event
+	assert.Len(t, webhooks.published(), 1)
+}
+
+func TestFanoutNotificationService_BestEffortSinkFailureIsIsolated(t *testing.T) {
+	rabbit, file := &sinkService{}, &sinkService{err: errors.New("disk full")}
+	fanout := NewFanoutNotificationService(zap.NewNop(), required("rabbitmq", rabbit), bestEffort("file", file))
+
+	assert.NoError(t, fanout.Publish(context.Background(), Event{ID: "1", Type: "user.deleted"}))
+	require.NoError(t, fanout.Close())
+
+	health := fanout.Health()
+	require.Len(t, health, 2)
+	assert.Equal(t, "ok", health[0].Status)
+	assert.Equal(t, uint64(1), health[0].Published)
+	assert.Equal(t, "degraded", health[1].Status)
+	assert.Equal(t, uint64(1), health[1].Failed)
+	assert.Equal(t, 1, health[1].ConsecutiveFailures)
+	assert.Equal(t, "disk full", health[1].LastError)
+	assert.NotNil(t, health[1].LastErrorAt)
+}
+
+func TestFanoutNotificationService_SlowSinkDoesNotDelayOthers(t *testing.T) {
+	slow := &sinkService{release: make(chan struct{})}
+	rabbit := &sinkService{}
+	fanout := NewFanoutNotificationService(zap.NewNop(), required("rabbitmq", rabbit), bestEffort("bus", slow))
+
+	start := time.Now()
+	assert.NoError(t, fanout.Publish(context.Background(), Event{ID: "1", Type: "user.deleted"}))
+	assert.NoError(t, fanout.Publish(context.Background(), Event{ID: "2", Type: "user.deleted"}))
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Len(t, rabbit.published(), 2)
+
+	assert.Equal(t, 1, fanout.Health()[1].Queued)
+	close(slow.release)
+	require.NoError(t, fanout.Close())
+	assert.Len(t, slow.published(), 2)
+}
+
+func TestFanoutNotificationService_SinkTimeout(t *testing.T) {
+	stuck := &sinkService{release: make(chan struct{})}
+	sink := required("webhooks", stuck)
+	sink.Timeout = 20 * time.Millisecond
+	fanout := NewFanoutNotificationService(zap.NewNop(), sink)
+	defer fanout.Close()
+
+	err := fanout.Publish(context.Background(), Event{ID: "1", Type: "user.deleted"})
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, "degraded", fanout.Health()[0].Status)
+}
+
+func TestFanoutNotificationService_FullQueueDropsEvents(t *testing.T) {
+	slow := &sinkService{release: make(chan struct{})}
+	rabbit := &sinkService{release: make(chan struct{})}
+	file := bestEffort("file", slow)
+	file.QueueSize = 1
+	broker := required("rabbitmq", rabbit)
+	broker.QueueSize = 1
+	fanout := NewFanoutNotificationService(zap.NewNop(), file, broker)
+
+	// each worker takes the first event and blocks, the second one waits in
+	// the queue and the third one is dropped
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, fanout.Publish(context.Background(), Event{Type: "user.deleted"}))
+		}()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	err := fanout.Publish(context.Background(), Event{Type: "user.deleted"})
+	assert.ErrorIs(t, err, ErrSinkQueueFull)
+
+	health := fanout.Health()
+	assert.Equal(t, uint64(1), health[0].Dropped)
+	assert.Equal(t, uint64(1), health[1].Dropped)
+	assert.Equal(t, "degraded", health[1].Status)
+
+	close(slow.release)
+	close(rabbit.release)
+	wg.Wait()
+	require.NoError(t, fanout.Close())
+	assert.Len(t, rabbit.published(), 2)
+}
+
+func TestFanoutNotificationService_Closed(t *testing.T) {
+	fanout := NewFanoutNotificationService(zap.NewNop(), required("rabbitmq", &sinkService{}))
+	require.NoError(t, fanout.Close())
+	require.NoError(t, fanout.Close())
+
+	assert.ErrorIs(t, fanout.Publish(context.Background(), Event{Type: "user.deleted"}), errServiceClosed)
+}
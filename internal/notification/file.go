@@ -0,0 +1,83 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// FileNotificationService appends every event to a file as a structured
+// CloudEvent, one JSON object per line, e.g. as an audit log or for local
+// debugging
+type FileNotificationService struct {
+	cloudEvents config.CloudEventsConfig
+	logger      *zap.Logger
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileNotificationService opens path for appending, creating it if needed
+func NewFileNotificationService(path string, cloudEvents config.CloudEventsConfig, logger *zap.Logger) (*FileNotificationService, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, errors.Wrap(err, "error opening event file")
+	}
+	return &FileNotificationService{
+		cloudEvents: cloudEvents,
+		logger:      logger.With(zap.String("component", "notification_file")),
+		file:        file,
+	}, nil
+}
+
+func (s *FileNotificationService) NotifyUserCreated(ctx context.Context, user *models.User) error {
+	return s.Publish(ctx, UserCreatedEvent(user))
+}
+
+func (s *FileNotificationService) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
+	return s.Publish(ctx, UserUpdatedEvent(user, previous))
+}
+
+func (s *FileNotificationService) NotifyUserDeleted(ctx context.Context, userID string) error {
+	return s.Publish(ctx, UserDeletedEvent(userID))
+}
+
+func (s *FileNotificationService) Publish(ctx context.Context, event Event) error {
+	ce, err := NewCloudEvent(event, s.cloudEvents)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(ce)
+	if err != nil {
+		return errors.Wrap(err, "error serializing event")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errServiceClosed
+	}
+	// a single write keeps lines whole
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "error writing event file")
+	}
+	return nil
+}
+
+func (s *FileNotificationService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
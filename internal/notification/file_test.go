@@ -0,0 +1,47 @@
+package notification
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFileNotificationService_AppendsCloudEvents(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	service, err := NewFileNotificationService(path, config.CloudEventsConfig{Source: "user-microservice"}, zap.NewNop())
+	require.NoError(t, err)
+
+	require.NoError(t, service.NotifyUserCreated(context.Background(), &models.User{ID: "1", Password: "hash"}))
+	require.NoError(t, service.NotifyUserDeleted(context.Background(), "1"))
+	require.NoError(t, service.Close())
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	var events []CloudEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var ce CloudEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &ce))
+		events = append(events, ce)
+	}
+	require.Len(t, events, 2)
+	assert.Equal(t, "user.created", events[0].Type)
+	assert.Equal(t, "user-microservice", events[0].Source)
+	assert.NotContains(t, string(events[0].Data), "hash")
+	assert.Equal(t, "user.deleted", events[1].Type)
+	assert.Equal(t, "1", events[1].Subject)
+
+	assert.ErrorIs(t, service.NotifyUserDeleted(context.Background(), "2"), errServiceClosed)
+}
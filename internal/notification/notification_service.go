@@ -2,9 +2,12 @@ package notification
 
 import (
 	"context"
-	"encoding/json"
+	"io"
+	"sync"
 	"time"
 
+	"user-microservice/internal/backoff"
+	"user-microservice/internal/config"
 	"user-microservice/internal/models"
 
 	"github.com/pkg/errors"
@@ -12,116 +15,336 @@ import (
 	"go.uber.org/zap"
 )
 
+// Event is an event to publish; it is sent as a CloudEvent
 type Event struct {
+	// ID identifies the event, so consumers can drop redelivered events
+	ID        string      `json:"id,omitempty"`
 	Type      string      `json:"type"`
 	Timestamp time.Time   `json:"timestamp"`
 	Payload   interface{} `json:"payload"`
+	// Subject is the ID of the user the event is about
+	Subject string `json:"subject,omitempty"`
+	// RoutingKey overrides the type as routing key, e.g. to name the
+	// fields an update changed
+	RoutingKey string `json:"-"`
 }
 
 type NotificationService interface {
 	NotifyUserCreated(ctx context.Context, user *models.User) error
-	NotifyUserUpdated(ctx context.Context, user *models.User) error
+	// NotifyUserUpdated describes the update of previous into user
+	NotifyUserUpdated(ctx context.Context, user, previous *models.User) error
 	NotifyUserDeleted(ctx context.Context, userID string) error
+	// Publish sends an event built elsewhere, e.g. read from the outbox
+	Publish(ctx context.Context, event Event) error
 }
 
 type ChannelInterface interface {
 	Publish(exchange, routingKey string, mandatory, immediate bool, msg amqp.Publishing) error
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
 	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
 	Close() error
 }
 
+var (
+	// ErrNotConnected is returned by publishes while the connection to
+	// RabbitMQ is down; callers such as the outbox relay retry them later
+	ErrNotConnected = errors.New("not connected to RabbitMQ")
+	// ErrPublishNacked is returned when the broker refuses a message
+	ErrPublishNacked = errors.New("message was not acknowledged by RabbitMQ")
+	errServiceClosed = errors.New("notification service is closed")
+)
+
+const (
+	defaultPublishTimeout = 5 * time.Second
+	reconnectMinBackoff   = 500 * time.Millisecond
+	reconnectMaxBackoff   = 30 * time.Second
+	// confirmBuffer holds the confirmations of publishes that timed out
+	// until the next publish discards them
+	confirmBuffer = 64
+)
+
+// dialFunc opens a connection and a channel on it
+type dialFunc func() (io.Closer, ChannelInterface, error)
+
+// RabbitMQNotificationService publishes events in confirm mode: a publish
+// returns once the broker acknowledged the message. Messages are mandatory,
+// so an event no queue is bound for fails with ErrUnroutable rather than
+// being dropped by the exchange. A supervisor reconnects
+// with jittered back-off when the connection drops; meanwhile publishes fail
+// with ErrNotConnected rather than being buffered in memory, as the outbox
+// already keeps the events until they are published.
 type RabbitMQNotificationService struct {
-	conn      *amqp.Connection
-	channel   ChannelInterface
-	queueName string
-	logger    *zap.Logger
+	dial           dialFunc
+	topology       config.NotificationConfig
+	schemas        *schemaValidator
+	publishTimeout time.Duration
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	logger         *zap.Logger
+
+	// publishMu serializes publishes, so confirmations arrive in publish order
+	publishMu sync.Mutex
+
+	// mu guards the connection state; channel is nil while disconnected
+	mu       sync.Mutex
+	conn     io.Closer
+	channel  ChannelInterface
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	closed   chan *amqp.Error
+	// deliveryTag is the tag of the last message published on channel
+	deliveryTag uint64
+
+	done      chan struct{}
+	closeOnce sync.Once
+	stopped   chan struct{}
 }
 
-func NewRabbitMQNotificationService(rabbitMQURL, queueName string, logger *zap.Logger) (*RabbitMQNotificationService, error) {
-	conn, err := amqp.Dial(rabbitMQURL)
-	if err != nil {
-		return nil, errors.Wrap(err, "error connecting to RabbitMQ")
+// NewRabbitMQNotificationService connects to RabbitMQ and declares the
+// topology of cfg. Events are validated against their schema before being
+// published.
+func NewRabbitMQNotificationService(cfg config.NotificationConfig, logger *zap.Logger) (*RabbitMQNotificationService, error) {
+	dial := func() (io.Closer, ChannelInterface, error) {
+		conn, err := amqp.Dial(cfg.RabbitMQURL)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error connecting to RabbitMQ")
+		}
+
+		channel, err := conn.Channel()
+		if err != nil {
+			conn.Close()
+			return nil, nil, errors.Wrap(err, "error creating RabbitMQ channel")
+		}
+		return conn, channel, nil
 	}
 
-	channel, err := conn.Channel()
-	if err != nil {
-		return nil, errors.Wrap(err, "error creating RabbitMQ channel")
+	return newRabbitMQNotificationService(dial, cfg, logger)
+}
+
+func newRabbitMQNotificationService(dial dialFunc, topology config.NotificationConfig, logger *zap.Logger) (*RabbitMQNotificationService, error) {
+	logger = logger.With(zap.String("component", "notification_service"))
+	s := &RabbitMQNotificationService{
+		dial:           dial,
+		topology:       topology,
+		schemas:        newSchemaValidator(topology.SchemaValidation, logger),
+		publishTimeout: defaultPublishTimeout,
+		minBackoff:     reconnectMinBackoff,
+		maxBackoff:     reconnectMaxBackoff,
+		logger:         logger,
+		done:           make(chan struct{}),
+		stopped:        make(chan struct{}),
 	}
 
-	_, err = channel.QueueDeclare(
-		queueName,
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+
+	go s.supervise()
+	return s, nil
+}
+
+// connect opens a channel in confirm mode and declares the topology on it
+func (s *RabbitMQNotificationService) connect() error {
+	conn, channel, err := s.dial()
 	if err != nil {
-		return nil, errors.Wrap(err, "error declaring queue")
+		return err
+	}
+
+	if err := declareTopology(channel, s.topology); err != nil {
+		conn.Close()
+		return err
 	}
 
-	return &RabbitMQNotificationService{
-		conn:      conn,
-		channel:   channel,
-		queueName: queueName,
-		logger:    logger.With(zap.String("component", "notification_service")),
-	}, nil
+	if err := channel.Confirm(false); err != nil {
+		conn.Close()
+		return errors.Wrap(err, "error enabling publisher confirms")
+	}
+
+	closed := channel.NotifyClose(make(chan *amqp.Error, 1))
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer))
+	returns := channel.NotifyReturn(make(chan amqp.Return, confirmBuffer))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.done:
+		conn.Close()
+		return errServiceClosed
+	default:
+	}
+	s.conn = conn
+	s.channel = channel
+	s.confirms = confirms
+	s.returns = returns
+	s.closed = closed
+	s.deliveryTag = 0
+	return nil
 }
 
-func (s *RabbitMQNotificationService) NotifyUserCreated(ctx context.Context, user *models.User) error {
-	event := Event{
-		Type:      "user.created",
-		Timestamp: time.Now().UTC(),
-		Payload:   user,
+// supervise reconnects whenever the channel or its connection closes,
+// until the service is closed
+func (s *RabbitMQNotificationService) supervise() {
+	defer close(s.stopped)
+
+	for {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+
+		var reason *amqp.Error
+		select {
+		case <-s.done:
+			return
+		case reason = <-closed:
+		}
+
+		s.disconnect()
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		if reason != nil {
+			s.logger.Warn("RabbitMQ connection lost, reconnecting", zap.String("reason", reason.Reason), zap.Int("code", reason.Code))
+		} else {
+			s.logger.Warn("RabbitMQ channel closed, reconnecting")
+		}
+
+		if !s.reconnect() {
+			return
+		}
 	}
+}
 
-	return s.sendNotification(ctx, event)
+func (s *RabbitMQNotificationService) disconnect() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn = nil
+	s.channel = nil
+	s.confirms = nil
+	s.returns = nil
+	s.closed = nil
 }
 
-func (s *RabbitMQNotificationService) NotifyUserUpdated(ctx context.Context, user *models.User) error {
-	event := Event{
-		Type:      "user.updated",
-		Timestamp: time.Now().UTC(),
-		Payload:   user,
+// reconnect retries connect until it succeeds or the service is closed
+func (s *RabbitMQNotificationService) reconnect() bool {
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(s.backoff(attempt)):
+		}
+
+		if err := s.connect(); err != nil {
+			if errors.Is(err, errServiceClosed) {
+				return false
+			}
+			s.logger.Warn("error reconnecting to RabbitMQ", zap.Int("attempt", attempt+1), zap.Error(err))
+			continue
+		}
+
+		s.logger.Info("reconnected to RabbitMQ", zap.Int("attempts", attempt+1))
+		return true
 	}
+}
 
-	return s.sendNotification(ctx, event)
+// backoff doubles the delay with each attempt up to the maximum, picking a
+// random delay between half and all of it so replicas do not reconnect in
+// lockstep
+func (s *RabbitMQNotificationService) backoff(attempt int) time.Duration {
+	// attempt counts from 0
+	return backoff.Exponential{Min: s.minBackoff, Max: s.maxBackoff}.Jittered(attempt + 1)
+}
+
+func (s *RabbitMQNotificationService) NotifyUserCreated(ctx context.Context, user *models.User) error {
+	return s.sendNotification(ctx, UserCreatedEvent(user))
+}
+
+func (s *RabbitMQNotificationService) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
+	return s.sendNotification(ctx, UserUpdatedEvent(user, previous))
 }
 
 func (s *RabbitMQNotificationService) NotifyUserDeleted(ctx context.Context, userID string) error {
-	event := Event{
-		Type:      "user.deleted",
-		Timestamp: time.Now().UTC(),
-		Payload: map[string]string{
-			"id": userID,
-		},
-	}
+	return s.sendNotification(ctx, UserDeletedEvent(userID))
+}
 
+func (s *RabbitMQNotificationService) Publish(ctx context.Context, event Event) error {
 	return s.sendNotification(ctx, event)
 }
 
+// sendNotification publishes event and waits until the broker confirms it,
+// the publish timeout elapses or ctx is done
 func (s *RabbitMQNotificationService) sendNotification(ctx context.Context, event Event) error {
-	payload, err := json.Marshal(event)
+	ce, err := NewCloudEvent(event, s.topology.CloudEvents)
+	if err != nil {
+		return err
+	}
+	if err := s.schemas.check(ce); err != nil {
+		return err
+	}
+	msg, err := encodeCloudEvent(ce, s.topology.CloudEvents.Mode)
 	if err != nil {
-		return errors.Wrap(err, "error serializing event")
-	}
-
-	s.logger.Debug("Payload", zap.String("payload", string(payload)))
-	s.logger.Info("Sending message to RabbitMQ", zap.String("queue", s.queueName), zap.String("event_type", event.Type))
-
-	err = s.channel.Publish(
-		"",          // Exchange
-		s.queueName, // Routing the message to the queue
-		false,       // No delivery confirmation
-		false,       // No priority
-		amqp.Publishing{
-			ContentType:  "application/json",
-			Body:         payload,
-			DeliveryMode: amqp.Persistent,
-		},
+		return err
+	}
+
+	s.logger.Debug("Payload", zap.String("payload", string(msg.Body)))
+	key := routingKey(event)
+	s.logger.Info("Sending message to RabbitMQ",
+		zap.String("exchange", s.topology.Exchange.Name),
+		zap.String("routing_key", key),
+		zap.String("event_id", ce.ID),
+		zap.String("event_type", event.Type))
+
+	s.publishMu.Lock()
+	defer s.publishMu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "error publishing message")
+	}
+
+	s.mu.Lock()
+	channel, confirms, returns := s.channel, s.confirms, s.returns
+	s.mu.Unlock()
+	if channel == nil {
+		return ErrNotConnected
+	}
+
+	// returns of the messages confirmed so far are theirs
+	drainReturns(returns)
+
+	err = channel.Publish(
+		s.topology.Exchange.Name,
+		key,
+		true,  // mandatory
+		false, // Not immediate
+		msg,
 	)
 	if err != nil {
-		return errors.Wrap(err, "error sending message to the queue")
+		return errors.Wrap(err, "error publishing message")
+	}
+
+	s.mu.Lock()
+	if s.channel != channel {
+		// reconnected meanwhile; the message was published on the old channel
+		s.mu.Unlock()
+		return ErrNotConnected
+	}
+	s.deliveryTag++
+	tag := s.deliveryTag
+	s.mu.Unlock()
+
+	if err := s.waitForConfirm(ctx, confirms, returns, tag); err != nil {
+		return err
 	}
 
 	s.logger.Info("Notification sent successfully",
@@ -131,11 +354,60 @@ func (s *RabbitMQNotificationService) sendNotification(ctx context.Context, even
 	return nil
 }
 
-func (s *RabbitMQNotificationService) Close() error {
-	if err := s.channel.Close(); err != nil {
-		return err
+// waitForConfirm waits for the confirmation of the message published with
+// tag. Confirmations of earlier messages, whose publish timed out, are
+// skipped. A timed out message may still be delivered, so delivery is at
+// least once. RabbitMQ returns an unroutable message before confirming it.
+func (s *RabbitMQNotificationService) waitForConfirm(ctx context.Context, confirms chan amqp.Confirmation, returns chan amqp.Return, tag uint64) error {
+	timer := time.NewTimer(s.publishTimeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case confirm, ok := <-confirms:
+			if !ok {
+				return errors.Wrap(ErrNotConnected, "channel closed before the message was confirmed")
+			}
+			if confirm.DeliveryTag < tag {
+				continue
+			}
+			if !confirm.Ack {
+				return ErrPublishNacked
+			}
+			if returned := drainReturns(returns); returned != nil {
+				return unroutable(returned)
+			}
+			return nil
+		case <-timer.C:
+			return errors.New("timed out waiting for RabbitMQ to confirm the message")
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "error waiting for RabbitMQ to confirm the message")
+		}
 	}
-	return s.conn.Close()
+}
+
+// Close stops reconnecting and closes the connection
+func (s *RabbitMQNotificationService) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		close(s.done)
+		channel, conn := s.channel, s.conn
+		s.channel, s.conn = nil, nil
+		s.mu.Unlock()
+
+		<-s.stopped
+
+		if channel != nil {
+			err = channel.Close()
+		}
+		if conn != nil {
+			if closeErr := conn.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
 }
 
 type MockNotificationService struct {
@@ -153,7 +425,7 @@ func (s *MockNotificationService) NotifyUserCreated(ctx context.Context, user *m
 	return nil
 }
 
-func (s *MockNotificationService) NotifyUserUpdated(ctx context.Context, user *models.User) error {
+func (s *MockNotificationService) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
 	s.logger.Info("Simulating user update notification", zap.String("id", user.ID))
 	return nil
 }
@@ -162,3 +434,8 @@ func (s *MockNotificationService) NotifyUserDeleted(ctx context.Context, userID
 	s.logger.Info("Simulating user deletion notification", zap.String("id", userID))
 	return nil
 }
+
+func (s *MockNotificationService) Publish(ctx context.Context, event Event) error {
+	s.logger.Info("Simulating event notification", zap.String("event_id", event.ID), zap.String("type", event.Type))
+	return nil
+}
@@ -2,113 +2,440 @@ package notification
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"io"
+	"sync"
 	"testing"
+	"time"
+	"user-microservice/internal/config"
 	"user-microservice/internal/models"
 
 	"github.com/google/uuid"
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
 )
 
-// MockChannel is a mock implementation of ChannelInterface
-type MockChannel struct {
-	mock.Mock
+// fakeChannel is a ChannelInterface confirming every publish unless told
+// otherwise; with noRoute set, mandatory messages are returned first
+type fakeChannel struct {
+	mu        sync.Mutex
+	published []published
+	exchanges []string
+	declared  []string
+	bindings  []string
+	confirms  []chan amqp.Confirmation
+	returns   []chan amqp.Return
+	closers   []chan *amqp.Error
+	tag       uint64
+	nack      bool
+	noConfirm bool
+	noRoute   bool
+	closed    bool
 }
 
-func (m *MockChannel) Publish(exchange, routingKey string, mandatory, immediate bool, msg amqp.Publishing) error {
-	args := m.Called(exchange, routingKey, mandatory, immediate, msg)
-	return args.Error(0)
+type published struct {
+	exchange   string
+	routingKey string
+	msg        amqp.Publishing
 }
 
-func (m *MockChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
-	callArgs := m.Called(name, durable, autoDelete, exclusive, noWait, args)
-
-	queue, ok := callArgs.Get(0).(amqp.Queue)
-	if !ok {
-		return amqp.Queue{}, errors.New("failed to assert amqp.Queue from mock arguments")
+func (c *fakeChannel) Publish(exchange, routingKey string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return amqp.ErrClosed
+	}
+	c.published = append(c.published, published{exchange: exchange, routingKey: routingKey, msg: msg})
+	c.tag++
+	if c.noRoute && mandatory {
+		for _, returns := range c.returns {
+			returns <- amqp.Return{Exchange: exchange, RoutingKey: routingKey, ReplyText: "NO_ROUTE"}
+		}
+	}
+	if !c.noConfirm {
+		for _, confirms := range c.confirms {
+			confirms <- amqp.Confirmation{DeliveryTag: c.tag, Ack: !c.nack}
+		}
 	}
+	return nil
+}
 
-	return queue, callArgs.Error(1)
+func (c *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.exchanges = append(c.exchanges, name+":"+kind)
+	return nil
 }
 
-func (m *MockChannel) Close() error {
-	args := m.Called()
-	return args.Error(0)
+func (c *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bindings = append(c.bindings, exchange+"->"+name+":"+key)
+	return nil
 }
 
-func TestRabbitMQNotificationService_NotifyUserCreated(t *testing.T) {
-	mockChannel := new(MockChannel)
-	mockChannel.On("Publish", "", "testQueue", false, false, mock.Anything).Return(nil)
+func (c *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.declared = append(c.declared, name)
+	return amqp.Queue{Name: name}, nil
+}
 
-	logger, _ := zap.NewDevelopment()
+func (c *fakeChannel) Confirm(noWait bool) error {
+	return nil
+}
 
-	service := &RabbitMQNotificationService{
-		conn:      nil, // Not needed for this test
-		channel:   mockChannel,
-		queueName: "testQueue",
-		logger:    logger,
-	}
+func (c *fakeChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirms = append(c.confirms, confirm)
+	return confirm
+}
 
-	user := &models.User{
-		ID:        uuid.New().String(),
-		FirstName: "John Doe",
+func (c *fakeChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.returns = append(c.returns, returns)
+	return returns
+}
+
+func (c *fakeChannel) NotifyClose(closer chan *amqp.Error) chan *amqp.Error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closers = append(c.closers, closer)
+	return closer
+}
+
+func (c *fakeChannel) Close() error {
+	c.shutdown(nil)
+	return nil
+}
+
+// drop simulates the loss of the connection
+func (c *fakeChannel) drop() {
+	c.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "connection lost"})
+}
+
+func (c *fakeChannel) shutdown(reason *amqp.Error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
 	}
+	c.closed = true
+	for _, closer := range c.closers {
+		if reason != nil {
+			closer <- reason
+		}
+		close(closer)
+	}
+	for _, confirms := range c.confirms {
+		close(confirms)
+	}
+}
 
-	err := service.NotifyUserCreated(context.Background(), user)
+func (c *fakeChannel) messages() []published {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]published(nil), c.published...)
+}
 
-	assert.NoError(t, err)
+type nopCloser struct{}
 
-	mockChannel.AssertExpectations(t)
+func (nopCloser) Close() error { return nil }
+
+// fakeDialer hands out channels in order and fails once they run out
+type fakeDialer struct {
+	mu       sync.Mutex
+	channels []*fakeChannel
+	dials    int
 }
 
-func TestRabbitMQNotificationService_NotifyUserUpdated(t *testing.T) {
-	mockChannel := new(MockChannel)
-	mockChannel.On("Publish", "", "testQueue", false, false, mock.Anything).Return(nil)
+func (d *fakeDialer) dial() (io.Closer, ChannelInterface, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if len(d.channels) == 0 {
+		return nil, nil, errors.New("connection refused")
+	}
+	channel := d.channels[0]
+	d.channels = d.channels[1:]
+	return nopCloser{}, channel, nil
+}
 
-	logger, _ := zap.NewDevelopment()
+func (d *fakeDialer) dialCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
 
-	service := &RabbitMQNotificationService{
-		conn:      nil, // Not needed for this test
-		channel:   mockChannel,
-		queueName: "testQueue",
-		logger:    logger,
+func newTestService(t *testing.T, channels ...*fakeChannel) (*RabbitMQNotificationService, *fakeDialer) {
+	dialer := &fakeDialer{channels: channels}
+	topology := config.NotificationConfig{
+		QueueName:   "testQueue",
+		Exchange:    config.ExchangeConfig{Name: "user.events"},
+		CloudEvents: config.CloudEventsConfig{Mode: config.CloudEventsStructured, Source: "user-microservice"},
+		// events not matching their schema fail the tests
+		SchemaValidation: config.SchemaValidationStrict,
 	}
+	service, err := newRabbitMQNotificationService(dialer.dial, topology, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.publishTimeout = 50 * time.Millisecond
+	service.minBackoff = time.Millisecond
+	service.maxBackoff = 5 * time.Millisecond
+	t.Cleanup(func() { service.Close() })
+	return service, dialer
+}
+
+func TestRabbitMQNotificationService_NotifyUserCreated(t *testing.T) {
+	channel := &fakeChannel{}
+	service, _ := newTestService(t, channel)
 
 	user := &models.User{
 		ID:        uuid.New().String(),
 		FirstName: "John Doe",
 	}
 
-	err := service.NotifyUserUpdated(context.Background(), user)
+	err := service.NotifyUserCreated(context.Background(), user)
 
 	assert.NoError(t, err)
+	assert.Equal(t, []string{"user.events:topic"}, channel.exchanges)
+	assert.Equal(t, []string{"testQueue"}, channel.declared)
+	assert.Equal(t, []string{"user.events->testQueue:#"}, channel.bindings)
+	if messages := channel.messages(); assert.Len(t, messages, 1) {
+		assert.Equal(t, "user.events", messages[0].exchange)
+		assert.Equal(t, "user.created", messages[0].routingKey)
+		assert.Equal(t, amqp.Persistent, messages[0].msg.DeliveryMode)
+		assert.Equal(t, CloudEventsContentType, messages[0].msg.ContentType)
+
+		var ce CloudEvent
+		assert.NoError(t, json.Unmarshal(messages[0].msg.Body, &ce))
+		assert.Equal(t, CloudEventsSpecVersion, ce.SpecVersion)
+		assert.Equal(t, "user.created", ce.Type)
+		assert.Equal(t, "user-microservice", ce.Source)
+		assert.Equal(t, user.ID, ce.Subject)
+		assert.NotEmpty(t, ce.ID)
+		assert.Equal(t, ce.ID, messages[0].msg.MessageId)
+		assert.JSONEq(t, `"John Doe"`, string(mustField(t, ce.Data, "first_name")))
+	}
+}
 
-	mockChannel.AssertExpectations(t)
+// Events are copied from the user without reading its password, so
+// sanitizing the user while events are published is not a data race; run
+// with -race (make test-race) to check
+func TestRabbitMQNotificationService_PublishBinaryMode(t *testing.T) {
+	channel := &fakeChannel{}
+	service, _ := newTestService(t, channel)
+	service.topology.CloudEvents.Mode = config.CloudEventsBinary
+	service.topology.CloudEvents.DataSchemaURL = "https://users.example.com/events/schemas/"
+
+	event := Event{ID: "event-1", Type: "user.deleted", Subject: "user-1", Payload: map[string]string{"id": "user-1"}}
+	assert.NoError(t, service.Publish(context.Background(), event))
+
+	if messages := channel.messages(); assert.Len(t, messages, 1) {
+		msg := messages[0].msg
+		assert.Equal(t, "application/json", msg.ContentType)
+		assert.JSONEq(t, `{"id":"user-1"}`, string(msg.Body))
+		assert.Equal(t, "1.0", msg.Headers["cloudEvents:specversion"])
+		assert.Equal(t, "event-1", msg.Headers["cloudEvents:id"])
+		assert.Equal(t, "user-microservice", msg.Headers["cloudEvents:source"])
+		assert.Equal(t, "user.deleted", msg.Headers["cloudEvents:type"])
+		assert.Equal(t, "user-1", msg.Headers["cloudEvents:subject"])
+		assert.Equal(t, "https://users.example.com/events/schemas/user.deleted/v1", msg.Headers["cloudEvents:dataschema"])
+	}
 }
 
-func TestRabbitMQNotificationService_NotifyUserDeleted(t *testing.T) {
-	mockChannel := new(MockChannel)
-	mockChannel.On("Publish", "", "testQueue", false, false, mock.Anything).Return(nil)
+func mustField(t *testing.T, data json.RawMessage, name string) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	return fields[name]
+}
 
-	logger, _ := zap.NewDevelopment()
+func TestRabbitMQNotificationService_NotifyUserUpdated(t *testing.T) {
+	channel := &fakeChannel{}
+	service, _ := newTestService(t, channel)
+
+	previous := &models.User{
+		ID:        uuid.New().String(),
+		FirstName: "John",
+	}
+	user := *previous
+	user.FirstName = "John Doe"
+
+	err := service.NotifyUserUpdated(context.Background(), &user, previous)
 
-	service := &RabbitMQNotificationService{
-		conn:      nil, // Not needed for this test
-		channel:   mockChannel,
-		queueName: "testQueue",
-		logger:    logger,
+	assert.NoError(t, err)
+	if messages := channel.messages(); assert.Len(t, messages, 1) {
+		assert.Equal(t, "user.updated.first_name", messages[0].routingKey)
+		var ce struct {
+			Data models.UserUpdatedV1 `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(messages[0].msg.Body, &ce))
+		assert.Equal(t, []string{"first_name"}, ce.Data.ChangedFields)
+		assert.Equal(t, "John", ce.Data.Previous["first_name"])
 	}
+}
+
+func TestRabbitMQNotificationService_NotifyUserDeleted(t *testing.T) {
+	channel := &fakeChannel{}
+	service, _ := newTestService(t, channel)
 
 	userID := uuid.New().String()
 
 	err := service.NotifyUserDeleted(context.Background(), userID)
 
 	assert.NoError(t, err)
+	if messages := channel.messages(); assert.Len(t, messages, 1) {
+		assert.Equal(t, "user.deleted", messages[0].routingKey)
+	}
+}
+
+func TestRabbitMQNotificationService_PublishRoutingKey(t *testing.T) {
+	channel := &fakeChannel{}
+	service, _ := newTestService(t, channel)
+
+	event := Event{ID: uuid.New().String(), Type: "user.updated", RoutingKey: "user.updated.email.nickname", Payload: models.NewUserUpdatedV1(&models.User{ID: "1"}, nil)}
+	assert.NoError(t, service.Publish(context.Background(), event))
+
+	if messages := channel.messages(); assert.Len(t, messages, 1) {
+		assert.Equal(t, "user.updated.email.nickname", messages[0].routingKey)
+		assert.Equal(t, event.ID, messages[0].msg.MessageId)
+		assert.NotContains(t, string(messages[0].msg.Body), "user.updated.email")
+	}
+}
+
+func TestDeclareTopology(t *testing.T) {
+	channel := &fakeChannel{}
+	queues := []config.QueueConfig{
+		{Name: "user_notifications", Bindings: []string{"user.#"}},
+		{Name: "email_changes", Bindings: []string{"user.created", "user.updated.#.email.#"}, Arguments: map[string]interface{}{"x-message-ttl": 60000}},
+	}
+	cfg := config.NotificationConfig{
+		QueueName: "user_notifications",
+		Exchange:  config.ExchangeConfig{Name: "events", Type: "topic"},
+		Queues:    queues,
+	}
+
+	assert.NoError(t, declareTopology(channel, cfg))
+
+	assert.Equal(t, []string{"events:topic"}, channel.exchanges)
+	// the subscriber queue is configured, so it is not bound to everything
+	assert.Equal(t, []string{"user_notifications", "email_changes"}, channel.declared)
+	assert.Equal(t, []string{
+		"events->user_notifications:user.#",
+		"events->email_changes:user.created",
+		"events->email_changes:user.updated.#.email.#",
+	}, channel.bindings)
+	assert.Len(t, cfg.Queues, 2)
+}
+
+func TestRabbitMQNotificationService_PublishWaitsForConfirm(t *testing.T) {
+	event := Event{ID: uuid.New().String(), Type: "user.created", Timestamp: time.Now().UTC(), Payload: models.NewUserCreatedV1(&models.User{ID: "1"})}
+
+	t.Run("nack", func(t *testing.T) {
+		service, _ := newTestService(t, &fakeChannel{nack: true})
+		assert.ErrorIs(t, service.Publish(context.Background(), event), ErrPublishNacked)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		channel := &fakeChannel{noConfirm: true}
+		service, _ := newTestService(t, channel)
+
+		err := service.Publish(context.Background(), event)
+
+		assert.ErrorContains(t, err, "timed out")
+		assert.Len(t, channel.messages(), 1)
+	})
 
-	mockChannel.AssertExpectations(t)
+	t.Run("context done", func(t *testing.T) {
+		service, _ := newTestService(t, &fakeChannel{noConfirm: true})
+		service.publishTimeout = time.Minute
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, service.Publish(ctx, event), context.DeadlineExceeded)
+	})
+
+	t.Run("unroutable", func(t *testing.T) {
+		channel := &fakeChannel{noRoute: true}
+		service, _ := newTestService(t, channel)
+
+		// the broker confirms the message it dropped, after returning it
+		assert.ErrorIs(t, service.Publish(context.Background(), event), ErrUnroutable)
+		assert.Len(t, channel.messages(), 1)
+
+		channel.mu.Lock()
+		channel.noRoute = false
+		channel.mu.Unlock()
+		assert.NoError(t, service.Publish(context.Background(), event))
+	})
+
+	t.Run("late confirms are skipped", func(t *testing.T) {
+		channel := &fakeChannel{noConfirm: true}
+		service, _ := newTestService(t, channel)
+
+		assert.Error(t, service.Publish(context.Background(), event))
+
+		// the first message is acked late, the second one is nacked
+		channel.mu.Lock()
+		channel.noConfirm = false
+		channel.nack = true
+		channel.confirms[0] <- amqp.Confirmation{DeliveryTag: 1, Ack: true}
+		channel.mu.Unlock()
+
+		assert.ErrorIs(t, service.Publish(context.Background(), event), ErrPublishNacked)
+	})
+}
+
+func TestRabbitMQNotificationService_Reconnects(t *testing.T) {
+	first, second := &fakeChannel{}, &fakeChannel{}
+	service, dialer := newTestService(t, first, second)
+
+	first.drop()
+
+	assert.Eventually(t, func() bool {
+		return service.Publish(context.Background(), Event{Type: "user.deleted", Payload: models.NewUserDeletedV1("1")}) == nil
+	}, time.Second, 5*time.Millisecond)
+
+	assert.Len(t, second.messages(), 1)
+	assert.Equal(t, []string{"user.events:topic"}, second.exchanges)
+	assert.Equal(t, []string{"testQueue"}, second.declared)
+	assert.Equal(t, 2, dialer.dialCount())
+}
+
+func TestRabbitMQNotificationService_RejectsWhileDisconnected(t *testing.T) {
+	channel := &fakeChannel{}
+	service, dialer := newTestService(t, channel)
+
+	channel.drop()
+
+	// the dialer has no channel left, so every reconnect fails
+	assert.Eventually(t, func() bool { return dialer.dialCount() > 2 }, time.Second, time.Millisecond)
+	assert.ErrorIs(t, service.Publish(context.Background(), Event{Type: "user.deleted", Payload: models.NewUserDeletedV1("1")}), ErrNotConnected)
+
+	assert.NoError(t, service.Close())
+	dials := dialer.dialCount()
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, dials, dialer.dialCount(), "reconnecting after Close")
+}
+
+func TestRabbitMQNotificationService_Backoff(t *testing.T) {
+	service := &RabbitMQNotificationService{minBackoff: time.Second, maxBackoff: 8 * time.Second}
+
+	for attempt, ceiling := range map[int]time.Duration{0: time.Second, 1: 2 * time.Second, 3: 8 * time.Second, 50: 8 * time.Second} {
+		for range 20 {
+			delay := service.backoff(attempt)
+			assert.GreaterOrEqual(t, delay, ceiling/2)
+			assert.LessOrEqual(t, delay, ceiling)
+		}
+	}
 }
 
 func TestMockNotificationService_NotifyUserCreated(t *testing.T) {
@@ -136,7 +463,7 @@ func TestMockNotificationService_NotifyUserUpdated(t *testing.T) {
 		FirstName: "John Doe",
 	}
 
-	err := service.NotifyUserUpdated(context.Background(), user)
+	err := service.NotifyUserUpdated(context.Background(), user, user)
 
 	assert.NoError(t, err)
 }
@@ -0,0 +1,111 @@
+package notification
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// ErrUnroutable is returned when the broker had no queue to route a
+// mandatory message to
+var ErrUnroutable = errors.New("message was returned as unroutable by RabbitMQ")
+
+// confirmChannel publishes in confirm mode and reports returned messages
+type confirmChannel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
+}
+
+// confirmedPublisher republishes messages taken from a queue, one at a
+// time. Messages are mandatory and published in confirm mode, so the
+// message being replaced is only acknowledged once its copy is queued.
+type confirmedPublisher struct {
+	channel  confirmChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	timeout  time.Duration
+
+	// mu serializes publishes, so confirmations arrive in publish order
+	mu sync.Mutex
+	// deliveryTag is the tag of the last message published on channel
+	deliveryTag uint64
+}
+
+func newConfirmedPublisher(channel confirmChannel) (*confirmedPublisher, error) {
+	if err := channel.Confirm(false); err != nil {
+		return nil, errors.Wrap(err, "error enabling publisher confirms")
+	}
+	return &confirmedPublisher{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer)),
+		returns:  channel.NotifyReturn(make(chan amqp.Return, confirmBuffer)),
+		timeout:  defaultPublishTimeout,
+	}, nil
+}
+
+// publish publishes msg and waits until the broker confirms it. RabbitMQ
+// returns an unroutable message before confirming it; a return left over
+// from an earlier message that timed out fails this one too, which only
+// leads to the message being handled again.
+func (p *confirmedPublisher) publish(exchange, key string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// returns of the messages confirmed so far are theirs
+	drainReturns(p.returns)
+
+	if err := p.channel.Publish(exchange, key, true, false, msg); err != nil {
+		return err
+	}
+	p.deliveryTag++
+	tag := p.deliveryTag
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case confirm, ok := <-p.confirms:
+			if !ok {
+				return errors.Wrap(ErrNotConnected, "channel closed before the message was confirmed")
+			}
+			if confirm.DeliveryTag < tag {
+				continue
+			}
+			if !confirm.Ack {
+				return ErrPublishNacked
+			}
+			if returned := drainReturns(p.returns); returned != nil {
+				return unroutable(returned)
+			}
+			return nil
+		case <-timer.C:
+			return errors.New("timed out waiting for RabbitMQ to confirm the message")
+		}
+	}
+}
+
+// drainReturns empties returns, reporting the last returned message
+func drainReturns(returns chan amqp.Return) *amqp.Return {
+	var last *amqp.Return
+	for {
+		select {
+		case returned, ok := <-returns:
+			if !ok {
+				return last
+			}
+			last = &returned
+		default:
+			return last
+		}
+	}
+}
+
+// unroutable is the error of a returned message
+func unroutable(returned *amqp.Return) error {
+	return errors.Wrapf(ErrUnroutable, "%s to %q: %s", returned.Exchange, returned.RoutingKey, returned.ReplyText)
+}
@@ -0,0 +1,173 @@
+package notification
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"user-microservice/internal/backoff"
+	"user-microservice/internal/config"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// RetryCountHeader counts the failed attempts to handle a message
+	RetryCountHeader = "x-retry-count"
+	// lastErrorHeader is the error of the last failed attempt
+	lastErrorHeader = "x-last-error"
+	// deadLetterReasonHeader tells why a message was dead-lettered
+	deadLetterReasonHeader = "x-dead-letter-reason"
+	// deadLetteredAtHeader is the time a message was dead-lettered at
+	deadLetteredAtHeader = "x-dead-lettered-at"
+	// originalRoutingKeyHeader is the routing key the message was first
+	// published with; retries are routed to the retry queues instead
+	originalRoutingKeyHeader = "x-original-routing-key"
+
+	// Reasons for dead-lettering a message
+	deadLetterRetriesExhausted = "retries exhausted"
+	deadLetterUnprocessable    = "unprocessable"
+
+	// maxErrorHeaderLength bounds the error kept in a message header
+	maxErrorHeaderLength = 1024
+)
+
+// retryPolicy routes the messages the subscriber failed to handle. A retry
+// is published to the queue of its delay, whose TTL is that delay and whose
+// expired messages go back to the subscriber's queue; retrying never
+// publishes to the events exchange, so other consumers do not see the event
+// again. The delay is part of the queue name, as RabbitMQ refuses to
+// redeclare a queue with another TTL when the back-off changes.
+type retryPolicy struct {
+	queue              string
+	maxAttempts        int
+	minBackoff         time.Duration
+	maxBackoff         time.Duration
+	deadLetterExchange string
+	deadLetterQueue    string
+}
+
+func newRetryPolicy(queue string, cfg config.SubscriberRetryConfig) retryPolicy {
+	p := retryPolicy{
+		queue:              queue,
+		maxAttempts:        max(cfg.MaxAttempts, 1),
+		minBackoff:         cfg.MinBackoff,
+		maxBackoff:         cfg.MaxBackoff,
+		deadLetterExchange: cfg.DeadLetterExchange,
+		deadLetterQueue:    cfg.DeadLetterQueue,
+	}
+	if p.minBackoff <= 0 {
+		p.minBackoff = time.Second
+	}
+	if p.maxBackoff < p.minBackoff {
+		p.maxBackoff = p.minBackoff
+	}
+	if p.deadLetterExchange == "" {
+		p.deadLetterExchange = "user.events.dlx"
+	}
+	if p.deadLetterQueue == "" {
+		p.deadLetterQueue = queue + ".dlq"
+	}
+	return p
+}
+
+// retryQueue is the queue delaying the retry after attempt failed attempts
+func (p retryPolicy) retryQueue(attempt int) string {
+	return fmt.Sprintf("%s.retry.%dms", p.queue, p.delay(attempt).Milliseconds())
+}
+
+// delay is the delay before the retry after attempt failed attempts,
+// doubling after each attempt up to the maximum back-off
+func (p retryPolicy) delay(attempt int) time.Duration {
+	return backoff.Exponential{Min: p.minBackoff, Max: p.maxBackoff}.Delay(attempt)
+}
+
+// declare declares the dead-letter exchange and queue, and a retry queue
+// per delay
+func (p retryPolicy) declare(channel topologyChannel) error {
+	if err := channel.ExchangeDeclare(p.deadLetterExchange, amqp.ExchangeDirect, true, false, false, false, nil); err != nil {
+		return errors.Wrapf(err, "error declaring exchange %s", p.deadLetterExchange)
+	}
+	if _, err := channel.QueueDeclare(p.deadLetterQueue, true, false, false, false, nil); err != nil {
+		return errors.Wrapf(err, "error declaring queue %s", p.deadLetterQueue)
+	}
+	if err := channel.QueueBind(p.deadLetterQueue, p.queue, p.deadLetterExchange, false, nil); err != nil {
+		return errors.Wrapf(err, "error binding queue %s to %s", p.deadLetterQueue, p.deadLetterExchange)
+	}
+
+	declared := map[string]bool{}
+	for attempt := 1; attempt < p.maxAttempts; attempt++ {
+		name := p.retryQueue(attempt)
+		if declared[name] {
+			continue
+		}
+		declared[name] = true
+		_, err := channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+			"x-message-ttl":             p.delay(attempt).Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": p.queue,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error declaring queue %s", name)
+		}
+	}
+	return nil
+}
+
+// retryCount returns the failed attempts recorded in the headers of msg
+func retryCount(headers amqp.Table) int {
+	switch count := headers[RetryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	case int16:
+		return int(count)
+	case int8:
+		return int(count)
+	}
+	return 0
+}
+
+// republish copies msg for publishing again with the given headers set
+func republish(msg amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	copied := amqp.Table{}
+	for name, value := range msg.Headers {
+		copied[name] = value
+	}
+	if _, ok := copied[originalRoutingKeyHeader]; !ok && msg.RoutingKey != "" {
+		copied[originalRoutingKeyHeader] = msg.RoutingKey
+	}
+	for name, value := range headers {
+		if value == nil {
+			delete(copied, name)
+			continue
+		}
+		copied[name] = value
+	}
+
+	return amqp.Publishing{
+		Headers:         copied,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		CorrelationId:   msg.CorrelationId,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
+
+// errorHeader is err as a header value, truncated to a reasonable size
+func errorHeader(err error) string {
+	message := err.Error()
+	if len(message) > maxErrorHeaderLength {
+		message = message[:maxErrorHeaderLength]
+	}
+	return strings.ToValidUTF8(message, "")
+}
@@ -0,0 +1,293 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// jsonSchemaDialect is the JSON Schema version of the generated schemas
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// ErrSchemaViolation is returned for event data not matching its schema
+var ErrSchemaViolation = errors.New("event data does not match its schema")
+
+// eventPayloads maps each event type and version to its payload type
+var eventPayloads = map[string]map[string]interface{}{
+	"user.created": {"v1": models.UserCreatedV1{}},
+	"user.updated": {"v1": models.UserUpdatedV1{}},
+	"user.deleted": {"v1": models.UserDeletedV1{}},
+}
+
+// EventTypes lists the types of the published events, sorted
+func EventTypes() []string {
+	types := make([]string, 0, len(eventPayloads))
+	for eventType := range eventPayloads {
+		types = append(types, eventType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Schema is the subset of JSON Schema needed to describe event payloads
+type Schema struct {
+	Dialect    string             `json:"$schema,omitempty"`
+	Title      string             `json:"title,omitempty"`
+	Type       string             `json:"type"`
+	Format     string             `json:"format,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is false for structs and the schema of the
+	// values for maps
+	AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+}
+
+// SchemaRegistry holds the JSON Schemas of the event payloads, generated
+// from their Go types
+type SchemaRegistry struct {
+	schemas map[string]*Schema
+}
+
+// NewSchemaRegistry generates the schemas of every event payload
+func NewSchemaRegistry() *SchemaRegistry {
+	registry := &SchemaRegistry{schemas: map[string]*Schema{}}
+	for eventType, versions := range eventPayloads {
+		for version, payload := range versions {
+			schema := schemaFor(reflect.TypeOf(payload))
+			schema.Dialect = jsonSchemaDialect
+			schema.Title = eventType + " " + version
+			registry.schemas[eventType+"/"+version] = schema
+		}
+	}
+	return registry
+}
+
+// Schema returns the schema of the data of eventType events in version
+func (r *SchemaRegistry) Schema(eventType, version string) (*Schema, bool) {
+	schema, ok := r.schemas[eventType+"/"+version]
+	return schema, ok
+}
+
+// Names lists the registered schemas as type/version, sorted
+func (r *SchemaRegistry) Names() []string {
+	names := make([]string, 0, len(r.schemas))
+	for name := range r.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Validate checks data against the schema of eventType events in version
+func (r *SchemaRegistry) Validate(eventType, version string, data []byte) error {
+	schema, ok := r.Schema(eventType, version)
+	if !ok {
+		return errors.Wrapf(ErrSchemaViolation, "no schema for %s %s", eventType, version)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return errors.Wrapf(ErrSchemaViolation, "invalid JSON: %v", err)
+	}
+
+	if err := schema.validate(value, ""); err != nil {
+		return errors.Wrapf(ErrSchemaViolation, "%s %s: %v", eventType, version, err)
+	}
+	return nil
+}
+
+// schemaVersion is the version of the schema of ce, the last segment of its
+// dataschema
+func schemaVersion(ce *CloudEvent) string {
+	if i := strings.LastIndex(ce.DataSchema, "/"); i >= 0 && i < len(ce.DataSchema)-1 {
+		return ce.DataSchema[i+1:]
+	}
+	return eventSchemaVersion
+}
+
+// schemaValidator validates event data against the registry, failing on
+// violations in strict mode and only logging them otherwise
+type schemaValidator struct {
+	registry *SchemaRegistry
+	strict   bool
+	logger   *zap.Logger
+}
+
+func newSchemaValidator(mode string, logger *zap.Logger) *schemaValidator {
+	return &schemaValidator{
+		registry: NewSchemaRegistry(),
+		strict:   mode == config.SchemaValidationStrict,
+		logger:   logger,
+	}
+}
+
+func (v *schemaValidator) check(ce *CloudEvent) error {
+	err := v.registry.Validate(ce.Type, schemaVersion(ce), ce.Data)
+	if err == nil || v.strict {
+		return err
+	}
+	v.logger.Warn("event does not match its schema",
+		zap.String("event_id", ce.ID),
+		zap.String("type", ce.Type),
+		zap.Error(err))
+	return nil
+}
+
+// schemaFor describes values of type t as encoded by encoding/json. Every
+// struct field without omitempty is required.
+func schemaFor(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaFor(t.Elem())}
+	case reflect.Struct:
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}, AdditionalProperties: false}
+		addFields(schema, t)
+		sort.Strings(schema.Required)
+		return schema
+	}
+	panic(fmt.Sprintf("no JSON Schema for %s", t))
+}
+
+// addFields adds the fields of struct t to schema, flattening embedded structs
+func addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(schema, field.Type)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema.Properties[name] = schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// validate checks a value decoded with UseNumber against s; path locates
+// the value in the payload for error messages
+func (s *Schema) validate(value interface{}, path string) error {
+	at := func(format string, args ...interface{}) error {
+		if path == "" {
+			return fmt.Errorf(format, args...)
+		}
+		return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	switch s.Type {
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return at("expected string")
+		}
+		if s.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return at("expected date-time")
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return at("expected boolean")
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return at("expected integer")
+		}
+		if _, err := number.Int64(); err != nil {
+			return at("expected integer")
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			return at("expected number")
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return at("expected array")
+		}
+		for i, item := range items {
+			if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return at("expected object")
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				return at("missing property %s", name)
+			}
+		}
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				additional, isSchema := s.AdditionalProperties.(*Schema)
+				if !isSchema {
+					return at("unexpected property %s", name)
+				}
+				property = additional
+			}
+			if err := property.validate(object[name], joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
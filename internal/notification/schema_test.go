@@ -0,0 +1,103 @@
+package notification
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"user-microservice/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaRegistry_Schema(t *testing.T) {
+	registry := NewSchemaRegistry()
+
+	assert.Equal(t, []string{"user.created/v1", "user.deleted/v1", "user.updated/v1"}, registry.Names())
+
+	schema, ok := registry.Schema("user.updated", "v1")
+	if assert.True(t, ok) {
+		body, err := json.Marshal(schema)
+		assert.NoError(t, err)
+
+		var decoded map[string]interface{}
+		assert.NoError(t, json.Unmarshal(body, &decoded))
+		assert.Equal(t, "https://json-schema.org/draft/2020-12/schema", decoded["$schema"])
+		assert.Equal(t, "object", decoded["type"])
+		assert.Equal(t, false, decoded["additionalProperties"])
+		assert.Contains(t, decoded["required"], "changed_fields")
+		assert.Contains(t, decoded["required"], "nickname")
+		assert.NotContains(t, decoded["properties"], "password")
+		assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, decoded["properties"].(map[string]interface{})["created_at"])
+	}
+
+	_, ok = registry.Schema("user.created", "v2")
+	assert.False(t, ok)
+}
+
+func TestSchemaRegistry_ValidatePayloads(t *testing.T) {
+	registry := NewSchemaRegistry()
+	user := &models.User{ID: "user-1", Nickname: "ann", Email: "ann@example.com", CreatedAt: time.Now(), UpdatedAt: time.Now()}
+	previous := *user
+	previous.Nickname = "annie"
+
+	for eventType, payload := range map[string]interface{}{
+		"user.created": models.NewUserCreatedV1(user),
+		"user.updated": models.NewUserUpdatedV1(user, &previous),
+		"user.deleted": models.NewUserDeletedV1(user.ID),
+	} {
+		data, err := json.Marshal(payload)
+		assert.NoError(t, err)
+		assert.NoError(t, registry.Validate(eventType, "v1", data), eventType)
+	}
+}
+
+func TestSchemaRegistry_ValidateViolations(t *testing.T) {
+	registry := NewSchemaRegistry()
+	valid := `"id":"1","first_name":"","last_name":"","nickname":"ann","email":"","country":"","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"`
+
+	tests := map[string]struct {
+		eventType string
+		version   string
+		data      string
+		message   string
+	}{
+		"unknown version":  {"user.created", "v2", `{}`, "no schema"},
+		"not json":         {"user.deleted", "v1", `{`, "invalid JSON"},
+		"not an object":    {"user.deleted", "v1", `null`, "expected object"},
+		"missing property": {"user.deleted", "v1", `{}`, "missing property id"},
+		"wrong type":       {"user.deleted", "v1", `{"id":1}`, "id: expected string"},
+		"extra property":   {"user.created", "v1", `{` + valid + `,"password":"hash"}`, "unexpected property password"},
+		"bad date":         {"user.created", "v1", `{"id":"1","first_name":"","last_name":"","nickname":"ann","email":"","country":"","created_at":"yesterday","updated_at":"2024-01-02T03:04:05Z"}`, "created_at: expected date-time"},
+		"bad array item":   {"user.updated", "v1", `{` + valid + `,"changed_fields":[1],"previous":{}}`, "changed_fields[0]: expected string"},
+		"bad map value":    {"user.updated", "v1", `{` + valid + `,"changed_fields":["email"],"previous":{"email":true}}`, "previous.email: expected string"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := registry.Validate(tt.eventType, tt.version, []byte(tt.data))
+			assert.ErrorIs(t, err, ErrSchemaViolation)
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	assert.Equal(t, "v1", schemaVersion(&CloudEvent{}))
+	assert.Equal(t, "v2", schemaVersion(&CloudEvent{DataSchema: "http://localhost:8080/events/schemas/user.created/v2"}))
+}
+
+func TestRabbitMQNotificationService_ValidatesSchema(t *testing.T) {
+	invalid := Event{Type: "user.deleted", Payload: map[string]int{"id": 1}}
+
+	channel := &fakeChannel{}
+	service, _ := newTestService(t, channel)
+	assert.ErrorIs(t, service.Publish(context.Background(), invalid), ErrSchemaViolation)
+	assert.Empty(t, channel.messages())
+
+	// in log mode the violation is only logged
+	service.schemas.strict = false
+	assert.NoError(t, service.Publish(context.Background(), invalid))
+	assert.Len(t, channel.messages(), 1)
+}
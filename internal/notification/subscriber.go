@@ -2,9 +2,11 @@ package notification
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"user-microservice/internal/models"
+	"hash/fnv"
+	"sync"
+	"time"
+	"user-microservice/internal/config"
 
 	"github.com/pkg/errors"
 	"github.com/streadway/amqp"
@@ -17,23 +19,56 @@ type RabbitMQConnection interface {
 }
 
 type RabbitMQChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
 	QueueDeclare(queue string, durable, delete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
 	Qos(prefetchCount, prefetchSize int, global bool) error
 	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(returns chan amqp.Return) chan amqp.Return
 	Close() error
 }
 
+// errEmptyMessage is returned for messages without a body
+var errEmptyMessage = errors.New("empty message")
+
 type RabbitMQSubscriber struct {
 	conn           RabbitMQConnection
 	channel        RabbitMQChannel
 	queueName      string
+	topology       config.NotificationConfig
+	schemas        *schemaValidator
+	retry          retryPolicy
+	republisher    *confirmedPublisher
 	logger         *zap.Logger
 	handler        EventHandlerInterface
 	enableConsumer bool
+	prefetch       int
+	workers        int
+	orderByUser    bool
+	drainTimeout   time.Duration
+
+	// stop and done end the consumer started by StartConsuming
+	stop context.CancelFunc
+	done chan struct{}
+	// abort cancels the handlers still running when draining times out
+	abort context.CancelFunc
 }
 
-func NewRabbitMQSubscriber(rabbitMQURL, queueName string, logger *zap.Logger, handler EventHandlerInterface, enableConsumer bool) (*RabbitMQSubscriber, error) {
-	conn, err := amqp.Dial(rabbitMQURL)
+const (
+	defaultDrainTimeout = 30 * time.Second
+	// abortTimeout bounds the wait for cancelled handlers to requeue their
+	// messages
+	abortTimeout = 5 * time.Second
+)
+
+// NewRabbitMQSubscriber creates a subscriber consuming cfg.QueueName. Events
+// are validated against their schema before being handled; failed events
+// are retried and dead-lettered as configured in cfg.Retry.
+func NewRabbitMQSubscriber(cfg config.NotificationConfig, logger *zap.Logger, handler EventHandlerInterface) (*RabbitMQSubscriber, error) {
+	conn, err := amqp.Dial(cfg.RabbitMQURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to connect to RabbitMQ")
 	}
@@ -43,35 +78,45 @@ func NewRabbitMQSubscriber(rabbitMQURL, queueName string, logger *zap.Logger, ha
 		return nil, errors.Wrap(err, "failed to create RabbitMQ channel")
 	}
 
+	logger = logger.With(zap.String("component", "notification_subscriber"))
 	return &RabbitMQSubscriber{
 		conn:           conn,
 		channel:        channel,
-		queueName:      queueName,
-		logger:         logger.With(zap.String("component", "notification_subscriber")),
+		queueName:      cfg.QueueName,
+		topology:       cfg,
+		schemas:        newSchemaValidator(cfg.SchemaValidation, logger),
+		retry:          newRetryPolicy(cfg.QueueName, cfg.Retry),
+		logger:         logger,
 		handler:        handler,
-		enableConsumer: enableConsumer,
+		enableConsumer: cfg.EnableConsumer,
+		prefetch:       cfg.Prefetch,
+		workers:        cfg.Workers,
+		orderByUser:    cfg.OrderByUser,
+		drainTimeout:   cfg.DrainTimeout,
 	}, nil
 }
 
 func (s *RabbitMQSubscriber) StartConsuming(ctx context.Context) error {
-	_, err := s.channel.QueueDeclare(
-		s.queueName,
-		true,  // durable
-		false, // delete when unused
-		false, // exclusive
-		false, // no-wait
-		nil,   // arguments
-	)
-	if err != nil {
-		return errors.Wrap(err, "failed to declare queue")
+	if err := declareTopology(s.channel, s.topology); err != nil {
+		return errors.Wrap(err, "failed to declare topology")
+	}
+
+	if err := s.retry.declare(s.channel); err != nil {
+		return errors.Wrap(err, "failed to declare retry topology")
 	}
 
 	s.logger.Info("Queue declared successfully", zap.String("queue", s.queueName))
 
+	republisher, err := newConfirmedPublisher(s.channel)
+	if err != nil {
+		return err
+	}
+	s.republisher = republisher
+
 	err = s.channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		max(s.prefetch, 1), // prefetch count
+		0,                  // prefetch size
+		false,              // global
 	)
 	if err != nil {
 		return errors.Wrap(err, "failed to configure QoS")
@@ -95,128 +140,232 @@ func (s *RabbitMQSubscriber) StartConsuming(ctx context.Context) error {
 		return nil
 	}
 
+	ctx, s.stop = context.WithCancel(ctx)
+	// handlers finish the messages in flight after ctx is done, until
+	// Close gives up draining
+	handlerCtx, abort := context.WithCancel(context.WithoutCancel(ctx))
+	s.abort = abort
+	s.done = make(chan struct{})
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				s.logger.Info("Stopping consumer due to canceled context")
-				return
-			case msg := <-msgs:
-				s.processMessage(ctx, msg)
-			}
-		}
+		defer close(s.done)
+		s.consume(ctx, handlerCtx, msgs)
 	}()
 
-	s.logger.Info("Consumer started successfully", zap.String("queue", s.queueName))
+	s.logger.Info("Consumer started successfully",
+		zap.String("queue", s.queueName),
+		zap.Int("workers", max(s.workers, 1)),
+		zap.Bool("order_by_user", s.orderByUser))
 	return nil
 }
 
-func (s *RabbitMQSubscriber) processMessage(ctx context.Context, msg amqp.Delivery) {
-	if msg.Body == nil {
-		s.logger.Error("Received empty message", zap.String("type", fmt.Sprintf("%T", msg)))
-		// aknowledge even if empty, otherwise it stays stuck in the queue
-		if err := msg.Ack(false); err != nil {
-			s.logger.Error("Error acknowledging empty message", zap.Error(err))
-		}
-		return
+// consume hands msgs to a pool of workers until ctx is done or the broker
+// closes the deliveries, then waits for the workers to finish the messages
+// they were given, handling them with handlerCtx. Messages not handed to a
+// worker yet stay unacknowledged and are redelivered once the channel
+// closes.
+func (s *RabbitMQSubscriber) consume(ctx, handlerCtx context.Context, msgs <-chan amqp.Delivery) {
+	workers := max(s.workers, 1)
+
+	// without ordering every worker takes from the same queue
+	queues := make([]chan amqp.Delivery, 1)
+	if s.orderByUser {
+		queues = make([]chan amqp.Delivery, workers)
+	}
+	for i := range queues {
+		queues[i] = make(chan amqp.Delivery, max(s.prefetch, 1))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		queue := queues[i%len(queues)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range queue {
+				s.processMessage(handlerCtx, msg)
+			}
+		}()
 	}
-	s.logger.Debug("Message received", zap.String("body", string(msg.Body)))
 
 	defer func() {
-		if err := msg.Ack(false); err != nil {
-			s.logger.Error("Error acknowledging message", zap.Error(err))
-		} else {
-			s.logger.Info("Message acknowledged successfully")
+		for _, queue := range queues {
+			close(queue)
 		}
+		wg.Wait()
+		s.logger.Info("Consumer drained")
 	}()
 
-	var event Event
-	if err := json.Unmarshal(msg.Body, &event); err != nil {
-		s.logger.Error("Failed to decode message", zap.Error(err))
-		return
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Stopping consumer due to canceled context")
+			return
+		case msg, ok := <-msgs:
+			if !ok {
+				s.logger.Warn("Delivery channel closed, stopping consumer")
+				return
+			}
+			queue := queues[0]
+			if len(queues) > 1 {
+				queue = queues[workerFor(orderingKey(msg), len(queues))]
+			}
+			select {
+			case queue <- msg:
+			case <-ctx.Done():
+				// not handed out; redelivered once the channel closes
+				return
+			}
+		}
 	}
+}
 
-	s.logger.Debug("Message received", zap.String("type", event.Type), zap.Time("timestamp", event.Timestamp))
+// orderingKey is the user a message is about: the subject of its event, or
+// its message ID when it is not a valid event
+func orderingKey(msg amqp.Delivery) string {
+	if event, err := decodeCloudEvent(msg); err == nil && event.Subject != "" {
+		return event.Subject
+	}
+	return msg.MessageId
+}
 
-	payload, err := json.Marshal(event.Payload)
-	if err != nil {
-		s.logger.Error("Failed to serialize payload", zap.Error(err))
+// workerFor picks the worker handling the messages with key
+func workerFor(key string, workers int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	return int(hash.Sum32() % uint32(workers))
+}
+
+// processMessage handles msg and acknowledges it. A message whose handling
+// failed is first published again, to its retry queue or to the dead-letter
+// exchange, and only acknowledged once the broker confirmed the copy; when
+// that fails it is requeued.
+func (s *RabbitMQSubscriber) processMessage(ctx context.Context, msg amqp.Delivery) {
+	err := s.handleMessage(ctx, msg)
+	if err != nil && ctx.Err() != nil {
+		// cancelled as draining timed out; the attempt does not count
+		if err := msg.Nack(false, true); err != nil {
+			s.logger.Error("Error requeuing message", zap.Error(err))
+		}
 		return
 	}
-	s.logger.Debug("Payload", zap.String("payload", string(payload)))
+	if err != nil {
+		if err := s.retryOrDeadLetter(msg, err); err != nil {
+			s.logger.Error("Error rerouting failed message, requeuing it", zap.String("message_id", msg.MessageId), zap.Error(err))
+			if err := msg.Nack(false, true); err != nil {
+				s.logger.Error("Error requeuing message", zap.Error(err))
+			}
+			return
+		}
+	}
 
-	switch event.Type {
-	case "user.created":
-		s.handleUserCreated(ctx, event)
-	case "user.updated":
-		s.handleUserUpdated(ctx, event)
-	case "user.deleted":
-		s.handleUserDeleted(ctx, event)
-	default:
-		s.logger.Warn("Unknown event type", zap.String("type", event.Type))
+	if err := msg.Ack(false); err != nil {
+		s.logger.Error("Error acknowledging message", zap.Error(err))
+	} else {
+		s.logger.Debug("Message acknowledged successfully")
 	}
 }
 
-func (s *RabbitMQSubscriber) handleUserCreated(ctx context.Context, event Event) {
-	s.logger.Info("Handling user.created event")
-	if payloadMap, ok := event.Payload.(map[string]interface{}); ok {
-		var user models.User
-		payloadBytes, err := json.Marshal(payloadMap)
-		if err != nil {
-			s.logger.Error("Failed to marshal payload", zap.Error(err))
-			return
-		}
+// handleMessage decodes msg and passes it to the handler
+func (s *RabbitMQSubscriber) handleMessage(ctx context.Context, msg amqp.Delivery) error {
+	if msg.Body == nil {
+		s.logger.Error("Received empty message", zap.String("type", fmt.Sprintf("%T", msg)))
+		return errEmptyMessage
+	}
+	s.logger.Debug("Message received", zap.String("body", string(msg.Body)))
 
-		if err := json.Unmarshal(payloadBytes, &user); err != nil {
-			s.logger.Error("Failed to unmarshal payload to user", zap.Error(err))
-			return
-		}
+	event, err := decodeCloudEvent(msg)
+	if err != nil {
+		s.logger.Error("Failed to decode message", zap.Error(err))
+		return err
+	}
 
-		s.logger.Debug("User unmarshalled successfully", zap.String("user_id", user.ID))
-		if err := s.handler.HandleUserCreated(ctx, &user); err != nil {
-			s.logger.Error("Failed to process user.created", zap.Error(err))
-		}
+	s.logger.Debug("Message received",
+		zap.String("id", event.ID),
+		zap.String("source", event.Source),
+		zap.String("type", event.Type),
+		zap.String("subject", event.Subject))
+
+	if err := s.schemas.check(event); err != nil {
+		s.logger.Error("Rejected event not matching its schema", zap.String("id", event.ID), zap.Error(err))
+		return err
 	}
+
+	if err := HandleCloudEvent(ctx, s.handler, event); err != nil {
+		s.logger.Error("Failed to process event", zap.String("id", event.ID), zap.String("type", event.Type), zap.Error(err))
+		return err
+	}
+	return nil
 }
 
-func (s *RabbitMQSubscriber) handleUserUpdated(ctx context.Context, event Event) {
-	s.logger.Info("Handling user.updated event")
-	if payloadMap, ok := event.Payload.(map[string]interface{}); ok {
-		var user models.User
-		payloadBytes, err := json.Marshal(payloadMap)
-		if err != nil {
-			s.logger.Error("Failed to marshal payload", zap.Error(err))
-			return
-		}
+// retryOrDeadLetter publishes a message whose handling failed with cause to
+// the retry queue of its attempt, or to the dead-letter exchange once its
+// attempts are exhausted or when retrying cannot help
+func (s *RabbitMQSubscriber) retryOrDeadLetter(msg amqp.Delivery, cause error) error {
+	attempts := retryCount(msg.Headers) + 1
+	headers := amqp.Table{
+		RetryCountHeader: int32(attempts),
+		lastErrorHeader:  errorHeader(cause),
+	}
 
-		if err := json.Unmarshal(payloadBytes, &user); err != nil {
-			s.logger.Error("Failed to unmarshal payload to user", zap.Error(err))
-			return
+	if unprocessable(cause) || attempts >= s.retry.maxAttempts {
+		reason := deadLetterRetriesExhausted
+		if unprocessable(cause) {
+			reason = deadLetterUnprocessable
 		}
+		headers[deadLetterReasonHeader] = reason
+		headers[deadLetteredAtHeader] = time.Now().UTC().Format(time.RFC3339)
 
-		s.logger.Debug("User unmarshalled successfully", zap.String("user_id", user.ID))
-		if err := s.handler.HandleUserUpdated(ctx, &user); err != nil {
-			s.logger.Error("Failed to process user.updated", zap.Error(err))
-		}
+		s.logger.Warn("Dead-lettering message",
+			zap.String("message_id", msg.MessageId),
+			zap.Int("attempts", attempts),
+			zap.String("reason", reason),
+			zap.Error(cause))
+		return s.republisher.publish(s.retry.deadLetterExchange, s.queueName, republish(msg, headers))
 	}
+
+	s.logger.Info("Retrying message",
+		zap.String("message_id", msg.MessageId),
+		zap.Int("attempts", attempts),
+		zap.Duration("delay", s.retry.delay(attempts)))
+	return s.republisher.publish("", s.retry.retryQueue(attempts), republish(msg, headers))
 }
 
-func (s *RabbitMQSubscriber) handleUserDeleted(ctx context.Context, event Event) {
-	s.logger.Info("Handling user.deleted event")
-	if payload, ok := event.Payload.(map[string]interface{}); ok {
-		if id, exists := payload["id"].(string); exists {
-			if err := s.handler.HandleUserDeleted(ctx, id); err != nil {
-				s.logger.Error("Failed to process user.deleted", zap.Error(err))
+// unprocessable reports whether handling a message failed in a way
+// retrying cannot fix, such as a message that is not a valid event
+func unprocessable(err error) bool {
+	return errors.Is(err, errEmptyMessage) ||
+		errors.Is(err, ErrInvalidCloudEvent) ||
+		errors.Is(err, ErrSchemaViolation) ||
+		errors.Is(err, ErrUnknownEventType) ||
+		errors.Is(err, ErrInvalidEventData)
+}
+
+// Close stops the consumer, waits up to the drain timeout for the messages
+// being handled, then cancels the handlers still running and waits briefly
+// for them to requeue their messages, before closing the channel and the
+// connection
+func (s *RabbitMQSubscriber) Close() error {
+	if s.stop != nil {
+		s.stop()
+		timeout := s.drainTimeout
+		if timeout <= 0 {
+			timeout = defaultDrainTimeout
+		}
+		select {
+		case <-s.done:
+		case <-time.After(timeout):
+			s.logger.Warn("Timed out draining the consumer, cancelling the handlers", zap.Duration("timeout", timeout))
+			s.abort()
+			select {
+			case <-s.done:
+			case <-time.After(abortTimeout):
+				// the broker requeues their messages once the channel closes
+				s.logger.Warn("Cancelled handlers did not return", zap.Duration("timeout", abortTimeout))
 			}
-		} else {
-			s.logger.Error("ID not found or invalid in payload", zap.Any("payload", payload))
 		}
-	} else {
-		s.logger.Error("Payload is not of the expected type", zap.Any("payload", event.Payload))
+		s.abort()
 	}
-}
 
-func (s *RabbitMQSubscriber) Close() error {
 	if err := s.channel.Close(); err != nil {
 		return err
 	}
@@ -3,12 +3,17 @@ package notification
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
+	"user-microservice/internal/config"
 	"user-microservice/internal/models"
 
 	"github.com/streadway/amqp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
 
@@ -27,9 +32,24 @@ func (m *MockRabbitMQConnection) Close() error {
 	return args.Error(0)
 }
 
-// Mocking RabbitMQChannel
+// Mocking RabbitMQChannel; published messages are confirmed right away,
+// after being returned when unroutable is set
 type MockRabbitMQChannel struct {
 	mock.Mock
+	confirms   chan amqp.Confirmation
+	returns    chan amqp.Return
+	tag        uint64
+	unroutable bool
+}
+
+func (m *MockRabbitMQChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	argsC := m.Called(name, kind, durable, autoDelete, internal, noWait, args)
+	return argsC.Error(0)
+}
+
+func (m *MockRabbitMQChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	argsC := m.Called(name, key, exchange, noWait, args)
+	return argsC.Error(0)
 }
 
 func (m *MockRabbitMQChannel) QueueDeclare(queue string, durable, delete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
@@ -47,6 +67,33 @@ func (m *MockRabbitMQChannel) Consume(queue, consumer string, autoAck, exclusive
 	return argsC.Get(0).(<-chan amqp.Delivery), argsC.Error(1)
 }
 
+func (m *MockRabbitMQChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	args := m.Called(exchange, key, mandatory, immediate, msg)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	if m.unroutable {
+		m.returns <- amqp.Return{Exchange: exchange, RoutingKey: key, ReplyText: "NO_ROUTE"}
+	}
+	m.tag++
+	m.confirms <- amqp.Confirmation{DeliveryTag: m.tag, Ack: true}
+	return nil
+}
+
+func (m *MockRabbitMQChannel) Confirm(noWait bool) error {
+	return nil
+}
+
+func (m *MockRabbitMQChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	m.confirms = confirm
+	return confirm
+}
+
+func (m *MockRabbitMQChannel) NotifyReturn(returns chan amqp.Return) chan amqp.Return {
+	m.returns = returns
+	return returns
+}
+
 func (m *MockRabbitMQChannel) Close() error {
 	args := m.Called()
 	return args.Error(0)
@@ -77,7 +124,13 @@ func TestRabbitMQSubscriber_StartConsuming(t *testing.T) {
 	mockChannel := new(MockRabbitMQChannel)
 	mockHandler := new(MockEventHandler)
 
+	mockChannel.On("ExchangeDeclare", "user.events", "topic", true, false, false, false, mock.Anything).Return(nil).Once()
 	mockChannel.On("QueueDeclare", "test-queue", true, false, false, false, mock.Anything).Return(amqp.Queue{}, nil).Once()
+	mockChannel.On("QueueBind", "test-queue", "#", "user.events", false, mock.Anything).Return(nil).Once()
+	mockChannel.On("ExchangeDeclare", "user.events.dlx", "direct", true, false, false, false, mock.Anything).Return(nil).Once()
+	mockChannel.On("QueueDeclare", "test-queue.dlq", true, false, false, false, mock.Anything).Return(amqp.Queue{}, nil).Once()
+	mockChannel.On("QueueBind", "test-queue.dlq", "test-queue", "user.events.dlx", false, mock.Anything).Return(nil).Once()
+	mockChannel.On("QueueDeclare", "test-queue.retry.1000ms", true, false, false, false, mock.Anything).Return(amqp.Queue{}, nil).Once()
 	mockChannel.On("Qos", 1, 0, false).Return(nil).Once()
 
 	mockChannel.On("Consume", "test-queue", "", false, false, false, false, mock.Anything).Return(make(<-chan amqp.Delivery), nil).Once()
@@ -87,6 +140,8 @@ func TestRabbitMQSubscriber_StartConsuming(t *testing.T) {
 		conn:           mockConn,
 		channel:        mockChannel,
 		queueName:      "test-queue",
+		topology:       config.NotificationConfig{QueueName: "test-queue", Exchange: config.ExchangeConfig{Name: "user.events"}},
+		retry:          newRetryPolicy("test-queue", config.SubscriberRetryConfig{MaxAttempts: 2}),
 		logger:         logger,
 		handler:        mockHandler,
 		enableConsumer: true,
@@ -106,7 +161,13 @@ func TestRabbitMQSubscriber_StartConsuming_WithError(t *testing.T) {
 	mockChannel := new(MockRabbitMQChannel)
 	mockHandler := new(MockEventHandler)
 
+	mockChannel.On("ExchangeDeclare", "user.events", "topic", true, false, false, false, mock.Anything).Return(nil).Once()
 	mockChannel.On("QueueDeclare", "test-queue", true, false, false, false, mock.Anything).Return(amqp.Queue{}, nil).Once()
+	mockChannel.On("QueueBind", "test-queue", "#", "user.events", false, mock.Anything).Return(nil).Once()
+	mockChannel.On("ExchangeDeclare", "user.events.dlx", "direct", true, false, false, false, mock.Anything).Return(nil).Once()
+	mockChannel.On("QueueDeclare", "test-queue.dlq", true, false, false, false, mock.Anything).Return(amqp.Queue{}, nil).Once()
+	mockChannel.On("QueueBind", "test-queue.dlq", "test-queue", "user.events.dlx", false, mock.Anything).Return(nil).Once()
+	mockChannel.On("QueueDeclare", "test-queue.retry.1000ms", true, false, false, false, mock.Anything).Return(amqp.Queue{}, nil).Once()
 	mockChannel.On("Qos", 1, 0, false).Return(nil).Once()
 	mockChannel.On("Consume", "test-queue", "", false, false, false, false, mock.Anything).Return(make(<-chan amqp.Delivery), errors.New("failed to register consumer")).Once()
 
@@ -115,6 +176,8 @@ func TestRabbitMQSubscriber_StartConsuming_WithError(t *testing.T) {
 		conn:           mockConn,
 		channel:        mockChannel,
 		queueName:      "test-queue",
+		topology:       config.NotificationConfig{QueueName: "test-queue", Exchange: config.ExchangeConfig{Name: "user.events"}},
+		retry:          newRetryPolicy("test-queue", config.SubscriberRetryConfig{MaxAttempts: 2}),
 		logger:         logger,
 		handler:        mockHandler,
 		enableConsumer: true,
@@ -129,3 +192,427 @@ func TestRabbitMQSubscriber_StartConsuming_WithError(t *testing.T) {
 	mockConn.AssertExpectations(t)
 	mockChannel.AssertExpectations(t)
 }
+
+func TestRabbitMQSubscriber_ProcessMessage(t *testing.T) {
+	cfg := config.CloudEventsConfig{Source: "user-microservice"}
+	user := &models.User{ID: "user-1", Nickname: "ann"}
+
+	for _, mode := range []string{config.CloudEventsStructured, config.CloudEventsBinary} {
+		t.Run(mode, func(t *testing.T) {
+			mockHandler := new(MockEventHandler)
+			mockHandler.On("HandleUserCreated", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
+				return u.ID == "user-1" && u.Nickname == "ann"
+			})).Return(nil).Once()
+			mockHandler.On("HandleUserDeleted", mock.Anything, "user-1").Return(nil).Once()
+
+			mockChannel := new(MockRabbitMQChannel)
+			subscriber := newTestSubscriber(mockHandler, mockChannel)
+			for _, event := range []Event{
+				{Type: "user.created", Subject: user.ID, Payload: models.NewUserCreatedV1(user)},
+				{Type: "user.deleted", Subject: user.ID, Payload: models.NewUserDeletedV1(user.ID)},
+			} {
+				ce, err := NewCloudEvent(event, cfg)
+				assert.NoError(t, err)
+				msg, err := encodeCloudEvent(ce, mode)
+				assert.NoError(t, err)
+				subscriber.processMessage(context.Background(), deliver(msg))
+			}
+
+			mockHandler.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRabbitMQSubscriber_ProcessMessage_DeadLettersInvalidEvents(t *testing.T) {
+	mockHandler := new(MockEventHandler)
+	mockChannel := new(MockRabbitMQChannel)
+	subscriber := newTestSubscriber(mockHandler, mockChannel)
+
+	var published []amqp.Publishing
+	mockChannel.On("Publish", "user.events.dlx", "test-queue", true, false, mock.Anything).
+		Run(func(args mock.Arguments) { published = append(published, args.Get(4).(amqp.Publishing)) }).
+		Return(nil).Times(2)
+
+	acks := &fakeAcknowledger{}
+	// not a CloudEvent
+	subscriber.processMessage(context.Background(), amqp.Delivery{
+		Acknowledger: acks,
+		MessageId:    "1",
+		RoutingKey:   "user.deleted",
+		Body:         []byte(`{"type":"user.deleted","payload":{"id":"user-1"}}`),
+	})
+	// data not matching the schema
+	subscriber.processMessage(context.Background(), amqp.Delivery{
+		Acknowledger: acks,
+		MessageId:    "2",
+		ContentType:  CloudEventsContentType,
+		Body:         []byte(`{"specversion":"1.0","id":"1","source":"s","type":"user.deleted","data":{"id":1}}`),
+	})
+
+	mockHandler.AssertNotCalled(t, "HandleUserDeleted", mock.Anything, mock.Anything)
+	mockChannel.AssertExpectations(t)
+	assert.Equal(t, 2, acks.acked)
+	require.Len(t, published, 2)
+	// retrying cannot fix them, so they are dead-lettered right away
+	assert.Equal(t, int32(1), published[0].Headers[RetryCountHeader])
+	assert.Equal(t, deadLetterUnprocessable, published[0].Headers[deadLetterReasonHeader])
+	assert.Equal(t, "user.deleted", published[0].Headers[originalRoutingKeyHeader])
+	assert.Equal(t, "1", published[0].MessageId)
+	assert.Contains(t, published[1].Headers[lastErrorHeader], "schema")
+}
+
+func TestRabbitMQSubscriber_ProcessMessage_RetriesHandlerFailures(t *testing.T) {
+	ce, err := NewCloudEvent(Event{Type: "user.deleted", Subject: "user-1", Payload: models.NewUserDeletedV1("user-1")}, config.CloudEventsConfig{Source: "user-microservice"})
+	require.NoError(t, err)
+	msg, err := encodeCloudEvent(ce, config.CloudEventsStructured)
+	require.NoError(t, err)
+
+	mockHandler := new(MockEventHandler)
+	mockHandler.On("HandleUserDeleted", mock.Anything, "user-1").Return(errors.New("database unavailable"))
+	mockChannel := new(MockRabbitMQChannel)
+	subscriber := newTestSubscriber(mockHandler, mockChannel)
+
+	var published []amqp.Publishing
+	record := func(args mock.Arguments) { published = append(published, args.Get(4).(amqp.Publishing)) }
+	// both retries wait the maximum back-off of 1s
+	mockChannel.On("Publish", "", "test-queue.retry.1000ms", true, false, mock.Anything).Run(record).Return(nil).Twice()
+	mockChannel.On("Publish", "user.events.dlx", "test-queue", true, false, mock.Anything).Run(record).Return(nil).Once()
+
+	acks := &fakeAcknowledger{}
+	delivery := deliver(msg)
+	delivery.Acknowledger = acks
+	for attempt := 0; attempt < 3; attempt++ {
+		subscriber.processMessage(context.Background(), delivery)
+		// the retry queue sends the message back with its headers
+		last := published[len(published)-1]
+		delivery.Headers = last.Headers
+	}
+
+	mockChannel.AssertExpectations(t)
+	assert.Equal(t, 3, acks.acked)
+	require.Len(t, published, 3)
+	assert.Equal(t, int32(1), published[0].Headers[RetryCountHeader])
+	assert.Equal(t, int32(2), published[1].Headers[RetryCountHeader])
+	assert.Equal(t, int32(3), published[2].Headers[RetryCountHeader])
+	assert.Equal(t, deadLetterRetriesExhausted, published[2].Headers[deadLetterReasonHeader])
+	assert.Equal(t, "database unavailable", published[2].Headers[lastErrorHeader])
+	assert.Equal(t, msg.Body, published[2].Body)
+}
+
+func TestRabbitMQSubscriber_ProcessMessage_RequeuesWhenRerouteFails(t *testing.T) {
+	mockChannel := new(MockRabbitMQChannel)
+	subscriber := newTestSubscriber(new(MockEventHandler), mockChannel)
+	mockChannel.On("Publish", "user.events.dlx", "test-queue", true, false, mock.Anything).Return(errors.New("channel closed")).Once()
+
+	acks := &fakeAcknowledger{}
+	subscriber.processMessage(context.Background(), amqp.Delivery{Acknowledger: acks, Body: []byte("not json")})
+
+	assert.Equal(t, 0, acks.acked)
+	assert.Equal(t, 1, acks.requeued)
+}
+
+func TestRabbitMQSubscriber_ProcessMessage_RequeuesUnroutableReroutes(t *testing.T) {
+	mockChannel := &MockRabbitMQChannel{unroutable: true}
+	subscriber := newTestSubscriber(new(MockEventHandler), mockChannel)
+	mockChannel.On("Publish", "user.events.dlx", "test-queue", true, false, mock.Anything).Return(nil).Once()
+
+	acks := &fakeAcknowledger{}
+	subscriber.processMessage(context.Background(), amqp.Delivery{Acknowledger: acks, Body: []byte("not json")})
+
+	// the dead-letter queue is missing, so the message must not be dropped
+	mockChannel.AssertExpectations(t)
+	assert.Equal(t, 0, acks.acked)
+	assert.Equal(t, 1, acks.requeued)
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy := newRetryPolicy("users", config.SubscriberRetryConfig{MaxAttempts: 5, MinBackoff: time.Second, MaxBackoff: 5 * time.Second})
+
+	assert.Equal(t, "users.retry.2000ms", policy.retryQueue(2))
+	assert.Equal(t, "users.retry.5000ms", policy.retryQueue(5))
+	assert.Equal(t, "users.dlq", policy.deadLetterQueue)
+	assert.Equal(t, time.Second, policy.delay(1))
+	assert.Equal(t, 2*time.Second, policy.delay(2))
+	assert.Equal(t, 4*time.Second, policy.delay(3))
+	assert.Equal(t, 5*time.Second, policy.delay(4))
+
+	channel := newFakeDeadLetterChannel()
+	require.NoError(t, policy.declare(channel))
+	assert.Equal(t, []string{"user.events.dlx:direct"}, channel.exchanges)
+	assert.Equal(t, []string{"users.dlq", "users.retry.1000ms", "users.retry.2000ms", "users.retry.4000ms", "users.retry.5000ms"}, channel.declared)
+	assert.Equal(t, int64(4000), channel.queueArgs["users.retry.4000ms"]["x-message-ttl"])
+	assert.Equal(t, "", channel.queueArgs["users.retry.4000ms"]["x-dead-letter-exchange"])
+	assert.Equal(t, "users", channel.queueArgs["users.retry.4000ms"]["x-dead-letter-routing-key"])
+
+	// attempts past the maximum back-off share its queue
+	long := newRetryPolicy("users", config.SubscriberRetryConfig{MaxAttempts: 6, MinBackoff: time.Second, MaxBackoff: 2 * time.Second})
+	channel = newFakeDeadLetterChannel()
+	require.NoError(t, long.declare(channel))
+	assert.Equal(t, []string{"users.dlq", "users.retry.1000ms", "users.retry.2000ms"}, channel.declared)
+	assert.Equal(t, []string{"user.events.dlx->users.dlq:users"}, channel.bindings)
+}
+
+// fakeAcknowledger counts the acknowledgements of deliveries
+type fakeAcknowledger struct {
+	mu       sync.Mutex
+	acked    int
+	requeued int
+	rejected int
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked++
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if requeue {
+		a.requeued++
+	} else {
+		a.rejected++
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func newTestSubscriber(handler EventHandlerInterface, channel RabbitMQChannel) *RabbitMQSubscriber {
+	republisher, err := newConfirmedPublisher(channel)
+	if err != nil {
+		panic(err)
+	}
+	return &RabbitMQSubscriber{
+		channel:     channel,
+		republisher: republisher,
+		queueName:   "test-queue",
+		schemas:     newSchemaValidator(config.SchemaValidationStrict, zap.NewNop()),
+		retry:       newRetryPolicy("test-queue", config.SubscriberRetryConfig{MaxAttempts: 3}),
+		logger:      zap.NewNop(),
+		handler:     handler,
+	}
+}
+
+// recordingHandler records the nicknames of the updated users it handles,
+// per user, and blocks each call until release is closed when it is set
+type recordingHandler struct {
+	mu        sync.Mutex
+	nicknames map[string][]string
+	inFlight  int
+	maxFlight int
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func newRecordingHandler() *recordingHandler {
+	return &recordingHandler{nicknames: map[string][]string{}, started: make(chan struct{}, 100)}
+}
+
+func (h *recordingHandler) HandleUserCreated(ctx context.Context, user *models.User) error {
+	return nil
+}
+
+func (h *recordingHandler) HandleUserUpdated(ctx context.Context, user *models.User) error {
+	h.mu.Lock()
+	h.inFlight++
+	h.maxFlight = max(h.maxFlight, h.inFlight)
+	h.mu.Unlock()
+	h.started <- struct{}{}
+
+	if h.release != nil {
+		<-h.release
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.inFlight--
+	h.nicknames[user.ID] = append(h.nicknames[user.ID], user.Nickname)
+	return nil
+}
+
+func (h *recordingHandler) HandleUserDeleted(ctx context.Context, id string) error {
+	return nil
+}
+
+// updatedDelivery is a structured user.updated event acknowledged to acks
+func updatedDelivery(t *testing.T, acks amqp.Acknowledger, userID, nickname string) amqp.Delivery {
+	user := &models.User{ID: userID, Nickname: nickname}
+	ce, err := NewCloudEvent(Event{Type: "user.updated", Subject: userID, Payload: models.NewUserUpdatedV1(user, nil)}, config.CloudEventsConfig{Source: "test"})
+	require.NoError(t, err)
+	msg, err := encodeCloudEvent(ce, config.CloudEventsStructured)
+	require.NoError(t, err)
+	delivery := deliver(msg)
+	delivery.Acknowledger = acks
+	return delivery
+}
+
+func TestRabbitMQSubscriber_Consume_OrdersEventsPerUser(t *testing.T) {
+	handler := newRecordingHandler()
+	subscriber := newTestSubscriber(handler, new(MockRabbitMQChannel))
+	subscriber.workers = 4
+	subscriber.prefetch = 8
+	subscriber.orderByUser = true
+
+	acks := &fakeAcknowledger{}
+	msgs := make(chan amqp.Delivery, 30)
+	users := []string{"user-1", "user-2", "user-3"}
+	for i := 0; i < 10; i++ {
+		for _, user := range users {
+			msgs <- updatedDelivery(t, acks, user, fmt.Sprintf("nick-%d", i))
+		}
+	}
+	close(msgs)
+
+	// returns once the closed deliveries are drained
+	subscriber.consume(context.Background(), context.Background(), msgs)
+
+	assert.Equal(t, 30, acks.acked)
+	for _, user := range users {
+		want := make([]string, 10)
+		for i := range want {
+			want[i] = fmt.Sprintf("nick-%d", i)
+		}
+		assert.Equal(t, want, handler.nicknames[user], user)
+	}
+}
+
+func TestRabbitMQSubscriber_Consume_HandlesConcurrently(t *testing.T) {
+	handler := newRecordingHandler()
+	handler.release = make(chan struct{})
+	subscriber := newTestSubscriber(handler, new(MockRabbitMQChannel))
+	subscriber.workers = 3
+	subscriber.prefetch = 3
+
+	acks := &fakeAcknowledger{}
+	msgs := make(chan amqp.Delivery, 3)
+	for i := 0; i < 3; i++ {
+		msgs <- updatedDelivery(t, acks, "user-1", fmt.Sprintf("nick-%d", i))
+	}
+	close(msgs)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		subscriber.consume(context.Background(), context.Background(), msgs)
+	}()
+	for i := 0; i < 3; i++ {
+		select {
+		case <-handler.started:
+		case <-time.After(time.Second):
+			t.Fatal("messages were not handled concurrently")
+		}
+	}
+	close(handler.release)
+	<-done
+
+	assert.Equal(t, 3, handler.maxFlight)
+	assert.Equal(t, 3, acks.acked)
+}
+
+func TestRabbitMQSubscriber_Close_DrainsInFlightMessages(t *testing.T) {
+	handler := newRecordingHandler()
+	handler.release = make(chan struct{})
+	mockConn := new(MockRabbitMQConnection)
+	mockConn.On("Close").Return(nil).Once()
+	mockChannel := new(MockRabbitMQChannel)
+	mockChannel.On("Close").Return(nil).Once()
+
+	subscriber := newTestSubscriber(handler, mockChannel)
+	subscriber.conn = mockConn
+	subscriber.workers = 2
+	subscriber.drainTimeout = time.Second
+
+	acks := &fakeAcknowledger{}
+	msgs := make(chan amqp.Delivery, 1)
+	msgs <- updatedDelivery(t, acks, "user-1", "ann")
+
+	var ctx, handlerCtx context.Context
+	ctx, subscriber.stop = context.WithCancel(context.Background())
+	handlerCtx, subscriber.abort = context.WithCancel(context.Background())
+	subscriber.done = make(chan struct{})
+	go func() {
+		defer close(subscriber.done)
+		subscriber.consume(ctx, handlerCtx, msgs)
+	}()
+	<-handler.started
+
+	closed := make(chan error)
+	go func() { closed <- subscriber.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned before the in-flight message was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(handler.release)
+	require.NoError(t, <-closed)
+	assert.Equal(t, 1, acks.acked)
+	assert.Equal(t, []string{"ann"}, handler.nicknames["user-1"])
+	mockChannel.AssertExpectations(t)
+	mockConn.AssertExpectations(t)
+}
+
+// cancelledHandler blocks until its context is cancelled
+type cancelledHandler struct {
+	MockEventHandler
+	started chan struct{}
+}
+
+func (h *cancelledHandler) HandleUserUpdated(ctx context.Context, user *models.User) error {
+	close(h.started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRabbitMQSubscriber_Close_CancelsHandlersAfterDrainTimeout(t *testing.T) {
+	handler := &cancelledHandler{started: make(chan struct{})}
+	mockConn := new(MockRabbitMQConnection)
+	mockConn.On("Close").Return(nil).Once()
+	acks := &fakeAcknowledger{}
+	// no Publish expected: the cancelled attempt is not retried
+	mockChannel := new(MockRabbitMQChannel)
+	mockChannel.On("Close").Run(func(mock.Arguments) {
+		acks.mu.Lock()
+		defer acks.mu.Unlock()
+		// requeued on the open channel rather than left to redelivery
+		assert.Equal(t, 1, acks.requeued)
+	}).Return(nil).Once()
+
+	subscriber := newTestSubscriber(handler, mockChannel)
+	subscriber.conn = mockConn
+	subscriber.drainTimeout = 50 * time.Millisecond
+
+	msgs := make(chan amqp.Delivery, 1)
+	msgs <- updatedDelivery(t, acks, "user-1", "ann")
+
+	var ctx, handlerCtx context.Context
+	ctx, subscriber.stop = context.WithCancel(context.Background())
+	handlerCtx, subscriber.abort = context.WithCancel(context.Background())
+	subscriber.done = make(chan struct{})
+	go func() {
+		defer close(subscriber.done)
+		subscriber.consume(ctx, handlerCtx, msgs)
+	}()
+	<-handler.started
+
+	require.NoError(t, subscriber.Close())
+
+	assert.Equal(t, 0, acks.acked)
+	assert.Equal(t, 1, acks.requeued)
+	mockChannel.AssertExpectations(t)
+	mockConn.AssertExpectations(t)
+}
+
+func TestWorkerFor(t *testing.T) {
+	assert.Equal(t, workerFor("user-1", 4), workerFor("user-1", 4))
+	for _, key := range []string{"user-1", "user-2", ""} {
+		worker := workerFor(key, 3)
+		assert.GreaterOrEqual(t, worker, 0)
+		assert.Less(t, worker, 3)
+	}
+}
@@ -0,0 +1,87 @@
+package notification
+
+import (
+	"user-microservice/internal/config"
+
+	"github.com/pkg/errors"
+	"github.com/streadway/amqp"
+)
+
+// ownQueueBinding routes every event to the subscriber's queue when the
+// queue is not configured explicitly
+const ownQueueBinding = "#"
+
+// topologyChannel declares exchanges, queues and bindings
+type topologyChannel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+}
+
+// declareTopology declares the events exchange and the configured queues
+// with their bindings. Declarations are idempotent, so the publisher and the
+// subscriber both declare the whole topology, whichever starts first.
+func declareTopology(channel topologyChannel, cfg config.NotificationConfig) error {
+	kind := cfg.Exchange.Type
+	if kind == "" {
+		kind = amqp.ExchangeTopic
+	}
+
+	err := channel.ExchangeDeclare(
+		cfg.Exchange.Name,
+		kind,
+		true,  // durable
+		false, // auto-delete
+		false, // internal
+		false, // no-wait
+		amqp.Table(cfg.Exchange.Arguments),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "error declaring exchange %s", cfg.Exchange.Name)
+	}
+
+	queues := append([]config.QueueConfig(nil), cfg.Queues...)
+	if cfg.QueueName != "" && !hasQueue(queues, cfg.QueueName) {
+		queues = append(queues, config.QueueConfig{Name: cfg.QueueName, Bindings: []string{ownQueueBinding}})
+	}
+
+	for _, queue := range queues {
+		_, err := channel.QueueDeclare(
+			queue.Name,
+			true,  // durable
+			false, // auto-delete
+			false, // exclusive
+			false, // no-wait
+			amqp.Table(queue.Arguments),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "error declaring queue %s", queue.Name)
+		}
+
+		for _, key := range queue.Bindings {
+			if err := channel.QueueBind(queue.Name, key, cfg.Exchange.Name, false, nil); err != nil {
+				return errors.Wrapf(err, "error binding queue %s to %s", queue.Name, key)
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasQueue(queues []config.QueueConfig, name string) bool {
+	for _, queue := range queues {
+		if queue.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// routingKey is the routing key of event: its type, refined for updates
+// with the names of the changed fields
+func routingKey(event Event) string {
+	if event.RoutingKey != "" {
+		return event.RoutingKey
+	}
+	return event.Type
+}
@@ -0,0 +1,201 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"user-microservice/internal/backoff"
+	"user-microservice/internal/config"
+	"user-microservice/internal/notification"
+	"user-microservice/internal/repository"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 100
+	defaultMinBackoff   = time.Second
+	defaultMaxBackoff   = 5 * time.Minute
+	defaultRetention    = 7 * 24 * time.Hour
+	defaultMaxAttempts  = 100
+	// defaultPublishTimeout bounds the publication of a single event
+	defaultPublishTimeout = 15 * time.Second
+
+	// purgeInterval is how often published events past their retention are deleted
+	purgeInterval = time.Hour
+)
+
+// Store holds the outbox; see PostgresUserRepository
+type Store interface {
+	PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func(ctx context.Context, event *repository.OutboxEvent) error, retry func(event *repository.OutboxEvent, err error) (time.Time, bool)) (int, error)
+	PurgeOutbox(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Relay publishes the events written to the outbox. Events survive crashes
+// and broker outages: an event is only marked sent once it was published,
+// and failed events are retried with exponential back-off until they are
+// parked after too many attempts. Relays may run on every replica; each
+// event is claimed by one of them at a time.
+type Relay struct {
+	store        Store
+	notifier     notification.NotificationService
+	pollInterval time.Duration
+	batchSize    int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	maxAttempts  int
+	// publishTimeout bounds the publication of a single event
+	publishTimeout time.Duration
+	retention      time.Duration
+	logger         *zap.Logger
+}
+
+// NewRelay creates a Relay publishing the outbox of store with notifier.
+// Zero values in cfg use the defaults.
+func NewRelay(store Store, notifier notification.NotificationService, cfg config.OutboxConfig, logger *zap.Logger) *Relay {
+	r := &Relay{
+		store:          store,
+		notifier:       notifier,
+		pollInterval:   cfg.PollInterval,
+		batchSize:      cfg.BatchSize,
+		minBackoff:     cfg.MinBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		maxAttempts:    cfg.MaxAttempts,
+		publishTimeout: cfg.PublishTimeout,
+		retention:      cfg.Retention,
+		logger:         logger.With(zap.String("component", "outbox_relay")),
+	}
+	if r.pollInterval <= 0 {
+		r.pollInterval = defaultPollInterval
+	}
+	if r.batchSize <= 0 {
+		r.batchSize = defaultBatchSize
+	}
+	if r.minBackoff <= 0 {
+		r.minBackoff = defaultMinBackoff
+	}
+	if r.maxBackoff < r.minBackoff {
+		r.maxBackoff = max(defaultMaxBackoff, r.minBackoff)
+	}
+	if r.maxAttempts <= 0 {
+		r.maxAttempts = defaultMaxAttempts
+	}
+	if r.publishTimeout <= 0 {
+		r.publishTimeout = defaultPublishTimeout
+	}
+	if r.retention <= 0 {
+		r.retention = defaultRetention
+	}
+	return r
+}
+
+// Run publishes pending events every poll interval until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	poll := time.NewTicker(r.pollInterval)
+	defer poll.Stop()
+	purge := time.NewTicker(purgeInterval)
+	defer purge.Stop()
+
+	for {
+		r.Drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-purge.C:
+			r.purge(ctx)
+		}
+	}
+}
+
+// Drain publishes due events batch after batch until none is left or none
+// of a batch could be published, and returns the number published
+func (r *Relay) Drain(ctx context.Context) int {
+	total := 0
+	for ctx.Err() == nil {
+		published, err := r.store.PublishOutbox(ctx, r.batchSize, r.lease(), r.publish, r.retry)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Error("error publishing outbox", zap.Error(err))
+			}
+			break
+		}
+		if published == 0 {
+			break
+		}
+		total += published
+	}
+	return total
+}
+
+func (r *Relay) publish(ctx context.Context, event *repository.OutboxEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, r.publishTimeout)
+	defer cancel()
+
+	err := r.notifier.Publish(ctx, notification.Event{
+		ID:         event.EventID,
+		Type:       event.Type,
+		Timestamp:  event.CreatedAt.UTC(),
+		Payload:    json.RawMessage(event.Payload),
+		Subject:    event.AggregateID,
+		RoutingKey: event.RoutingKey,
+	})
+	if err != nil {
+		r.logger.Warn("error publishing event",
+			zap.String("event_id", event.EventID),
+			zap.String("type", event.Type),
+			zap.Int("attempts", event.Attempts+1),
+			zap.Error(err))
+	}
+	return err
+}
+
+// lease is how long a claimed batch is reserved for this relay: long enough
+// to publish every event of the batch
+func (r *Relay) lease() time.Duration {
+	return time.Duration(r.batchSize+1) * r.publishTimeout
+}
+
+// retry schedules the next attempt of an event that failed to publish, or
+// parks it once it failed maxAttempts times. An event rejected by strict
+// schema validation is parked at once, since retrying cannot fix it.
+func (r *Relay) retry(event *repository.OutboxEvent, err error) (time.Time, bool) {
+	if errors.Is(err, notification.ErrSchemaViolation) {
+		r.logger.Error("outbox event parked, it does not match its schema",
+			zap.String("event_id", event.EventID),
+			zap.String("type", event.Type),
+			zap.String("aggregate_id", event.AggregateID),
+			zap.Error(err))
+		return time.Time{}, false
+	}
+	if event.Attempts >= r.maxAttempts {
+		r.logger.Error("outbox event parked after too many attempts",
+			zap.String("event_id", event.EventID),
+			zap.String("type", event.Type),
+			zap.String("aggregate_id", event.AggregateID),
+			zap.Int("attempts", event.Attempts),
+			zap.Error(err))
+		return time.Time{}, false
+	}
+	return r.retryAt(event.Attempts), true
+}
+
+// retryAt schedules the next attempt of an event that failed attempts
+// times, doubling the delay after each failure up to the maximum back-off
+func (r *Relay) retryAt(attempts int) time.Time {
+	return time.Now().Add(backoff.Exponential{Min: r.minBackoff, Max: r.maxBackoff}.Delay(attempts))
+}
+
+func (r *Relay) purge(ctx context.Context) {
+	purged, err := r.store.PurgeOutbox(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		return
+	}
+	if purged > 0 {
+		r.logger.Info("published outbox events purged", zap.Int64("count", purged))
+	}
+}
@@ -0,0 +1,237 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+	"user-microservice/internal/notification"
+	"user-microservice/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// memoryStore is an in-memory Store publishing events in order
+type memoryStore struct {
+	mu      sync.Mutex
+	events  []*repository.OutboxEvent
+	sent    map[int64]bool
+	parked  map[int64]bool
+	retries map[int64]time.Time
+	batches int
+}
+
+func newMemoryStore(events ...*repository.OutboxEvent) *memoryStore {
+	return &memoryStore{events: events, sent: map[int64]bool{}, parked: map[int64]bool{}, retries: map[int64]time.Time{}}
+}
+
+func (s *memoryStore) PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func(ctx context.Context, event *repository.OutboxEvent) error, retry func(event *repository.OutboxEvent, err error) (time.Time, bool)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batches++
+
+	published, claimed := 0, 0
+	for _, event := range s.events {
+		if claimed == limit {
+			break
+		}
+		if s.sent[event.ID] || s.parked[event.ID] || s.retries[event.ID].After(time.Now()) || s.blocked(event) {
+			continue
+		}
+		claimed++
+		if err := publish(ctx, event); err != nil {
+			event.Attempts++
+			at, ok := retry(event, err)
+			s.retries[event.ID] = at
+			s.parked[event.ID] = !ok
+			continue
+		}
+		s.sent[event.ID] = true
+		published++
+	}
+	return published, nil
+}
+
+// blocked reports whether an earlier event of the same user is neither
+// published nor parked
+func (s *memoryStore) blocked(event *repository.OutboxEvent) bool {
+	for _, earlier := range s.events {
+		if earlier.ID < event.ID && earlier.AggregateID == event.AggregateID && !s.sent[earlier.ID] && !s.parked[earlier.ID] {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// recordingNotifier records published events and fails while err is set
+type recordingNotifier struct {
+	notification.MockNotificationService
+	mu     sync.Mutex
+	events []notification.Event
+	err    error
+}
+
+func (n *recordingNotifier) Publish(ctx context.Context, event notification.Event) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	n.events = append(n.events, event)
+	return nil
+}
+
+func outboxEvent(id int64, eventType string) *repository.OutboxEvent {
+	return &repository.OutboxEvent{
+		ID:          id,
+		EventID:     fmt.Sprintf("event-%d", id),
+		Type:        eventType,
+		RoutingKey:  eventType,
+		AggregateID: "user-1",
+		Payload:     []byte(`{"id":"user-1","nickname":"ann"}`),
+		CreatedAt:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestRelay_DrainPublishesEveryBatch(t *testing.T) {
+	store := newMemoryStore(
+		outboxEvent(1, repository.EventUserCreated),
+		outboxEvent(2, repository.EventUserUpdated),
+		outboxEvent(3, repository.EventUserDeleted),
+	)
+	notifier := &recordingNotifier{}
+	relay := NewRelay(store, notifier, config.OutboxConfig{BatchSize: 2}, zap.NewNop())
+
+	assert.Equal(t, 3, relay.Drain(context.Background()))
+	// two full batches and an empty one ending the drain
+	assert.Equal(t, 3, store.batches)
+
+	if assert.Len(t, notifier.events, 3) {
+		event := notifier.events[0]
+		assert.Equal(t, "event-1", event.ID)
+		assert.Equal(t, repository.EventUserCreated, event.Type)
+		assert.Equal(t, repository.EventUserCreated, event.RoutingKey)
+		assert.Equal(t, "user-1", event.Subject)
+		assert.Equal(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), event.Timestamp)
+
+		body, err := json.Marshal(event)
+		assert.NoError(t, err)
+		var decoded struct {
+			Payload models.User `json:"payload"`
+		}
+		assert.NoError(t, json.Unmarshal(body, &decoded))
+		assert.Equal(t, "ann", decoded.Payload.Nickname)
+
+		assert.Equal(t, repository.EventUserUpdated, notifier.events[1].Type)
+		assert.Equal(t, repository.EventUserDeleted, notifier.events[2].Type)
+	}
+}
+
+func TestRelay_RetriesFailedEventsLater(t *testing.T) {
+	store := newMemoryStore(outboxEvent(1, repository.EventUserCreated))
+	notifier := &recordingNotifier{err: errors.New("broker unavailable")}
+	relay := NewRelay(store, notifier, config.OutboxConfig{MinBackoff: time.Minute}, zap.NewNop())
+
+	before := time.Now()
+	assert.Equal(t, 0, relay.Drain(context.Background()))
+	assert.Equal(t, 1, store.batches)
+	assert.WithinDuration(t, before.Add(time.Minute), store.retries[1], time.Second)
+
+	// the event is not retried before its back-off elapses
+	notifier.err = nil
+	assert.Equal(t, 0, relay.Drain(context.Background()))
+
+	store.retries[1] = time.Now()
+	assert.Equal(t, 1, relay.Drain(context.Background()))
+	assert.Len(t, notifier.events, 1)
+}
+
+func TestRelay_ParksEventsAfterMaxAttempts(t *testing.T) {
+	store := newMemoryStore(outboxEvent(1, repository.EventUserCreated))
+	notifier := &recordingNotifier{err: errors.New("broker unavailable")}
+	relay := NewRelay(store, notifier, config.OutboxConfig{MaxAttempts: 2}, zap.NewNop())
+
+	assert.Equal(t, 0, relay.Drain(context.Background()))
+	assert.False(t, store.parked[1])
+
+	store.retries[1] = time.Now()
+	assert.Equal(t, 0, relay.Drain(context.Background()))
+	assert.True(t, store.parked[1])
+	assert.Equal(t, 2, store.events[0].Attempts)
+
+	// parked events are not retried
+	notifier.err = nil
+	store.retries[1] = time.Now()
+	assert.Equal(t, 0, relay.Drain(context.Background()))
+	assert.Empty(t, notifier.events)
+}
+
+// strictNotifier rejects events whose payload does not match its schema,
+// like the RabbitMQ publisher in strict mode
+type strictNotifier struct {
+	recordingNotifier
+	registry *notification.SchemaRegistry
+}
+
+func (n *strictNotifier) Publish(ctx context.Context, event notification.Event) error {
+	if err := n.registry.Validate(event.Type, "v1", event.Payload.(json.RawMessage)); err != nil {
+		return err
+	}
+	return n.recordingNotifier.Publish(ctx, event)
+}
+
+func TestRelay_ParksEventsViolatingTheirSchema(t *testing.T) {
+	// written before payloads were versioned: a bare user, without the
+	// changed fields of a v1 update
+	legacy := outboxEvent(1, repository.EventUserUpdated)
+	legacy.Payload = []byte(`{"id":"user-1","first_name":"Ann","last_name":"Doe","nickname":"ann","email":"ann@example.com","country":"PT","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}`)
+	deleted := outboxEvent(2, repository.EventUserDeleted)
+	deleted.Payload = []byte(`{"id":"user-1"}`)
+
+	store := newMemoryStore(legacy, deleted)
+	notifier := &strictNotifier{registry: notification.NewSchemaRegistry()}
+	relay := NewRelay(store, notifier, config.OutboxConfig{}, zap.NewNop())
+
+	// the legacy event is parked on its first attempt and the later event
+	// of the same user is published behind it
+	assert.Equal(t, 1, relay.Drain(context.Background()))
+	assert.True(t, store.parked[1])
+	assert.Equal(t, 1, legacy.Attempts)
+	if assert.Len(t, notifier.events, 1) {
+		assert.Equal(t, "event-2", notifier.events[0].ID)
+	}
+}
+
+func TestRelay_LeaseCoversBatch(t *testing.T) {
+	relay := NewRelay(newMemoryStore(), &recordingNotifier{}, config.OutboxConfig{BatchSize: 10}, zap.NewNop())
+
+	assert.GreaterOrEqual(t, relay.lease(), 10*defaultPublishTimeout)
+}
+
+func TestRelay_RetryBackoff(t *testing.T) {
+	relay := NewRelay(newMemoryStore(), &recordingNotifier{}, config.OutboxConfig{
+		MinBackoff: time.Second,
+		MaxBackoff: 10 * time.Second,
+	}, zap.NewNop())
+
+	for attempts, want := range map[int]time.Duration{
+		1:    time.Second,
+		2:    2 * time.Second,
+		4:    8 * time.Second,
+		5:    10 * time.Second,
+		1000: 10 * time.Second,
+	} {
+		assert.WithinDuration(t, time.Now().Add(want), relay.retryAt(attempts), 100*time.Millisecond, "attempts %d", attempts)
+	}
+}
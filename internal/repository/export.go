@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// exportBatchSize is the number of rows fetched from the cursor at a time
+const exportBatchSize = 500
+
+// Export streams every user matching filter to fn, oldest first, through a
+// server-side cursor so memory use does not depend on the number of rows.
+// It stops at the first error returned by fn or when ctx is cancelled.
+func (r *PostgresUserRepository) Export(ctx context.Context, filter FilterOptions, fn func(*models.User) error) error {
+	conditions, args, _, err := filter.where(1)
+	if err != nil {
+		return err
+	}
+
+	// cursors only live inside a transaction; a read-only one also gives the
+	// export a consistent snapshot
+	tx, err := r.beginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		r.logger.Error("error starting transaction", zap.Error(err))
+		return errors.Wrap(err, "error starting transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			r.logger.Error("error rolling back transaction", zap.Error(err))
+		}
+	}()
+
+	declare := `
+		DECLARE user_export NO SCROLL CURSOR FOR
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
+		FROM users
+		WHERE 1=1` + conditions + `
+		ORDER BY created_at, id
+	`
+
+	r.logger.Debug("exporting users", zap.Any("filter", filter))
+
+	if _, err := tx.ExecContext(ctx, declare, args...); err != nil {
+		r.logger.Error("error declaring export cursor", zap.Error(err))
+		return errors.Wrap(err, "error declaring export cursor")
+	}
+
+	var exported int
+	for {
+		rows, err := tx.QueryxContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM user_export", exportBatchSize))
+		if err != nil {
+			r.logger.Error("error fetching users for export", zap.Error(err))
+			return errors.Wrap(err, "error fetching users for export")
+		}
+
+		fetched := 0
+		for rows.Next() {
+			var user models.User
+			if err := rows.StructScan(&user); err != nil {
+				rows.Close()
+				r.logger.Error("error scanning user", zap.Error(err))
+				return errors.Wrap(err, "error scanning user from the database")
+			}
+			fetched++
+			if err := fn(&user); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			rows.Close()
+			r.logger.Error("error iterating over exported users", zap.Error(err))
+			return errors.Wrap(err, "error iterating over users from the database")
+		}
+		rows.Close()
+
+		exported += fetched
+		if fetched < exportBatchSize {
+			break
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.Error("error committing transaction", zap.Error(err))
+		return errors.Wrap(err, "error committing transaction")
+	}
+
+	r.logger.Debug("users exported", zap.Int("count", exported))
+	return nil
+}
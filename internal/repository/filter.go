@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// FilterOp is the comparison applied by a StringFilter
+type FilterOp string
+
+const (
+	// OpEq matches the value case-insensitively
+	OpEq FilterOp = "eq"
+	// OpPrefix matches values starting with the value, case-insensitively
+	OpPrefix FilterOp = "prefix"
+	// OpContains matches values containing the value, case-insensitively
+	OpContains FilterOp = "contains"
+	// OpIn matches any of the values case-insensitively
+	OpIn FilterOp = "in"
+)
+
+// likeEscaper escapes LIKE metacharacters so user input only matches literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// StringFilter filters a text column. The zero value matches everything.
+type StringFilter struct {
+	Op     FilterOp
+	Values []string
+}
+
+// Eq returns a filter matching value case-insensitively
+func Eq(value string) StringFilter {
+	return StringFilter{Op: OpEq, Values: []string{value}}
+}
+
+// IsZero reports whether the filter matches everything
+func (f StringFilter) IsZero() bool {
+	return len(f.Values) == 0
+}
+
+// ParseStringFilter parses "op:value" as used in query strings, e.g.
+// "prefix:jo" or "in:PT,ES". A value without a known operator is an equality
+// match, so "jo:hn" matches the literal "jo:hn". An empty string matches everything.
+func ParseStringFilter(raw string) (StringFilter, error) {
+	if raw == "" {
+		return StringFilter{}, nil
+	}
+
+	op, value, ok := strings.Cut(raw, ":")
+	switch FilterOp(op) {
+	case OpEq, OpPrefix, OpContains:
+	case OpIn:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		if len(values) == 0 {
+			return StringFilter{}, errors.Wrap(ErrInvalidFilter, "in: requires at least one value")
+		}
+		return StringFilter{Op: OpIn, Values: values}, nil
+	default:
+		ok = false
+	}
+
+	if !ok {
+		return Eq(raw), nil
+	}
+	if value == "" {
+		return StringFilter{}, errors.Wrapf(ErrInvalidFilter, "%s: requires a value", op)
+	}
+	return StringFilter{Op: FilterOp(op), Values: []string{value}}, nil
+}
+
+// TimeRange filters a timestamp column to [From, To). Zero bounds are open.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+type FilterOptions struct {
+	Country   StringFilter
+	Email     StringFilter
+	Nickname  StringFilter
+	FirstName StringFilter
+	LastName  StringFilter
+	CreatedAt TimeRange
+	UpdatedAt TimeRange
+}
+
+// where builds the SQL conditions of the filter, numbering parameters from
+// argIndex. It returns the conditions, their arguments and the next index.
+func (f FilterOptions) where(argIndex int) (string, []interface{}, int, error) {
+	var conditions string
+	var args []interface{}
+
+	// country codes are stored upper-case, so exact matches compare the
+	// column as is and can use its index
+	columns := []struct {
+		name      string
+		filter    StringFilter
+		upperCase bool
+	}{
+		{"country", f.Country, true},
+		{"email", f.Email, false},
+		{"nickname", f.Nickname, false},
+		{"first_name", f.FirstName, false},
+		{"last_name", f.LastName, false},
+	}
+
+	for _, column := range columns {
+		if column.filter.IsZero() {
+			continue
+		}
+
+		var condition string
+		var arg interface{}
+		switch column.filter.Op {
+		case OpEq, "":
+			if column.upperCase {
+				condition = fmt.Sprintf(" AND %s = $%d", column.name, argIndex)
+				arg = strings.ToUpper(column.filter.Values[0])
+				break
+			}
+			condition = fmt.Sprintf(" AND lower(%s) = lower($%d)", column.name, argIndex)
+			arg = column.filter.Values[0]
+		case OpPrefix:
+			condition = fmt.Sprintf(` AND %s ILIKE $%d ESCAPE '\'`, column.name, argIndex)
+			arg = likeEscaper.Replace(column.filter.Values[0]) + "%"
+		case OpContains:
+			condition = fmt.Sprintf(` AND %s ILIKE $%d ESCAPE '\'`, column.name, argIndex)
+			arg = "%" + likeEscaper.Replace(column.filter.Values[0]) + "%"
+		case OpIn:
+			if column.upperCase {
+				condition = fmt.Sprintf(" AND %s = ANY($%d)", column.name, argIndex)
+				arg = pq.Array(upperAll(column.filter.Values))
+				break
+			}
+			condition = fmt.Sprintf(" AND lower(%s) = ANY($%d)", column.name, argIndex)
+			arg = pq.Array(lowerAll(column.filter.Values))
+		default:
+			return "", nil, argIndex, errors.Wrapf(ErrInvalidFilter, "unknown operator %q", column.filter.Op)
+		}
+
+		conditions += condition
+		args = append(args, arg)
+		argIndex++
+	}
+
+	ranges := []struct {
+		name  string
+		value TimeRange
+	}{
+		{"created_at", f.CreatedAt},
+		{"updated_at", f.UpdatedAt},
+	}
+
+	for _, r := range ranges {
+		if !r.value.From.IsZero() {
+			conditions += fmt.Sprintf(" AND %s >= $%d", r.name, argIndex)
+			args = append(args, r.value.From)
+			argIndex++
+		}
+		if !r.value.To.IsZero() {
+			conditions += fmt.Sprintf(" AND %s < $%d", r.name, argIndex)
+			args = append(args, r.value.To)
+			argIndex++
+		}
+	}
+
+	return conditions, args, argIndex, nil
+}
@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var ErrImportJobNotFound = errors.New("import job not found")
+
+// insertBatchSize is the number of users written by one multi-row INSERT;
+// at ten parameters per row it stays well below the Postgres limit of 65535
+const insertBatchSize = 500
+
+// CreateBatch inserts users with multi-row INSERTs in a single transaction,
+// along with their creation events. Users conflicting with an existing email or nickname are skipped rather
+// than failing the batch; the IDs of the users actually inserted are returned.
+func (r *PostgresUserRepository) CreateBatch(ctx context.Context, users []*models.User) ([]string, error) {
+	tx, err := r.beginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error("error starting transaction", zap.Error(err))
+		return nil, errors.Wrap(err, "error starting transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			r.logger.Error("error rolling back transaction", zap.Error(err))
+		}
+	}()
+
+	r.logger.Debug("creating users in batch", zap.Int("count", len(users)))
+
+	inserted := make([]string, 0, len(users))
+	for start := 0; start < len(users); start += insertBatchSize {
+		end := min(start+insertBatchSize, len(users))
+		batch := users[start:end]
+
+		values := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*10)
+		for i, user := range batch {
+			n := i * 10
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10)
+			args = append(args,
+				user.ID,
+				user.FirstName,
+				user.LastName,
+				user.Nickname,
+				user.NicknameSkeleton,
+				user.Password,
+				user.Email,
+				user.Country,
+				user.CreatedAt,
+				user.UpdatedAt,
+			)
+		}
+
+		query := `
+			INSERT INTO users (id, first_name, last_name, nickname, nickname_skeleton, password, email, country, created_at, updated_at)
+			VALUES ` + strings.Join(values, ", ") + `
+			ON CONFLICT DO NOTHING
+			RETURNING id
+		`
+
+		var ids []string
+		if err := tx.SelectContext(ctx, &ids, query, args...); err != nil {
+			r.logger.Error("error inserting users", zap.Error(err))
+			return nil, errors.Wrap(err, "error inserting users into the database")
+		}
+		inserted = append(inserted, ids...)
+
+		if err := r.enqueueEvents(ctx, tx, createdEntries(batch, ids)...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.Error("error committing transaction", zap.Error(err))
+		return nil, errors.Wrap(err, "error committing transaction")
+	}
+
+	r.logger.Debug("users created in batch",
+		zap.Int("requested", len(users)),
+		zap.Int("inserted", len(inserted)))
+	return inserted, nil
+}
+
+// createdEntries describes the creation of the users of batch whose IDs
+// were inserted
+func createdEntries(batch []*models.User, ids []string) []outboxEntry {
+	inserted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		inserted[strings.ToLower(id)] = true
+	}
+
+	entries := make([]outboxEntry, 0, len(ids))
+	for _, user := range batch {
+		if inserted[strings.ToLower(user.ID)] {
+			entries = append(entries, userCreatedEntry(user))
+		}
+	}
+	return entries
+}
+
+// CreateImportJob records a new import job
+func (r *PostgresUserRepository) CreateImportJob(ctx context.Context, job *models.ImportJob) error {
+	query := `
+		INSERT INTO import_jobs (id, status, dry_run, total_rows, error, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	_, err := r.conn().ExecContext(ctx, query,
+		job.ID, job.Status, job.DryRun, job.TotalRows, job.Error, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		r.logger.Error("error creating import job", zap.Error(err))
+		return errors.Wrap(err, "error inserting import job into the database")
+	}
+
+	return nil
+}
+
+// UpdateImportJob stores the status, report and error of an import job
+func (r *PostgresUserRepository) UpdateImportJob(ctx context.Context, job *models.ImportJob) error {
+	var report []byte
+	if job.Report != nil {
+		var err error
+		if report, err = json.Marshal(job.Report); err != nil {
+			return errors.Wrap(err, "error encoding import report")
+		}
+	}
+
+	query := `
+		UPDATE import_jobs
+		SET status = $2, report = $3, error = $4, updated_at = $5
+		WHERE id = $1
+	`
+
+	result, err := r.conn().ExecContext(ctx, query, job.ID, job.Status, report, job.Error, job.UpdatedAt)
+	if err != nil {
+		r.logger.Error("error updating import job", zap.Error(err))
+		return errors.Wrap(err, "error updating import job in the database")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error checking affected rows")
+	}
+	if rowsAffected == 0 {
+		return ErrImportJobNotFound
+	}
+
+	return nil
+}
+
+// GetImportJob retrieves an import job with its report, if finished
+func (r *PostgresUserRepository) GetImportJob(ctx context.Context, id string) (*models.ImportJob, error) {
+	query := `
+		SELECT id, status, dry_run, total_rows, report, error, created_at, updated_at
+		FROM import_jobs
+		WHERE id::text = $1
+	`
+
+	var row struct {
+		models.ImportJob
+		Report []byte `db:"report"`
+	}
+	if err := r.conn().GetContext(ctx, &row, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrImportJobNotFound
+		}
+		r.logger.Error("error retrieving import job", zap.Error(err))
+		return nil, errors.Wrap(err, "error retrieving import job from the database")
+	}
+
+	job := row.ImportJob
+	if len(row.Report) > 0 {
+		job.Report = &models.ImportReport{}
+		if err := json.Unmarshal(row.Report, job.Report); err != nil {
+			return nil, errors.Wrap(err, "error decoding import report")
+		}
+	}
+
+	return &job, nil
+}
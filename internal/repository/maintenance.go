@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// CountryCount is a distinct stored country value and the number of rows using it
+type CountryCount struct {
+	Value string `db:"country"`
+	Count int    `db:"count"`
+}
+
+// CountryBackfillReport summarises a country normalisation run
+type CountryBackfillReport struct {
+	// Mapped holds every stored value that was (or would be) rewritten, keyed by the original value
+	Mapped map[string]string
+	// RowsUpdated is the number of rows rewritten; zero on a dry run
+	RowsUpdated int64
+	// Unmappable holds the values that could not be resolved to an ISO 3166-1 code
+	Unmappable []CountryCount
+}
+
+// NormalizeCountries rewrites every stored country value to its ISO 3166-1
+// alpha-2 code. Unmappable values are left untouched and reported. With
+// dryRun set nothing is written.
+func (r *PostgresUserRepository) NormalizeCountries(ctx context.Context, dryRun bool) (*CountryBackfillReport, error) {
+	tx, err := r.beginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error("error starting transaction", zap.Error(err))
+		return nil, errors.Wrap(err, "error starting transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			r.logger.Error("error rolling back transaction", zap.Error(err))
+		}
+	}()
+
+	var values []CountryCount
+	query := `SELECT country, COUNT(*) AS count FROM users GROUP BY country ORDER BY country`
+	if err := tx.SelectContext(ctx, &values, query); err != nil {
+		r.logger.Error("error listing stored countries", zap.Error(err))
+		return nil, errors.Wrap(err, "error listing stored countries")
+	}
+
+	report := &CountryBackfillReport{Mapped: map[string]string{}}
+
+	for _, value := range values {
+		code, err := models.NormalizeCountry(value.Value)
+		if err != nil {
+			report.Unmappable = append(report.Unmappable, value)
+			continue
+		}
+		if code == value.Value {
+			continue
+		}
+
+		report.Mapped[value.Value] = code
+		if dryRun {
+			continue
+		}
+
+		result, err := tx.ExecContext(ctx, `UPDATE users SET country = $1 WHERE country = $2`, code, value.Value)
+		if err != nil {
+			r.logger.Error("error normalising country", zap.String("country", value.Value), zap.Error(err))
+			return nil, errors.Wrap(err, "error normalising country")
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, errors.Wrap(err, "error checking affected rows")
+		}
+		report.RowsUpdated += rowsAffected
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.Error("error committing transaction", zap.Error(err))
+		return nil, errors.Wrap(err, "error committing transaction")
+	}
+
+	return report, nil
+}
+
+// ConflictKind identifies why a group of users is considered conflicting
+type ConflictKind string
+
+const (
+	ConflictEmail            ConflictKind = "email"
+	ConflictNickname         ConflictKind = "nickname"
+	ConflictNicknameSkeleton ConflictKind = "nickname_skeleton"
+)
+
+// ConflictingUser is the subset of a user needed to resolve a conflict by hand
+type ConflictingUser struct {
+	ID        string    `db:"id"`
+	Nickname  string    `db:"nickname"`
+	Email     string    `db:"email"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// ConflictGroup is a set of users that share the same canonical key
+type ConflictGroup struct {
+	Kind  ConflictKind
+	Key   string
+	Users []ConflictingUser
+}
+
+// FindConflicts lists users whose emails or nicknames collide once
+// canonicalised and compared case-insensitively, plus nicknames that share a
+// confusable skeleton. It only reads the original columns, so it can run
+// before the case-insensitive unique indexes are created.
+func (r *PostgresUserRepository) FindConflicts(ctx context.Context) ([]ConflictGroup, error) {
+	query := `SELECT id, nickname, email, created_at FROM users ORDER BY created_at`
+
+	rows, err := r.conn().QueryxContext(ctx, query)
+	if err != nil {
+		r.logger.Error("error listing users for conflict report", zap.Error(err))
+		return nil, errors.Wrap(err, "error listing users for conflict report")
+	}
+	defer rows.Close()
+
+	keyFuncs := []struct {
+		kind ConflictKind
+		key  func(ConflictingUser) string
+	}{
+		{ConflictEmail, func(u ConflictingUser) string { return strings.ToLower(models.CanonicalEmail(u.Email)) }},
+		{ConflictNickname, func(u ConflictingUser) string { return strings.ToLower(models.CanonicalNickname(u.Nickname)) }},
+		{ConflictNicknameSkeleton, func(u ConflictingUser) string { return models.NicknameSkeleton(u.Nickname) }},
+	}
+
+	groups := make([]map[string][]ConflictingUser, len(keyFuncs))
+	for i := range groups {
+		groups[i] = map[string][]ConflictingUser{}
+	}
+
+	for rows.Next() {
+		var user ConflictingUser
+		if err := rows.StructScan(&user); err != nil {
+			r.logger.Error("error scanning user", zap.Error(err))
+			return nil, errors.Wrap(err, "error scanning user from the database")
+		}
+		for i, kf := range keyFuncs {
+			key := kf.key(user)
+			if key == "" {
+				continue
+			}
+			groups[i][key] = append(groups[i][key], user)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		r.logger.Error("error iterating over users", zap.Error(err))
+		return nil, errors.Wrap(err, "error iterating over users from the database")
+	}
+
+	var conflicts []ConflictGroup
+	for i, kf := range keyFuncs {
+		keys := make([]string, 0, len(groups[i]))
+		for key, users := range groups[i] {
+			if len(users) > 1 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			conflicts = append(conflicts, ConflictGroup{Kind: kf.kind, Key: key, Users: groups[i][key]})
+		}
+	}
+
+	return conflicts, nil
+}
+
+// BackfillNicknameSkeletons computes the confusable skeleton of every
+// nickname that does not have one yet and returns the number of rows updated.
+// Nicknames confusable with one that has a skeleton are logged and skipped.
+func (r *PostgresUserRepository) BackfillNicknameSkeletons(ctx context.Context) (int64, error) {
+	var users []ConflictingUser
+	query := `SELECT id, nickname, email, created_at FROM users WHERE nickname_skeleton = ''`
+	if err := r.conn().SelectContext(ctx, &users, query); err != nil {
+		r.logger.Error("error listing users without skeleton", zap.Error(err))
+		return 0, errors.Wrap(err, "error listing users without skeleton")
+	}
+
+	var updated int64
+	for _, user := range users {
+		result, err := r.conn().ExecContext(ctx,
+			`UPDATE users SET nickname_skeleton = $1 WHERE id = $2 AND nickname = $3`,
+			models.NicknameSkeleton(user.Nickname), user.ID, user.Nickname)
+		if errors.Is(translateUniqueViolation(err), ErrNicknameConfusable) {
+			r.logger.Warn("nickname is confusable with another, skeleton not stored", zap.String("id", user.ID), zap.String("nickname", user.Nickname))
+			continue
+		}
+		if err != nil {
+			r.logger.Error("error updating nickname skeleton", zap.String("id", user.ID), zap.Error(err))
+			return updated, errors.Wrap(err, "error updating nickname skeleton")
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return updated, errors.Wrap(err, "error checking affected rows")
+		}
+		updated += rowsAffected
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"user-microservice/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Event types written to the outbox
+const (
+	EventUserCreated = "user.created"
+	EventUserUpdated = "user.updated"
+	EventUserDeleted = "user.deleted"
+)
+
+// maxOutboxErrorLength bounds the publish error kept on an outbox event
+const maxOutboxErrorLength = 1000
+
+// OutboxEvent is an event recorded in the same transaction as the change it
+// describes, waiting to be published by the relay
+type OutboxEvent struct {
+	ID          int64     `db:"id"`
+	EventID     string    `db:"event_id"`
+	Type        string    `db:"event_type"`
+	RoutingKey  string    `db:"routing_key"`
+	AggregateID string    `db:"aggregate_id"`
+	Payload     []byte    `db:"payload"`
+	Attempts    int       `db:"attempts"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// outboxEntry is an event to be written to the outbox
+type outboxEntry struct {
+	eventType   string
+	routingKey  string
+	aggregateID string
+	payload     interface{}
+}
+
+// userCreatedEntry describes the creation of user
+func userCreatedEntry(user *models.User) outboxEntry {
+	return outboxEntry{eventType: EventUserCreated, routingKey: EventUserCreated, aggregateID: user.ID, payload: models.NewUserCreatedV1(user)}
+}
+
+// userUpdatedEntry describes the update of previous into user; the routing
+// key names the changed fields, e.g. user.updated.email.nickname, so
+// consumers can bind to the changes they care about
+func userUpdatedEntry(user, previous *models.User) outboxEntry {
+	payload := models.NewUserUpdatedV1(user, previous)
+	return outboxEntry{
+		eventType:   EventUserUpdated,
+		routingKey:  strings.Join(append([]string{EventUserUpdated}, payload.ChangedFields...), "."),
+		aggregateID: user.ID,
+		payload:     payload,
+	}
+}
+
+func userDeletedEntry(id string) outboxEntry {
+	return outboxEntry{eventType: EventUserDeleted, routingKey: EventUserDeleted, aggregateID: id, payload: models.NewUserDeletedV1(id)}
+}
+
+// enqueueEvents writes entries to the outbox with q, which must be the
+// transaction of the change they describe
+func (r *PostgresUserRepository) enqueueEvents(ctx context.Context, q queryer, entries ...outboxEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	values := make([]string, len(entries))
+	args := make([]interface{}, 0, len(entries)*5+1)
+	args = append(args, now)
+	for i, entry := range entries {
+		payload, err := json.Marshal(entry.payload)
+		if err != nil {
+			return errors.Wrap(err, "error encoding event payload")
+		}
+		n := len(args)
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $1, $1)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, uuid.New().String(), entry.eventType, entry.routingKey, entry.aggregateID, payload)
+	}
+
+	query := `
+		INSERT INTO outbox (event_id, event_type, routing_key, aggregate_id, payload, next_attempt_at, created_at)
+		VALUES ` + strings.Join(values, ", ")
+
+	if _, err := q.ExecContext(ctx, query, args...); err != nil {
+		r.logger.Error("error writing events to the outbox", zap.Error(err))
+		return errors.Wrap(err, "error writing events to the outbox")
+	}
+	return nil
+}
+
+// PublishOutbox claims up to limit due events and passes them to publish in
+// order. Claiming leases the events by pushing their next attempt back by
+// lease and commits before anything is published, so no lock is held while
+// a sink is slow; events of a relay that died are claimed again once their
+// lease expires. SKIP LOCKED lets relays on several replicas claim disjoint
+// events, and an event is only claimed once every earlier event of the same
+// user was published or parked, so consumers see a user's events in order.
+// Published events are marked sent. Failed ones are rescheduled at the time
+// retry returns, or parked as failed when it returns false; retry sees the
+// attempt count including the failed attempt. It returns the number of
+// events published.
+func (r *PostgresUserRepository) PublishOutbox(ctx context.Context, limit int, lease time.Duration, publish func(ctx context.Context, event *OutboxEvent) error, retry func(event *OutboxEvent, err error) (time.Time, bool)) (int, error) {
+	query := `
+		UPDATE outbox
+		SET next_attempt_at = $3
+		WHERE id IN (
+			SELECT id
+			FROM outbox o
+			WHERE sent_at IS NULL
+				AND failed_at IS NULL
+				AND next_attempt_at <= $1
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox earlier
+					WHERE earlier.aggregate_id = o.aggregate_id
+						AND earlier.sent_at IS NULL
+						AND earlier.failed_at IS NULL
+						AND earlier.id < o.id
+				)
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_id, event_type, routing_key, aggregate_id, payload, attempts, created_at
+	`
+
+	now := time.Now().UTC()
+	var events []*OutboxEvent
+	if err := r.conn().SelectContext(ctx, &events, query, now, limit, now.Add(lease)); err != nil {
+		r.logger.Error("error claiming outbox events", zap.Error(err))
+		return 0, errors.Wrap(err, "error claiming outbox events")
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	published := 0
+	for _, event := range events {
+		var err error
+		if publishErr := publish(ctx, event); publishErr != nil {
+			err = r.failOutboxEvent(ctx, event, publishErr, retry)
+		} else {
+			published++
+			_, err = r.conn().ExecContext(ctx, `UPDATE outbox SET sent_at = $2 WHERE id = $1`, event.ID, time.Now().UTC())
+		}
+		if err != nil {
+			r.logger.Error("error updating outbox event", zap.Error(err))
+			return published, errors.Wrap(err, "error updating outbox event")
+		}
+	}
+
+	return published, nil
+}
+
+// failOutboxEvent records a failed publish of event, rescheduling or
+// parking it as retry decides
+func (r *PostgresUserRepository) failOutboxEvent(ctx context.Context, event *OutboxEvent, publishErr error, retry func(event *OutboxEvent, err error) (time.Time, bool)) error {
+	message := publishErr.Error()
+	if len(message) > maxOutboxErrorLength {
+		message = strings.ToValidUTF8(message[:maxOutboxErrorLength], "")
+	}
+
+	event.Attempts++
+	if at, ok := retry(event, publishErr); ok {
+		_, err := r.conn().ExecContext(ctx, `
+			UPDATE outbox
+			SET attempts = $2, last_error = $3, next_attempt_at = $4
+			WHERE id = $1
+		`, event.ID, event.Attempts, message, at.UTC())
+		return err
+	}
+
+	_, err := r.conn().ExecContext(ctx, `
+		UPDATE outbox
+		SET attempts = $2, last_error = $3, failed_at = $4
+		WHERE id = $1
+	`, event.ID, event.Attempts, message, time.Now().UTC())
+	return err
+}
+
+// PurgeOutbox deletes events published before the given time and returns
+// how many were removed
+func (r *PostgresUserRepository) PurgeOutbox(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.conn().ExecContext(ctx, `DELETE FROM outbox WHERE sent_at < $1`, before.UTC())
+	if err != nil {
+		r.logger.Error("error purging outbox", zap.Error(err))
+		return 0, errors.Wrap(err, "error purging outbox")
+	}
+	return result.RowsAffected()
+}
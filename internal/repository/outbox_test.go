@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"user-microservice/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresUserRepository_PublishOutbox(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	columns := []string{"id", "event_id", "event_type", "routing_key", "aggregate_id", "payload", "attempts", "created_at"}
+	now := time.Now()
+
+	// the claim commits on its own, before anything is published
+	mock.ExpectQuery(`UPDATE outbox\s+SET next_attempt_at = \$3\s+WHERE id IN .*failed_at IS NULL.*earlier.failed_at IS NULL.*FOR UPDATE SKIP LOCKED`).
+		WithArgs(sqlmock.AnyArg(), 10, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "event-3", EventUserCreated, EventUserCreated, "user-2", []byte(`{}`), 4, now).
+			AddRow(1, "event-1", EventUserCreated, EventUserCreated, "user-1", []byte(`{}`), 0, now).
+			AddRow(2, "event-2", EventUserCreated, EventUserCreated, "user-3", []byte(`{}`), 0, now))
+	mock.ExpectExec(`UPDATE outbox SET sent_at = \$2 WHERE id = \$1`).
+		WithArgs(1, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SET attempts = \$2, last_error = \$3, next_attempt_at = \$4`).
+		WithArgs(2, 1, "broker unavailable", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SET attempts = \$2, last_error = \$3, failed_at = \$4`).
+		WithArgs(3, 5, "broker unavailable", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	var order []int64
+	published, err := repo.PublishOutbox(context.Background(), 10, time.Minute,
+		func(ctx context.Context, event *OutboxEvent) error {
+			order = append(order, event.ID)
+			if event.ID == 1 {
+				return nil
+			}
+			return errors.New("broker unavailable")
+		},
+		func(event *OutboxEvent, err error) (time.Time, bool) {
+			return time.Now(), event.Attempts < 5
+		})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []int64{1, 2, 3}, order)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxEntries_NeverContainPassword(t *testing.T) {
+	previous, err := models.NewUser("John", "Doe", "jdoe", "Password123!", "john@example.com", "BR")
+	require.NoError(t, err)
+	user := *previous
+	require.NoError(t, user.UpdatePassword("Password456!"))
+	user.Nickname = "johnd"
+
+	for _, entry := range []outboxEntry{userCreatedEntry(previous), userUpdatedEntry(&user, previous)} {
+		t.Run(entry.eventType, func(t *testing.T) {
+			body, err := json.Marshal(entry.payload)
+			require.NoError(t, err)
+
+			var payload map[string]interface{}
+			require.NoError(t, json.Unmarshal(body, &payload))
+			assertNoPasswordKey(t, payload)
+			assert.NotContains(t, string(body), previous.Password)
+			assert.NotContains(t, string(body), user.Password)
+		})
+	}
+}
+
+// assertNoPasswordKey fails if any object nested in value has a password key
+func assertNoPasswordKey(t *testing.T, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			assert.NotContains(t, []string{"password", "password_hash"}, key)
+			assertNoPasswordKey(t, nested)
+		}
+	case []interface{}:
+		for _, nested := range v {
+			assertNoPasswordKey(t, nested)
+		}
+	}
+}
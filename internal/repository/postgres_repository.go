@@ -4,43 +4,81 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"user-microservice/internal/models"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 var (
-	ErrUserNotFound = errors.New("user not found")
-	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrUserExists            = errors.New("user already exists")
+	ErrEmailAlreadyExists    = errors.New("email already registered")
+	ErrNicknameAlreadyExists = errors.New("nickname already registered")
+	ErrNicknameConfusable    = errors.New("nickname is too similar to an existing nickname")
 )
 
-type FilterOptions struct {
-	Country   string
-	Email     string
-	Nickname  string
-	FirstName string
-	LastName  string
-}
+const (
+	uniqueViolationCode = "23505"
+	emailUniqueIndex    = "users_email_key"
+	nicknameUniqueIndex = "users_nickname_key"
+	skeletonUniqueIndex = "users_nickname_skeleton_key"
+	grantUniqueIndex    = "nickname_grants_nickname_key"
+)
 
 type PaginationOptions struct {
 	Page     int
 	PageSize int
+	// After switches to keyset pagination: rows following After are returned
+	// and Page is ignored
+	After *Keyset
+	// SkipTotal skips the COUNT(*) query; List then returns a total of -1
+	SkipTotal bool
+	// Sort is the list order; DefaultSort when empty
+	Sort []SortField
+}
+
+// Keyset identifies the last row of a page: its values for each sort field,
+// in order, and its id
+type Keyset struct {
+	Values []string
+	ID     string
 }
 
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
+	CreateBatch(ctx context.Context, users []*models.User) ([]string, error)
 	GetByID(ctx context.Context, id string) (*models.User, error)
+	GetByIDs(ctx context.Context, ids []string) ([]*models.User, error)
 	GetByEmail(ctx context.Context, email string) (*models.User, error)
 	GetByNickname(ctx context.Context, nickname string) (*models.User, error)
+	GetByNicknameSkeleton(ctx context.Context, skeleton string) (*models.User, error)
+	GetByNicknameSkeletons(ctx context.Context, skeletons []string) ([]*models.User, error)
 	Update(ctx context.Context, user *models.User) error
 	UpdatePassword(ctx context.Context, id, password string) error
+	MarkVerified(ctx context.Context, id string, at time.Time) error
 	Delete(ctx context.Context, id string) error
 	List(ctx context.Context, filter FilterOptions, pagination PaginationOptions) ([]*models.User, int, error)
+	Search(ctx context.Context, term string, pagination PaginationOptions) ([]*ScoredUser, int, error)
+	Export(ctx context.Context, filter FilterOptions, fn func(*models.User) error) error
+	CreateNicknameGrant(ctx context.Context, grant *models.NicknameGrant) error
+	HasNicknameGrant(ctx context.Context, nickname, userID string) (bool, error)
+	GetByPreviousNickname(ctx context.Context, nickname string) (*models.User, error)
+	Lookup(ctx context.Context, ids, emails, nicknames []string) ([]*models.User, error)
+	LastNicknameChange(ctx context.Context, userID string) (time.Time, error)
+	IsNicknameHeld(ctx context.Context, nickname, excludeUserID string, since time.Time) (bool, error)
+	HeldNicknames(ctx context.Context, nicknames []string, since time.Time) ([]string, error)
+	LockNicknames(ctx context.Context, nicknames ...string) error
+	WithinTransaction(ctx context.Context, fn func(repo UserRepository) error) error
+	CreateImportJob(ctx context.Context, job *models.ImportJob) error
+	UpdateImportJob(ctx context.Context, job *models.ImportJob) error
+	GetImportJob(ctx context.Context, id string) (*models.ImportJob, error)
 }
 
 type HealthChecker interface {
@@ -48,7 +86,9 @@ type HealthChecker interface {
 }
 
 type PostgresUserRepository struct {
-	db     *sqlx.DB
+	db *sqlx.DB
+	// tx is set on repositories bound to a transaction by WithinTransaction
+	tx     *sqlx.Tx
 	logger *zap.Logger
 }
 
@@ -64,9 +104,9 @@ func (r *PostgresUserRepository) CheckHealth() error {
 	return r.db.Ping()
 }
 
-// Create adds a new user
+// Create adds a new user and records its creation in the outbox
 func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User) error {
-	tx, err := r.db.BeginTxx(ctx, nil)
+	tx, err := r.beginTx(ctx, nil)
 	if err != nil {
 		r.logger.Error("error starting transaction", zap.Error(err))
 		return errors.Wrap(err, "error starting transaction")
@@ -78,8 +118,8 @@ func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User)
 	}()
 
 	query := `
-		INSERT INTO users (id, first_name, last_name, nickname, password, email, country, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO users (id, first_name, last_name, nickname, nickname_skeleton, password, email, country, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 	`
 
 	if user.ID == "" {
@@ -104,6 +144,7 @@ func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User)
 		user.FirstName,
 		user.LastName,
 		user.Nickname,
+		user.NicknameSkeleton,
 		user.Password,
 		user.Email,
 		user.Country,
@@ -112,10 +153,18 @@ func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User)
 	)
 
 	if err != nil {
+		if uniqueErr := translateUniqueViolation(err); uniqueErr != nil {
+			r.logger.Debug("user conflicts with an existing one", zap.Error(err))
+			return uniqueErr
+		}
 		r.logger.Error("error creating user", zap.Error(err))
 		return errors.Wrap(err, "error inserting user into database")
 	}
 
+	if err := r.enqueueEvents(ctx, tx, userCreatedEntry(user)); err != nil {
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		r.logger.Error("error committing transaction", zap.Error(err))
 		return errors.Wrap(err, "error committing transaction")
@@ -127,7 +176,7 @@ func (r *PostgresUserRepository) Create(ctx context.Context, user *models.User)
 // GetByID retrieves a user by ID
 func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	query := `
-		SELECT id, first_name, last_name, nickname, email, country, created_at, updated_at
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
 		FROM users
 		WHERE id = $1
 	`
@@ -135,7 +184,7 @@ func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*model
 	r.logger.Debug("retrieving user by ID", zap.String("id", id))
 
 	var user models.User
-	err := r.db.GetContext(ctx, &user, query, id)
+	err := r.conn().GetContext(ctx, &user, query, id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
@@ -147,18 +196,38 @@ func (r *PostgresUserRepository) GetByID(ctx context.Context, id string) (*model
 	return &user, nil
 }
 
+// GetByIDs retrieves the users with the given IDs in a single query. Users
+// are returned in no particular order; unknown IDs are skipped.
+func (r *PostgresUserRepository) GetByIDs(ctx context.Context, ids []string) ([]*models.User, error) {
+	query := `
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
+		FROM users
+		WHERE id = ANY($1::uuid[])
+	`
+
+	r.logger.Debug("retrieving users by IDs", zap.Int("count", len(ids)))
+
+	users := []*models.User{}
+	if err := r.conn().SelectContext(ctx, &users, query, pq.Array(ids)); err != nil {
+		r.logger.Error("error retrieving users by IDs", zap.Error(err))
+		return nil, errors.Wrap(err, "error retrieving users from database")
+	}
+
+	return users, nil
+}
+
 // GetByEmail retrieves a user by email
 func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	query := `
-		SELECT id, first_name, last_name, nickname, email, country, created_at, updated_at
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
 		FROM users
-		WHERE email = $1
+		WHERE lower(email) = lower($1)
 	`
 
 	r.logger.Debug("retrieving user by email", zap.String("email", email))
 
 	var user models.User
-	err := r.db.GetContext(ctx, &user, query, email)
+	err := r.conn().GetContext(ctx, &user, query, email)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
@@ -173,15 +242,15 @@ func (r *PostgresUserRepository) GetByEmail(ctx context.Context, email string) (
 // GetByNickname retrieves a user by nickname
 func (r *PostgresUserRepository) GetByNickname(ctx context.Context, nickname string) (*models.User, error) {
 	query := `
-		SELECT id, first_name, last_name, nickname, email, country, created_at, updated_at
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
 		FROM users
-		WHERE nickname = $1
+		WHERE lower(nickname) = lower($1)
 	`
 
 	r.logger.Debug("retrieving user by nickname", zap.String("nickname", nickname))
 
 	var user models.User
-	err := r.db.GetContext(ctx, &user, query, nickname)
+	err := r.conn().GetContext(ctx, &user, query, nickname)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrUserNotFound
@@ -193,9 +262,54 @@ func (r *PostgresUserRepository) GetByNickname(ctx context.Context, nickname str
 	return &user, nil
 }
 
-// Update updates an existing user
+// GetByNicknameSkeleton retrieves a user whose nickname is visually confusable
+// with the given skeleton
+func (r *PostgresUserRepository) GetByNicknameSkeleton(ctx context.Context, skeleton string) (*models.User, error) {
+	query := `
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
+		FROM users
+		WHERE nickname_skeleton = $1
+		LIMIT 1
+	`
+
+	r.logger.Debug("retrieving user by nickname skeleton", zap.String("skeleton", skeleton))
+
+	var user models.User
+	err := r.conn().GetContext(ctx, &user, query, skeleton)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		r.logger.Error("error retrieving user by nickname skeleton", zap.Error(err))
+		return nil, errors.Wrap(err, "error retrieving user from database")
+	}
+
+	return &user, nil
+}
+
+// GetByNicknameSkeletons retrieves the users whose nickname skeleton is one
+// of skeletons
+func (r *PostgresUserRepository) GetByNicknameSkeletons(ctx context.Context, skeletons []string) ([]*models.User, error) {
+	query := `
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
+		FROM users
+		WHERE nickname_skeleton = ANY($1)
+	`
+
+	r.logger.Debug("retrieving users by nickname skeleton", zap.Int("count", len(skeletons)))
+
+	users := []*models.User{}
+	if err := r.conn().SelectContext(ctx, &users, query, pq.Array(skeletons)); err != nil {
+		r.logger.Error("error retrieving users by nickname skeleton", zap.Error(err))
+		return nil, errors.Wrap(err, "error retrieving users from database")
+	}
+
+	return users, nil
+}
+
+// Update updates an existing user and records the change in the outbox
 func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User) error {
-	tx, err := r.db.BeginTxx(ctx, nil)
+	tx, err := r.beginTx(ctx, nil)
 	if err != nil {
 		r.logger.Error("error starting transaction", zap.Error(err))
 		return errors.Wrap(err, "error starting transaction")
@@ -205,10 +319,26 @@ func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User)
 			r.logger.Error("error rolling back transaction", zap.Error(err))
 		}
 	}()
+
+	var previous models.User
+	err = tx.GetContext(ctx, &previous, `
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
+		FROM users
+		WHERE id = $1
+		FOR UPDATE
+	`, user.ID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		r.logger.Error("error locking user for update", zap.Error(err))
+		return errors.Wrap(err, "error retrieving user from database")
+	}
+
 	query := `
 		UPDATE users
-		SET first_name = $1, last_name = $2, nickname = $3, email = $4, country = $5, updated_at = $6
-		WHERE id = $7
+		SET first_name = $1, last_name = $2, nickname = $3, nickname_skeleton = $4, email = $5, country = $6, updated_at = $7
+		WHERE id = $8
 	`
 
 	r.logger.Debug("updating user", zap.String("id", user.ID))
@@ -219,16 +349,37 @@ func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User)
 		user.FirstName,
 		user.LastName,
 		user.Nickname,
+		user.NicknameSkeleton,
 		user.Email,
 		user.Country,
 		user.UpdatedAt,
 		user.ID,
 	)
 	if err != nil {
+		if uniqueErr := translateUniqueViolation(err); uniqueErr != nil {
+			r.logger.Debug("user conflicts with an existing one", zap.Error(err))
+			return uniqueErr
+		}
 		r.logger.Error("error updating user", zap.Error(err))
 		return errors.Wrap(err, "error updating user in the database")
 	}
 
+	// Case-only changes keep the same name, so only real renames are recorded
+	if !strings.EqualFold(previous.Nickname, user.Nickname) {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO nickname_history (user_id, nickname, changed_at)
+			VALUES ($1, $2, $3)
+		`, user.ID, previous.Nickname, user.UpdatedAt)
+		if err != nil {
+			r.logger.Error("error recording nickname history", zap.Error(err))
+			return errors.Wrap(err, "error recording nickname history")
+		}
+	}
+
+	if err := r.enqueueEvents(ctx, tx, userUpdatedEntry(user, &previous)); err != nil {
+		return err
+	}
+
 	if err := tx.Commit(); err != nil {
 		r.logger.Error("error committing transaction", zap.Error(err))
 		return errors.Wrap(err, "error committing transaction")
@@ -248,7 +399,7 @@ func (r *PostgresUserRepository) Update(ctx context.Context, user *models.User)
 
 // UpdatePassword updates a user's password
 func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, id, password string) error {
-	tx, err := r.db.BeginTxx(ctx, nil)
+	tx, err := r.beginTx(ctx, nil)
 	if err != nil {
 		r.logger.Error("error starting transaction", zap.Error(err))
 		return errors.Wrap(err, "error starting transaction")
@@ -292,13 +443,70 @@ func (r *PostgresUserRepository) UpdatePassword(ctx context.Context, id, passwor
 	return nil
 }
 
-// Delete removes a user
+// MarkVerified records that the account of a user was verified at the given
+// time; accounts verified before keep their original time
+func (r *PostgresUserRepository) MarkVerified(ctx context.Context, id string, at time.Time) error {
+	query := `
+		UPDATE users
+		SET verified_at = COALESCE(verified_at, $1)
+		WHERE id = $2
+	`
+
+	r.logger.Debug("marking user verified", zap.String("id", id))
+
+	result, err := r.conn().ExecContext(ctx, query, at, id)
+	if err != nil {
+		r.logger.Error("error marking user verified", zap.Error(err))
+		return errors.Wrap(err, "error marking user verified in the database")
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error checking affected rows")
+	}
+
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a user and records the deletion in the outbox
 func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
+	tx, err := r.beginTx(ctx, nil)
+	if err != nil {
+		r.logger.Error("error starting transaction", zap.Error(err))
+		return errors.Wrap(err, "error starting transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			r.logger.Error("error rolling back transaction", zap.Error(err))
+		}
+	}()
+
+	// the nickname of a deleted user is released like a renamed one
+	_, err = tx.ExecContext(ctx, `
+		SELECT pg_advisory_xact_lock(`+nicknameLockKey+`) FROM users WHERE id = $1
+	`, id)
+	if err != nil {
+		r.logger.Error("error locking nickname of removed user", zap.Error(err))
+		return errors.Wrap(err, "error locking nickname of removed user")
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO nickname_history (user_id, nickname, changed_at)
+		SELECT id, nickname, $2 FROM users WHERE id = $1
+	`, id, time.Now().UTC())
+	if err != nil {
+		r.logger.Error("error recording nickname history", zap.Error(err))
+		return errors.Wrap(err, "error recording nickname history")
+	}
+
 	query := `DELETE FROM users WHERE id = $1`
 
 	r.logger.Debug("removing user", zap.String("id", id))
 
-	result, err := r.db.ExecContext(ctx, query, id)
+	result, err := tx.ExecContext(ctx, query, id)
 	if err != nil {
 		r.logger.Error("error removing user", zap.Error(err))
 		return errors.Wrap(err, "error removing user from the database")
@@ -313,6 +521,15 @@ func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
 		return ErrUserNotFound
 	}
 
+	if err := r.enqueueEvents(ctx, tx, userDeletedEntry(id)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.Error("error committing transaction", zap.Error(err))
+		return errors.Wrap(err, "error committing transaction")
+	}
+
 	return nil
 }
 
@@ -320,7 +537,7 @@ func (r *PostgresUserRepository) Delete(ctx context.Context, id string) error {
 func (r *PostgresUserRepository) List(ctx context.Context, filter FilterOptions, pagination PaginationOptions) ([]*models.User, int, error) {
 	// Build base query
 	baseQuery := `
-		SELECT id, first_name, last_name, nickname, email, country, created_at, updated_at
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
 		FROM users
 		WHERE 1=1
 	`
@@ -331,39 +548,14 @@ func (r *PostgresUserRepository) List(ctx context.Context, filter FilterOptions,
 	`
 
 	// Add filters
-	var conditions string
-	var args []interface{}
-	var argIndex int = 1
-
-	if filter.Country != "" {
-		conditions += fmt.Sprintf(" AND country ILIKE $%d", argIndex)
-		args = append(args, filter.Country)
-		argIndex++
-	}
-
-	if filter.Email != "" {
-		conditions += fmt.Sprintf(" AND email ILIKE $%d", argIndex)
-		args = append(args, filter.Email)
-		argIndex++
-	}
-
-	if filter.Nickname != "" {
-		conditions += fmt.Sprintf(" AND nickname ILIKE $%d", argIndex)
-		args = append(args, filter.Nickname)
-		argIndex++
-	}
-
-	if filter.FirstName != "" {
-		conditions += fmt.Sprintf(" AND first_name ILIKE $%d", argIndex)
-		args = append(args, filter.FirstName)
-		argIndex++
+	conditions, args, argIndex, err := filter.where(1)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	if filter.LastName != "" {
-		conditions += fmt.Sprintf(" AND last_name ILIKE $%d", argIndex)
-		args = append(args, filter.LastName)
-		argIndex++
-	}
+	// Count query, before the keyset condition so the total covers every page
+	countQueryFinal := countQuery + conditions
+	countArgs := append([]interface{}{}, args...)
 
 	// Add pagination
 	if pagination.Page < 1 {
@@ -376,27 +568,47 @@ func (r *PostgresUserRepository) List(ctx context.Context, filter FilterOptions,
 	offset := (pagination.Page - 1) * pagination.PageSize
 	limit := pagination.PageSize
 
+	sort := pagination.Sort
+	if len(sort) == 0 {
+		sort = DefaultSort
+	}
+	for _, field := range sort {
+		if !isSortable(field.Column) {
+			return nil, 0, errors.Wrapf(ErrInvalidSort, "unknown sort field %q", field.Column)
+		}
+	}
+
+	if pagination.After != nil {
+		if len(pagination.After.Values) != len(sort) {
+			return nil, 0, errors.New("keyset does not match the sort order")
+		}
+		condition, keysetArgs := keysetCondition(sort, pagination.After, argIndex)
+		conditions += condition
+		args = append(args, keysetArgs...)
+		argIndex += len(keysetArgs)
+		offset = 0
+	}
+
 	// Final query
-	query := baseQuery + conditions + fmt.Sprintf(" ORDER BY created_at DESC LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
+	query := baseQuery + conditions + orderBy(sort) + fmt.Sprintf(" LIMIT $%d OFFSET $%d", argIndex, argIndex+1)
 	args = append(args, limit, offset)
 
-	// Count query
-	countQueryFinal := countQuery + conditions
-
 	r.logger.Debug("listing users",
 		zap.Any("filter", filter),
 		zap.Any("pagination", pagination))
 
 	// Execute count query
-	var total int
-	err := r.db.GetContext(ctx, &total, countQueryFinal, args[:argIndex-1]...)
-	if err != nil {
-		r.logger.Error("error counting users", zap.Error(err))
-		return nil, 0, errors.Wrap(err, "error counting users in the database")
+	total := -1
+	if !pagination.SkipTotal {
+		err := r.conn().GetContext(ctx, &total, countQueryFinal, countArgs...)
+		if err != nil {
+			r.logger.Error("error counting users", zap.Error(err))
+			return nil, 0, errors.Wrap(err, "error counting users in the database")
+		}
 	}
 
 	// Execute main query
-	rows, err := r.db.QueryxContext(ctx, query, args...)
+	rows, err := r.conn().QueryxContext(ctx, query, args...)
 	if err != nil {
 		r.logger.Error("error listing users", zap.Error(err))
 		return nil, 0, errors.Wrap(err, "error listing users in the database")
@@ -420,3 +632,217 @@ func (r *PostgresUserRepository) List(ctx context.Context, filter FilterOptions,
 
 	return users, total, nil
 }
+
+// CreateNicknameGrant allows a user to take a reserved nickname
+func (r *PostgresUserRepository) CreateNicknameGrant(ctx context.Context, grant *models.NicknameGrant) error {
+	query := `
+		INSERT INTO nickname_grants (nickname, user_id, granted_by, granted_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	if grant.GrantedAt.IsZero() {
+		grant.GrantedAt = time.Now().UTC()
+	}
+
+	r.logger.Debug("granting reserved nickname",
+		zap.String("nickname", grant.Nickname),
+		zap.String("user_id", grant.UserID))
+
+	_, err := r.conn().ExecContext(ctx, query, grant.Nickname, grant.UserID, grant.GrantedBy, grant.GrantedAt)
+	if err != nil {
+		if uniqueErr := translateUniqueViolation(err); uniqueErr != nil {
+			return uniqueErr
+		}
+		r.logger.Error("error granting nickname", zap.Error(err))
+		return errors.Wrap(err, "error inserting nickname grant into database")
+	}
+
+	return nil
+}
+
+// HasNicknameGrant reports whether the user was granted the reserved nickname
+func (r *PostgresUserRepository) HasNicknameGrant(ctx context.Context, nickname, userID string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM nickname_grants
+			WHERE lower(nickname) = lower($1) AND user_id = $2
+		)
+	`
+
+	var granted bool
+	if err := r.conn().GetContext(ctx, &granted, query, nickname, userID); err != nil {
+		r.logger.Error("error checking nickname grant", zap.Error(err))
+		return false, errors.Wrap(err, "error checking nickname grant")
+	}
+
+	return granted, nil
+}
+
+// GetByPreviousNickname retrieves the user who most recently released the nickname
+func (r *PostgresUserRepository) GetByPreviousNickname(ctx context.Context, nickname string) (*models.User, error) {
+	query := `
+		SELECT u.id, u.first_name, u.last_name, u.nickname, u.nickname_skeleton, u.email, u.country, u.created_at, u.updated_at, u.verified_at
+		FROM nickname_history h
+		JOIN users u ON u.id = h.user_id
+		WHERE lower(h.nickname) = lower($1)
+		ORDER BY h.changed_at DESC
+		LIMIT 1
+	`
+
+	r.logger.Debug("retrieving user by previous nickname", zap.String("nickname", nickname))
+
+	var user models.User
+	err := r.conn().GetContext(ctx, &user, query, nickname)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrUserNotFound
+		}
+		r.logger.Error("error retrieving user by previous nickname", zap.Error(err))
+		return nil, errors.Wrap(err, "error retrieving user from database")
+	}
+
+	return &user, nil
+}
+
+// Lookup retrieves the users matching any of the ids, emails or nicknames in
+// a single query. Emails and nicknames are matched case-insensitively.
+func (r *PostgresUserRepository) Lookup(ctx context.Context, ids, emails, nicknames []string) ([]*models.User, error) {
+	query := `
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at
+		FROM users
+		WHERE id = ANY($1::uuid[])
+			OR lower(email) = ANY($2)
+			OR lower(nickname) = ANY($3)
+	`
+
+	r.logger.Debug("looking up users",
+		zap.Int("ids", len(ids)),
+		zap.Int("emails", len(emails)),
+		zap.Int("nicknames", len(nicknames)))
+
+	users := []*models.User{}
+	err := r.conn().SelectContext(ctx, &users, query,
+		pq.Array(ids),
+		pq.Array(lowerAll(emails)),
+		pq.Array(lowerAll(nicknames)),
+	)
+	if err != nil {
+		r.logger.Error("error looking up users", zap.Error(err))
+		return nil, errors.Wrap(err, "error looking up users in the database")
+	}
+
+	return users, nil
+}
+
+// LastNicknameChange returns when the user last changed nickname, or the zero
+// time if they never did
+func (r *PostgresUserRepository) LastNicknameChange(ctx context.Context, userID string) (time.Time, error) {
+	query := `SELECT MAX(changed_at) FROM nickname_history WHERE user_id = $1`
+
+	var changedAt sql.NullTime
+	if err := r.conn().GetContext(ctx, &changedAt, query, userID); err != nil {
+		r.logger.Error("error retrieving last nickname change", zap.Error(err))
+		return time.Time{}, errors.Wrap(err, "error retrieving last nickname change")
+	}
+
+	return changedAt.Time, nil
+}
+
+// IsNicknameHeld reports whether another user, possibly deleted since,
+// released the nickname after since
+func (r *PostgresUserRepository) IsNicknameHeld(ctx context.Context, nickname, excludeUserID string, since time.Time) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM nickname_history
+			WHERE lower(nickname) = lower($1) AND (user_id IS NULL OR user_id::text <> $2) AND changed_at > $3
+		)
+	`
+
+	var held bool
+	if err := r.conn().GetContext(ctx, &held, query, nickname, excludeUserID, since); err != nil {
+		r.logger.Error("error checking nickname quarantine", zap.Error(err))
+		return false, errors.Wrap(err, "error checking nickname quarantine")
+	}
+
+	return held, nil
+}
+
+// HeldNicknames returns which of nicknames, compared case-insensitively,
+// were released since the given time, in lower case
+func (r *PostgresUserRepository) HeldNicknames(ctx context.Context, nicknames []string, since time.Time) ([]string, error) {
+	query := `
+		SELECT DISTINCT lower(nickname)
+		FROM nickname_history
+		WHERE lower(nickname) = ANY($1) AND changed_at > $2
+	`
+
+	held := []string{}
+	if err := r.conn().SelectContext(ctx, &held, query, pq.Array(lowerAll(nicknames)), since); err != nil {
+		r.logger.Error("error checking nickname quarantine", zap.Error(err))
+		return nil, errors.Wrap(err, "error checking nickname quarantine")
+	}
+
+	return held, nil
+}
+
+// nicknameLockKey is the advisory lock key of the nickname in scope; users
+// and LockNicknames share it
+const nicknameLockKey = `hashtext('nickname:' || lower(nickname))`
+
+// LockNicknames locks each nickname, compared case-insensitively, until the
+// bound transaction ends, so the history checks of a rename or sign-up are
+// not raced by another one releasing or taking the same nickname. Deletions
+// take the same lock. It has no effect outside WithinTransaction.
+func (r *PostgresUserRepository) LockNicknames(ctx context.Context, nicknames ...string) error {
+	// locks are taken in a fixed order so transactions do not deadlock
+	query := `
+		SELECT pg_advisory_xact_lock(key)
+		FROM (
+			SELECT DISTINCT ` + nicknameLockKey + ` AS key
+			FROM unnest($1::text[]) AS nickname
+			ORDER BY key
+		) AS keys
+	`
+
+	if _, err := r.conn().ExecContext(ctx, query, pq.Array(nicknames)); err != nil {
+		r.logger.Error("error locking nicknames", zap.Error(err))
+		return errors.Wrap(err, "error locking nicknames")
+	}
+	return nil
+}
+
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(value)
+	}
+	return lowered
+}
+
+func upperAll(values []string) []string {
+	uppered := make([]string, len(values))
+	for i, value := range values {
+		uppered[i] = strings.ToUpper(value)
+	}
+	return uppered
+}
+
+// translateUniqueViolation maps a Postgres unique violation on the email,
+// nickname or nickname skeleton index to the matching domain error. It returns nil for any other error.
+func translateUniqueViolation(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolationCode {
+		return nil
+	}
+
+	switch pqErr.Constraint {
+	case emailUniqueIndex:
+		return ErrEmailAlreadyExists
+	case nicknameUniqueIndex, grantUniqueIndex:
+		return ErrNicknameAlreadyExists
+	case skeletonUniqueIndex:
+		return ErrNicknameConfusable
+	default:
+		return ErrUserExists
+	}
+}
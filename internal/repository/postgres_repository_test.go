@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"user-microservice/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newMockRepository(t *testing.T) (*PostgresUserRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return NewPostgresUserRepository(sqlx.NewDb(db, "postgres"), zap.NewNop()), mock
+}
+
+func uniqueViolation(constraint string) error {
+	return &pq.Error{Code: uniqueViolationCode, Constraint: constraint}
+}
+
+func TestPostgresUserRepository_Create_TranslatesUniqueViolations(t *testing.T) {
+	tests := []struct {
+		constraint string
+		want       error
+	}{
+		{emailUniqueIndex, ErrEmailAlreadyExists},
+		{nicknameUniqueIndex, ErrNicknameAlreadyExists},
+		{skeletonUniqueIndex, ErrNicknameConfusable},
+		{"users_pkey", ErrUserExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectBegin()
+			mock.ExpectExec("INSERT INTO users").WillReturnError(uniqueViolation(tt.constraint))
+			mock.ExpectRollback()
+
+			err := repo.Create(context.Background(), &models.User{Nickname: "alice", Email: "alice@example.com"})
+
+			assert.ErrorIs(t, err, tt.want)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresUserRepository_Create_ConcurrentDuplicates(t *testing.T) {
+	// the unique index lets one of the concurrent inserts through
+	repo, mock := newMockRepository(t)
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectBegin()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO users").WillReturnError(uniqueViolation(emailUniqueIndex))
+	mock.ExpectExec("INSERT INTO outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	errs := make([]error, 2)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = repo.Create(context.Background(), &models.User{Nickname: "alice", Email: "alice@example.com"})
+		}()
+	}
+	wg.Wait()
+
+	assert.ElementsMatch(t, []bool{true, false}, []bool{errs[0] == nil, errs[1] == nil})
+	for _, err := range errs {
+		if err != nil {
+			assert.ErrorIs(t, err, ErrEmailAlreadyExists)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_Update_TranslatesUniqueViolations(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	user := &models.User{ID: "0b8f3f2c-6f1e-4a8e-9d51-5c3f0c1d2e3f", Nickname: "alice", Email: "alice@example.com"}
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT .* FROM users").WillReturnRows(
+		sqlmock.NewRows([]string{"id", "nickname", "email"}).AddRow(user.ID, "alice", "old@example.com"))
+	mock.ExpectExec("UPDATE users").WillReturnError(uniqueViolation(emailUniqueIndex))
+	mock.ExpectRollback()
+
+	err := repo.Update(context.Background(), user)
+
+	assert.ErrorIs(t, err, ErrEmailAlreadyExists)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_Delete_QuarantinesNickname(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	id := "0b8f3f2c-6f1e-4a8e-9d51-5c3f0c1d2e3f"
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO nickname_history").WithArgs(id, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM users").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO outbox").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.Delete(context.Background(), id)
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFilterOptions_CountryMatchesStoredCode(t *testing.T) {
+	conditions, args, next, err := FilterOptions{
+		Country: StringFilter{Op: OpIn, Values: []string{"pt", "Es"}},
+		Email:   StringFilter{Op: OpEq, Values: []string{"Ann@example.com"}},
+	}.where(1)
+
+	require.NoError(t, err)
+	assert.Equal(t, " AND country = ANY($1) AND lower(email) = lower($2)", conditions)
+	assert.Equal(t, []interface{}{pq.Array([]string{"PT", "ES"}), "Ann@example.com"}, args)
+	assert.Equal(t, 3, next)
+
+	conditions, args, _, err = FilterOptions{Country: StringFilter{Values: []string{"pt"}}}.where(1)
+
+	require.NoError(t, err)
+	assert.Equal(t, " AND country = $1", conditions)
+	assert.Equal(t, []interface{}{"PT"}, args)
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// ScoredUser is a user matched by Search along with its relevance
+type ScoredUser struct {
+	models.User
+	Score float64 `db:"score" json:"score"`
+}
+
+// MarshalJSON adds the score to the fields of the user; the MarshalJSON
+// promoted from models.User would drop it
+func (u ScoredUser) MarshalJSON() ([]byte, error) {
+	user, err := json.Marshal(u.User)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(user, &fields); err != nil {
+		return nil, err
+	}
+	if fields["score"], err = json.Marshal(u.Score); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// searchCondition matches the full-text vector or a fuzzy match of the term
+// on any searchable column; both are backed by the indexes of migration 006
+const searchCondition = `
+	search_vector @@ plainto_tsquery('simple', $1::text)
+	OR $1::text <% first_name
+	OR $1::text <% last_name
+	OR $1::text <% nickname
+	OR $1::text <% email
+`
+
+// Search ranks users matching term across first name, last name, nickname
+// and email, combining full-text rank with trigram word similarity so partial
+// and misspelled terms still match. Only Page, PageSize and SkipTotal of
+// pagination apply.
+func (r *PostgresUserRepository) Search(ctx context.Context, term string, pagination PaginationOptions) ([]*ScoredUser, int, error) {
+	if pagination.Page < 1 {
+		pagination.Page = 1
+	}
+	if pagination.PageSize < 1 {
+		pagination.PageSize = 10
+	}
+
+	query := `
+		SELECT id, first_name, last_name, nickname, nickname_skeleton, email, country, created_at, updated_at, verified_at,
+			ts_rank(search_vector, plainto_tsquery('simple', $1::text)) + GREATEST(
+				word_similarity($1::text, first_name),
+				word_similarity($1::text, last_name),
+				word_similarity($1::text, nickname),
+				word_similarity($1::text, email)
+			) AS score
+		FROM users
+		WHERE ` + searchCondition + `
+		ORDER BY score DESC, id
+		LIMIT $2 OFFSET $3
+	`
+
+	r.logger.Debug("searching users",
+		zap.String("term", term),
+		zap.Any("pagination", pagination))
+
+	total := -1
+	if !pagination.SkipTotal {
+		if err := r.conn().GetContext(ctx, &total, `SELECT COUNT(*) FROM users WHERE `+searchCondition, term); err != nil {
+			r.logger.Error("error counting search results", zap.Error(err))
+			return nil, 0, errors.Wrap(err, "error counting search results in the database")
+		}
+	}
+
+	users := []*ScoredUser{}
+	offset := (pagination.Page - 1) * pagination.PageSize
+	if err := r.conn().SelectContext(ctx, &users, query, term, pagination.PageSize, offset); err != nil {
+		r.logger.Error("error searching users", zap.Error(err))
+		return nil, 0, errors.Wrap(err, "error searching users in the database")
+	}
+
+	return users, total, nil
+}
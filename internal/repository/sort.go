@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"user-microservice/internal/models"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// sortableColumns whitelists the columns users can be sorted by
+var sortableColumns = []string{"first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at"}
+
+// DefaultSort lists the newest users first
+var DefaultSort = []SortField{{Column: "created_at", Desc: true}}
+
+// SortField is one key of the list order
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ParseSort parses a comma-separated sort spec such as "last_name,-created_at".
+// A leading "-" sorts descending. An empty spec yields DefaultSort.
+func ParseSort(spec string) ([]SortField, error) {
+	if strings.TrimSpace(spec) == "" {
+		return DefaultSort, nil
+	}
+
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+		field := SortField{Column: strings.TrimPrefix(key, "-"), Desc: strings.HasPrefix(key, "-")}
+		if !isSortable(field.Column) {
+			return nil, errors.Wrapf(ErrInvalidSort, "unknown sort field %q, allowed fields: %s", field.Column, strings.Join(sortableColumns, ", "))
+		}
+		if seen[field.Column] {
+			return nil, errors.Wrapf(ErrInvalidSort, "sort field %q given twice", field.Column)
+		}
+		seen[field.Column] = true
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// FormatSort returns the canonical spec of a sort, as accepted by ParseSort
+func FormatSort(fields []SortField) string {
+	keys := make([]string, len(fields))
+	for i, field := range fields {
+		keys[i] = field.Column
+		if field.Desc {
+			keys[i] = "-" + keys[i]
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+// KeysetFor returns the keyset of user in the given order
+func KeysetFor(user *models.User, fields []SortField) Keyset {
+	keyset := Keyset{ID: user.ID, Values: make([]string, len(fields))}
+	for i, field := range fields {
+		keyset.Values[i] = sortValue(user, field.Column)
+	}
+	return keyset
+}
+
+func isSortable(column string) bool {
+	for _, c := range sortableColumns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+func sortValue(user *models.User, column string) string {
+	switch column {
+	case "first_name":
+		return user.FirstName
+	case "last_name":
+		return user.LastName
+	case "nickname":
+		return user.Nickname
+	case "email":
+		return user.Email
+	case "country":
+		return user.Country
+	case "created_at":
+		return user.CreatedAt.Format(time.RFC3339Nano)
+	case "updated_at":
+		return user.UpdatedAt.Format(time.RFC3339Nano)
+	}
+	return ""
+}
+
+// orderBy builds the ORDER BY clause. id breaks ties in the direction of
+// the last sort key so the order is total.
+func orderBy(fields []SortField) string {
+	keys := make([]string, 0, len(fields)+1)
+	for _, field := range fields {
+		keys = append(keys, field.Column+direction(field.Desc))
+	}
+	keys = append(keys, "id"+direction(tieBreakDesc(fields)))
+	return " ORDER BY " + strings.Join(keys, ", ")
+}
+
+// keysetCondition builds the condition selecting the rows after keyset in
+// the given order. Mixed directions rule out a row comparison, so it is
+// expanded to (a > $1) OR (a = $1 AND b < $2) OR ...
+func keysetCondition(fields []SortField, keyset *Keyset, argIndex int) (string, []interface{}) {
+	columns := make([]SortField, 0, len(fields)+1)
+	columns = append(columns, fields...)
+	columns = append(columns, SortField{Column: "id", Desc: tieBreakDesc(fields)})
+
+	values := make([]interface{}, 0, len(columns))
+	for _, value := range keyset.Values {
+		values = append(values, value)
+	}
+	values = append(values, keyset.ID)
+
+	terms := make([]string, len(columns))
+	for i, column := range columns {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = $%d", columns[j].Column, argIndex+j))
+		}
+		op := ">"
+		if column.Desc {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s $%d", column.Column, op, argIndex+i))
+		terms[i] = "(" + strings.Join(parts, " AND ") + ")"
+	}
+
+	return " AND (" + strings.Join(terms, " OR ") + ")", values
+}
+
+func tieBreakDesc(fields []SortField) bool {
+	return len(fields) > 0 && fields[len(fields)-1].Desc
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// nestedSavepoint names the savepoints standing in for nested transactions.
+// Postgres lets a name be reused; RELEASE and ROLLBACK TO act on the most
+// recent savepoint of that name.
+const nestedSavepoint = "repository_nested"
+
+// queryer is implemented by both *sqlx.DB and *sqlx.Tx
+type queryer interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// txn is a transaction, or a savepoint when the repository is already bound
+// to a transaction. Commit and Rollback behave alike in both cases.
+type txn struct {
+	*sqlx.Tx
+	savepoint bool
+	done      bool
+}
+
+func (t *txn) Commit() error {
+	if !t.savepoint {
+		return t.Tx.Commit()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Exec("RELEASE SAVEPOINT " + nestedSavepoint)
+	return err
+}
+
+func (t *txn) Rollback() error {
+	if !t.savepoint {
+		return t.Tx.Rollback()
+	}
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Exec("ROLLBACK TO SAVEPOINT " + nestedSavepoint)
+	return err
+}
+
+// conn returns the transaction the repository is bound to, or the database
+func (r *PostgresUserRepository) conn() queryer {
+	if r.tx != nil {
+		return r.tx
+	}
+	return r.db
+}
+
+// beginTx starts a transaction, or a savepoint of the bound transaction, in
+// which case opts are ignored
+func (r *PostgresUserRepository) beginTx(ctx context.Context, opts *sql.TxOptions) (*txn, error) {
+	if r.tx != nil {
+		if _, err := r.tx.ExecContext(ctx, "SAVEPOINT "+nestedSavepoint); err != nil {
+			return nil, err
+		}
+		return &txn{Tx: r.tx, savepoint: true}, nil
+	}
+
+	tx, err := r.db.BeginTxx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx}, nil
+}
+
+// WithinTransaction runs fn with a repository bound to a single transaction,
+// which is committed when fn returns nil and rolled back otherwise. Calls on
+// a repository that is already bound join its transaction.
+func (r *PostgresUserRepository) WithinTransaction(ctx context.Context, fn func(repo UserRepository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		r.logger.Error("error starting transaction", zap.Error(err))
+		return errors.Wrap(err, "error starting transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			r.logger.Error("error rolling back transaction", zap.Error(err))
+		}
+	}()
+
+	bound := &PostgresUserRepository{db: r.db, tx: tx, logger: r.logger}
+	if err := fn(bound); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.logger.Error("error committing transaction", zap.Error(err))
+		return errors.Wrap(err, "error committing transaction")
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+
+	"user-microservice/internal/models"
+	"user-microservice/internal/repository"
+
+	"github.com/pkg/errors"
+)
+
+var ErrBatchAborted = errors.New("batch aborted")
+
+// BatchAction is the kind of change made by a BatchOperation
+type BatchAction string
+
+const (
+	BatchCreate BatchAction = "create"
+	BatchUpdate BatchAction = "update"
+	BatchDelete BatchAction = "delete"
+)
+
+// BatchOperation is one change of a batch. Ref is chosen by the client to
+// match results to operations. ID names the user to update or delete;
+// Password only applies to creates.
+type BatchOperation struct {
+	Ref       string
+	Action    BatchAction
+	ID        string
+	FirstName string
+	LastName  string
+	Nickname  string
+	Password  string
+	Email     string
+	Country   string
+}
+
+// BatchOperationResult is the outcome of a BatchOperation. User is the
+// created or updated user and is nil for deletes and failed operations.
+type BatchOperationResult struct {
+	Ref    string
+	Action BatchAction
+	User   *models.User
+	Err    error
+}
+
+// ApplyBatch applies operations in order through the same checks as
+// CreateUser, UpdateUser and DeleteUser. When atomic is set they run in one
+// transaction that is rolled back at the first failure, which fails every
+// other operation with ErrBatchAborted. Otherwise each operation stands on
+// its own. Events are only published for committed operations, as they are
+// written to the outbox in the same transaction.
+func (s *UserService) ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchOperationResult, error) {
+	if len(ops) == 0 {
+		return nil, errors.Wrap(ErrInvalidInput, "no operations given")
+	}
+	if max := s.maxBatchSize(); len(ops) > max {
+		return nil, errors.Wrapf(ErrInvalidInput, "at most %d operations are allowed", max)
+	}
+
+	refs := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		if op.Ref == "" {
+			return nil, errors.Wrap(ErrInvalidInput, "every operation needs a ref")
+		}
+		if refs[op.Ref] {
+			return nil, errors.Wrapf(ErrInvalidInput, "ref %q is used more than once", op.Ref)
+		}
+		refs[op.Ref] = true
+	}
+
+	results := make([]BatchOperationResult, len(ops))
+	if !atomic {
+		for i, op := range ops {
+			results[i] = s.applyOperation(ctx, op)
+		}
+		return results, nil
+	}
+
+	failed := -1
+	err := s.repo.WithinTransaction(ctx, func(repo repository.UserRepository) error {
+		tx := *s
+		tx.repo = repo
+
+		for i, op := range ops {
+			results[i] = tx.applyOperation(ctx, op)
+			if results[i].Err != nil {
+				failed = i
+				return results[i].Err
+			}
+		}
+		return nil
+	})
+
+	if failed >= 0 {
+		for i, op := range ops {
+			if i != failed {
+				results[i] = BatchOperationResult{
+					Ref:    op.Ref,
+					Action: op.Action,
+					Err:    errors.Wrapf(ErrBatchAborted, "operation %q failed", ops[failed].Ref),
+				}
+			}
+		}
+		return results, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "error applying batch")
+	}
+	return results, nil
+}
+
+func (s *UserService) applyOperation(ctx context.Context, op BatchOperation) BatchOperationResult {
+	result := BatchOperationResult{Ref: op.Ref, Action: op.Action}
+
+	switch op.Action {
+	case BatchCreate:
+		result.User, result.Err = s.CreateUser(ctx, op.FirstName, op.LastName, op.Nickname, op.Password, op.Email, op.Country)
+	case BatchUpdate:
+		result.User, result.Err = s.UpdateUser(ctx, op.ID, op.FirstName, op.LastName, op.Nickname, op.Email, op.Country)
+	case BatchDelete:
+		result.Err = s.DeleteUser(ctx, op.ID)
+	default:
+		result.Err = errors.Wrapf(ErrInvalidInput, "unknown action %q", op.Action)
+	}
+
+	return result
+}
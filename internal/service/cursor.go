@@ -0,0 +1,82 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"user-microservice/internal/repository"
+
+	"github.com/pkg/errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorCodec turns a keyset into an opaque cursor and back. Cursors are
+// signed so clients cannot forge positions or tamper with their content.
+type cursorCodec struct {
+	secret []byte
+}
+
+// cursorPayload records the sort a cursor was issued for along with the
+// keyset, so it cannot be replayed against a different order
+type cursorPayload struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+	ID     string   `json:"id"`
+}
+
+// newCursorCodec creates a codec signing with secret. An empty secret is
+// replaced by a random one, so cursors do not survive a restart.
+func newCursorCodec(secret string) (cursorCodec, bool) {
+	if secret != "" {
+		return cursorCodec{secret: []byte(secret)}, true
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic(errors.Wrap(err, "error generating cursor secret"))
+	}
+	return cursorCodec{secret: random}, false
+}
+
+// Encode returns the cursor for the keyset in the given sort
+func (c cursorCodec) Encode(sort string, keyset repository.Keyset) string {
+	payload, _ := json.Marshal(cursorPayload{Sort: sort, Values: keyset.Values, ID: keyset.ID})
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded))
+}
+
+// Decode verifies the cursor signature and returns its sort and keyset
+func (c cursorCodec) Decode(cursor string) (string, *repository.Keyset, error) {
+	encoded, signature, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return "", nil, ErrInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, c.sign(encoded)) {
+		return "", nil, ErrInvalidCursor
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, ErrInvalidCursor
+	}
+
+	var payload cursorPayload
+	if err := json.Unmarshal(raw, &payload); err != nil || payload.ID == "" {
+		return "", nil, ErrInvalidCursor
+	}
+
+	return payload.Sort, &repository.Keyset{Values: payload.Values, ID: payload.ID}, nil
+}
+
+func (c cursorCodec) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
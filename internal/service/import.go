@@ -0,0 +1,416 @@
+package service
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"user-microservice/internal/models"
+	"user-microservice/internal/repository"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var ErrImportJobNotFound = repository.ErrImportJobNotFound
+
+// defaultMaxImportRows bounds imports when no limit is configured
+const defaultMaxImportRows = 10000
+
+// ImportRow is a user read from an import file. Err is set when the row
+// could not be parsed; the row is then reported as failed. Row is the
+// number the row is reported under; rows are numbered in order when it is
+// zero.
+type ImportRow struct {
+	Row       int
+	FirstName string
+	LastName  string
+	Nickname  string
+	Password  string
+	Email     string
+	Country   string
+	Err       error
+}
+
+// ImportUsers validates rows and, unless dryRun is set, creates the valid
+// ones in batches. Rows duplicating an earlier row of the file or an existing
+// user fail; the report lists the outcome of every row in file order.
+func (s *UserService) ImportUsers(ctx context.Context, rows []ImportRow, dryRun bool) (*models.ImportReport, error) {
+	if err := s.checkImportSize(rows); err != nil {
+		return nil, err
+	}
+
+	report := &models.ImportReport{
+		DryRun: dryRun,
+		Total:  len(rows),
+		Rows:   make([]models.ImportRowResult, len(rows)),
+	}
+	for i, row := range rows {
+		report.Rows[i].Row = row.Row
+		if row.Row == 0 {
+			report.Rows[i].Row = i + 1
+		}
+	}
+
+	users, err := s.validateImportRows(ctx, rows, report.Rows)
+	if err != nil {
+		return nil, err
+	}
+	s.rejectDuplicateRows(users, report.Rows)
+	if err := s.rejectExistingUsers(ctx, users, report.Rows); err != nil {
+		return nil, err
+	}
+
+	valid := make([]*models.User, 0, len(users))
+	for _, user := range users {
+		if user != nil {
+			s.storeSkeleton(user)
+			valid = append(valid, user)
+		}
+	}
+
+	if dryRun {
+		for i, user := range users {
+			if user != nil {
+				report.Rows[i].Status = models.ImportRowValid
+				report.Valid++
+			}
+		}
+	} else if len(valid) > 0 {
+		ids, err := s.repo.CreateBatch(ctx, valid)
+		if err != nil {
+			return nil, errors.Wrap(err, "error persisting imported users")
+		}
+
+		inserted := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			inserted[strings.ToLower(id)] = true
+		}
+
+		for i, user := range users {
+			if user == nil {
+				continue
+			}
+			// a user registered concurrently took the email or nickname
+			if !inserted[strings.ToLower(user.ID)] {
+				failImportRow(&report.Rows[i], ErrUserAlreadyExists)
+				continue
+			}
+			report.Rows[i].Status = models.ImportRowCreated
+			report.Rows[i].UserID = user.ID
+			report.Created++
+		}
+	}
+
+	for _, row := range report.Rows {
+		if row.Status == models.ImportRowError {
+			report.Failed++
+		}
+	}
+
+	s.logger.Info("users imported",
+		zap.Bool("dry_run", dryRun),
+		zap.Int("total", report.Total),
+		zap.Int("created", report.Created),
+		zap.Int("failed", report.Failed))
+
+	return report, nil
+}
+
+// importJobs tracks the imports running in the background so Shutdown can
+// stop them
+type importJobs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newImportJobs() *importJobs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &importJobs{ctx: ctx, cancel: cancel}
+}
+
+// StartImport records an import job and runs the import in the background.
+// The job outlives the request, but not Shutdown; poll it with GetImportJob.
+func (s *UserService) StartImport(ctx context.Context, rows []ImportRow, dryRun bool) (*models.ImportJob, error) {
+	if err := s.checkImportSize(rows); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	job := models.ImportJob{
+		ID:        uuid.New().String(),
+		Status:    models.ImportJobPending,
+		DryRun:    dryRun,
+		TotalRows: len(rows),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := s.repo.CreateImportJob(ctx, &job); err != nil {
+		return nil, errors.Wrap(err, "error creating import job")
+	}
+
+	running := job
+	s.imports.wg.Add(1)
+	go func() {
+		defer s.imports.wg.Done()
+		s.runImportJob(s.imports.ctx, &running, rows)
+	}()
+
+	return &job, nil
+}
+
+// Shutdown cancels the running imports and waits until they are marked as
+// failed, or until ctx is done
+func (s *UserService) Shutdown(ctx context.Context) error {
+	s.imports.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.imports.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "error waiting for import jobs")
+	}
+}
+
+func (s *UserService) runImportJob(ctx context.Context, job *models.ImportJob, rows []ImportRow) {
+	logger := s.logger.With(zap.String("job_id", job.ID))
+	// the outcome is stored even when the import was cancelled
+	storeCtx := context.WithoutCancel(ctx)
+
+	job.Status = models.ImportJobRunning
+	job.UpdatedAt = time.Now().UTC()
+	if err := s.repo.UpdateImportJob(storeCtx, job); err != nil {
+		logger.Error("error marking import job as running", zap.Error(err))
+	}
+
+	report, err := s.ImportUsers(ctx, rows, job.DryRun)
+	if err != nil && ctx.Err() != nil {
+		err = errors.Wrap(err, "import interrupted by shutdown")
+	}
+	if err != nil {
+		logger.Error("import job failed", zap.Error(err))
+		job.Status = models.ImportJobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = models.ImportJobCompleted
+		job.Report = report
+	}
+
+	job.UpdatedAt = time.Now().UTC()
+	if err := s.repo.UpdateImportJob(storeCtx, job); err != nil {
+		logger.Error("error storing import job result", zap.Error(err))
+	}
+}
+
+// GetImportJob retrieves an import job and, once completed, its report
+func (s *UserService) GetImportJob(ctx context.Context, id string) (*models.ImportJob, error) {
+	if id == "" {
+		return nil, ErrInvalidInput
+	}
+
+	job, err := s.repo.GetImportJob(ctx, id)
+	if err != nil {
+		if errors.Is(err, ErrImportJobNotFound) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "error fetching import job")
+	}
+	return job, nil
+}
+
+func (s *UserService) checkImportSize(rows []ImportRow) error {
+	if len(rows) == 0 {
+		return errors.Wrap(ErrInvalidInput, "no rows to import")
+	}
+	if max := s.maxImportRows(); len(rows) > max {
+		return errors.Wrapf(ErrInvalidInput, "at most %d rows can be imported at once", max)
+	}
+	return nil
+}
+
+func (s *UserService) maxImportRows() int {
+	if s.config.MaxImportRows > 0 {
+		return s.config.MaxImportRows
+	}
+	return defaultMaxImportRows
+}
+
+// validateImportRows builds a user from every row on a pool of workers, as
+// password hashing dominates the cost of an import. Users of failed rows
+// are nil.
+func (s *UserService) validateImportRows(ctx context.Context, rows []ImportRow, results []models.ImportRowResult) ([]*models.User, error) {
+	users := make([]*models.User, len(rows))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	workers := min(runtime.NumCPU(), len(rows))
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				user, err := s.validateImportRow(rows[i])
+				if err != nil {
+					failImportRow(&results[i], err)
+					continue
+				}
+				users[i] = user
+			}
+		}()
+	}
+
+	for i := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// validateImportRow applies the checks of CreateUser that need no query to
+// a row; the others run per batch in rejectExistingUsers
+func (s *UserService) validateImportRow(row ImportRow) (*models.User, error) {
+	if row.Err != nil {
+		return nil, row.Err
+	}
+
+	// imported users hold no grants, so no query is needed
+	if err := s.nicknamePolicy.Check(models.CanonicalNickname(row.Nickname)); err != nil {
+		return nil, err
+	}
+
+	return models.NewUser(row.FirstName, row.LastName, row.Nickname, row.Password, row.Email, row.Country)
+}
+
+// rejectDuplicateRows fails rows whose email or nickname, compared
+// case-insensitively, already appears in an earlier row
+func (s *UserService) rejectDuplicateRows(users []*models.User, results []models.ImportRowResult) {
+	emails := map[string]int{}
+	nicknames := map[string]int{}
+	skeletons := map[string]int{}
+
+	for i, user := range users {
+		if user == nil {
+			continue
+		}
+
+		email := strings.ToLower(user.Email)
+		nickname := strings.ToLower(user.Nickname)
+		var err error
+		if first, ok := emails[email]; ok {
+			err = errors.Wrapf(ErrEmailAlreadyExists, "duplicate of row %d", results[first].Row)
+		} else if first, ok := nicknames[nickname]; ok {
+			err = errors.Wrapf(ErrNicknameAlreadyExists, "duplicate of row %d", results[first].Row)
+		} else if first, ok := skeletons[user.NicknameSkeleton]; ok && s.config.ConfusableCheck {
+			err = errors.Wrapf(ErrNicknameConfusable, "similar to row %d", results[first].Row)
+		}
+		if err != nil {
+			failImportRow(&results[i], err)
+			users[i] = nil
+			continue
+		}
+
+		emails[email] = i
+		nicknames[nickname] = i
+		skeletons[user.NicknameSkeleton] = i
+	}
+}
+
+// rejectExistingUsers fails rows whose email or nickname is already
+// registered, whose nickname is confusable with a registered one or whose
+// nickname is quarantined, with one query per check and batch
+func (s *UserService) rejectExistingUsers(ctx context.Context, users []*models.User, results []models.ImportRowResult) error {
+	indexes := make([]int, 0, len(users))
+	for i, user := range users {
+		if user != nil {
+			indexes = append(indexes, i)
+		}
+	}
+
+	batchSize := s.maxBatchSize()
+	for start := 0; start < len(indexes); start += batchSize {
+		batch := indexes[start:min(start+batchSize, len(indexes))]
+
+		emails := make([]string, len(batch))
+		nicknames := make([]string, len(batch))
+		skeletons := make([]string, len(batch))
+		for j, i := range batch {
+			emails[j] = users[i].Email
+			nicknames[j] = users[i].Nickname
+			skeletons[j] = users[i].NicknameSkeleton
+		}
+
+		existing, err := s.repo.Lookup(ctx, nil, emails, nicknames)
+		if err != nil {
+			return errors.Wrap(err, "error checking existing users")
+		}
+
+		takenEmails := make(map[string]bool, len(existing))
+		takenNicknames := make(map[string]bool, len(existing))
+		for _, user := range existing {
+			takenEmails[strings.ToLower(user.Email)] = true
+			takenNicknames[strings.ToLower(user.Nickname)] = true
+		}
+
+		takenSkeletons := map[string]bool{}
+		if s.config.ConfusableCheck {
+			confusable, err := s.repo.GetByNicknameSkeletons(ctx, skeletons)
+			if err != nil {
+				return errors.Wrap(err, "error checking confusable nicknames")
+			}
+			for _, user := range confusable {
+				takenSkeletons[user.NicknameSkeleton] = true
+			}
+		}
+
+		held := map[string]bool{}
+		if quarantine := s.config.Nickname.Quarantine; quarantine > 0 {
+			released, err := s.repo.HeldNicknames(ctx, nicknames, time.Now().UTC().Add(-quarantine))
+			if err != nil {
+				return errors.Wrap(err, "error checking nickname quarantine")
+			}
+			for _, nickname := range released {
+				held[nickname] = true
+			}
+		}
+
+		for _, i := range batch {
+			switch {
+			case takenEmails[strings.ToLower(users[i].Email)]:
+				failImportRow(&results[i], ErrEmailAlreadyExists)
+			case takenNicknames[strings.ToLower(users[i].Nickname)]:
+				failImportRow(&results[i], ErrNicknameAlreadyExists)
+			case takenSkeletons[users[i].NicknameSkeleton]:
+				failImportRow(&results[i], ErrNicknameConfusable)
+			case held[strings.ToLower(users[i].Nickname)]:
+				failImportRow(&results[i], ErrNicknameQuarantined)
+			default:
+				continue
+			}
+			users[i] = nil
+		}
+	}
+	return nil
+}
+
+func failImportRow(result *models.ImportRowResult, err error) {
+	result.Status = models.ImportRowError
+	result.Error = err.Error()
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"user-microservice/internal/models"
+	"user-microservice/internal/repository"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	minSearchLength = 2
+	maxSearchLength = 100
+)
+
+// SearchHit is a user matched by SearchUsers. Highlights holds, for each
+// field that literally contains a query term, the HTML-escaped field value
+// with the matches wrapped in <mark>.
+type SearchHit struct {
+	User       *models.User
+	Score      float64
+	Highlights map[string]string
+}
+
+// SearchResult is a page of search hits, best first. Total is nil when it
+// was not requested.
+type SearchResult struct {
+	Hits  []*SearchHit
+	Total *int
+}
+
+// SearchUsers ranks users matching query by name, nickname and email,
+// tolerating partial words and misspellings. Results are paged by Page and
+// PageSize; cursors and sort orders do not apply.
+func (s *UserService) SearchUsers(ctx context.Context, query string, opts ListOptions) (*SearchResult, error) {
+	query = strings.Join(strings.Fields(query), " ")
+	if n := utf8.RuneCountInString(query); n < minSearchLength || n > maxSearchLength {
+		return nil, errors.Wrapf(ErrInvalidInput, "search query must be between %d and %d characters", minSearchLength, maxSearchLength)
+	}
+	if opts.Cursor != "" || opts.Sort != "" {
+		return nil, errors.Wrap(ErrInvalidInput, "search results are ordered by relevance and paged by page number")
+	}
+
+	users, total, err := s.repo.Search(ctx, query, repository.PaginationOptions{
+		Page:      opts.Page,
+		PageSize:  opts.PageSize,
+		SkipTotal: opts.SkipTotal,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error searching users")
+	}
+
+	terms := strings.Fields(query)
+	result := &SearchResult{Hits: make([]*SearchHit, 0, len(users))}
+	for _, scored := range users {
+		user := scored.User
+		user.SanitizeForOutput()
+		result.Hits = append(result.Hits, &SearchHit{
+			User:       &user,
+			Score:      scored.Score,
+			Highlights: highlightUser(&user, terms),
+		})
+	}
+	if !opts.SkipTotal {
+		result.Total = &total
+	}
+
+	return result, nil
+}
+
+func highlightUser(user *models.User, terms []string) map[string]string {
+	highlights := map[string]string{}
+	for field, value := range map[string]string{
+		"first_name": user.FirstName,
+		"last_name":  user.LastName,
+		"nickname":   user.Nickname,
+		"email":      user.Email,
+	} {
+		if marked, ok := highlight(value, terms); ok {
+			highlights[field] = marked
+		}
+	}
+	return highlights
+}
+
+// highlight wraps case-insensitive occurrences of the terms in value with
+// <mark>, escaping everything else. It reports whether any term matched.
+func highlight(value string, terms []string) (string, bool) {
+	// marked[i] is set for every byte of value covered by a match
+	marked := make([]bool, len(value))
+	found := false
+	for i := range value {
+		for _, term := range terms {
+			if n, ok := matchFold(value[i:], term); ok {
+				for j := i; j < i+n; j++ {
+					marked[j] = true
+				}
+				found = true
+			}
+		}
+	}
+	if !found {
+		return "", false
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); {
+		j := i
+		for j < len(value) && marked[j] == marked[i] {
+			j++
+		}
+		if marked[i] {
+			b.WriteString("<mark>" + html.EscapeString(value[i:j]) + "</mark>")
+		} else {
+			b.WriteString(html.EscapeString(value[i:j]))
+		}
+		i = j
+	}
+	return b.String(), true
+}
+
+// matchFold reports whether s starts with term, ignoring case, and returns
+// the length in bytes of the match in s
+func matchFold(s, term string) (int, bool) {
+	n := 0
+	for _, t := range term {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if size == 0 || unicode.ToLower(r) != unicode.ToLower(t) {
+			return 0, false
+		}
+		n += size
+	}
+	return n, n > 0
+}
@@ -2,82 +2,186 @@ package service
 
 import (
 	"context"
-	"fmt"
+	"strings"
 	"time"
 
+	"user-microservice/internal/config"
 	"user-microservice/internal/models"
-	"user-microservice/internal/notification"
 	"user-microservice/internal/repository"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 var (
 	ErrInvalidInput          = errors.New("invalid input data")
-	ErrEmailAlreadyExists    = errors.New("email already registered")
-	ErrNicknameAlreadyExists = errors.New("nickname already registered")
+	ErrEmailAlreadyExists    = repository.ErrEmailAlreadyExists
+	ErrNicknameAlreadyExists = repository.ErrNicknameAlreadyExists
+	ErrNicknameConfusable    = repository.ErrNicknameConfusable
+	ErrUserAlreadyExists     = repository.ErrUserExists
+	ErrNicknameNotAllowed    = models.ErrNicknameNotAllowed
+	ErrNicknameNotReserved   = errors.New("nickname is not reserved")
+	ErrNicknameQuarantined   = errors.New("nickname was recently released and is on hold")
+	ErrNicknameCooldown      = errors.New("nickname was changed too recently")
+	ErrUserNotVerified       = errors.New("user account is not verified")
 	ErrUserNotFound          = repository.ErrUserNotFound
+	ErrInvalidSort           = repository.ErrInvalidSort
+	ErrInvalidFilter         = repository.ErrInvalidFilter
 )
 
-const (
-	notificationTimeout = 5 * time.Second
-)
+// defaultMaxBatchSize bounds batch requests when no limit is configured
+const defaultMaxBatchSize = 100
 
 type UserServiceInterface interface {
 	CreateUser(ctx context.Context, firstName, lastName, nickname, password, email, country string) (*models.User, error)
 	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	GetUsersByIDs(ctx context.Context, ids []string) (*BatchGetResult, error)
 	UpdateUser(ctx context.Context, id, firstName, lastName, nickname, email, country string) (*models.User, error)
 	UpdatePassword(ctx context.Context, id, password string) error
 	DeleteUser(ctx context.Context, id string) error
-	ListUsers(ctx context.Context, country, email, nickname, firstname, lastname string, page, pageSize int) ([]*models.User, int, error)
+	ListUsers(ctx context.Context, filter FilterOptions, opts ListOptions) (*ListResult, error)
+	SearchUsers(ctx context.Context, query string, opts ListOptions) (*SearchResult, error)
+	ExportUsers(ctx context.Context, filter FilterOptions, fn func(*models.User) error) error
+	GrantReservedNickname(ctx context.Context, nickname, userID, grantedBy string) (*models.NicknameGrant, error)
+	VerifyUser(ctx context.Context, id string) (*models.User, error)
+	GetUserByNickname(ctx context.Context, nickname string) (*NicknameLookup, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	LookupUsers(ctx context.Context, identifiers []string) (*LookupResult, error)
+	ImportUsers(ctx context.Context, rows []ImportRow, dryRun bool) (*models.ImportReport, error)
+	StartImport(ctx context.Context, rows []ImportRow, dryRun bool) (*models.ImportJob, error)
+	GetImportJob(ctx context.Context, id string) (*models.ImportJob, error)
+	ApplyBatch(ctx context.Context, ops []BatchOperation, atomic bool) ([]BatchOperationResult, error)
+}
+
+// NicknameLookup is the result of resolving a nickname. Redirected is set
+// when the nickname is a previous nickname of the returned user.
+type NicknameLookup struct {
+	User       *models.User
+	Redirected bool
+}
+
+// FilterOptions, StringFilter and TimeRange select the users returned by
+// ListUsers; see the repository for their semantics
+type (
+	FilterOptions = repository.FilterOptions
+	StringFilter  = repository.StringFilter
+	TimeRange     = repository.TimeRange
+)
+
+// ParseStringFilter parses an "op:value" filter such as "prefix:jo"
+var ParseStringFilter = repository.ParseStringFilter
+
+// ListOptions controls the pagination of ListUsers. A Cursor from a
+// previous ListResult takes precedence over Page and carries its sort, so
+// Sort may be left empty when paging by cursor.
+type ListOptions struct {
+	Page      int
+	PageSize  int
+	Cursor    string
+	SkipTotal bool
+	// Sort is a comma-separated list of fields, "-" prefixed for descending
+	Sort string
+}
+
+// ListResult is a page of users. Total is nil when it was not requested and
+// NextCursor is empty on the last page.
+type ListResult struct {
+	Users      []*models.User
+	Total      *int
+	NextCursor string
 }
 
 type UserService struct {
-	repo         repository.UserRepository
-	notification notification.NotificationService
-	logger       *zap.Logger
+	repo           repository.UserRepository
+	logger         *zap.Logger
+	config         config.UsersConfig
+	nicknamePolicy *models.NicknamePolicy
+	cursors        cursorCodec
+	imports        *importJobs
 }
 
-func NewUserService(repo repository.UserRepository, notification notification.NotificationService, logger *zap.Logger) *UserService {
-	return &UserService{
-		repo:         repo,
-		notification: notification,
-		logger:       logger.With(zap.String("component", "user_service")),
+// NewUserService creates a new UserService. A nil cfg uses the zero
+// configuration and the default nickname policy. User events are written to
+// the outbox by the repository and published by the outbox relay.
+func NewUserService(repo repository.UserRepository, logger *zap.Logger, cfg *config.UsersConfig) *UserService {
+	s := &UserService{
+		repo:           repo,
+		logger:         logger.With(zap.String("component", "user_service")),
+		nicknamePolicy: models.DefaultNicknamePolicy(),
+		imports:        newImportJobs(),
+	}
+	if cfg != nil {
+		s.config = *cfg
+		s.nicknamePolicy = newNicknamePolicy(cfg.Nickname)
+	}
+
+	var configured bool
+	s.cursors, configured = newCursorCodec(s.config.CursorSecret)
+	if !configured {
+		s.logger.Warn("no cursor secret configured, using a random one; list cursors will not survive a restart or work across instances")
 	}
+	return s
 }
 
+func newNicknamePolicy(cfg config.NicknamePolicyConfig) *models.NicknamePolicy {
+	policy := models.DefaultNicknamePolicy()
+	if cfg.MinLength > 0 {
+		policy.MinLength = cfg.MinLength
+	}
+	if cfg.MaxLength > 0 && cfg.MaxLength < models.DefaultNicknameMaxLength {
+		policy.MaxLength = cfg.MaxLength
+	}
+	policy.Reserved = models.NewWordList(cfg.Reserved, cfg.ReservedSubstring)
+	policy.Profanity = models.NewWordList(cfg.Profanity, cfg.ProfanitySubstring)
+	return policy
+}
+
+// CreateUser registers a user. The nickname checks and the insert run in one
+// transaction.
 func (s *UserService) CreateUser(ctx context.Context, firstName, lastName, nickname, password, email, country string) (*models.User, error) {
+	var user *models.User
+	err := s.inTransaction(ctx, func(tx *UserService) error {
+		var err error
+		user, err = tx.createUser(ctx, firstName, lastName, nickname, password, email, country)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *UserService) createUser(ctx context.Context, firstName, lastName, nickname, password, email, country string) (*models.User, error) {
+	if err := s.checkNicknamePolicy(ctx, "", models.CanonicalNickname(nickname)); err != nil {
+		return nil, err
+	}
+
 	user, err := models.NewUser(firstName, lastName, nickname, password, email, country)
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating user")
 	}
 
-	_, err = s.repo.GetByEmail(ctx, email)
-	if err == nil {
-		return nil, ErrEmailAlreadyExists
-	} else if !errors.Is(err, repository.ErrUserNotFound) {
-		return nil, errors.Wrap(err, "error checking existing email")
+	if err := s.checkConfusableNickname(ctx, "", user.NicknameSkeleton); err != nil {
+		return nil, err
 	}
 
-	_, err = s.repo.GetByNickname(ctx, nickname)
-	if err == nil {
-		return nil, ErrNicknameAlreadyExists
-	} else if !errors.Is(err, repository.ErrUserNotFound) {
-		return nil, errors.Wrap(err, "error checking existing nickname")
+	if err := s.lockNicknames(ctx, user.Nickname); err != nil {
+		return nil, err
+	}
+	if err := s.checkNicknameQuarantine(ctx, "", user.Nickname); err != nil {
+		return nil, err
 	}
 
+	// uniqueness of email and nickname is enforced by the database
+	s.storeSkeleton(user)
 	if err := s.repo.Create(ctx, user); err != nil {
+		if isUniqueViolation(err) {
+			return nil, err
+		}
 		return nil, errors.Wrap(err, "error persisting user")
 	}
 
-	s.sendNotification(ctx, func(ctx context.Context) error {
-		if err := s.notification.NotifyUserCreated(ctx, user); err != nil {
-			fmt.Println("Error notifying user create:", err)
-		}
-		return nil
-	})
-
 	user.SanitizeForOutput()
 	return user, nil
 }
@@ -100,21 +204,96 @@ func (s *UserService) GetUserByID(ctx context.Context, id string) (*models.User,
 	return user, nil
 }
 
+// BatchGetResult holds the users found for a batch of IDs, in request
+// order, and the IDs that matched no user
+type BatchGetResult struct {
+	Users   []*models.User
+	Missing []string
+}
+
+// GetUsersByIDs retrieves a batch of users with a single query. IDs are
+// compared in canonical UUID form, so duplicates in any notation are
+// returned once; malformed IDs are reported as missing.
+func (s *UserService) GetUsersByIDs(ctx context.Context, ids []string) (*BatchGetResult, error) {
+	if len(ids) == 0 {
+		return nil, errors.Wrap(ErrInvalidInput, "no ids given")
+	}
+	if max := s.maxBatchSize(); len(ids) > max {
+		return nil, errors.Wrapf(ErrInvalidInput, "at most %d ids are allowed", max)
+	}
+
+	requested := make([]string, 0, len(ids))
+	valid := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		key := canonicalID(id)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		requested = append(requested, id)
+		if _, err := uuid.Parse(id); err == nil {
+			valid = append(valid, key)
+		}
+	}
+
+	byID := make(map[string]*models.User, len(valid))
+	if len(valid) > 0 {
+		users, err := s.repo.GetByIDs(ctx, valid)
+		if err != nil {
+			return nil, errors.Wrap(err, "error fetching users")
+		}
+		for _, user := range users {
+			user.SanitizeForOutput()
+			byID[canonicalID(user.ID)] = user
+		}
+	}
+
+	result := &BatchGetResult{Users: []*models.User{}, Missing: []string{}}
+	for _, id := range requested {
+		if user, ok := byID[canonicalID(id)]; ok {
+			result.Users = append(result.Users, user)
+		} else {
+			result.Missing = append(result.Missing, id)
+		}
+	}
+
+	return result, nil
+}
+
+func (s *UserService) maxBatchSize() int {
+	if s.config.MaxBatchSize > 0 {
+		return s.config.MaxBatchSize
+	}
+	return defaultMaxBatchSize
+}
+
+// UpdateUser changes the fields of a user that are given. The nickname checks
+// and the update run in one transaction.
 func (s *UserService) UpdateUser(ctx context.Context, id, firstName, lastName, nickname, email, country string) (*models.User, error) {
 	if id == "" {
 		return nil, ErrInvalidInput
 	}
 
-	user, err := s.repo.GetByID(ctx, id)
+	var user *models.User
+	err := s.inTransaction(ctx, func(tx *UserService) error {
+		var err error
+		user, err = tx.updateUser(ctx, id, firstName, lastName, nickname, email, country)
+		return err
+	})
 	if err != nil {
-		return nil, errors.Wrap(err, "error fetching user for update")
+		return nil, err
 	}
+	return user, nil
+}
 
-	if err := s.validateAndCheckEmail(ctx, user, email); err != nil {
-		return nil, errors.Wrap(err, "error validating email")
+func (s *UserService) updateUser(ctx context.Context, id, firstName, lastName, nickname, email, country string) (*models.User, error) {
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching user for update")
 	}
 
-	if err := s.validateAndCheckNickname(ctx, user, nickname); err != nil {
+	if err := s.validateNickname(ctx, user, nickname); err != nil {
 		return nil, errors.Wrap(err, "error validating nickname")
 	}
 
@@ -122,45 +301,378 @@ func (s *UserService) UpdateUser(ctx context.Context, id, firstName, lastName, n
 		return nil, errors.Wrap(err, "error updating user fields")
 	}
 
+	s.storeSkeleton(user)
 	if err := s.repo.Update(ctx, user); err != nil {
+		if isUniqueViolation(err) {
+			return nil, err
+		}
 		return nil, errors.Wrap(err, "error updating user")
 	}
 
-	s.sendNotification(ctx, func(ctx context.Context) error {
-		if err := s.notification.NotifyUserUpdated(ctx, user); err != nil {
-			fmt.Println("Error notifying user update:", err)
+	user.SanitizeForOutput()
+	return user, nil
+}
+
+func (s *UserService) validateNickname(ctx context.Context, user *models.User, nickname string) error {
+	nickname = models.CanonicalNickname(nickname)
+	if nickname != "" && nickname != user.Nickname {
+		if err := s.checkNicknamePolicy(ctx, user.ID, nickname); err != nil {
+			return err
 		}
-		return nil
+
+		if err := s.checkConfusableNickname(ctx, user.ID, models.NicknameSkeleton(nickname)); err != nil {
+			return err
+		}
+
+		// a case-only change keeps the same name and is not a rename
+		if !strings.EqualFold(nickname, user.Nickname) {
+			if err := s.lockNicknames(ctx, user.Nickname, nickname); err != nil {
+				return err
+			}
+			if err := s.checkNicknameCooldown(ctx, user.ID); err != nil {
+				return err
+			}
+			if err := s.checkNicknameQuarantine(ctx, user.ID, nickname); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// inTransaction runs fn with a service bound to a single transaction of the
+// repository
+func (s *UserService) inTransaction(ctx context.Context, fn func(tx *UserService) error) error {
+	return s.repo.WithinTransaction(ctx, func(repo repository.UserRepository) error {
+		tx := *s
+		tx.repo = repo
+		return fn(&tx)
 	})
+}
+
+// lockNicknames holds off other renames, sign-ups and deletions taking or
+// releasing the nicknames until the transaction ends, so the history checks
+// that follow are not raced. Renames lock the old nickname too, which also
+// serializes concurrent renames of the same user.
+func (s *UserService) lockNicknames(ctx context.Context, nicknames ...string) error {
+	if s.config.Nickname.Quarantine <= 0 && s.config.Nickname.ChangeCooldown <= 0 {
+		return nil
+	}
+	if err := s.repo.LockNicknames(ctx, nicknames...); err != nil {
+		return errors.Wrap(err, "error locking nicknames")
+	}
+	return nil
+}
+
+// checkNicknameCooldown rejects a rename within the configured cooldown of
+// the user's previous rename
+func (s *UserService) checkNicknameCooldown(ctx context.Context, userID string) error {
+	cooldown := s.config.Nickname.ChangeCooldown
+	if cooldown <= 0 {
+		return nil
+	}
+
+	lastChange, err := s.repo.LastNicknameChange(ctx, userID)
+	if err != nil {
+		return errors.Wrap(err, "error checking nickname cooldown")
+	}
+	if !lastChange.IsZero() && time.Since(lastChange) < cooldown {
+		return ErrNicknameCooldown
+	}
+	return nil
+}
+
+// checkNicknameQuarantine rejects a nickname another user released within
+// the quarantine period. The previous owner may take it back.
+func (s *UserService) checkNicknameQuarantine(ctx context.Context, userID, nickname string) error {
+	quarantine := s.config.Nickname.Quarantine
+	if quarantine <= 0 {
+		return nil
+	}
+
+	held, err := s.repo.IsNicknameHeld(ctx, nickname, userID, time.Now().UTC().Add(-quarantine))
+	if err != nil {
+		return errors.Wrap(err, "error checking nickname quarantine")
+	}
+	if held {
+		return ErrNicknameQuarantined
+	}
+	return nil
+}
+
+// GetUserByEmail retrieves a user by email, matched case-insensitively
+func (s *UserService) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	email = models.CanonicalEmail(email)
+	if email == "" {
+		return nil, ErrInvalidInput
+	}
+
+	user, err := s.repo.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "error fetching user by email")
+	}
 
 	user.SanitizeForOutput()
 	return user, nil
 }
 
-func (s *UserService) validateAndCheckEmail(ctx context.Context, user *models.User, email string) error {
-	if email != "" && email != user.Email {
-		if err := user.ValidateEmail(email); err != nil {
-			return err
+// LookupResult maps each resolved identifier to its user and lists the
+// identifiers that matched no user
+type LookupResult struct {
+	Users    map[string]*models.User
+	NotFound []string
+}
+
+// LookupUsers resolves a batch of mixed identifiers in one query. An
+// identifier is treated as an id when it is a UUID, as an email when it
+// contains "@" and as a nickname otherwise. A UUID that matches no id is
+// still tried as a nickname.
+func (s *UserService) LookupUsers(ctx context.Context, identifiers []string) (*LookupResult, error) {
+	if len(identifiers) == 0 {
+		return nil, errors.Wrap(ErrInvalidInput, "no identifiers given")
+	}
+	if max := s.maxBatchSize(); len(identifiers) > max {
+		return nil, errors.Wrapf(ErrInvalidInput, "at most %d identifiers are allowed", max)
+	}
+
+	unique := make([]string, 0, len(identifiers))
+	seen := make(map[string]bool, len(identifiers))
+	for _, identifier := range identifiers {
+		if !seen[identifier] {
+			seen[identifier] = true
+			unique = append(unique, identifier)
+		}
+	}
+
+	var ids, emails, nicknames []string
+	for _, identifier := range unique {
+		switch identifierKind(identifier) {
+		case identifierID:
+			ids = append(ids, canonicalID(identifier))
+			nicknames = append(nicknames, models.CanonicalNickname(identifier))
+		case identifierEmail:
+			emails = append(emails, models.CanonicalEmail(identifier))
+		default:
+			nicknames = append(nicknames, models.CanonicalNickname(identifier))
 		}
+	}
+
+	users, err := s.repo.Lookup(ctx, ids, emails, nicknames)
+	if err != nil {
+		return nil, errors.Wrap(err, "error looking up users")
+	}
 
-		_, err := s.repo.GetByEmail(ctx, email)
-		if err == nil {
-			return ErrEmailAlreadyExists
-		} else if !errors.Is(err, repository.ErrUserNotFound) {
-			return errors.Wrap(err, "error checking existing email")
+	result := &LookupResult{Users: map[string]*models.User{}, NotFound: []string{}}
+	for _, identifier := range unique {
+		if user := matchIdentifier(users, identifier); user != nil {
+			user.SanitizeForOutput()
+			result.Users[identifier] = user
+		} else {
+			result.NotFound = append(result.NotFound, identifier)
+		}
+	}
+
+	return result, nil
+}
+
+type identifierType int
+
+const (
+	identifierNickname identifierType = iota
+	identifierID
+	identifierEmail
+)
+
+func identifierKind(identifier string) identifierType {
+	if _, err := uuid.Parse(identifier); err == nil {
+		return identifierID
+	}
+	if strings.Contains(identifier, "@") {
+		return identifierEmail
+	}
+	return identifierNickname
+}
+
+// canonicalID returns the lower-case hyphenated form of a UUID, or id as is
+// when it does not parse
+func canonicalID(id string) string {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return id
+	}
+	return parsed.String()
+}
+
+func matchIdentifier(users []*models.User, identifier string) *models.User {
+	kind := identifierKind(identifier)
+	if kind == identifierID {
+		for _, user := range users {
+			if canonicalID(user.ID) == canonicalID(identifier) {
+				return user
+			}
+		}
+		kind = identifierNickname
+	}
+	for _, user := range users {
+		switch kind {
+		case identifierEmail:
+			if strings.EqualFold(user.Email, models.CanonicalEmail(identifier)) {
+				return user
+			}
+		default:
+			if strings.EqualFold(user.Nickname, models.CanonicalNickname(identifier)) {
+				return user
+			}
 		}
 	}
 	return nil
 }
 
-func (s *UserService) validateAndCheckNickname(ctx context.Context, user *models.User, nickname string) error {
-	if nickname != "" && nickname != user.Nickname {
-		_, err := s.repo.GetByNickname(ctx, nickname)
-		if err == nil {
-			return ErrNicknameAlreadyExists
-		} else if !errors.Is(err, repository.ErrUserNotFound) {
-			return errors.Wrap(err, "error checking existing nickname")
+// GetUserByNickname resolves a nickname to its current owner, falling back
+// to the user who most recently released it
+func (s *UserService) GetUserByNickname(ctx context.Context, nickname string) (*NicknameLookup, error) {
+	nickname = models.CanonicalNickname(nickname)
+	if nickname == "" {
+		return nil, ErrInvalidInput
+	}
+
+	user, err := s.repo.GetByNickname(ctx, nickname)
+	redirected := false
+	if errors.Is(err, repository.ErrUserNotFound) {
+		user, err = s.repo.GetByPreviousNickname(ctx, nickname)
+		redirected = true
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "error fetching user by nickname")
+	}
+
+	user.SanitizeForOutput()
+	return &NicknameLookup{User: user, Redirected: redirected}, nil
+}
+
+// checkNicknamePolicy validates a canonical nickname against the nickname
+// policy. A reserved nickname is accepted when it was granted to userID.
+func (s *UserService) checkNicknamePolicy(ctx context.Context, userID, nickname string) error {
+	err := s.nicknamePolicy.Check(nickname)
+	if errors.Is(err, models.ErrNicknameReserved) && userID != "" {
+		granted, grantErr := s.repo.HasNicknameGrant(ctx, nickname, userID)
+		if grantErr != nil {
+			return errors.Wrap(grantErr, "error checking nickname grant")
+		}
+		if granted {
+			return nil
+		}
+	}
+	return err
+}
+
+// GrantReservedNickname lets a specific verified account use a reserved
+// nickname. Deleted accounts are removed, so any account found is active.
+func (s *UserService) GrantReservedNickname(ctx context.Context, nickname, userID, grantedBy string) (*models.NicknameGrant, error) {
+	nickname = models.CanonicalNickname(nickname)
+	if nickname == "" || userID == "" {
+		return nil, ErrInvalidInput
+	}
+
+	// only reserved nicknames can be granted; any other policy violation still applies
+	if err := s.nicknamePolicy.Check(nickname); !errors.Is(err, models.ErrNicknameReserved) {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNicknameNotReserved
+	}
+
+	user, err := s.repo.GetByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "error fetching user for nickname grant")
+	}
+	if user.VerifiedAt == nil {
+		return nil, ErrUserNotVerified
+	}
+
+	grant := &models.NicknameGrant{
+		Nickname:  nickname,
+		UserID:    userID,
+		GrantedBy: grantedBy,
+	}
+	if err := s.repo.CreateNicknameGrant(ctx, grant); err != nil {
+		if isUniqueViolation(err) {
+			return nil, err
 		}
+		return nil, errors.Wrap(err, "error persisting nickname grant")
+	}
+
+	s.logger.Info("reserved nickname granted",
+		zap.String("nickname", nickname),
+		zap.String("user_id", userID),
+		zap.String("granted_by", grantedBy))
+
+	return grant, nil
+}
+
+// VerifyUser marks the account of a user as verified, which makes it
+// eligible for reserved nickname grants
+func (s *UserService) VerifyUser(ctx context.Context, id string) (*models.User, error) {
+	if id == "" {
+		return nil, ErrInvalidInput
+	}
+
+	if err := s.repo.MarkVerified(ctx, id, time.Now().UTC()); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "error verifying user")
+	}
+
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, errors.Wrap(err, "error fetching verified user")
+	}
+
+	s.logger.Info("user verified", zap.String("user_id", id))
+
+	user.SanitizeForOutput()
+	return user, nil
+}
+
+// isUniqueViolation reports whether err is a uniqueness conflict raised by
+// the repository
+func isUniqueViolation(err error) bool {
+	return errors.Is(err, ErrEmailAlreadyExists) ||
+		errors.Is(err, ErrNicknameAlreadyExists) ||
+		errors.Is(err, ErrNicknameConfusable) ||
+		errors.Is(err, ErrUserAlreadyExists)
+}
+
+// storeSkeleton clears the skeleton of user when the confusable check is
+// disabled; the database rejects nicknames sharing a stored skeleton
+func (s *UserService) storeSkeleton(user *models.User) {
+	if !s.config.ConfusableCheck {
+		user.NicknameSkeleton = ""
+	}
+}
+
+// checkConfusableNickname rejects a nickname whose skeleton matches the
+// nickname of another user, when the confusable check is enabled
+func (s *UserService) checkConfusableNickname(ctx context.Context, userID, skeleton string) error {
+	if !s.config.ConfusableCheck || skeleton == "" {
+		return nil
+	}
+
+	existing, err := s.repo.GetByNicknameSkeleton(ctx, skeleton)
+	if err == nil && existing.ID != userID {
+		return ErrNicknameConfusable
+	} else if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		return errors.Wrap(err, "error checking confusable nickname")
 	}
 	return nil
 }
@@ -200,13 +712,6 @@ func (s *UserService) DeleteUser(ctx context.Context, id string) error {
 		return errors.Wrap(err, "error removing user")
 	}
 
-	s.sendNotification(ctx, func(ctx context.Context) error {
-		if err := s.notification.NotifyUserDeleted(ctx, id); err != nil {
-			fmt.Println("Error notifying user create:", err)
-		}
-		return nil
-	})
-
 	s.logger.Info("user removed successfully",
 		zap.String("id", id),
 		zap.String("email", user.Email))
@@ -214,23 +719,49 @@ func (s *UserService) DeleteUser(ctx context.Context, id string) error {
 	return nil
 }
 
-func (s *UserService) ListUsers(ctx context.Context, country, email, nickname, firstname, lastname string, page, pageSize int) ([]*models.User, int, error) {
-	filter := repository.FilterOptions{
-		FirstName: firstname,
-		LastName:  lastname,
-		Country:   country,
-		Email:     email,
-		Nickname:  nickname,
+func (s *UserService) ListUsers(ctx context.Context, filter FilterOptions, opts ListOptions) (*ListResult, error) {
+	// countries are stored as ISO alpha-2 codes, so match on the code
+	if !filter.Country.IsZero() {
+		country, err := normalizeCountryFilter(filter.Country)
+		if err != nil {
+			return nil, err
+		}
+		filter.Country = country
 	}
 
 	pagination := repository.PaginationOptions{
-		Page:     page,
-		PageSize: pageSize,
+		Page:      opts.Page,
+		PageSize:  opts.PageSize,
+		SkipTotal: opts.SkipTotal,
+	}
+
+	sortSpec := opts.Sort
+	var cursorSort string
+	if opts.Cursor != "" {
+		var err error
+		cursorSort, pagination.After, err = s.cursors.Decode(opts.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if sortSpec == "" {
+			sortSpec = cursorSort
+		}
+	}
+
+	sort, err := repository.ParseSort(sortSpec)
+	if err != nil {
+		return nil, err
+	}
+	sortSpec = repository.FormatSort(sort)
+	pagination.Sort = sort
+
+	if pagination.After != nil && (cursorSort != sortSpec || len(pagination.After.Values) != len(sort)) {
+		return nil, errors.Wrap(ErrInvalidCursor, "cursor was issued for a different sort")
 	}
 
 	users, total, err := s.repo.List(ctx, filter, pagination)
 	if err != nil {
-		return nil, 0, errors.Wrap(err, "error listing users")
+		return nil, errors.Wrap(err, "error listing users")
 	}
 
 	// ensure no passwords are returned
@@ -238,20 +769,52 @@ func (s *UserService) ListUsers(ctx context.Context, country, email, nickname, f
 		user.SanitizeForOutput()
 	}
 
-	return users, total, nil
+	result := &ListResult{Users: users}
+	if !opts.SkipTotal {
+		result.Total = &total
+	}
+
+	// a full page may be followed by more rows
+	if opts.PageSize > 0 && len(users) == opts.PageSize {
+		last := users[len(users)-1]
+		result.NextCursor = s.cursors.Encode(sortSpec, repository.KeysetFor(last, sort))
+	}
+
+	return result, nil
 }
 
-func (s *UserService) sendNotification(ctx context.Context, fn func(context.Context) error) {
-	if s.notification == nil {
-		return
+// ExportUsers streams every user matching filter to fn, oldest first,
+// without password hashes
+func (s *UserService) ExportUsers(ctx context.Context, filter FilterOptions, fn func(*models.User) error) error {
+	if !filter.Country.IsZero() {
+		country, err := normalizeCountryFilter(filter.Country)
+		if err != nil {
+			return err
+		}
+		filter.Country = country
 	}
 
-	go func() {
-		notifyCtx, cancel := context.WithTimeout(ctx, notificationTimeout)
-		defer cancel()
+	return s.repo.Export(ctx, filter, func(user *models.User) error {
+		user.SanitizeForOutput()
+		return fn(user)
+	})
+}
 
-		if err := fn(notifyCtx); err != nil {
-			s.logger.Error("notification failed", zap.Error(err))
+// normalizeCountryFilter resolves the values of a country filter to ISO
+// alpha-2 codes. Codes are not searched by substring, so only equality and
+// in are accepted.
+func normalizeCountryFilter(filter StringFilter) (StringFilter, error) {
+	if filter.Op != repository.OpEq && filter.Op != repository.OpIn {
+		return filter, errors.Wrapf(ErrInvalidFilter, "country supports only eq and in, not %s", filter.Op)
+	}
+
+	codes := make([]string, len(filter.Values))
+	for i, value := range filter.Values {
+		code, err := models.NormalizeCountry(value)
+		if err != nil {
+			return filter, errors.Wrap(ErrInvalidInput, err.Error())
 		}
-	}()
+		codes[i] = code
+	}
+	return StringFilter{Op: filter.Op, Values: codes}, nil
 }
@@ -2,9 +2,11 @@ package service_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 
+	"user-microservice/internal/config"
 	"user-microservice/internal/models"
 	"user-microservice/internal/repository"
 	"user-microservice/internal/service"
@@ -13,6 +15,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
 	"golang.org/x/crypto/bcrypt"
@@ -28,6 +31,17 @@ func (m *MockUserRepository) Create(ctx context.Context, user *models.User) erro
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) CreateBatch(ctx context.Context, users []*models.User) ([]string, error) {
+	args := m.Called(ctx, users)
+	switch ids := args.Get(0).(type) {
+	case []string:
+		return ids, args.Error(1)
+	case func([]*models.User) []string:
+		return ids(users), args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func (m *MockUserRepository) GetByID(ctx context.Context, id string) (*models.User, error) {
 	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
@@ -36,6 +50,14 @@ func (m *MockUserRepository) GetByID(ctx context.Context, id string) (*models.Us
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockUserRepository) GetByIDs(ctx context.Context, ids []string) ([]*models.User, error) {
+	args := m.Called(ctx, ids)
+	if users, ok := args.Get(0).([]*models.User); ok {
+		return users, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
 func (m *MockUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	args := m.Called(ctx, email)
 	if args.Get(0) == nil {
@@ -52,6 +74,22 @@ func (m *MockUserRepository) GetByNickname(ctx context.Context, nickname string)
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
+func (m *MockUserRepository) GetByNicknameSkeleton(ctx context.Context, skeleton string) (*models.User, error) {
+	args := m.Called(ctx, skeleton)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
+
+func (m *MockUserRepository) GetByNicknameSkeletons(ctx context.Context, skeletons []string) ([]*models.User, error) {
+	args := m.Called(ctx, skeletons)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*models.User), args.Error(1)
+}
+
 func (m *MockUserRepository) Update(ctx context.Context, user *models.User) error {
 	args := m.Called(ctx, user)
 	return args.Error(0)
@@ -62,6 +100,11 @@ func (m *MockUserRepository) UpdatePassword(ctx context.Context, id, password st
 	return args.Error(0)
 }
 
+func (m *MockUserRepository) MarkVerified(ctx context.Context, id string, at time.Time) error {
+	args := m.Called(ctx, id, at)
+	return args.Error(0)
+}
+
 func (m *MockUserRepository) Delete(ctx context.Context, id string) error {
 	args := m.Called(ctx, id)
 	return args.Error(0)
@@ -78,37 +121,126 @@ func (m *MockUserRepository) List(ctx context.Context, filter repository.FilterO
 	return nil, 0, args.Error(2)
 }
 
-// MockNotificationService is a mock of the notification service for testing
-type MockNotificationService struct {
-	mock.Mock
+func (m *MockUserRepository) CreateNicknameGrant(ctx context.Context, grant *models.NicknameGrant) error {
+	args := m.Called(ctx, grant)
+	return args.Error(0)
 }
 
-func (m *MockNotificationService) NotifyUserCreated(ctx context.Context, user *models.User) error {
-	args := m.Called(ctx, user)
+func (m *MockUserRepository) HasNicknameGrant(ctx context.Context, nickname, userID string) (bool, error) {
+	args := m.Called(ctx, nickname, userID)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) GetByPreviousNickname(ctx context.Context, nickname string) (*models.User, error) {
+	args := m.Called(ctx, nickname)
+	if user, ok := args.Get(0).(*models.User); ok {
+		return user, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserRepository) Search(ctx context.Context, term string, pagination repository.PaginationOptions) ([]*repository.ScoredUser, int, error) {
+	args := m.Called(ctx, term, pagination)
+	if users, ok := args.Get(0).([]*repository.ScoredUser); ok {
+		return users, args.Int(1), args.Error(2)
+	}
+	return nil, args.Int(1), args.Error(2)
+}
+
+// Export passes the users given to Return to fn before returning the error
+func (m *MockUserRepository) Export(ctx context.Context, filter repository.FilterOptions, fn func(*models.User) error) error {
+	args := m.Called(ctx, filter, fn)
+	if users, ok := args.Get(0).([]*models.User); ok {
+		for _, user := range users {
+			if err := fn(user); err != nil {
+				return err
+			}
+		}
+	}
+	return args.Error(1)
+}
+
+func (m *MockUserRepository) Lookup(ctx context.Context, ids, emails, nicknames []string) ([]*models.User, error) {
+	args := m.Called(ctx, ids, emails, nicknames)
+	if users, ok := args.Get(0).([]*models.User); ok {
+		return users, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserRepository) CreateImportJob(ctx context.Context, job *models.ImportJob) error {
+	args := m.Called(ctx, job)
 	return args.Error(0)
 }
 
-func (m *MockNotificationService) NotifyUserUpdated(ctx context.Context, user *models.User) error {
-	args := m.Called(ctx, user)
+func (m *MockUserRepository) UpdateImportJob(ctx context.Context, job *models.ImportJob) error {
+	args := m.Called(ctx, job)
 	return args.Error(0)
 }
 
-func (m *MockNotificationService) NotifyUserDeleted(ctx context.Context, userID string) error {
-	args := m.Called(ctx, userID)
+func (m *MockUserRepository) GetImportJob(ctx context.Context, id string) (*models.ImportJob, error) {
+	args := m.Called(ctx, id)
+	if job, ok := args.Get(0).(*models.ImportJob); ok {
+		return job, args.Error(1)
+	}
+	return nil, args.Error(1)
+}
+
+func (m *MockUserRepository) LockNicknames(ctx context.Context, nicknames ...string) error {
+	args := m.Called(ctx, nicknames)
 	return args.Error(0)
 }
 
-func setupTest(t *testing.T) (*zap.Logger, *MockUserRepository, *MockNotificationService) {
+// WithinTransaction runs fn on the mock bound to a transaction; a returned
+// error simulates a failed commit
+func (m *MockUserRepository) WithinTransaction(ctx context.Context, fn func(repo repository.UserRepository) error) error {
+	args := m.Called(ctx)
+	if err := fn(boundMockRepository{m}); err != nil {
+		return err
+	}
+	return args.Error(0)
+}
+
+// boundMockRepository is a MockUserRepository bound to a transaction, which
+// nested transactions join like they do on PostgresUserRepository
+type boundMockRepository struct {
+	*MockUserRepository
+}
+
+func (b boundMockRepository) WithinTransaction(ctx context.Context, fn func(repo repository.UserRepository) error) error {
+	return fn(b)
+}
+
+func (m *MockUserRepository) LastNicknameChange(ctx context.Context, userID string) (time.Time, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockUserRepository) IsNicknameHeld(ctx context.Context, nickname, excludeUserID string, since time.Time) (bool, error) {
+	args := m.Called(ctx, nickname, excludeUserID, since)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) HeldNicknames(ctx context.Context, nicknames []string, since time.Time) ([]string, error) {
+	args := m.Called(ctx, nicknames, since)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func setupTest(t *testing.T) (*zap.Logger, *MockUserRepository) {
 	logger := zaptest.NewLogger(t)
 	mockRepo := new(MockUserRepository)
-	mockNotification := new(MockNotificationService)
-	return logger, mockRepo, mockNotification
+	// creates and updates run in a transaction
+	mockRepo.On("WithinTransaction", mock.Anything).Return(nil).Maybe()
+	return logger, mockRepo
 }
 
 func TestUserService_CreateUser(t *testing.T) {
-	logger, mockRepo, mockNotification := setupTest(t)
+	logger, mockRepo := setupTest(t)
 
-	userService := service.NewUserService(mockRepo, mockNotification, logger)
+	userService := service.NewUserService(mockRepo, logger, nil)
 
 	firstName := "John"
 	lastName := "Travolta"
@@ -116,21 +248,17 @@ func TestUserService_CreateUser(t *testing.T) {
 	password := "password123"
 	email := "john@gggmail.com"
 	country := "us"
+	countryCode := "US"
 
 	t.Run("successful creation", func(t *testing.T) {
-		mockRepo.On("GetByEmail", mock.Anything, email).Return(nil, repository.ErrUserNotFound).Once()
-		mockRepo.On("GetByNickname", mock.Anything, nickname).Return(nil, repository.ErrUserNotFound).Once()
-
 		mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
 			return u.FirstName == firstName &&
 				u.LastName == lastName &&
 				u.Nickname == nickname &&
 				u.Email == email &&
-				u.Country == country
+				u.Country == countryCode
 		})).Return(nil).Once()
 
-		mockNotification.On("NotifyUserCreated", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil)
-
 		user, err := userService.CreateUser(context.Background(), firstName, lastName, nickname, password, email, country)
 
 		// Check results
@@ -140,28 +268,15 @@ func TestUserService_CreateUser(t *testing.T) {
 		assert.Equal(t, lastName, user.LastName)
 		assert.Equal(t, nickname, user.Nickname)
 		assert.Equal(t, email, user.Email)
-		assert.Equal(t, country, user.Country)
+		assert.Equal(t, countryCode, user.Country)
 		assert.Empty(t, user.Password) // Password should not be returned
 
 		mockRepo.AssertExpectations(t)
-
-		// we don't verify mockNotification because it's called in a goroutine
 	})
 
 	// Test case: email already exists
 	t.Run("email already exists", func(t *testing.T) {
-		existingUser := &models.User{
-			ID:        uuid.New().String(),
-			FirstName: "Existing",
-			LastName:  "User",
-			Nickname:  "EU456",
-			Email:     email,
-			Country:   "US",
-			CreatedAt: time.Now().UTC(),
-			UpdatedAt: time.Now().UTC(),
-		}
-
-		mockRepo.On("GetByEmail", mock.Anything, email).Return(existingUser, nil).Once()
+		mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*models.User")).Return(repository.ErrEmailAlreadyExists).Once()
 
 		user, err := userService.CreateUser(context.Background(), firstName, lastName, nickname, password, email, country)
 
@@ -175,19 +290,7 @@ func TestUserService_CreateUser(t *testing.T) {
 
 	// Test case: nickname already exists
 	t.Run("nickname already exists", func(t *testing.T) {
-		existingUser := &models.User{
-			ID:        uuid.New().String(),
-			FirstName: "Existing",
-			LastName:  "User",
-			Nickname:  nickname,
-			Email:     "different@example.com",
-			Country:   "US",
-			CreatedAt: time.Now().UTC(),
-			UpdatedAt: time.Now().UTC(),
-		}
-
-		mockRepo.On("GetByEmail", mock.Anything, email).Return(nil, repository.ErrUserNotFound).Once()
-		mockRepo.On("GetByNickname", mock.Anything, nickname).Return(existingUser, nil).Once()
+		mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*models.User")).Return(repository.ErrNicknameAlreadyExists).Once()
 
 		user, err := userService.CreateUser(context.Background(), firstName, lastName, nickname, password, email, country)
 
@@ -208,10 +311,661 @@ func TestUserService_CreateUser(t *testing.T) {
 	})
 }
 
+func TestUserService_CreateUser_Canonicalisation(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+
+	userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{ConfusableCheck: true})
+
+	t.Run("email is persisted in canonical form", func(t *testing.T) {
+		mockRepo.On("GetByNicknameSkeleton", mock.Anything, "alice").Return(nil, repository.ErrUserNotFound).Once()
+		mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
+			return u.Email == "Alice@example.com"
+		})).Return(nil).Once()
+
+		user, err := userService.CreateUser(context.Background(), "Alice", "Smith", "alice", "password123", " Alice@EXAMPLE.com ", "PT")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "Alice@example.com", user.Email)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("confusable nickname is rejected", func(t *testing.T) {
+		mockRepo.On("GetByNicknameSkeleton", mock.Anything, "paypal").Return(&models.User{ID: uuid.New().String(), Nickname: "paypal"}, nil).Once()
+
+		user, err := userService.CreateUser(context.Background(), "Pay", "Pal", "p\u0430ypal", "password123", "paypal@example.com", "US")
+
+		assert.Nil(t, user)
+		assert.Equal(t, service.ErrNicknameConfusable, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("confusable nickname created concurrently is rejected", func(t *testing.T) {
+		mockRepo.On("GetByNicknameSkeleton", mock.Anything, "paypal").Return(nil, repository.ErrUserNotFound).Once()
+		mockRepo.On("Create", mock.Anything, mock.Anything).Return(repository.ErrNicknameConfusable).Once()
+
+		user, err := userService.CreateUser(context.Background(), "Pay", "Pal", "paypal", "password123", "paypal@example.com", "US")
+
+		assert.Nil(t, user)
+		assert.Equal(t, service.ErrNicknameConfusable, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("skeleton is not stored when the check is disabled", func(t *testing.T) {
+		unchecked := service.NewUserService(mockRepo, logger, &config.UsersConfig{})
+		mockRepo.On("Create", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
+			return u.Nickname == "bob" && u.NicknameSkeleton == ""
+		})).Return(nil).Once()
+
+		_, err := unchecked.CreateUser(context.Background(), "Bob", "Smith", "bob", "password123", "bob@example.com", "US")
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestUserService_NicknamePolicy(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+
+	userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{
+		Nickname: config.NicknamePolicyConfig{Reserved: []string{"admin"}},
+	})
+
+	userID := uuid.New().String()
+
+	t.Run("reserved nickname is rejected on creation", func(t *testing.T) {
+		user, err := userService.CreateUser(context.Background(), "John", "Doe", "Admin", "password123", "john@example.com", "US")
+
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, service.ErrNicknameNotAllowed))
+	})
+
+	t.Run("granted reserved nickname is accepted on update", func(t *testing.T) {
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID, Nickname: "johndoe", Country: "US"}, nil).Once()
+		mockRepo.On("HasNicknameGrant", mock.Anything, "admin", userID).Return(true, nil).Once()
+		mockRepo.On("Update", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
+			return u.Nickname == "admin"
+		})).Return(nil).Once()
+
+		user, err := userService.UpdateUser(context.Background(), userID, "", "", "admin", "", "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "admin", user.Nickname)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("reserved nickname without grant is rejected on update", func(t *testing.T) {
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID, Nickname: "johndoe", Country: "US"}, nil).Once()
+		mockRepo.On("HasNicknameGrant", mock.Anything, "admin", userID).Return(false, nil).Once()
+
+		user, err := userService.UpdateUser(context.Background(), userID, "", "", "admin", "", "")
+
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, service.ErrNicknameNotAllowed))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("grant reserved nickname", func(t *testing.T) {
+		verifiedAt := time.Now().UTC()
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID, VerifiedAt: &verifiedAt}, nil).Once()
+		mockRepo.On("CreateNicknameGrant", mock.Anything, mock.MatchedBy(func(g *models.NicknameGrant) bool {
+			return g.Nickname == "admin" && g.UserID == userID && g.GrantedBy == "ops"
+		})).Return(nil).Once()
+
+		grant, err := userService.GrantReservedNickname(context.Background(), "admin", userID, "ops")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "admin", grant.Nickname)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("reserved nickname is not granted to unverified accounts", func(t *testing.T) {
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID}, nil).Once()
+
+		grant, err := userService.GrantReservedNickname(context.Background(), "admin", userID, "ops")
+
+		assert.Nil(t, grant)
+		assert.Equal(t, service.ErrUserNotVerified, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("verify user", func(t *testing.T) {
+		mockRepo.On("MarkVerified", mock.Anything, userID, mock.AnythingOfType("time.Time")).Return(nil).Once()
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID, Password: "hash"}, nil).Once()
+
+		user, err := userService.VerifyUser(context.Background(), userID)
+
+		assert.NoError(t, err)
+		assert.Empty(t, user.Password)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("only reserved nicknames can be granted", func(t *testing.T) {
+		grant, err := userService.GrantReservedNickname(context.Background(), "johndoe", userID, "ops")
+
+		assert.Nil(t, grant)
+		assert.Equal(t, service.ErrNicknameNotReserved, err)
+	})
+}
+
+func TestUserService_NicknameHistory(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+
+	userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{
+		Nickname: config.NicknamePolicyConfig{
+			Quarantine:     30 * 24 * time.Hour,
+			ChangeCooldown: 7 * 24 * time.Hour,
+		},
+	})
+
+	userID := uuid.New().String()
+	mockRepo.On("LockNicknames", mock.Anything, mock.Anything).Return(nil).Maybe()
+
+	t.Run("rename within cooldown is rejected", func(t *testing.T) {
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID, Nickname: "johndoe", Country: "US"}, nil).Once()
+		mockRepo.On("LastNicknameChange", mock.Anything, userID).Return(time.Now().Add(-time.Hour), nil).Once()
+
+		user, err := userService.UpdateUser(context.Background(), userID, "", "", "johnny", "", "")
+
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, service.ErrNicknameCooldown))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("case-only change skips cooldown", func(t *testing.T) {
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{ID: userID, Nickname: "johndoe", Country: "US"}, nil).Once()
+		mockRepo.On("Update", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil).Once()
+
+		user, err := userService.UpdateUser(context.Background(), userID, "", "", "JohnDoe", "", "")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "JohnDoe", user.Nickname)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("quarantined nickname is rejected on creation", func(t *testing.T) {
+		mockRepo.On("IsNicknameHeld", mock.Anything, "oldname", "", mock.AnythingOfType("time.Time")).Return(true, nil).Once()
+
+		user, err := userService.CreateUser(context.Background(), "John", "Doe", "oldname", "password123", "john@example.com", "US")
+
+		assert.Nil(t, user)
+		assert.True(t, errors.Is(err, service.ErrNicknameQuarantined))
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("previous nickname resolves to current user", func(t *testing.T) {
+		mockRepo.On("GetByNickname", mock.Anything, "oldname").Return(nil, repository.ErrUserNotFound).Once()
+		mockRepo.On("GetByPreviousNickname", mock.Anything, "oldname").Return(&models.User{ID: userID, Nickname: "newname", Password: "hash"}, nil).Once()
+
+		lookup, err := userService.GetUserByNickname(context.Background(), "oldname")
+
+		assert.NoError(t, err)
+		assert.True(t, lookup.Redirected)
+		assert.Equal(t, "newname", lookup.User.Nickname)
+		assert.Empty(t, lookup.User.Password)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("current nickname resolves without redirect", func(t *testing.T) {
+		mockRepo.On("GetByNickname", mock.Anything, "newname").Return(&models.User{ID: userID, Nickname: "newname"}, nil).Once()
+
+		lookup, err := userService.GetUserByNickname(context.Background(), "newname")
+
+		assert.NoError(t, err)
+		assert.False(t, lookup.Redirected)
+		mockRepo.AssertExpectations(t)
+	})
+}
+
+func TestUserService_LookupUsers(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
+
+	id := uuid.New().String()
+	users := []*models.User{
+		{ID: id, Nickname: "jane", Email: "jane@example.com", Password: "hash"},
+		{ID: uuid.New().String(), Nickname: "JohnDoe", Email: "John@example.com", Password: "hash"},
+	}
+
+	t.Run("resolves mixed identifiers", func(t *testing.T) {
+		mockRepo.On("Lookup", mock.Anything,
+			[]string{id},
+			[]string{"John@example.com"},
+			[]string{id, "johndoe", "ghost"},
+		).Return(users, nil).Once()
+
+		result, err := userService.LookupUsers(context.Background(),
+			[]string{id, "John@EXAMPLE.com", "johndoe", "ghost", id})
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Users, 3)
+		assert.Equal(t, id, result.Users[id].ID)
+		assert.Equal(t, "JohnDoe", result.Users["John@EXAMPLE.com"].Nickname)
+		assert.Equal(t, "JohnDoe", result.Users["johndoe"].Nickname)
+		assert.Empty(t, result.Users[id].Password)
+		assert.Equal(t, []string{"ghost"}, result.NotFound)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("matches ids in any UUID notation", func(t *testing.T) {
+		upper := strings.ToUpper(id)
+		mockRepo.On("Lookup", mock.Anything, []string{id}, []string(nil), []string{upper}).
+			Return([]*models.User{{ID: id, Nickname: "jane"}}, nil).Once()
+
+		result, err := userService.LookupUsers(context.Background(), []string{upper})
+
+		assert.NoError(t, err)
+		assert.Equal(t, id, result.Users[upper].ID)
+		assert.Empty(t, result.NotFound)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("falls back to nickname for UUID-shaped identifiers", func(t *testing.T) {
+		nickname := uuid.New().String()
+		mockRepo.On("Lookup", mock.Anything, []string{nickname}, []string(nil), []string{nickname}).
+			Return([]*models.User{{ID: id, Nickname: nickname}}, nil).Once()
+
+		result, err := userService.LookupUsers(context.Background(), []string{nickname})
+
+		assert.NoError(t, err)
+		assert.Equal(t, id, result.Users[nickname].ID)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("rejects empty batch", func(t *testing.T) {
+		result, err := userService.LookupUsers(context.Background(), nil)
+
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidInput))
+	})
+}
+
+func TestUserService_GetUsersByIDs(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{MaxBatchSize: 3})
+
+	first, second, unknown := uuid.New().String(), uuid.New().String(), uuid.New().String()
+
+	t.Run("preserves request order and reports missing ids", func(t *testing.T) {
+		mockRepo.On("GetByIDs", mock.Anything, []string{second, unknown, first}).Return([]*models.User{
+			{ID: first, Password: "hash"},
+			{ID: second, Password: "hash"},
+		}, nil).Once()
+
+		result, err := userService.GetUsersByIDs(context.Background(), []string{second, unknown, first})
+
+		assert.NoError(t, err)
+		if assert.Len(t, result.Users, 2) {
+			assert.Equal(t, second, result.Users[0].ID)
+			assert.Equal(t, first, result.Users[1].ID)
+			assert.Empty(t, result.Users[0].Password)
+		}
+		assert.Equal(t, []string{unknown}, result.Missing)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("matches ids in any UUID notation", func(t *testing.T) {
+		braced := "{" + strings.ToUpper(first) + "}"
+		mockRepo.On("GetByIDs", mock.Anything, []string{first}).Return([]*models.User{{ID: first}}, nil).Once()
+
+		result, err := userService.GetUsersByIDs(context.Background(), []string{braced, first})
+
+		assert.NoError(t, err)
+		if assert.Len(t, result.Users, 1) {
+			assert.Equal(t, first, result.Users[0].ID)
+		}
+		assert.Empty(t, result.Missing)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("malformed ids are missing without a query", func(t *testing.T) {
+		result, err := userService.GetUsersByIDs(context.Background(), []string{"not-a-uuid"})
+
+		assert.NoError(t, err)
+		assert.Empty(t, result.Users)
+		assert.Equal(t, []string{"not-a-uuid"}, result.Missing)
+	})
+
+	t.Run("rejects batches over the configured size", func(t *testing.T) {
+		result, err := userService.GetUsersByIDs(context.Background(), []string{"a", "b", "c", "d"})
+
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidInput))
+	})
+}
+
+func TestUserService_SearchUsers(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
+
+	t.Run("highlights matched fields", func(t *testing.T) {
+		mockRepo.On("Search", mock.Anything, "jo doe", repository.PaginationOptions{Page: 1, PageSize: 10}).
+			Return([]*repository.ScoredUser{
+				{User: models.User{ID: "1", FirstName: "John", LastName: "Doe", Nickname: "<jojo>", Email: "x@example.com", Password: "hash"}, Score: 1.2},
+			}, 1, nil).Once()
+
+		result, err := userService.SearchUsers(context.Background(), "  jo   doe ", service.ListOptions{Page: 1, PageSize: 10})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, *result.Total)
+		if assert.Len(t, result.Hits, 1) {
+			hit := result.Hits[0]
+			assert.Empty(t, hit.User.Password)
+			assert.Equal(t, "<mark>Jo</mark>hn", hit.Highlights["first_name"])
+			assert.Equal(t, "<mark>Doe</mark>", hit.Highlights["last_name"])
+			assert.Equal(t, "&lt;<mark>jojo</mark>&gt;", hit.Highlights["nickname"])
+			assert.NotContains(t, hit.Highlights, "email")
+		}
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("rejects short queries", func(t *testing.T) {
+		result, err := userService.SearchUsers(context.Background(), " j ", service.ListOptions{Page: 1, PageSize: 10})
+
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidInput))
+	})
+}
+
+func TestUserService_ExportUsers(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
+
+	mockRepo.On("Export", mock.Anything, service.FilterOptions{Country: repository.Eq("PT")}, mock.Anything).
+		Return([]*models.User{{ID: "1", Password: "hash"}, {ID: "2", Password: "hash"}}, nil).Once()
+
+	var exported []*models.User
+	err := userService.ExportUsers(context.Background(), service.FilterOptions{Country: repository.Eq("Portugal")}, func(user *models.User) error {
+		exported = append(exported, user)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	if assert.Len(t, exported, 2) {
+		assert.Empty(t, exported[0].Password)
+		assert.Empty(t, exported[1].Password)
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_ImportUsers(t *testing.T) {
+	row := func(first, nickname, email string) service.ImportRow {
+		return service.ImportRow{FirstName: first, LastName: "Doe", Nickname: nickname, Password: "password123", Email: email, Country: "PT"}
+	}
+	rows := []service.ImportRow{
+		row("Ann", "ann", "ann@example.com"),
+		row("", "nofirst", "nofirst@example.com"),
+		row("Ann", "ann2", "ANN@example.com"),
+		row("Bob", "bob", "bob@example.com"),
+		{Err: errors.New("malformed JSON line")},
+		row("Carl", "carl", "carl@example.com"),
+	}
+
+	t.Run("creates valid rows and reports the others", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		mockRepo.On("Lookup", mock.Anything, []string(nil),
+			[]string{"ann@example.com", "bob@example.com", "carl@example.com"},
+			[]string{"ann", "bob", "carl"}).
+			Return([]*models.User{{ID: uuid.New().String(), Nickname: "someone", Email: "Bob@example.com"}}, nil).Once()
+		// carl was registered concurrently and is skipped by the insert
+		mockRepo.On("CreateBatch", mock.Anything, mock.MatchedBy(func(users []*models.User) bool {
+			return len(users) == 2 && users[0].Nickname == "ann" && users[1].Nickname == "carl"
+		})).Return(func(users []*models.User) []string { return []string{users[0].ID} }, nil).Once()
+
+		report, err := userService.ImportUsers(context.Background(), rows, false)
+
+		assert.NoError(t, err)
+		assert.False(t, report.DryRun)
+		assert.Equal(t, 6, report.Total)
+		assert.Equal(t, 1, report.Created)
+		assert.Equal(t, 5, report.Failed)
+		if assert.Len(t, report.Rows, 6) {
+			assert.Equal(t, models.ImportRowCreated, report.Rows[0].Status)
+			assert.NotEmpty(t, report.Rows[0].UserID)
+			assert.Contains(t, report.Rows[1].Error, "first name is required")
+			assert.Equal(t, "duplicate of row 1: email already registered", report.Rows[2].Error)
+			assert.Equal(t, service.ErrEmailAlreadyExists.Error(), report.Rows[3].Error)
+			assert.Equal(t, "malformed JSON line", report.Rows[4].Error)
+			assert.Equal(t, service.ErrUserAlreadyExists.Error(), report.Rows[5].Error)
+			for i, result := range report.Rows {
+				assert.Equal(t, i+1, result.Row)
+			}
+		}
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("dry run creates nothing", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		mockRepo.On("Lookup", mock.Anything, []string(nil), mock.Anything, mock.Anything).Return([]*models.User{}, nil).Once()
+
+		report, err := userService.ImportUsers(context.Background(), rows, true)
+
+		assert.NoError(t, err)
+		assert.True(t, report.DryRun)
+		assert.Equal(t, 0, report.Created)
+		assert.Equal(t, 3, report.Valid)
+		assert.Equal(t, 3, report.Failed)
+		assert.Equal(t, models.ImportRowValid, report.Rows[3].Status)
+		mockRepo.AssertNotCalled(t, "CreateBatch", mock.Anything, mock.Anything)
+	})
+
+	t.Run("reports the row numbers of the file", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		mockRepo.On("Lookup", mock.Anything, []string(nil), mock.Anything, mock.Anything).Return([]*models.User{}, nil).Once()
+
+		// lines 1 and 3 of the file were blank
+		first, second := row("Ann", "ann", "ann@example.com"), row("Ann", "ann2", "ann@example.com")
+		first.Row, second.Row = 2, 4
+		report, err := userService.ImportUsers(context.Background(), []service.ImportRow{first, second}, true)
+
+		assert.NoError(t, err)
+		if assert.Len(t, report.Rows, 2) {
+			assert.Equal(t, 2, report.Rows[0].Row)
+			assert.Equal(t, 4, report.Rows[1].Row)
+			assert.Equal(t, "duplicate of row 2: email already registered", report.Rows[1].Error)
+		}
+	})
+
+	t.Run("checks confusable and quarantined nicknames per batch", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{
+			ConfusableCheck: true,
+			Nickname:        config.NicknamePolicyConfig{Quarantine: 24 * time.Hour},
+		})
+		batch := []service.ImportRow{rows[0], rows[3], rows[5]}
+
+		mockRepo.On("Lookup", mock.Anything, []string(nil), mock.Anything, mock.Anything).Return([]*models.User{}, nil).Once()
+		mockRepo.On("GetByNicknameSkeletons", mock.Anything, []string{"ann", "bob", "carl"}).
+			Return([]*models.User{{ID: uuid.New().String(), Nickname: "B0b", NicknameSkeleton: "bob"}}, nil).Once()
+		mockRepo.On("HeldNicknames", mock.Anything, []string{"ann", "bob", "carl"}, mock.AnythingOfType("time.Time")).
+			Return([]string{"carl"}, nil).Once()
+
+		report, err := userService.ImportUsers(context.Background(), batch, true)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Valid)
+		if assert.Len(t, report.Rows, 3) {
+			assert.Equal(t, models.ImportRowValid, report.Rows[0].Status)
+			assert.Equal(t, service.ErrNicknameConfusable.Error(), report.Rows[1].Error)
+			assert.Equal(t, service.ErrNicknameQuarantined.Error(), report.Rows[2].Error)
+		}
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("too many rows", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{MaxImportRows: 5})
+
+		report, err := userService.ImportUsers(context.Background(), rows, false)
+
+		assert.Nil(t, report)
+		assert.ErrorIs(t, err, service.ErrInvalidInput)
+		mockRepo.AssertNotCalled(t, "Lookup", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestUserService_StartImport(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
+
+	rows := []service.ImportRow{{FirstName: "Ann", LastName: "Doe", Nickname: "ann", Password: "password123", Email: "ann@example.com", Country: "PT"}}
+
+	mockRepo.On("CreateImportJob", mock.Anything, mock.MatchedBy(func(job *models.ImportJob) bool {
+		return job.Status == models.ImportJobPending && job.DryRun && job.TotalRows == 1
+	})).Return(nil).Once()
+	mockRepo.On("Lookup", mock.Anything, []string(nil), mock.Anything, mock.Anything).Return([]*models.User{}, nil).Once()
+
+	finished := make(chan models.ImportJob, 2)
+	mockRepo.On("UpdateImportJob", mock.Anything, mock.AnythingOfType("*models.ImportJob")).
+		Run(func(args mock.Arguments) { finished <- *args.Get(1).(*models.ImportJob) }).
+		Return(nil).Twice()
+
+	job, err := userService.StartImport(context.Background(), rows, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, models.ImportJobPending, job.Status)
+
+	for _, status := range []string{models.ImportJobRunning, models.ImportJobCompleted} {
+		select {
+		case update := <-finished:
+			assert.Equal(t, job.ID, update.ID)
+			assert.Equal(t, status, update.Status)
+			if status == models.ImportJobCompleted && assert.NotNil(t, update.Report) {
+				assert.Equal(t, 1, update.Report.Valid)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("import job was not marked %s", status)
+		}
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_Shutdown_FailsRunningImports(t *testing.T) {
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
+
+	rows := []service.ImportRow{{FirstName: "Ann", LastName: "Doe", Nickname: "ann", Password: "password123", Email: "ann@example.com", Country: "PT"}}
+
+	mockRepo.On("CreateImportJob", mock.Anything, mock.AnythingOfType("*models.ImportJob")).Return(nil).Once()
+	started := make(chan struct{})
+	mockRepo.On("Lookup", mock.Anything, []string(nil), mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			close(started)
+			<-args.Get(0).(context.Context).Done()
+		}).
+		Return(nil, context.Canceled).Once()
+
+	var updates []models.ImportJob
+	mockRepo.On("UpdateImportJob", mock.Anything, mock.AnythingOfType("*models.ImportJob")).
+		Run(func(args mock.Arguments) { updates = append(updates, *args.Get(1).(*models.ImportJob)) }).
+		Return(nil).Twice()
+
+	_, err := userService.StartImport(context.Background(), rows, false)
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, userService.Shutdown(ctx))
+
+	if assert.Len(t, updates, 2) {
+		assert.Equal(t, models.ImportJobFailed, updates[1].Status)
+		assert.Contains(t, updates[1].Error, "interrupted by shutdown")
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_ApplyBatch(t *testing.T) {
+	create := service.BatchOperation{Ref: "new", Action: service.BatchCreate, FirstName: "Ann", LastName: "Doe", Nickname: "ann", Password: "password123", Email: "ann@example.com", Country: "PT"}
+	missingID := uuid.New().String()
+	update := service.BatchOperation{Ref: "missing", Action: service.BatchUpdate, ID: missingID, FirstName: "Bob"}
+
+	t.Run("atomic batch runs in one transaction", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		existing := &models.User{ID: uuid.New().String(), Email: "old@example.com"}
+		remove := service.BatchOperation{Ref: "old", Action: service.BatchDelete, ID: existing.ID}
+
+		mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil).Once()
+		mockRepo.On("GetByID", mock.Anything, existing.ID).Return(existing, nil).Once()
+		mockRepo.On("Delete", mock.Anything, existing.ID).Return(nil).Once()
+
+		results, err := userService.ApplyBatch(context.Background(), []service.BatchOperation{create, remove}, true)
+
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.NoError(t, results[0].Err)
+			assert.Equal(t, "new", results[0].Ref)
+			assert.Equal(t, "ann", results[0].User.Nickname)
+			assert.Empty(t, results[0].User.Password)
+			assert.NoError(t, results[1].Err)
+		}
+		mockRepo.AssertExpectations(t)
+		mockRepo.AssertNumberOfCalls(t, "WithinTransaction", 1)
+	})
+
+	t.Run("atomic batch fails every operation", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil).Once()
+		mockRepo.On("GetByID", mock.Anything, missingID).Return(nil, repository.ErrUserNotFound).Once()
+
+		results, err := userService.ApplyBatch(context.Background(), []service.BatchOperation{create, update}, true)
+
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.ErrorIs(t, results[0].Err, service.ErrBatchAborted)
+			assert.Nil(t, results[0].User)
+			assert.ErrorIs(t, results[1].Err, service.ErrUserNotFound)
+		}
+		mockRepo.AssertNumberOfCalls(t, "WithinTransaction", 1)
+	})
+
+	t.Run("best effort applies operations independently", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		mockRepo.On("Create", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil).Once()
+		mockRepo.On("GetByID", mock.Anything, missingID).Return(nil, repository.ErrUserNotFound).Once()
+
+		results, err := userService.ApplyBatch(context.Background(), []service.BatchOperation{create, update}, false)
+
+		assert.NoError(t, err)
+		if assert.Len(t, results, 2) {
+			assert.NoError(t, results[0].Err)
+			assert.ErrorIs(t, results[1].Err, service.ErrUserNotFound)
+		}
+		// Each operation commits on its own.
+		mockRepo.AssertNumberOfCalls(t, "WithinTransaction", 2)
+	})
+
+	t.Run("invalid batches", func(t *testing.T) {
+		logger, mockRepo := setupTest(t)
+		userService := service.NewUserService(mockRepo, logger, nil)
+
+		for _, ops := range [][]service.BatchOperation{
+			nil,
+			{{Action: service.BatchDelete, ID: missingID}},
+			{create, create},
+		} {
+			results, err := userService.ApplyBatch(context.Background(), ops, true)
+			assert.Nil(t, results)
+			assert.ErrorIs(t, err, service.ErrInvalidInput)
+		}
+	})
+}
+
 func TestUserService_GetUserByID(t *testing.T) {
-	logger, mockRepo, mockNotification := setupTest(t)
+	logger, mockRepo := setupTest(t)
 
-	userService := service.NewUserService(mockRepo, mockNotification, logger)
+	userService := service.NewUserService(mockRepo, logger, nil)
 
 	userID := uuid.New().String()
 	existingUser := &models.User{
@@ -269,9 +1023,9 @@ func TestUserService_GetUserByID(t *testing.T) {
 }
 
 func TestUserService_DeleteUser(t *testing.T) {
-	logger, mockRepo, mockNotification := setupTest(t)
+	logger, mockRepo := setupTest(t)
 
-	userService := service.NewUserService(mockRepo, mockNotification, logger)
+	userService := service.NewUserService(mockRepo, logger, nil)
 
 	userID := uuid.New().String()
 	existingUser := &models.User{
@@ -291,8 +1045,6 @@ func TestUserService_DeleteUser(t *testing.T) {
 		mockRepo.On("GetByID", mock.Anything, userID).Return(existingUser, nil).Once()
 		mockRepo.On("Delete", mock.Anything, userID).Return(nil).Once()
 
-		mockNotification.On("NotifyUserDeleted", mock.Anything, userID).Return(nil)
-
 		err := userService.DeleteUser(context.Background(), userID)
 
 		assert.NoError(t, err)
@@ -314,8 +1066,8 @@ func TestUserService_DeleteUser(t *testing.T) {
 }
 
 func TestUserService_UpdateUser(t *testing.T) {
-	logger, mockRepo, mockNotification := setupTest(t)
-	userService := service.NewUserService(mockRepo, mockNotification, logger)
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
 
 	userID := uuid.New().String()
 	firstName := "John"
@@ -335,16 +1087,11 @@ func TestUserService_UpdateUser(t *testing.T) {
 			Country:   "UK",
 		}, nil).Once()
 
-		mockRepo.On("GetByEmail", mock.Anything, email).Return(nil, repository.ErrUserNotFound).Once()
-		mockRepo.On("GetByNickname", mock.Anything, nickname).Return(nil, repository.ErrUserNotFound).Once()
-
 		mockRepo.On("Update", mock.Anything, mock.MatchedBy(func(u *models.User) bool {
 			return u.FirstName == firstName && u.LastName == lastName &&
 				u.Nickname == nickname && u.Email == email && u.Country == country
 		})).Return(nil).Once()
 
-		mockNotification.On("NotifyUserUpdated", mock.Anything, mock.AnythingOfType("*models.User")).Return(nil)
-
 		user, err := userService.UpdateUser(context.Background(), userID, firstName, lastName, nickname, email, country)
 
 		assert.NoError(t, err)
@@ -358,6 +1105,23 @@ func TestUserService_UpdateUser(t *testing.T) {
 		mockRepo.AssertExpectations(t)
 	})
 
+	// Test case: email taken by another user
+	t.Run("email already exists", func(t *testing.T) {
+		mockRepo.On("GetByID", mock.Anything, userID).Return(&models.User{
+			ID:       userID,
+			Nickname: nickname,
+			Email:    "john@travolta.com",
+			Country:  "US",
+		}, nil).Once()
+		mockRepo.On("Update", mock.Anything, mock.AnythingOfType("*models.User")).Return(repository.ErrEmailAlreadyExists).Once()
+
+		user, err := userService.UpdateUser(context.Background(), userID, "", "", "", email, "")
+
+		assert.Nil(t, user)
+		assert.Equal(t, service.ErrEmailAlreadyExists, err)
+		mockRepo.AssertExpectations(t)
+	})
+
 	// Test case: user not found
 	t.Run("user not found", func(t *testing.T) {
 		mockRepo.On("GetByID", mock.Anything, userID).Return(nil, repository.ErrUserNotFound).Once()
@@ -371,8 +1135,8 @@ func TestUserService_UpdateUser(t *testing.T) {
 }
 
 func TestUserService_UpdatePassword(t *testing.T) {
-	logger, mockRepo, _ := setupTest(t)
-	userService := service.NewUserService(mockRepo, nil, logger)
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, nil)
 
 	userID := uuid.New().String()
 	newPassword := "newsecurepassword"
@@ -409,36 +1173,151 @@ func TestUserService_UpdatePassword(t *testing.T) {
 }
 
 func TestUserService_ListUsers(t *testing.T) {
-	logger, mockRepo, _ := setupTest(t)
-	userService := service.NewUserService(mockRepo, nil, logger)
+	logger, mockRepo := setupTest(t)
+	userService := service.NewUserService(mockRepo, logger, &config.UsersConfig{CursorSecret: "secret"})
 
-	page := 1
-	pageSize := 10
+	opts := service.ListOptions{Page: 1, PageSize: 10}
+	pagination := repository.PaginationOptions{Page: 1, PageSize: 10, Sort: repository.DefaultSort}
 	country := "US"
 
 	// Test case: successful listing
 	t.Run("successful listing", func(t *testing.T) {
-		mockRepo.On("List", mock.Anything, repository.FilterOptions{Country: country}, repository.PaginationOptions{Page: page, PageSize: pageSize}).
+		mockRepo.On("List", mock.Anything, service.FilterOptions{Country: repository.Eq(country)}, pagination).
 			Return([]*models.User{
 				{ID: uuid.New().String(), FirstName: "John", LastName: "Travolta", Nickname: "john123", Email: "john@gggmail.com", Country: country},
 			}, 1, nil).Once()
 
-		users, total, err := userService.ListUsers(context.Background(), country, "", "", "", "", page, pageSize)
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{Country: repository.Eq(country)}, opts)
+
+		assert.NoError(t, err)
+		assert.Len(t, result.Users, 1)
+		if assert.NotNil(t, result.Total) {
+			assert.Equal(t, 1, *result.Total)
+		}
+		assert.Empty(t, result.NextCursor)
+	})
+
+	// Test case: country names are matched on their ISO code
+	t.Run("country name is normalised", func(t *testing.T) {
+		mockRepo.On("List", mock.Anything, service.FilterOptions{Country: repository.Eq("US")}, pagination).
+			Return([]*models.User{}, 0, nil).Once()
+
+		_, err := userService.ListUsers(context.Background(), service.FilterOptions{Country: repository.Eq("United States")}, opts)
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: each country of an in filter is normalised
+	t.Run("country in filter is normalised", func(t *testing.T) {
+		mockRepo.On("List", mock.Anything, service.FilterOptions{Country: service.StringFilter{Op: repository.OpIn, Values: []string{"PT", "ES"}}}, pagination).
+			Return([]*models.User{}, 0, nil).Once()
+
+		_, err := userService.ListUsers(context.Background(), service.FilterOptions{
+			Country: service.StringFilter{Op: repository.OpIn, Values: []string{"Portugal", "esp"}},
+		}, opts)
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: country codes are not matched by substring
+	t.Run("country prefix filter", func(t *testing.T) {
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{
+			Country: service.StringFilter{Op: repository.OpPrefix, Values: []string{"P"}},
+		}, opts)
+
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidFilter))
+	})
+
+	// Test case: unknown country
+	t.Run("unknown country", func(t *testing.T) {
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{Country: repository.Eq("Atlantis")}, opts)
 
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidInput))
+	})
+
+	// Test case: a full page returns a cursor that resumes after its last row
+	t.Run("cursor round trip", func(t *testing.T) {
+		last := &models.User{ID: uuid.New().String(), CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)}
+		mockRepo.On("List", mock.Anything, service.FilterOptions{}, repository.PaginationOptions{Page: 1, PageSize: 1, SkipTotal: true, Sort: repository.DefaultSort}).
+			Return([]*models.User{last}, -1, nil).Once()
+
+		first, err := userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{Page: 1, PageSize: 1, SkipTotal: true})
+		assert.NoError(t, err)
+		assert.Nil(t, first.Total)
+		assert.NotEmpty(t, first.NextCursor)
+
+		mockRepo.On("List", mock.Anything, service.FilterOptions{}, mock.MatchedBy(func(p repository.PaginationOptions) bool {
+			return p.After != nil && p.After.ID == last.ID &&
+				assert.ObjectsAreEqual([]string{"2024-05-01T12:00:00.123456Z"}, p.After.Values)
+		})).Return([]*models.User{}, -1, nil).Once()
+
+		second, err := userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1, Cursor: first.NextCursor, SkipTotal: true})
+		assert.NoError(t, err)
+		assert.Empty(t, second.NextCursor)
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: cursors signed with another secret are rejected
+	t.Run("tampered cursor", func(t *testing.T) {
+		other := service.NewUserService(mockRepo, logger, &config.UsersConfig{CursorSecret: "other"})
+		mockRepo.On("List", mock.Anything, service.FilterOptions{}, repository.PaginationOptions{PageSize: 1, Sort: repository.DefaultSort}).
+			Return([]*models.User{{ID: uuid.New().String()}}, 1, nil).Once()
+
+		page, err := other.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1})
+		assert.NoError(t, err)
+
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1, Cursor: page.NextCursor})
+		assert.Nil(t, result)
+		assert.Equal(t, service.ErrInvalidCursor, err)
+	})
+
+	// Test case: multiple sort keys
+	t.Run("sort keys are passed to the repository", func(t *testing.T) {
+		sort := []repository.SortField{{Column: "last_name"}, {Column: "created_at", Desc: true}}
+		user := &models.User{ID: uuid.New().String(), LastName: "Doe", CreatedAt: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)}
+		mockRepo.On("List", mock.Anything, service.FilterOptions{}, repository.PaginationOptions{PageSize: 1, Sort: sort}).
+			Return([]*models.User{user}, 1, nil).Once()
+
+		first, err := userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1, Sort: "last_name, -created_at"})
+		assert.NoError(t, err)
+
+		// the cursor carries the sort, so it can be followed without repeating it
+		mockRepo.On("List", mock.Anything, service.FilterOptions{}, mock.MatchedBy(func(p repository.PaginationOptions) bool {
+			return assert.ObjectsAreEqual(sort, p.Sort) && p.After != nil &&
+				assert.ObjectsAreEqual([]string{"Doe", "2024-05-01T00:00:00Z"}, p.After.Values)
+		})).Return([]*models.User{}, 1, nil).Once()
+
+		_, err = userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1, Cursor: first.NextCursor})
 		assert.NoError(t, err)
-		assert.Len(t, users, 1)
-		assert.Equal(t, 1, total)
+
+		// but not with a different one
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1, Cursor: first.NextCursor, Sort: "email"})
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidCursor))
+		mockRepo.AssertExpectations(t)
+	})
+
+	// Test case: unknown sort field
+	t.Run("invalid sort field", func(t *testing.T) {
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{}, service.ListOptions{PageSize: 1, Sort: "password"})
+
+		assert.Nil(t, result)
+		assert.True(t, errors.Is(err, service.ErrInvalidSort))
+		assert.Contains(t, err.Error(), "first_name, last_name, nickname, email, country, created_at, updated_at")
 	})
 
 	// Test case: error while listing
 	t.Run("error while listing", func(t *testing.T) {
-		mockRepo.On("List", mock.Anything, repository.FilterOptions{Country: country}, repository.PaginationOptions{Page: page, PageSize: pageSize}).
+		mockRepo.On("List", mock.Anything, service.FilterOptions{Country: repository.Eq(country)}, pagination).
 			Return(nil, 0, errors.New("error listing users")).Once()
 
-		users, total, err := userService.ListUsers(context.Background(), country, "", "", "", "", page, pageSize)
+		result, err := userService.ListUsers(context.Background(), service.FilterOptions{Country: repository.Eq(country)}, opts)
 
 		assert.Error(t, err)
-		assert.Nil(t, users)
-		assert.Equal(t, 0, total)
+		assert.Nil(t, result)
 	})
 }
@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"context"
+	"net/netip"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ErrForbiddenAddress is returned for webhook URLs pointing at the service's
+// own network: loopback, private, link-local, multicast or unspecified
+// addresses
+var ErrForbiddenAddress = errors.New("webhook address is not publicly routable")
+
+// sharedAddressSpace is the carrier-grade NAT range, which net/netip does
+// not count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// resolver resolves the host of a webhook URL; net.DefaultResolver in
+// production
+type resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// forbiddenAddr reports whether addr must not receive webhooks
+func forbiddenAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() ||
+		sharedAddressSpace.Contains(addr)
+}
+
+// checkHost resolves host and fails if any of its addresses is forbidden
+func checkHost(ctx context.Context, r resolver, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if forbiddenAddr(addr) {
+			return errors.Wrapf(ErrForbiddenAddress, "%s", addr)
+		}
+		return nil
+	}
+
+	addrs, err := r.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return errors.Wrapf(err, "cannot resolve host %q", host)
+	}
+	for _, addr := range addrs {
+		if forbiddenAddr(addr) {
+			return errors.Wrapf(ErrForbiddenAddress, "%s resolves to %s", host, addr)
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to forbidden addresses. It runs after
+// DNS resolution, so a host re-pointed at an internal address after the
+// subscription was created is still refused.
+func dialControl(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return errors.Wrapf(ErrForbiddenAddress, "unexpected address %q", address)
+	}
+	if forbiddenAddr(addrPort.Addr()) {
+		return errors.Wrapf(ErrForbiddenAddress, "%s", addrPort.Addr())
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"user-microservice/internal/backoff"
+	"user-microservice/internal/config"
+	"user-microservice/internal/notification"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultBatchSize    = 50
+	defaultTimeout      = 10 * time.Second
+	defaultMinBackoff   = 10 * time.Second
+	defaultMaxBackoff   = time.Hour
+	defaultMaxAttempts  = 10
+	defaultDisableAfter = 20
+	defaultRetention    = 30 * 24 * time.Hour
+
+	// purgeInterval is how often finished deliveries past their retention are deleted
+	purgeInterval = time.Hour
+	// maxResponseBytes bounds the response body drained so the connection
+	// can be reused; it is never stored
+	maxResponseBytes = 1 << 10
+)
+
+// Dispatcher POSTs the scheduled deliveries to their subscribers. A
+// receiver answering 2xx has the delivery marked succeeded; any other
+// answer, or none, schedules another attempt later and later, until the
+// delivery is given up as failed. A subscription whose deliveries keep
+// failing in a row is disabled. Each replica runs a dispatcher; a claimed
+// delivery is leased to one of them while it is being sent.
+type Dispatcher struct {
+	store        Store
+	client       *http.Client
+	pollInterval time.Duration
+	batchSize    int
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	maxAttempts  int
+	disableAfter int
+	retention    time.Duration
+	logger       *zap.Logger
+}
+
+// NewDispatcher creates a Dispatcher sending the deliveries of store. Zero
+// values in cfg use the defaults.
+func NewDispatcher(store Store, cfg config.WebhookConfig, logger *zap.Logger) *Dispatcher {
+	d := &Dispatcher{
+		store:        store,
+		pollInterval: cfg.PollInterval,
+		batchSize:    cfg.BatchSize,
+		minBackoff:   cfg.MinBackoff,
+		maxBackoff:   cfg.MaxBackoff,
+		maxAttempts:  cfg.MaxAttempts,
+		disableAfter: cfg.DisableAfter,
+		retention:    cfg.Retention,
+		logger:       logger.With(zap.String("component", "webhook_dispatcher")),
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = dialControl
+	}
+	d.client = &http.Client{
+		Timeout: timeout,
+		// no proxy, so the dialer sees the receiver's address
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		// a redirect could send the signed payload elsewhere
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	if d.pollInterval <= 0 {
+		d.pollInterval = defaultPollInterval
+	}
+	if d.batchSize <= 0 {
+		d.batchSize = defaultBatchSize
+	}
+	if d.minBackoff <= 0 {
+		d.minBackoff = defaultMinBackoff
+	}
+	if d.maxBackoff < d.minBackoff {
+		d.maxBackoff = max(defaultMaxBackoff, d.minBackoff)
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = defaultMaxAttempts
+	}
+	if d.disableAfter <= 0 {
+		d.disableAfter = defaultDisableAfter
+	}
+	if d.retention <= 0 {
+		d.retention = defaultRetention
+	}
+	return d
+}
+
+// Run polls for due deliveries, and hourly deletes old finished ones, until
+// ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	poll := time.NewTicker(d.pollInterval)
+	defer poll.Stop()
+	purge := time.NewTicker(purgeInterval)
+	defer purge.Stop()
+
+	for {
+		d.Drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-purge.C:
+			d.purge(ctx)
+		}
+	}
+}
+
+// Drain claims due deliveries a batch at a time and sends each batch
+// concurrently, until no delivery is due; it returns how many requests
+// were sent
+func (d *Dispatcher) Drain(ctx context.Context) int {
+	total := 0
+	for ctx.Err() == nil {
+		// the lease outlasts the batch, whose deliveries are sent concurrently
+		deliveries, err := d.store.ClaimDeliveries(ctx, d.batchSize, 2*d.client.Timeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.logger.Error("error claiming webhook deliveries", zap.Error(err))
+			}
+			break
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		for _, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+		total += len(deliveries)
+	}
+	return total
+}
+
+// deliver sends delivery and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery *Delivery) {
+	statusCode, err := d.send(ctx, delivery)
+	if ctx.Err() != nil {
+		// shutting down; the delivery is claimed again once its lease expires
+		return
+	}
+
+	attempt := Attempt{Status: DeliverySucceeded, ResponseCode: statusCode}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.Status = DeliveryFailed
+		if delivery.Attempts+1 < d.maxAttempts {
+			attempt.Status = DeliveryPending
+			attempt.NextAttemptAt = d.retryAt(delivery.Attempts + 1)
+		}
+		d.logger.Warn("webhook delivery failed",
+			zap.Int64("delivery_id", delivery.ID),
+			zap.String("subscription_id", delivery.SubscriptionID),
+			zap.Int("attempts", delivery.Attempts+1),
+			zap.String("status", attempt.Status),
+			zap.Error(err))
+	}
+
+	disabled, err := d.store.RecordAttempt(ctx, delivery, attempt, d.disableAfter)
+	if err != nil {
+		return
+	}
+	if disabled {
+		d.logger.Warn("webhook subscription disabled after repeated failures",
+			zap.String("subscription_id", delivery.SubscriptionID),
+			zap.Int("failures", d.disableAfter))
+	}
+}
+
+// send posts the delivery, signed with the subscription secret, and returns
+// the response status code, or 0 when no response was received
+func (d *Dispatcher) send(ctx context.Context, delivery *Delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", notification.CloudEventsContentType)
+	req.Header.Set("User-Agent", "user-microservice-webhooks")
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(EventTypeHeader, delivery.EventType)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// the receiver's body could echo internal data into the delivery log
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseBytes))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryAt is when a delivery whose receiver failed attempts times is sent
+// again
+func (d *Dispatcher) retryAt(attempts int) time.Time {
+	return time.Now().Add(backoff.Exponential{Min: d.minBackoff, Max: d.maxBackoff}.Delay(attempts))
+}
+
+// purge deletes the succeeded and failed deliveries older than the
+// retention; pending ones are kept
+func (d *Dispatcher) purge(ctx context.Context) {
+	purged, err := d.store.PurgeDeliveries(ctx, time.Now().Add(-d.retention))
+	if err != nil {
+		return
+	}
+	if purged > 0 {
+		d.logger.Info("finished webhook deliveries purged", zap.Int64("count", purged))
+	}
+}
@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/notification"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// receiver records the requests sent to it and answers with status
+type receiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := r.status
+	r.mu.Unlock()
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte("receiver says hi"))
+}
+
+func newTestDispatcher(t *testing.T, status int, cfg config.WebhookConfig) (*Dispatcher, *memoryStore, *receiver, *Subscription) {
+	t.Helper()
+	recv := &receiver{status: status}
+	server := httptest.NewServer(recv)
+	t.Cleanup(server.Close)
+
+	store := newMemoryStore()
+	subscription := &Subscription{ID: "sub-1", URL: server.URL, Secret: testSecret, Active: true}
+	require.NoError(t, store.CreateSubscription(context.Background(), subscription))
+
+	// the test receiver listens on loopback
+	cfg.AllowPrivateNetworks = true
+	return NewDispatcher(store, cfg, zap.NewNop()), store, recv, subscription
+}
+
+func notifyDeleted(t *testing.T, store Store, userID string) {
+	t.Helper()
+	notifier := NewNotifier(store, config.CloudEventsConfig{Source: "user-microservice"}, zap.NewNop())
+	require.NoError(t, notifier.NotifyUserDeleted(context.Background(), userID))
+}
+
+func TestDispatcher_DeliversSignedEvent(t *testing.T) {
+	dispatcher, store, recv, _ := newTestDispatcher(t, http.StatusNoContent, config.WebhookConfig{})
+	notifyDeleted(t, store, "123")
+
+	assert.Equal(t, 1, dispatcher.Drain(context.Background()))
+
+	require.Len(t, recv.requests, 1)
+	req, body := recv.requests[0], recv.bodies[0]
+	assert.Equal(t, http.MethodPost, req.Method)
+	assert.Equal(t, notification.CloudEventsContentType, req.Header.Get("Content-Type"))
+	assert.Equal(t, "user.deleted", req.Header.Get(EventTypeHeader))
+	assert.Equal(t, "1", req.Header.Get(DeliveryHeader))
+	assert.NoError(t, Verify(testSecret, req.Header.Get(SignatureHeader), req.Header.Get(TimestampHeader), body, time.Minute, time.Now()))
+	assert.JSONEq(t, string(store.delivery(1).Payload), string(body))
+	assert.Contains(t, string(body), `"subject":"123"`)
+
+	delivery := store.delivery(1)
+	assert.Equal(t, DeliverySucceeded, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	require.NotNil(t, delivery.ResponseCode)
+	assert.Equal(t, http.StatusNoContent, *delivery.ResponseCode)
+	assert.NotNil(t, delivery.DeliveredAt)
+
+	// delivered events are not sent again
+	assert.Equal(t, 0, dispatcher.Drain(context.Background()))
+}
+
+func TestDispatcher_RetriesFailedDelivery(t *testing.T) {
+	dispatcher, store, recv, _ := newTestDispatcher(t, http.StatusInternalServerError, config.WebhookConfig{MinBackoff: time.Hour})
+	notifyDeleted(t, store, "123")
+
+	assert.Equal(t, 1, dispatcher.Drain(context.Background()))
+	assert.Len(t, recv.requests, 1)
+
+	delivery := store.delivery(1)
+	assert.Equal(t, DeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	require.NotNil(t, delivery.ResponseCode)
+	assert.Equal(t, http.StatusInternalServerError, *delivery.ResponseCode)
+	// the response body is not kept
+	assert.Equal(t, "unexpected status 500", delivery.LastError)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), delivery.NextAttemptAt, time.Minute)
+
+	// not due before its back-off
+	assert.Equal(t, 0, dispatcher.Drain(context.Background()))
+}
+
+func TestDispatcher_FailsAfterMaxAttempts(t *testing.T) {
+	dispatcher, store, _, _ := newTestDispatcher(t, http.StatusBadGateway, config.WebhookConfig{MaxAttempts: 1})
+	notifyDeleted(t, store, "123")
+
+	assert.Equal(t, 1, dispatcher.Drain(context.Background()))
+
+	delivery := store.delivery(1)
+	assert.Equal(t, DeliveryFailed, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, 0, dispatcher.Drain(context.Background()))
+}
+
+func TestDispatcher_DisablesFailingSubscription(t *testing.T) {
+	dispatcher, store, recv, subscription := newTestDispatcher(t, http.StatusInternalServerError,
+		config.WebhookConfig{MaxAttempts: 1, DisableAfter: 2})
+	notifyDeleted(t, store, "1")
+	notifyDeleted(t, store, "2")
+
+	assert.Equal(t, 2, dispatcher.Drain(context.Background()))
+
+	stored, err := store.GetSubscription(context.Background(), subscription.ID)
+	require.NoError(t, err)
+	assert.False(t, stored.Active)
+	assert.NotNil(t, stored.DisabledAt)
+	assert.Equal(t, 2, stored.ConsecutiveFailures)
+
+	// disabled subscriptions receive nothing
+	notifyDeleted(t, store, "3")
+	assert.Equal(t, 0, dispatcher.Drain(context.Background()))
+	assert.Len(t, recv.requests, 2)
+}
+
+func TestDispatcher_SuccessResetsFailures(t *testing.T) {
+	dispatcher, store, recv, subscription := newTestDispatcher(t, http.StatusInternalServerError,
+		config.WebhookConfig{MaxAttempts: 1, DisableAfter: 2})
+	notifyDeleted(t, store, "1")
+	dispatcher.Drain(context.Background())
+
+	recv.mu.Lock()
+	recv.status = http.StatusOK
+	recv.mu.Unlock()
+	notifyDeleted(t, store, "2")
+	dispatcher.Drain(context.Background())
+
+	stored, err := store.GetSubscription(context.Background(), subscription.ID)
+	require.NoError(t, err)
+	assert.True(t, stored.Active)
+	assert.Equal(t, 0, stored.ConsecutiveFailures)
+}
+
+func TestDispatcher_DoesNotFollowRedirects(t *testing.T) {
+	var redirected bool
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { redirected = true }))
+	defer target.Close()
+
+	dispatcher, store, _, _ := newTestDispatcher(t, http.StatusOK, config.WebhookConfig{MaxAttempts: 1})
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+	store.subscriptions["sub-1"].URL = redirect.URL
+	notifyDeleted(t, store, "123")
+
+	dispatcher.Drain(context.Background())
+
+	assert.False(t, redirected)
+	assert.Equal(t, DeliveryFailed, store.delivery(1).Status)
+}
+
+func TestDispatcher_RefusesPrivateAddresses(t *testing.T) {
+	recv := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(recv)
+	defer server.Close()
+
+	store := newMemoryStore()
+	// a public name re-pointed at loopback after the subscription was created
+	require.NoError(t, store.CreateSubscription(context.Background(), &Subscription{ID: "sub-1", URL: server.URL, Secret: testSecret, Active: true}))
+	notifyDeleted(t, store, "123")
+
+	dispatcher := NewDispatcher(store, config.WebhookConfig{MinBackoff: time.Hour}, zap.NewNop())
+	dispatcher.Drain(context.Background())
+
+	assert.Empty(t, recv.requests)
+	delivery := store.delivery(1)
+	assert.Nil(t, delivery.ResponseCode)
+	assert.Contains(t, delivery.LastError, ErrForbiddenAddress.Error())
+}
+
+func TestDispatcher_RetryAt(t *testing.T) {
+	dispatcher := NewDispatcher(newMemoryStore(), config.WebhookConfig{MinBackoff: time.Second, MaxBackoff: 5 * time.Second}, zap.NewNop())
+
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		assert.WithinDuration(t, time.Now().Add(want), dispatcher.retryAt(attempts), 100*time.Millisecond, strconv.Itoa(attempts))
+	}
+}
@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore is an in-memory Store with the semantics of PostgresStore
+type memoryStore struct {
+	mu            sync.Mutex
+	subscriptions map[string]*Subscription
+	deliveries    []*Delivery
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{subscriptions: map[string]*Subscription{}}
+}
+
+func (s *memoryStore) CreateSubscription(ctx context.Context, subscription *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *subscription
+	s.subscriptions[subscription.ID] = &copied
+	return nil
+}
+
+func (s *memoryStore) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return nil, ErrSubscriptionNotFound
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (s *memoryStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscriptions := []*Subscription{}
+	for _, subscription := range s.subscriptions {
+		copied := *subscription
+		subscriptions = append(subscriptions, &copied)
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions, nil
+}
+
+func (s *memoryStore) UpdateSubscription(ctx context.Context, subscription *Subscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.subscriptions[subscription.ID]
+	if !ok {
+		return ErrSubscriptionNotFound
+	}
+	secret := stored.Secret
+	*stored = *subscription
+	stored.Secret = secret
+	return nil
+}
+
+func (s *memoryStore) DeleteSubscription(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscriptions[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	delete(s.subscriptions, id)
+	return nil
+}
+
+func (s *memoryStore) EnqueueDeliveries(ctx context.Context, eventID, eventType string, payload []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	enqueued := 0
+	for _, subscription := range s.subscriptions {
+		if !subscription.Active || !subscription.Matches(eventType) || s.scheduled(subscription.ID, eventID) {
+			continue
+		}
+		now := time.Now()
+		s.deliveries = append(s.deliveries, &Delivery{
+			ID:             int64(len(s.deliveries) + 1),
+			SubscriptionID: subscription.ID,
+			EventID:        eventID,
+			EventType:      eventType,
+			Payload:        payload,
+			Status:         DeliveryPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+		enqueued++
+	}
+	return enqueued, nil
+}
+
+func (s *memoryStore) scheduled(subscriptionID, eventID string) bool {
+	for _, delivery := range s.deliveries {
+		if delivery.SubscriptionID == subscriptionID && delivery.EventID == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *memoryStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	claimed := []*Delivery{}
+	for _, delivery := range s.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		subscription, ok := s.subscriptions[delivery.SubscriptionID]
+		if !ok || !subscription.Active || delivery.Status != DeliveryPending || delivery.NextAttemptAt.After(now) {
+			continue
+		}
+		delivery.NextAttemptAt = now.Add(lease)
+		copied := *delivery
+		copied.URL = subscription.URL
+		copied.Secret = subscription.Secret
+		claimed = append(claimed, &copied)
+	}
+	return claimed, nil
+}
+
+func (s *memoryStore) RecordAttempt(ctx context.Context, delivery *Delivery, attempt Attempt, disableAfter int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	stored := s.deliveries[delivery.ID-1]
+	stored.Status = attempt.Status
+	stored.Attempts++
+	stored.ResponseCode = nil
+	if attempt.ResponseCode != 0 {
+		code := attempt.ResponseCode
+		stored.ResponseCode = &code
+	}
+	stored.LastError = attempt.Error
+	stored.NextAttemptAt = attempt.NextAttemptAt
+	if attempt.Status == DeliverySucceeded {
+		stored.DeliveredAt = &now
+	}
+
+	subscription, ok := s.subscriptions[delivery.SubscriptionID]
+	if !ok {
+		return false, nil
+	}
+	if attempt.Status == DeliverySucceeded {
+		subscription.ConsecutiveFailures = 0
+		return false, nil
+	}
+	subscription.ConsecutiveFailures++
+	if subscription.Active && subscription.ConsecutiveFailures >= disableAfter {
+		subscription.Active = false
+		subscription.DisabledAt = &now
+		return true, nil
+	}
+	return false, nil
+}
+
+func (s *memoryStore) ListDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []*Delivery{}
+	for i := len(s.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if s.deliveries[i].SubscriptionID == subscriptionID {
+			copied := *s.deliveries[i]
+			deliveries = append(deliveries, &copied)
+		}
+	}
+	return deliveries, nil
+}
+
+func (s *memoryStore) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// delivery returns a copy of the stored delivery with the given ID
+func (s *memoryStore) delivery(id int64) Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.deliveries[id-1]
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+	"user-microservice/internal/notification"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Notifier is a NotificationService scheduling a delivery of each event to
+// the matching subscriptions; the Dispatcher sends them. Events are
+// delivered as structured CloudEvents, like those published to RabbitMQ.
+type Notifier struct {
+	store       Store
+	cloudEvents config.CloudEventsConfig
+	logger      *zap.Logger
+}
+
+// NewNotifier creates a new Notifier
+func NewNotifier(store Store, cloudEvents config.CloudEventsConfig, logger *zap.Logger) *Notifier {
+	return &Notifier{
+		store:       store,
+		cloudEvents: cloudEvents,
+		logger:      logger.With(zap.String("component", "webhook_notifier")),
+	}
+}
+
+func (n *Notifier) NotifyUserCreated(ctx context.Context, user *models.User) error {
+	return n.Publish(ctx, notification.UserCreatedEvent(user))
+}
+
+func (n *Notifier) NotifyUserUpdated(ctx context.Context, user, previous *models.User) error {
+	return n.Publish(ctx, notification.UserUpdatedEvent(user, previous))
+}
+
+func (n *Notifier) NotifyUserDeleted(ctx context.Context, userID string) error {
+	return n.Publish(ctx, notification.UserDeletedEvent(userID))
+}
+
+// Publish schedules event for the subscriptions receiving its type.
+// Publishing an event again does not deliver it twice.
+func (n *Notifier) Publish(ctx context.Context, event notification.Event) error {
+	ce, err := notification.NewCloudEvent(event, n.cloudEvents)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(ce)
+	if err != nil {
+		return errors.Wrap(err, "error serializing event")
+	}
+
+	enqueued, err := n.store.EnqueueDeliveries(ctx, ce.ID, ce.Type, body)
+	if err != nil {
+		return err
+	}
+	if enqueued > 0 {
+		n.logger.Debug("webhook deliveries scheduled", zap.String("event_id", ce.ID), zap.String("type", ce.Type), zap.Int("count", enqueued))
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const subscriptionColumns = `id, url, secret, event_types, active, consecutive_failures, disabled_at, created_at, updated_at`
+
+const deliveryColumns = `d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+	d.response_code, d.last_error, d.next_attempt_at, d.created_at, d.delivered_at`
+
+// PostgresStore keeps subscriptions in the webhook_subscriptions table and
+// their deliveries in webhook_deliveries
+type PostgresStore struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+// NewPostgresStore creates a new PostgresStore
+func NewPostgresStore(db *sqlx.DB, logger *zap.Logger) *PostgresStore {
+	return &PostgresStore{
+		db:     db,
+		logger: logger.With(zap.String("component", "webhook_store")),
+	}
+}
+
+func (s *PostgresStore) CreateSubscription(ctx context.Context, subscription *Subscription) error {
+	query := `
+		INSERT INTO webhook_subscriptions (` + subscriptionColumns + `)
+		VALUES (:id, :url, :secret, :event_types, :active, :consecutive_failures, :disabled_at, :created_at, :updated_at)
+	`
+	if _, err := s.db.NamedExecContext(ctx, query, subscription); err != nil {
+		s.logger.Error("error creating webhook subscription", zap.Error(err))
+		return errors.Wrap(err, "error creating webhook subscription")
+	}
+	return nil
+}
+
+func (s *PostgresStore) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	var subscription Subscription
+	query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+	if err := s.db.GetContext(ctx, &subscription, query, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSubscriptionNotFound
+		}
+		s.logger.Error("error retrieving webhook subscription", zap.Error(err))
+		return nil, errors.Wrap(err, "error retrieving webhook subscription")
+	}
+	return &subscription, nil
+}
+
+func (s *PostgresStore) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	subscriptions := []*Subscription{}
+	query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions ORDER BY created_at, id`
+	if err := s.db.SelectContext(ctx, &subscriptions, query); err != nil {
+		s.logger.Error("error listing webhook subscriptions", zap.Error(err))
+		return nil, errors.Wrap(err, "error listing webhook subscriptions")
+	}
+	return subscriptions, nil
+}
+
+func (s *PostgresStore) UpdateSubscription(ctx context.Context, subscription *Subscription) error {
+	query := `
+		UPDATE webhook_subscriptions
+		SET url = :url, event_types = :event_types, active = :active,
+			consecutive_failures = :consecutive_failures, disabled_at = :disabled_at, updated_at = :updated_at
+		WHERE id = :id
+	`
+	result, err := s.db.NamedExecContext(ctx, query, subscription)
+	if err != nil {
+		s.logger.Error("error updating webhook subscription", zap.Error(err))
+		return errors.Wrap(err, "error updating webhook subscription")
+	}
+	return requireRow(result)
+}
+
+func (s *PostgresStore) DeleteSubscription(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		s.logger.Error("error deleting webhook subscription", zap.Error(err))
+		return errors.Wrap(err, "error deleting webhook subscription")
+	}
+	return requireRow(result)
+}
+
+func (s *PostgresStore) EnqueueDeliveries(ctx context.Context, eventID, eventType string, payload []byte) (int, error) {
+	query := `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, status, next_attempt_at, created_at)
+		SELECT id, $1, $2, $3, $4, $5, $5
+		FROM webhook_subscriptions
+		WHERE active AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	`
+	result, err := s.db.ExecContext(ctx, query, eventID, eventType, payload, DeliveryPending, time.Now().UTC())
+	if err != nil {
+		s.logger.Error("error enqueueing webhook deliveries", zap.Error(err))
+		return 0, errors.Wrap(err, "error enqueueing webhook deliveries")
+	}
+	enqueued, err := result.RowsAffected()
+	return int(enqueued), err
+}
+
+// ClaimDeliveries pushes the next attempt of the claimed deliveries past the
+// lease; FOR UPDATE SKIP LOCKED keeps instances from claiming the same ones.
+// A delivery whose instance dies is claimed again once the lease expires.
+func (s *PostgresStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*Delivery, error) {
+	now := time.Now().UTC()
+	query := `
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = $2
+		FROM webhook_subscriptions s
+		WHERE s.id = d.subscription_id AND d.id IN (
+			SELECT pending.id
+			FROM webhook_deliveries pending
+			JOIN webhook_subscriptions subscription ON subscription.id = pending.subscription_id
+			WHERE pending.status = $4 AND pending.next_attempt_at <= $1 AND subscription.active
+			ORDER BY pending.next_attempt_at, pending.id
+			LIMIT $3
+			FOR UPDATE OF pending SKIP LOCKED
+		)
+		RETURNING ` + deliveryColumns + `, s.url, s.secret
+	`
+	deliveries := []*Delivery{}
+	if err := s.db.SelectContext(ctx, &deliveries, query, now, now.Add(lease), limit, DeliveryPending); err != nil {
+		s.logger.Error("error claiming webhook deliveries", zap.Error(err))
+		return nil, errors.Wrap(err, "error claiming webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+func (s *PostgresStore) RecordAttempt(ctx context.Context, delivery *Delivery, attempt Attempt, disableAfter int) (bool, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		s.logger.Error("error starting transaction", zap.Error(err))
+		return false, errors.Wrap(err, "error starting transaction")
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			s.logger.Error("error rolling back transaction", zap.Error(err))
+		}
+	}()
+
+	now := time.Now().UTC()
+	responseCode := sql.NullInt64{Int64: int64(attempt.ResponseCode), Valid: attempt.ResponseCode != 0}
+	var deliveredAt *time.Time
+	if attempt.Status == DeliverySucceeded {
+		deliveredAt = &now
+	}
+	nextAttemptAt := attempt.NextAttemptAt
+	if nextAttemptAt.IsZero() {
+		nextAttemptAt = now
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2, attempts = attempts + 1, response_code = $3, last_error = $4,
+			next_attempt_at = $5, delivered_at = $6
+		WHERE id = $1
+	`, delivery.ID, attempt.Status, responseCode, attempt.Error, nextAttemptAt.UTC(), deliveredAt)
+	if err != nil {
+		s.logger.Error("error recording webhook delivery attempt", zap.Error(err))
+		return false, errors.Wrap(err, "error recording webhook delivery attempt")
+	}
+
+	var disabled bool
+	if attempt.Status == DeliverySucceeded {
+		_, err = tx.ExecContext(ctx, `UPDATE webhook_subscriptions SET consecutive_failures = 0 WHERE id = $1`, delivery.SubscriptionID)
+	} else {
+		err = tx.GetContext(ctx, &disabled, `
+			UPDATE webhook_subscriptions
+			SET consecutive_failures = consecutive_failures + 1,
+				active = active AND consecutive_failures + 1 < $2,
+				disabled_at = CASE WHEN active AND consecutive_failures + 1 >= $2 THEN $3 ELSE disabled_at END
+			WHERE id = $1
+			RETURNING COALESCE(disabled_at = $3, FALSE)
+		`, delivery.SubscriptionID, disableAfter, now)
+		if err == sql.ErrNoRows {
+			// the subscription was deleted meanwhile
+			err = nil
+		}
+	}
+	if err != nil {
+		s.logger.Error("error updating webhook subscription failures", zap.Error(err))
+		return false, errors.Wrap(err, "error updating webhook subscription failures")
+	}
+
+	if err := tx.Commit(); err != nil {
+		s.logger.Error("error committing transaction", zap.Error(err))
+		return false, errors.Wrap(err, "error committing transaction")
+	}
+	return disabled, nil
+}
+
+func (s *PostgresStore) ListDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*Delivery, error) {
+	deliveries := []*Delivery{}
+	query := `
+		SELECT ` + deliveryColumns + `
+		FROM webhook_deliveries d
+		WHERE d.subscription_id = $1
+		ORDER BY d.id DESC
+		LIMIT $2
+	`
+	if err := s.db.SelectContext(ctx, &deliveries, query, subscriptionID, limit); err != nil {
+		s.logger.Error("error listing webhook deliveries", zap.Error(err))
+		return nil, errors.Wrap(err, "error listing webhook deliveries")
+	}
+	return deliveries, nil
+}
+
+func (s *PostgresStore) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE status <> $1 AND created_at < $2`, DeliveryPending, before.UTC())
+	if err != nil {
+		s.logger.Error("error purging webhook deliveries", zap.Error(err))
+		return 0, errors.Wrap(err, "error purging webhook deliveries")
+	}
+	return result.RowsAffected()
+}
+
+// requireRow maps an update or delete matching no row to ErrSubscriptionNotFound
+func requireRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "error reading affected rows")
+	}
+	if affected == 0 {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"time"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/notification"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// secretBytes is the size of generated secrets
+	secretBytes = 32
+	// minSecretLength rejects secrets too short to sign safely
+	minSecretLength = 16
+
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+// SubscriptionRequest describes a subscription to create
+type SubscriptionRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	// Secret signs the deliveries; one is generated when empty
+	Secret string `json:"secret,omitempty"`
+}
+
+// SubscriptionUpdate changes the fields it sets. Activating a subscription
+// resets its failures.
+type SubscriptionUpdate struct {
+	URL        *string   `json:"url,omitempty"`
+	EventTypes *[]string `json:"event_types,omitempty"`
+	Active     *bool     `json:"active,omitempty"`
+}
+
+// ServiceInterface manages webhook subscriptions
+type ServiceInterface interface {
+	CreateSubscription(ctx context.Context, req SubscriptionRequest) (*Subscription, error)
+	GetSubscription(ctx context.Context, id string) (*Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]*Subscription, error)
+	UpdateSubscription(ctx context.Context, id string, update SubscriptionUpdate) (*Subscription, error)
+	DeleteSubscription(ctx context.Context, id string) error
+	ListDeliveries(ctx context.Context, id string, limit int) ([]*Delivery, error)
+}
+
+// Service manages webhook subscriptions. Secrets are only returned by
+// CreateSubscription.
+type Service struct {
+	store        Store
+	resolver     resolver
+	allowPrivate bool
+	logger       *zap.Logger
+}
+
+// NewService creates a new Service. Subscription URLs must resolve to
+// public addresses unless cfg allows private networks.
+func NewService(store Store, cfg config.WebhookConfig, logger *zap.Logger) *Service {
+	return &Service{
+		store:        store,
+		resolver:     net.DefaultResolver,
+		allowPrivate: cfg.AllowPrivateNetworks,
+		logger:       logger.With(zap.String("component", "webhook_service")),
+	}
+}
+
+func (s *Service) CreateSubscription(ctx context.Context, req SubscriptionRequest) (*Subscription, error) {
+	if err := s.validateURL(ctx, req.URL); err != nil {
+		return nil, err
+	}
+	eventTypes, err := validateEventTypes(req.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		if secret, err = generateSecret(); err != nil {
+			return nil, err
+		}
+	} else if len(secret) < minSecretLength {
+		return nil, errors.Wrapf(ErrInvalidSubscription, "secret must have at least %d characters", minSecretLength)
+	}
+
+	now := time.Now().UTC()
+	subscription := &Subscription{
+		ID:         uuid.New().String(),
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		Active:     true,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := s.store.CreateSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("webhook subscription created", zap.String("id", subscription.ID), zap.Strings("event_types", eventTypes))
+	return subscription, nil
+}
+
+func (s *Service) GetSubscription(ctx context.Context, id string) (*Subscription, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrSubscriptionNotFound
+	}
+	subscription, err := s.store.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	subscription.Secret = ""
+	return subscription, nil
+}
+
+func (s *Service) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	subscriptions, err := s.store.ListSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, subscription := range subscriptions {
+		subscription.Secret = ""
+	}
+	return subscriptions, nil
+}
+
+func (s *Service) UpdateSubscription(ctx context.Context, id string, update SubscriptionUpdate) (*Subscription, error) {
+	subscription, err := s.GetSubscription(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.URL != nil {
+		if err := s.validateURL(ctx, *update.URL); err != nil {
+			return nil, err
+		}
+		subscription.URL = *update.URL
+	}
+	if update.EventTypes != nil {
+		if subscription.EventTypes, err = validateEventTypes(*update.EventTypes); err != nil {
+			return nil, err
+		}
+	}
+	if update.Active != nil {
+		if *update.Active && !subscription.Active {
+			subscription.ConsecutiveFailures = 0
+			subscription.DisabledAt = nil
+		}
+		subscription.Active = *update.Active
+	}
+	subscription.UpdatedAt = time.Now().UTC()
+
+	if err := s.store.UpdateSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+func (s *Service) DeleteSubscription(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrSubscriptionNotFound
+	}
+	if err := s.store.DeleteSubscription(ctx, id); err != nil {
+		return err
+	}
+	s.logger.Info("webhook subscription deleted", zap.String("id", id))
+	return nil
+}
+
+// ListDeliveries returns the latest deliveries of a subscription, newest
+// first; limit defaults to 50 and is capped at 500
+func (s *Service) ListDeliveries(ctx context.Context, id string, limit int) ([]*Delivery, error) {
+	if _, err := s.GetSubscription(ctx, id); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultDeliveryLimit
+	}
+	return s.store.ListDeliveries(ctx, id, min(limit, maxDeliveryLimit))
+}
+
+// validateURL accepts absolute http and https URLs whose host resolves to
+// public addresses only. The dispatcher checks the address again when it
+// connects, since DNS may change in between.
+func (s *Service) validateURL(ctx context.Context, raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.Wrap(ErrInvalidSubscription, "url must be an absolute http or https URL")
+	}
+	if s.allowPrivate {
+		return nil
+	}
+	if err := checkHost(ctx, s.resolver, u.Hostname()); err != nil {
+		return errors.Wrap(ErrInvalidSubscription, err.Error())
+	}
+	return nil
+}
+
+// validateEventTypes rejects unknown event types; none means every type
+func validateEventTypes(eventTypes []string) ([]string, error) {
+	known := map[string]bool{}
+	for _, eventType := range notification.EventTypes() {
+		known[eventType] = true
+	}
+
+	validated := []string{}
+	seen := map[string]bool{}
+	for _, eventType := range eventTypes {
+		if !known[eventType] {
+			return nil, errors.Wrapf(ErrInvalidSubscription, "unknown event type %q", eventType)
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			validated = append(validated, eventType)
+		}
+	}
+	return validated, nil
+}
+
+func generateSecret() (string, error) {
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", errors.Wrap(err, "error generating webhook secret")
+	}
+	return hex.EncodeToString(secret), nil
+}
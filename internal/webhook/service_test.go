@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"user-microservice/internal/config"
+	"user-microservice/internal/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// staticResolver resolves hosts from a fixed table
+type staticResolver map[string][]netip.Addr
+
+func (r staticResolver) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func newTestService(store Store) *Service {
+	service := NewService(store, config.WebhookConfig{}, zap.NewNop())
+	service.resolver = staticResolver{
+		"example.com":      {netip.MustParseAddr("93.184.215.14")},
+		"example.org":      {netip.MustParseAddr("96.7.128.198")},
+		"internal.example": {netip.MustParseAddr("93.184.215.14"), netip.MustParseAddr("10.0.0.7")},
+		"metadata.example": {netip.MustParseAddr("169.254.169.254")},
+	}
+	return service
+}
+
+func TestService_CreateSubscription(t *testing.T) {
+	service := newTestService(newMemoryStore())
+
+	subscription, err := service.CreateSubscription(context.Background(), SubscriptionRequest{
+		URL:        "https://example.com/hooks",
+		EventTypes: []string{"user.created", "user.created", "user.deleted"},
+	})
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, subscription.ID)
+	assert.True(t, subscription.Active)
+	assert.Len(t, subscription.Secret, 2*secretBytes)
+	assert.Equal(t, []string{"user.created", "user.deleted"}, []string(subscription.EventTypes))
+
+	// the secret is only returned on creation
+	fetched, err := service.GetSubscription(context.Background(), subscription.ID)
+	require.NoError(t, err)
+	assert.Empty(t, fetched.Secret)
+	listed, err := service.ListSubscriptions(context.Background())
+	require.NoError(t, err)
+	require.Len(t, listed, 1)
+	assert.Empty(t, listed[0].Secret)
+}
+
+func TestService_CreateSubscriptionInvalid(t *testing.T) {
+	service := newTestService(newMemoryStore())
+
+	tests := map[string]SubscriptionRequest{
+		"relative url":       {URL: "/hooks"},
+		"unsupported scheme": {URL: "ftp://example.com/hooks"},
+		"unknown event type": {URL: "https://example.com/hooks", EventTypes: []string{"user.renamed"}},
+		"short secret":       {URL: "https://example.com/hooks", Secret: "short"},
+		"loopback":           {URL: "http://127.0.0.1:8080/hooks"},
+		"ipv6 loopback":      {URL: "http://[::1]/hooks"},
+		"mapped loopback":    {URL: "http://[::ffff:127.0.0.1]/hooks"},
+		"private":            {URL: "https://192.168.1.10/hooks"},
+		"unspecified":        {URL: "http://0.0.0.0/hooks"},
+		"private resolution": {URL: "https://internal.example/hooks"},
+		"link-local name":    {URL: "http://metadata.example/latest"},
+		"unresolvable host":  {URL: "https://nowhere.example/hooks"},
+	}
+	for name, req := range tests {
+		_, err := service.CreateSubscription(context.Background(), req)
+		assert.ErrorIs(t, err, ErrInvalidSubscription, name)
+	}
+}
+
+func TestService_AllowPrivateNetworks(t *testing.T) {
+	service := NewService(newMemoryStore(), config.WebhookConfig{AllowPrivateNetworks: true}, zap.NewNop())
+
+	_, err := service.CreateSubscription(context.Background(), SubscriptionRequest{URL: "http://localhost:9000/hooks"})
+
+	assert.NoError(t, err)
+}
+
+func TestService_UpdateSubscriptionReactivates(t *testing.T) {
+	store := newMemoryStore()
+	service := newTestService(store)
+	subscription, err := service.CreateSubscription(context.Background(), SubscriptionRequest{URL: "https://example.com/hooks"})
+	require.NoError(t, err)
+
+	stored := store.subscriptions[subscription.ID]
+	stored.Active = false
+	stored.ConsecutiveFailures = 20
+
+	active := true
+	url := "https://example.org/hooks"
+	updated, err := service.UpdateSubscription(context.Background(), subscription.ID, SubscriptionUpdate{URL: &url, Active: &active})
+	require.NoError(t, err)
+
+	assert.True(t, updated.Active)
+	assert.Equal(t, 0, updated.ConsecutiveFailures)
+	assert.Equal(t, url, updated.URL)
+	// the secret is kept
+	assert.Equal(t, subscription.Secret, store.subscriptions[subscription.ID].Secret)
+}
+
+func TestService_NotFound(t *testing.T) {
+	service := newTestService(newMemoryStore())
+
+	for _, id := range []string{"not-a-uuid", uuid.New().String()} {
+		_, err := service.GetSubscription(context.Background(), id)
+		assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+		assert.ErrorIs(t, service.DeleteSubscription(context.Background(), id), ErrSubscriptionNotFound)
+		_, err = service.ListDeliveries(context.Background(), id, 0)
+		assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+	}
+}
+
+func TestNotifier_SchedulesMatchingSubscriptionsOnce(t *testing.T) {
+	store := newMemoryStore()
+	service := newTestService(store)
+	all, err := service.CreateSubscription(context.Background(), SubscriptionRequest{URL: "https://example.com/all"})
+	require.NoError(t, err)
+	created, err := service.CreateSubscription(context.Background(), SubscriptionRequest{URL: "https://example.com/created", EventTypes: []string{"user.created"}})
+	require.NoError(t, err)
+
+	notifier := NewNotifier(store, config.CloudEventsConfig{Source: "user-microservice"}, zap.NewNop())
+	user := &models.User{ID: "123", Email: "john@example.com", Password: "hash"}
+	require.NoError(t, notifier.NotifyUserCreated(context.Background(), user))
+	require.NoError(t, notifier.NotifyUserDeleted(context.Background(), "123"))
+
+	allDeliveries, err := service.ListDeliveries(context.Background(), all.ID, 0)
+	require.NoError(t, err)
+	assert.Len(t, allDeliveries, 2)
+	createdDeliveries, err := service.ListDeliveries(context.Background(), created.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, createdDeliveries, 1)
+	assert.Equal(t, "user.created", createdDeliveries[0].EventType)
+	assert.NotContains(t, string(createdDeliveries[0].Payload), "hash")
+
+	// the outbox may publish an event again; it is delivered once
+	_, err = store.EnqueueDeliveries(context.Background(), createdDeliveries[0].EventID, "user.created", createdDeliveries[0].Payload)
+	require.NoError(t, err)
+	createdDeliveries, err = service.ListDeliveries(context.Background(), created.ID, 0)
+	require.NoError(t, err)
+	assert.Len(t, createdDeliveries, 1)
+}
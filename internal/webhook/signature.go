@@ -0,0 +1,49 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// signatureScheme prefixes signatures, leaving room for other algorithms
+const signatureScheme = "sha256="
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature of a delivery body sent at timestamp (Unix
+// seconds): the hex HMAC-SHA256 of "{timestamp}.{body}" keyed with the
+// subscription secret, prefixed with "sha256=". Signing the timestamp lets
+// receivers reject replayed deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signatureScheme + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature and timestamp headers of a delivery received
+// at now, rejecting timestamps further than tolerance from now
+func Verify(secret, signature, timestamp string, body []byte, tolerance time.Duration, now time.Time) error {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, "invalid timestamp")
+	}
+
+	age := now.Sub(time.Unix(ts, 0))
+	if age > tolerance || age < -tolerance {
+		return errors.Wrap(ErrInvalidSignature, "timestamp outside of tolerance")
+	}
+
+	if !strings.HasPrefix(signature, signatureScheme) ||
+		!hmac.Equal([]byte(signature), []byte(Sign(secret, ts, body))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package webhook
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t,
+		"sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163",
+		Sign("secret", 1700000000, []byte("{}")))
+	assert.NotEqual(t, Sign("secret", 1700000000, []byte("{}")), Sign("secret", 1700000001, []byte("{}")))
+	assert.NotEqual(t, Sign("secret", 1700000000, []byte("{}")), Sign("other", 1700000000, []byte("{}")))
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"1"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign("secret", now.Unix(), body)
+
+	assert.NoError(t, Verify("secret", signature, timestamp, body, 5*time.Minute, now))
+	assert.NoError(t, Verify("secret", signature, timestamp, body, 5*time.Minute, now.Add(4*time.Minute)))
+
+	tests := map[string]error{
+		"wrong secret":   Verify("other", signature, timestamp, body, 5*time.Minute, now),
+		"tampered body":  Verify("secret", signature, timestamp, []byte(`{"id":"2"}`), 5*time.Minute, now),
+		"replayed":       Verify("secret", signature, timestamp, body, 5*time.Minute, now.Add(6*time.Minute)),
+		"bad timestamp":  Verify("secret", signature, "yesterday", body, 5*time.Minute, now),
+		"missing scheme": Verify("secret", signature[len(signatureScheme):], timestamp, body, 5*time.Minute, now),
+	}
+	for name, err := range tests {
+		assert.ErrorIs(t, err, ErrInvalidSignature, name)
+	}
+}
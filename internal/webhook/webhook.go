@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SignatureHeader carries the signature of a delivery, see Sign
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the Unix time the delivery was signed at
+	TimestampHeader = "X-Webhook-Timestamp"
+	// DeliveryHeader carries the ID of the delivery
+	DeliveryHeader = "X-Webhook-Delivery"
+	// EventTypeHeader carries the type of the delivered event
+	EventTypeHeader = "X-Webhook-Event"
+)
+
+// Delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("webhook subscription not found")
+	ErrInvalidSubscription  = errors.New("invalid webhook subscription")
+)
+
+// Subscription receives the events of its types, or every event when it
+// lists none, at its URL
+type Subscription struct {
+	ID  string `json:"id" db:"id"`
+	URL string `json:"url" db:"url"`
+	// Secret signs the deliveries; it is only returned when the subscription
+	// is created
+	Secret              string         `json:"secret,omitempty" db:"secret"`
+	EventTypes          pq.StringArray `json:"event_types" db:"event_types"`
+	Active              bool           `json:"active" db:"active"`
+	ConsecutiveFailures int            `json:"consecutive_failures" db:"consecutive_failures"`
+	// DisabledAt is set when the subscription was disabled after repeated failures
+	DisabledAt *time.Time `json:"disabled_at,omitempty" db:"disabled_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Matches reports whether the subscription receives events of eventType
+func (s *Subscription) Matches(eventType string) bool {
+	if len(s.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range s.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is an event to be sent to a subscription, and the outcome of
+// its last attempt
+type Delivery struct {
+	ID             int64           `json:"id" db:"id"`
+	SubscriptionID string          `json:"subscription_id" db:"subscription_id"`
+	EventID        string          `json:"event_id" db:"event_id"`
+	EventType      string          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage `json:"-" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	// ResponseCode is nil when the last attempt got no response
+	ResponseCode  *int       `json:"response_code,omitempty" db:"response_code"`
+	LastError     string     `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt time.Time  `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+
+	// URL and Secret are those of the subscription, set on claimed deliveries
+	URL    string `json:"-" db:"url"`
+	Secret string `json:"-" db:"secret"`
+}
+
+// Attempt is the outcome of sending a delivery
+type Attempt struct {
+	// Status is the status of the delivery after the attempt
+	Status string
+	// ResponseCode is 0 when no response was received
+	ResponseCode int
+	Error        string
+	// NextAttemptAt schedules the retry of a delivery still pending
+	NextAttemptAt time.Time
+}
+
+// Store persists subscriptions and their deliveries
+type Store interface {
+	CreateSubscription(ctx context.Context, subscription *Subscription) error
+	GetSubscription(ctx context.Context, id string) (*Subscription, error)
+	ListSubscriptions(ctx context.Context) ([]*Subscription, error)
+	UpdateSubscription(ctx context.Context, subscription *Subscription) error
+	DeleteSubscription(ctx context.Context, id string) error
+
+	// EnqueueDeliveries schedules payload for every active subscription
+	// receiving eventType and returns how many deliveries were scheduled.
+	// An event already scheduled for a subscription is skipped, so
+	// enqueueing the same event again is harmless.
+	EnqueueDeliveries(ctx context.Context, eventID, eventType string, payload []byte) (int, error)
+	// ClaimDeliveries leases up to limit due deliveries of active
+	// subscriptions, so other instances do not send them meanwhile
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*Delivery, error)
+	// RecordAttempt stores the outcome of an attempt. A failed attempt counts
+	// against the subscription, which is disabled after disableAfter failures
+	// in a row; it reports whether the subscription was disabled.
+	RecordAttempt(ctx context.Context, delivery *Delivery, attempt Attempt, disableAfter int) (disabled bool, err error)
+	// ListDeliveries returns the latest deliveries of a subscription
+	ListDeliveries(ctx context.Context, subscriptionID string, limit int) ([]*Delivery, error)
+	// PurgeDeliveries deletes finished deliveries created before the given time
+	PurgeDeliveries(ctx context.Context, before time.Time) (int64, error)
+}
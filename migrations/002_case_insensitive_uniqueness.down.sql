@@ -0,0 +1,14 @@
+-- Nome: 002_case_insensitive_uniqueness
+-- Descrição: Restore case-sensitive uniqueness on email and nickname
+-- Versão: 1.0
+
+DROP INDEX IF EXISTS users_nickname_skeleton_key;
+DROP INDEX IF EXISTS users_nickname_key;
+DROP INDEX IF EXISTS users_email_key;
+
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+ALTER TABLE users ADD CONSTRAINT users_nickname_key UNIQUE (nickname);
+CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
+CREATE INDEX IF NOT EXISTS idx_users_nickname ON users(nickname);
+
+ALTER TABLE users DROP COLUMN IF EXISTS nickname_skeleton;
@@ -0,0 +1,47 @@
+-- Nome: 002_case_insensitive_uniqueness
+-- Descrição: Canonicalise emails and nicknames and enforce case-insensitive uniqueness
+-- Versão: 1.0
+--
+-- Run `usertool report-conflicts` before applying: rows that only differ by
+-- case or normalisation, or whose nicknames share a confusable skeleton,
+-- make the unique indexes below fail to build.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS nickname_skeleton VARCHAR(50) NOT NULL DEFAULT '';
+
+-- Same as models.CanonicalEmail: the domain follows the last '@', and
+-- addresses without one are only trimmed
+UPDATE users u
+SET email = c.email
+FROM (
+    SELECT id, CASE
+        WHEN strpos(trim(email), '@') = 0 THEN trim(email)
+        ELSE substring(trim(email) from '^(.*)@[^@]*$') || '@' || lower(substring(trim(email) from '@([^@]*)$'))
+    END AS email
+    FROM users
+) c
+WHERE u.id = c.id AND u.email <> c.email;
+
+UPDATE users
+SET nickname = normalize(trim(nickname), NFKC)
+WHERE nickname <> normalize(trim(nickname), NFKC);
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_nickname_key;
+DROP INDEX IF EXISTS idx_users_email;
+DROP INDEX IF EXISTS idx_users_nickname;
+
+-- Same skeleton as models.NicknameSkeleton: decompose, strip combining marks,
+-- lower-case, map confusable characters and drop separators
+UPDATE users
+SET nickname_skeleton = translate(
+    regexp_replace(
+        lower(normalize(nickname, NFKD)),
+        '[\u0300-\u036f\u1ab0-\u1aff\u1dc0-\u1dff\u20d0-\u20ff\ufe20-\ufe2f_.[:space:]-]', '', 'g'),
+    'аеһіјорсухѕԁԛԝαγηινορυχıɡ',
+    'aehijopcyxsdqwaynivopuxig')
+WHERE nickname_skeleton = '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_key ON users (lower(email));
+CREATE UNIQUE INDEX IF NOT EXISTS users_nickname_key ON users (lower(nickname));
+-- rows stored while the confusable check is disabled have no skeleton
+CREATE UNIQUE INDEX IF NOT EXISTS users_nickname_skeleton_key ON users (nickname_skeleton) WHERE nickname_skeleton <> '';
@@ -0,0 +1,5 @@
+-- Nome: 003_nickname_grants
+-- Descrição: Drop nickname_grants table
+-- Versão: 1.0
+
+DROP TABLE IF EXISTS nickname_grants;
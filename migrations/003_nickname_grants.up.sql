@@ -0,0 +1,13 @@
+-- Nome: 003_nickname_grants
+-- Descrição: Create nickname_grants table for reserved nickname overrides
+-- Versão: 1.0
+
+CREATE TABLE IF NOT EXISTS nickname_grants (
+    nickname VARCHAR(50) NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    granted_by VARCHAR(100) NOT NULL,
+    granted_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS nickname_grants_nickname_key ON nickname_grants (lower(nickname));
+CREATE INDEX IF NOT EXISTS idx_nickname_grants_user_id ON nickname_grants(user_id);
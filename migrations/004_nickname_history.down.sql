@@ -0,0 +1,5 @@
+-- Nome: 004_nickname_history
+-- Descrição: Drop nickname_history table
+-- Versão: 1.0
+
+DROP TABLE IF EXISTS nickname_history;
@@ -0,0 +1,15 @@
+-- Nome: 004_nickname_history
+-- Descrição: Create nickname_history table to track released nicknames
+-- Versão: 1.0
+--
+-- Rows outlive deleted users, so the nicknames they released stay quarantined.
+
+CREATE TABLE IF NOT EXISTS nickname_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    nickname VARCHAR(50) NOT NULL,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_nickname_history_nickname ON nickname_history (lower(nickname), changed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_nickname_history_user_id ON nickname_history (user_id, changed_at DESC);
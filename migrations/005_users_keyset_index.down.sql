@@ -0,0 +1,5 @@
+-- Nome: 005_users_keyset_index
+-- Descrição: Drop the keyset pagination index
+-- Versão: 1.0
+
+DROP INDEX IF EXISTS idx_users_created_at_id;
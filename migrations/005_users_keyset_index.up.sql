@@ -0,0 +1,5 @@
+-- Nome: 005_users_keyset_index
+-- Descrição: Index the list order so keyset pagination reads constant-size ranges
+-- Versão: 1.0
+
+CREATE INDEX IF NOT EXISTS idx_users_created_at_id ON users (created_at DESC, id DESC);
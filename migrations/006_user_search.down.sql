@@ -0,0 +1,10 @@
+-- Nome: 006_user_search
+-- Descrição: Drop user search indexes
+-- Versão: 1.0
+
+DROP INDEX IF EXISTS idx_users_email_trgm;
+DROP INDEX IF EXISTS idx_users_nickname_trgm;
+DROP INDEX IF EXISTS idx_users_last_name_trgm;
+DROP INDEX IF EXISTS idx_users_first_name_trgm;
+DROP INDEX IF EXISTS idx_users_search_vector;
+ALTER TABLE users DROP COLUMN IF EXISTS search_vector;
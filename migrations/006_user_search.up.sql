@@ -0,0 +1,20 @@
+-- Nome: 006_user_search
+-- Descrição: Add full-text and trigram indexes for user search
+-- Versão: 1.0
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- names weigh more than the nickname, which weighs more than the email
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (
+        setweight(to_tsvector('simple', first_name), 'A') ||
+        setweight(to_tsvector('simple', last_name), 'A') ||
+        setweight(to_tsvector('simple', nickname), 'B') ||
+        setweight(to_tsvector('simple', email), 'C')
+    ) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_users_search_vector ON users USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_users_first_name_trgm ON users USING GIN (first_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_last_name_trgm ON users USING GIN (last_name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_nickname_trgm ON users USING GIN (nickname gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_email_trgm ON users USING GIN (email gin_trgm_ops);
@@ -0,0 +1,5 @@
+-- Nome: 007_import_jobs
+-- Descrição: Drop import_jobs table
+-- Versão: 1.0
+
+DROP TABLE IF EXISTS import_jobs;
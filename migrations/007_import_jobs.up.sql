@@ -0,0 +1,14 @@
+-- Nome: 007_import_jobs
+-- Descrição: Create import_jobs table for asynchronous user imports
+-- Versão: 1.0
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id UUID PRIMARY KEY,
+    status VARCHAR(20) NOT NULL,
+    dry_run BOOLEAN NOT NULL DEFAULT FALSE,
+    total_rows INTEGER NOT NULL DEFAULT 0,
+    report JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
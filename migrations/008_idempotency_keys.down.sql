@@ -0,0 +1,5 @@
+-- Nome: 008_idempotency_keys
+-- Descrição: Drop idempotency_keys table
+-- Versão: 1.0
+
+DROP TABLE IF EXISTS idempotency_keys;
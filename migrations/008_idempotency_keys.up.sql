@@ -0,0 +1,19 @@
+-- Nome: 008_idempotency_keys
+-- Descrição: Create idempotency_keys table storing responses replayed for retried requests
+-- Versão: 1.0
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope VARCHAR(255) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    -- NULL while the first request is in flight
+    status_code INTEGER,
+    response_header JSONB,
+    response_body BYTEA,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (scope, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at);
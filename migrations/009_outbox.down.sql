@@ -0,0 +1,5 @@
+-- Nome: 009_outbox
+-- Descrição: Drop outbox table
+-- Versão: 1.0
+
+DROP TABLE IF EXISTS outbox;
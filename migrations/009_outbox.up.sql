@@ -0,0 +1,21 @@
+-- Nome: 009_outbox
+-- Descrição: Create outbox table holding user events until the relay publishes them
+-- Versão: 1.0
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE,
+    event_type VARCHAR(100) NOT NULL,
+    aggregate_id VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- NULL until the event is published
+    sent_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (next_attempt_at, id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending_aggregate ON outbox (aggregate_id, id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_sent_at ON outbox (sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- Nome: 010_outbox_routing_key
+-- Descrição: Drop the routing key of outbox events
+-- Versão: 1.0
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS routing_key;
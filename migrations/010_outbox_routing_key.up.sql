@@ -0,0 +1,5 @@
+-- Nome: 010_outbox_routing_key
+-- Descrição: Add the routing key events are published with, naming the fields changed by an update
+-- Versão: 1.0
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS routing_key VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+-- Nome: 011_webhooks
+-- Descrição: Drop webhook subscriptions and their deliveries
+-- Versão: 1.0
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
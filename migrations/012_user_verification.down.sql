@@ -0,0 +1,5 @@
+-- Nome: 012_user_verification
+-- Descrição: Drop the verification time of user accounts
+-- Versão: 1.0
+
+ALTER TABLE users DROP COLUMN IF EXISTS verified_at;
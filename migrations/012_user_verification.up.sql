@@ -0,0 +1,5 @@
+-- Nome: 012_user_verification
+-- Descrição: Record when a user account was verified
+-- Versão: 1.0
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP WITH TIME ZONE;
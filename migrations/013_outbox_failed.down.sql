@@ -0,0 +1,11 @@
+-- Nome: 013_outbox_failed
+-- Descrição: Drop the parking of failed outbox events
+-- Versão: 1.0
+
+DROP INDEX IF EXISTS idx_outbox_failed_at;
+DROP INDEX IF EXISTS idx_outbox_pending;
+DROP INDEX IF EXISTS idx_outbox_pending_aggregate;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (next_attempt_at, id) WHERE sent_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending_aggregate ON outbox (aggregate_id, id) WHERE sent_at IS NULL;
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS failed_at;
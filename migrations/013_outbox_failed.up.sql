@@ -0,0 +1,12 @@
+-- Nome: 013_outbox_failed
+-- Descrição: Park outbox events that failed too many times so they stop blocking later events of their user
+-- Versão: 1.0
+
+-- NULL unless the relay gave up on the event
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS failed_at TIMESTAMP WITH TIME ZONE;
+
+DROP INDEX IF EXISTS idx_outbox_pending;
+DROP INDEX IF EXISTS idx_outbox_pending_aggregate;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (next_attempt_at, id) WHERE sent_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_pending_aggregate ON outbox (aggregate_id, id) WHERE sent_at IS NULL AND failed_at IS NULL;
+CREATE INDEX IF NOT EXISTS idx_outbox_failed_at ON outbox (failed_at) WHERE failed_at IS NOT NULL;